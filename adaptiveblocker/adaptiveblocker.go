@@ -0,0 +1,271 @@
+package adaptiveblocker
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/FucAttaCk/gateway/secevent"
+	"github.com/FucAttaCk/gateway/util"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of AdaptiveBlocker.
+	Kind = "AdaptiveBlocker"
+
+	resultBlocked = "blocked"
+
+	defaultHalfLife         = 5 * time.Minute
+	defaultThreshold        = 20
+	defaultBlockDuration    = 10 * time.Minute
+	defaultMaxBlockDuration = 24 * time.Hour
+	defaultMaxClients       = 100000
+)
+
+var results = []string{resultBlocked, resultHoneypot}
+
+func init() {
+	httppipeline.Register(&AdaptiveBlocker{})
+}
+
+type (
+	// Spec is the spec of AdaptiveBlocker.
+	Spec struct {
+		// Rules decide how much a finished request adds to the score
+		// of its client.
+		Rules []*RuleSpec
+		// The time it takes a score to decay to half, e.g. 10m.
+		// Default: 5m.
+		HalfLife string
+		// Clients whose score reaches this are blocked. Default: 20.
+		Threshold float64
+		// Clients whose score reaches this have their requests delayed
+		// by PenaltyDelay. Default: 0, no delay.
+		PenaltyThreshold float64
+		// How long penalized requests are delayed, e.g. 500ms.
+		PenaltyDelay string
+		// How long the first block lasts, e.g. 5m. Default: 10m.
+		// Every further block of the same client doubles it.
+		BlockDuration string
+		// Upper bound of the doubled block duration. Default: 24h.
+		MaxBlockDuration string
+		// The maximum number of tracked clients. Default: 100000.
+		MaxClients int
+		// Decoy paths that penalize the clients requesting them.
+		Honeypots *HoneypotSpec
+		// Where block decisions and honeypot hits are reported.
+		SecurityEvents *secevent.Spec
+		// The proxies in front of the gateway, IPs or CIDR prefixes.
+		// Clients are identified by the peer address of the
+		// connection, X-Forwarded-For and X-Real-Ip are only believed
+		// from these proxies. Default: none.
+		TrustedProxies []string
+	}
+
+	// RuleSpec matches finished requests by response status code
+	// and optionally by path prefix.
+	RuleSpec struct {
+		Name         string
+		StatusCodes  []int
+		PathPrefixes []string
+		// Score added to the client on every match. Default: 1.
+		Weight float64
+	}
+
+	// AdaptiveBlocker tracks per-client error patterns and blocks
+	// clients that look like scanners or brute forcers.
+	AdaptiveBlocker struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+		tracker    *tracker
+		events     *secevent.Stream
+		proxies    []netip.Prefix
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	for _, d := range []struct{ name, value string }{
+		{"halfLife", spec.HalfLife},
+		{"penaltyDelay", spec.PenaltyDelay},
+		{"blockDuration", spec.BlockDuration},
+		{"maxBlockDuration", spec.MaxBlockDuration},
+	} {
+		if d.value == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d.value); err != nil || v < 0 {
+			return fmt.Errorf("invalid %s %q", d.name, d.value)
+		}
+	}
+	for _, r := range spec.Rules {
+		if len(r.StatusCodes) == 0 {
+			return fmt.Errorf("rule %s: status codes are required", r.Name)
+		}
+		if r.Weight < 0 {
+			return fmt.Errorf("rule %s: weight must not be negative", r.Name)
+		}
+	}
+	if spec.SecurityEvents != nil {
+		if err := spec.SecurityEvents.Validate(); err != nil {
+			return err
+		}
+	}
+	if _, err := util.ParseTrustedProxies(spec.TrustedProxies); err != nil {
+		return err
+	}
+	if spec.Honeypots != nil {
+		for _, p := range spec.Honeypots.Paths {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("invalid honeypot path %q: %v", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+func parseDuration(s string, defaultValue time.Duration) time.Duration {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return defaultValue
+	}
+	return d
+}
+
+func (r *RuleSpec) match(code int, path string) bool {
+	matched := false
+	for _, c := range r.StatusCodes {
+		if c == code {
+			matched = true
+			break
+		}
+	}
+	if !matched || len(r.PathPrefixes) == 0 {
+		return matched
+	}
+	for _, p := range r.PathPrefixes {
+		if len(path) >= len(p) && path[:len(p)] == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Kind returns the kind of AdaptiveBlocker.
+func (ab *AdaptiveBlocker) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of AdaptiveBlocker.
+func (ab *AdaptiveBlocker) DefaultSpec() interface{} {
+	return &Spec{
+		Rules: []*RuleSpec{
+			{Name: "notFoundScan", StatusCodes: []int{http.StatusNotFound}, Weight: 1},
+			{Name: "authFailure", StatusCodes: []int{http.StatusUnauthorized, http.StatusForbidden}, Weight: 2},
+		},
+		Threshold: defaultThreshold,
+	}
+}
+
+// Description returns the description of AdaptiveBlocker.
+func (ab *AdaptiveBlocker) Description() string {
+	return "AdaptiveBlocker temporarily blocks clients with suspicious error patterns or honeypot hits."
+}
+
+// Results returns the results of AdaptiveBlocker.
+func (ab *AdaptiveBlocker) Results() []string {
+	return results
+}
+
+// Init initializes AdaptiveBlocker.
+func (ab *AdaptiveBlocker) Init(filterSpec *httppipeline.FilterSpec) {
+	ab.filterSpec = filterSpec
+	ab.spec = filterSpec.FilterSpec().(*Spec)
+	ab.tracker = newTracker(ab.spec)
+	ab.events = secevent.New(filterSpec.Name(), ab.spec.SecurityEvents)
+	ab.proxies, _ = util.ParseTrustedProxies(ab.spec.TrustedProxies)
+}
+
+// Inherit inherits previous generation of AdaptiveBlocker, the client
+// scores and blocks are kept.
+func (ab *AdaptiveBlocker) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	ab.filterSpec = filterSpec
+	ab.spec = filterSpec.FilterSpec().(*Spec)
+	prev := previousGeneration.(*AdaptiveBlocker)
+	prev.events.Close()
+	ab.tracker = prev.tracker
+	ab.tracker.reload(ab.spec)
+	ab.events = secevent.New(filterSpec.Name(), ab.spec.SecurityEvents)
+	ab.proxies, _ = util.ParseTrustedProxies(ab.spec.TrustedProxies)
+}
+
+// Handle handles HTTP request
+func (ab *AdaptiveBlocker) Handle(ctx context.HTTPContext) string {
+	res := ab.handle(ctx)
+	return ctx.CallNextHandler(res)
+}
+
+func (ab *AdaptiveBlocker) handle(ctx context.HTTPContext) string {
+	ip := util.ClientIP(ctx.Request().Std(), ab.proxies)
+	path := ctx.Request().Path()
+
+	blocked, penalized := ab.tracker.check(ip)
+	if blocked {
+		ctx.AddTag(fmt.Sprintf("adaptive blocker: %s is blocked", ip))
+		ctx.Response().SetStatusCode(http.StatusForbidden)
+		return resultBlocked
+	}
+	if res, ok := ab.handleHoneypot(ctx, ip); ok {
+		return res
+	}
+	if penalized {
+		ctx.AddTag(fmt.Sprintf("adaptive blocker: %s is penalized", ip))
+		select {
+		case <-time.After(ab.tracker.penaltyDelay()):
+		case <-ctx.Done():
+		}
+	}
+
+	method := ctx.Request().Method()
+	ctx.OnFinish(func() {
+		code := ctx.Response().StatusCode()
+		for _, r := range ab.spec.Rules {
+			if r.match(code, path) && ab.tracker.penalize(ip, r.weight(), r.Name) {
+				ab.events.Emit(&secevent.Event{
+					Type:     secevent.TypeAnomaly,
+					Severity: 7,
+					Action:   "block",
+					ClientIP: ip,
+					Method:   method,
+					Path:     path,
+					Reason:   r.Name,
+					Fields:   map[string]string{"statusCode": strconv.Itoa(code)},
+				})
+			}
+		}
+	})
+	return ""
+}
+
+func (r *RuleSpec) weight() float64 {
+	if r.Weight == 0 {
+		return 1
+	}
+	return r.Weight
+}
+
+// Status returns Status generated by Runtime.
+func (ab *AdaptiveBlocker) Status() interface{} {
+	return ab.tracker.status()
+}
+
+// Close closes AdaptiveBlocker.
+func (ab *AdaptiveBlocker) Close() {
+	ab.tracker.close()
+	ab.events.Close()
+}
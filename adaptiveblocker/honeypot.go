@@ -0,0 +1,117 @@
+package adaptiveblocker
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/FucAttaCk/gateway/secevent"
+	"github.com/megaease/easegress/pkg/context"
+)
+
+const resultHoneypot = "honeypot"
+
+// defaultHoneypotPaths are decoys that no legitimate client of a
+// gateway in front of our own content asks for.
+var defaultHoneypotPaths = []string{
+	"/wp-login.php",
+	"/wp-admin/*",
+	"/xmlrpc.php",
+	"/.env",
+	"/.git/*",
+	"/phpmyadmin/*",
+	"/server-status",
+}
+
+// HoneypotSpec describes decoy paths. Requests to them never reach
+// the next filter, the client is penalized and the hit is reported as
+// a security event.
+type HoneypotSpec struct {
+	// Exact paths or path.Match patterns, a pattern ending in /*
+	// matches the directory and everything below it. Default:
+	// common probes like /wp-login.php and /.env.
+	Paths []string
+	// Score added to the client on every hit. Default: the block
+	// threshold, so the first hit blocks.
+	Score float64
+	// The status code answered to decoy hits. Default: 404.
+	StatusCode int
+}
+
+func (spec *HoneypotSpec) paths() []string {
+	if len(spec.Paths) == 0 {
+		return defaultHoneypotPaths
+	}
+	return spec.Paths
+}
+
+func (spec *HoneypotSpec) match(p string) (string, bool) {
+	for _, pattern := range spec.paths() {
+		if pattern == p {
+			return pattern, true
+		}
+		if !strings.ContainsAny(pattern, "*?[") {
+			continue
+		}
+		if matched, _ := path.Match(pattern, p); matched {
+			return pattern, true
+		}
+		// path.Match doesn't cross slashes, so /.git/* matches
+		// /.git/config through it and /.git/refs/heads through the
+		// ancestor /.git/refs
+		if dir := strings.TrimSuffix(pattern, "/*"); dir != pattern {
+			for q := p; q != "/" && q != "."; q = path.Dir(q) {
+				if matched, _ := path.Match(pattern, q); matched {
+					return pattern, true
+				}
+				if matched, _ := path.Match(dir, q); matched {
+					return pattern, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// handleHoneypot answers decoy hits of the client ip, it returns false
+// for real paths.
+func (ab *AdaptiveBlocker) handleHoneypot(ctx context.HTTPContext, ip string) (string, bool) {
+	spec := ab.spec.Honeypots
+	if spec == nil {
+		return "", false
+	}
+	p := ctx.Request().Path()
+	pattern, ok := spec.match(p)
+	if !ok {
+		return "", false
+	}
+
+	score := spec.Score
+	if score <= 0 {
+		score = ab.tracker.blockThreshold()
+	}
+	action := "tag"
+	if ab.tracker.penalize(ip, score, "honeypot "+pattern) {
+		action = "block"
+	}
+
+	ab.events.Emit(&secevent.Event{
+		Type:     secevent.TypeHoneypot,
+		Severity: 8,
+		Action:   action,
+		ClientIP: ip,
+		Method:   ctx.Request().Method(),
+		Path:     p,
+		Reason:   "honeypot " + pattern,
+		Fields:   map[string]string{"userAgent": ctx.Request().Header().Get("User-Agent")},
+	})
+	ctx.AddTag(fmt.Sprintf("adaptive blocker: honeypot %s hit by %s", pattern, ip))
+
+	code := spec.StatusCode
+	if code == 0 {
+		code = http.StatusNotFound
+	}
+	ctx.Response().SetStatusCode(code)
+	return resultHoneypot, true
+}
@@ -0,0 +1,35 @@
+package adaptiveblocker
+
+import "testing"
+
+func TestHoneypotMatch(t *testing.T) {
+	spec := &HoneypotSpec{}
+	for p, want := range map[string]string{
+		"/wp-login.php":            "/wp-login.php",
+		"/.env":                    "/.env",
+		"/.git":                    "/.git/*",
+		"/.git/config":             "/.git/*",
+		"/.git/refs/heads/main":    "/.git/*",
+		"/wp-admin/includes/a.php": "/wp-admin/*",
+		"/phpmyadmin/":             "/phpmyadmin/*",
+		"/.github/workflows":       "",
+		"/wp-admin-guide":          "",
+		"/docs/.git/config":        "",
+		"/index.html":              "",
+	} {
+		if got, _ := spec.match(p); got != want {
+			t.Errorf("match(%s) = %q, want %q", p, got, want)
+		}
+	}
+
+	spec = &HoneypotSpec{Paths: []string{"/*/backup/*", "/admin?.php"}}
+	for p, want := range map[string]bool{
+		"/site/backup/2024/db.sql": true,
+		"/admin1.php":              true,
+		"/site/other/db.sql":       false,
+	} {
+		if _, ok := spec.match(p); ok != want {
+			t.Errorf("match(%s) = %v, want %v", p, ok, want)
+		}
+	}
+}
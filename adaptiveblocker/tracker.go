@@ -0,0 +1,227 @@
+package adaptiveblocker
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const cleanupInterval = time.Minute
+
+type (
+	// Status is the status of AdaptiveBlocker.
+	Status struct {
+		TrackedClients int              `yaml:"trackedClients"`
+		Blocked        []*BlockDecision `yaml:"blocked"`
+	}
+
+	// BlockDecision describes a client that is currently blocked.
+	BlockDecision struct {
+		IP     string    `yaml:"ip"`
+		Reason string    `yaml:"reason"`
+		Blocks int       `yaml:"blocks"`
+		Until  time.Time `yaml:"until"`
+	}
+
+	client struct {
+		score     float64
+		updated   time.Time
+		blocks    int
+		blockedAt time.Time
+		until     time.Time
+		reason    string
+	}
+
+	// tracker keeps decaying per-client scores and block decisions,
+	// it survives spec updates so blocks are not lifted by a reload.
+	tracker struct {
+		mutex   sync.Mutex
+		clients map[string]*client
+
+		halfLife         time.Duration
+		threshold        float64
+		penaltyThreshold float64
+		delay            time.Duration
+		blockDuration    time.Duration
+		maxBlockDuration time.Duration
+		maxClients       int
+
+		done chan struct{}
+		wg   sync.WaitGroup
+		now  func() time.Time
+	}
+)
+
+func newTracker(spec *Spec) *tracker {
+	t := &tracker{
+		clients: map[string]*client{},
+		done:    make(chan struct{}),
+		now:     time.Now,
+	}
+	t.reload(spec)
+
+	t.wg.Add(1)
+	go t.run()
+	return t
+}
+
+func (t *tracker) reload(spec *Spec) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.halfLife = parseDuration(spec.HalfLife, defaultHalfLife)
+	t.threshold = spec.Threshold
+	if t.threshold <= 0 {
+		t.threshold = defaultThreshold
+	}
+	t.penaltyThreshold = spec.PenaltyThreshold
+	t.delay = parseDuration(spec.PenaltyDelay, 0)
+	t.blockDuration = parseDuration(spec.BlockDuration, defaultBlockDuration)
+	t.maxBlockDuration = parseDuration(spec.MaxBlockDuration, defaultMaxBlockDuration)
+	t.maxClients = spec.MaxClients
+	if t.maxClients <= 0 {
+		t.maxClients = defaultMaxClients
+	}
+}
+
+func (t *tracker) penaltyDelay() time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.delay
+}
+
+func (t *tracker) blockThreshold() float64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.threshold
+}
+
+// decay brings the score of c up to now, caller must hold the lock.
+func (t *tracker) decay(c *client, now time.Time) {
+	elapsed := now.Sub(c.updated)
+	if elapsed > 0 {
+		c.score *= math.Exp2(-float64(elapsed) / float64(t.halfLife))
+		c.updated = now
+	}
+}
+
+// check reports whether ip is blocked, or penalized by a delay.
+func (t *tracker) check(ip string) (blocked, penalized bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	c := t.clients[ip]
+	if c == nil {
+		return false, false
+	}
+	now := t.now()
+	if now.Before(c.until) {
+		return true, false
+	}
+	t.decay(c, now)
+	return false, t.penaltyThreshold > 0 && t.delay > 0 && c.score >= t.penaltyThreshold
+}
+
+// penalize adds score to ip and blocks it once the threshold is
+// reached, it reports whether this call blocked the client. Every
+// further block doubles the block duration.
+func (t *tracker) penalize(ip string, score float64, reason string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	c := t.clients[ip]
+	if c == nil {
+		if len(t.clients) >= t.maxClients {
+			return false
+		}
+		c = &client{updated: now}
+		t.clients[ip] = c
+	}
+	if now.Before(c.until) {
+		return false
+	}
+
+	t.decay(c, now)
+	c.score += score
+	if c.score < t.threshold {
+		return false
+	}
+
+	d := t.blockDuration << c.blocks
+	if d > t.maxBlockDuration || d <= 0 {
+		d = t.maxBlockDuration
+	}
+	c.blocks++
+	c.blockedAt = now
+	c.until = now.Add(d)
+	c.reason = reason
+	c.score = 0
+	return true
+}
+
+func (t *tracker) run() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.cleanup()
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// cleanup forgets clients that are not blocked and whose score has
+// decayed to almost nothing. Clients blocked before are remembered
+// for maxBlockDuration, so repeat offenders get longer blocks.
+func (t *tracker) cleanup() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	for ip, c := range t.clients {
+		if now.Before(c.until) {
+			continue
+		}
+		t.decay(c, now)
+		if c.score >= 0.01 {
+			continue
+		}
+		if c.blocks > 0 && now.Sub(c.until) < t.maxBlockDuration {
+			continue
+		}
+		delete(t.clients, ip)
+	}
+}
+
+func (t *tracker) status() *Status {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	s := &Status{TrackedClients: len(t.clients)}
+	now := t.now()
+	for ip, c := range t.clients {
+		if now.Before(c.until) {
+			s.Blocked = append(s.Blocked, &BlockDecision{
+				IP:     ip,
+				Reason: c.reason,
+				Blocks: c.blocks,
+				Until:  c.until,
+			})
+		}
+	}
+	sort.Slice(s.Blocked, func(i, j int) bool {
+		return s.Blocked[i].Until.After(s.Blocked[j].Until)
+	})
+	return s
+}
+
+func (t *tracker) close() {
+	close(t.done)
+	t.wg.Wait()
+}
@@ -0,0 +1,47 @@
+package adaptiveblocker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrackerBlockAndDecay(t *testing.T) {
+	tr := newTracker(&Spec{HalfLife: "1m", Threshold: 4, BlockDuration: "10m"})
+	defer tr.close()
+
+	now := time.Unix(1000, 0)
+	tr.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		tr.penalize("1.2.3.4", 1, "notFoundScan")
+	}
+	if blocked, _ := tr.check("1.2.3.4"); blocked {
+		t.Fatal("blocked below threshold")
+	}
+
+	// after two half lives the score dropped from 3 to 0.75
+	now = now.Add(2 * time.Minute)
+	tr.penalize("1.2.3.4", 3, "notFoundScan")
+	if blocked, _ := tr.check("1.2.3.4"); blocked {
+		t.Fatal("score did not decay")
+	}
+	tr.penalize("1.2.3.4", 1, "authFailure")
+	if blocked, _ := tr.check("1.2.3.4"); !blocked {
+		t.Fatal("not blocked at threshold")
+	}
+
+	now = now.Add(11 * time.Minute)
+	if blocked, _ := tr.check("1.2.3.4"); blocked {
+		t.Fatal("block did not expire")
+	}
+
+	// the second block lasts twice as long
+	tr.penalize("1.2.3.4", 4, "authFailure")
+	now = now.Add(15 * time.Minute)
+	if blocked, _ := tr.check("1.2.3.4"); !blocked {
+		t.Fatal("repeat block not doubled")
+	}
+	if s := tr.status(); len(s.Blocked) != 1 || s.Blocked[0].Blocks != 2 {
+		t.Fatalf("unexpected status %+v", s)
+	}
+}
@@ -0,0 +1,407 @@
+package artifactproxy
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/FucAttaCk/gateway/diskwatch"
+	"github.com/FucAttaCk/gateway/eventbus"
+	"github.com/FucAttaCk/gateway/util"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// Kind is the kind of ArtifactProxy.
+	Kind = "ArtifactProxy"
+
+	modeGeneric = "generic"
+	modeGoProxy = "goproxy"
+
+	defaultMutableTTL     = 5 * time.Minute
+	defaultTimeout        = time.Minute
+	defaultMaxSize        = 1 << 30
+	defaultChecksumSuffix = ".sha256"
+
+	resultMethodNotAllowed = "methodNotAllowed"
+	resultNotFound         = "notFound"
+	resultOriginFailed     = "originFailed"
+	resultDiskFull         = "diskFull"
+	// the client went away while the artifact was pulled
+	resultClientDisconnected = "clientDisconnected"
+)
+
+var results = []string{resultMethodNotAllowed, resultNotFound, resultOriginFailed, resultDiskFull,
+	resultClientDisconnected}
+
+func init() {
+	httppipeline.Register(&ArtifactProxy{})
+}
+
+type (
+	// Spec is the spec of ArtifactProxy.
+	Spec struct {
+		// The repository the artifacts are pulled from, e.g.
+		// https://proxy.golang.org. The request path is appended.
+		Origin string
+		// The directory the pulled artifacts are kept in.
+		CacheDir string
+		// goproxy follows the GOPROXY protocol, version files are
+		// immutable while the version lists and latest versions
+		// expire. generic treats everything as immutable but the
+		// MutablePaths. Default: generic.
+		Mode string
+		// Globs of the paths that change in generic mode, like the
+		// path.Match patterns, e.g. */maven-metadata.xml.
+		MutablePaths []string
+		// How long mutable paths are served from the cache, e.g. 1m.
+		// They are still served stale when the origin fails.
+		// Default: 5m.
+		MutableTTL string
+		// The timeout of a pull from the origin, e.g. 5m. Default: 1m.
+		Timeout string
+		// Larger artifacts aren't cached. Default: 1GiB.
+		MaxSize int64
+		// In generic mode, a request for an artifact path with this
+		// suffix is answered with the SHA-256 of the artifact, like
+		// sha256sum prints it. Default: .sha256.
+		ChecksumSuffix string
+		// While the disk holding CacheDir is below the low watermark
+		// nothing is pulled, misses are answered with 507 and the
+		// artifacts pulled longest ago are evicted until the free
+		// space is back at the high watermark.
+		DiskWatermarks *diskwatch.Spec
+	}
+
+	// ArtifactProxy fronts a module mirror or an artifact repository,
+	// it pulls artifacts from the origin once and serves them from its
+	// cache afterwards.
+	ArtifactProxy struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+		cache      *cache
+		mutableTTL time.Duration
+		disk       *diskwatch.Watcher
+		diskEvents *eventbus.Subscription[*diskwatch.Event]
+
+		hits         uint64
+		misses       uint64
+		staleServed  uint64
+		originFailed uint64
+		// requests whose client went away, they are no origin
+		// failures
+		clientDisconnects uint64
+		evicted           uint64
+	}
+
+	// Status is the status of ArtifactProxy.
+	Status struct {
+		Hits              uint64 `yaml:"hits"`
+		Misses            uint64 `yaml:"misses"`
+		StaleServed       uint64 `yaml:"staleServed"`
+		OriginFailed      uint64 `yaml:"originFailed"`
+		ClientDisconnects uint64 `yaml:"clientDisconnects"`
+		// Evicted counts the artifacts evicted to free disk space.
+		Evicted uint64 `yaml:"evicted"`
+		// Disk is set if disk watermarks are configured.
+		Disk *diskwatch.Status `yaml:"disk,omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	u, err := url.Parse(spec.Origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid origin %q", spec.Origin)
+	}
+	if spec.CacheDir == "" {
+		return fmt.Errorf("cacheDir is required")
+	}
+	switch spec.Mode {
+	case "", modeGeneric, modeGoProxy:
+	default:
+		return fmt.Errorf("invalid mode %q", spec.Mode)
+	}
+	for _, g := range spec.MutablePaths {
+		if _, err := path.Match(g, ""); err != nil {
+			return fmt.Errorf("invalid mutable path %q: %v", g, err)
+		}
+	}
+	for _, d := range []struct{ name, value string }{
+		{"mutableTTL", spec.MutableTTL},
+		{"timeout", spec.Timeout},
+	} {
+		if d.value == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d.value); err != nil || v <= 0 {
+			return fmt.Errorf("invalid %s %q", d.name, d.value)
+		}
+	}
+	if spec.DiskWatermarks != nil {
+		if err := spec.DiskWatermarks.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseDuration(s string, defaultValue time.Duration) time.Duration {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return defaultValue
+	}
+	return d
+}
+
+// Kind returns the kind of ArtifactProxy.
+func (ap *ArtifactProxy) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of ArtifactProxy.
+func (ap *ArtifactProxy) DefaultSpec() interface{} {
+	return &Spec{Mode: modeGeneric}
+}
+
+// Description returns the description of ArtifactProxy.
+func (ap *ArtifactProxy) Description() string {
+	return "ArtifactProxy caches the artifacts of a module mirror or artifact repository."
+}
+
+// Results returns the results of ArtifactProxy.
+func (ap *ArtifactProxy) Results() []string {
+	return results
+}
+
+// Init initializes ArtifactProxy.
+func (ap *ArtifactProxy) Init(filterSpec *httppipeline.FilterSpec) {
+	ap.filterSpec = filterSpec
+	ap.spec = filterSpec.FilterSpec().(*Spec)
+	ap.mutableTTL = parseDuration(ap.spec.MutableTTL, defaultMutableTTL)
+	maxSize := ap.spec.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	origin, _ := url.Parse(ap.spec.Origin)
+	ap.cache = newCache(ap.spec.CacheDir, origin, parseDuration(ap.spec.Timeout, defaultTimeout), maxSize)
+	if ap.spec.DiskWatermarks != nil {
+		// subscribed first, the watcher announces a full disk
+		// right away
+		ap.diskEvents = eventbus.Get[*diskwatch.Event](diskwatch.Topic).Subscribe(0, ap.onDiskEvent)
+		ap.disk = diskwatch.New(ap.spec.CacheDir, ap.spec.DiskWatermarks)
+	}
+}
+
+// onDiskEvent evicts artifacts when the disk of the cache is paused.
+func (ap *ArtifactProxy) onDiskEvent(ev *diskwatch.Event) {
+	if !ev.Paused || ev.Path != ap.spec.CacheDir || ev.Resume <= ev.Free {
+		return
+	}
+	n := ap.cache.evict(int64(ev.Resume - ev.Free))
+	atomic.AddUint64(&ap.evicted, uint64(n))
+	logger.Warn("disk of the artifact cache below low watermark, evicted artifacts",
+		zap.String("dir", ap.spec.CacheDir), zap.Int("evicted", n))
+}
+
+// Inherit inherits previous generation of ArtifactProxy.
+func (ap *ArtifactProxy) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	ap.Init(filterSpec)
+}
+
+// Handle handles HTTP request
+func (ap *ArtifactProxy) Handle(ctx context.HTTPContext) string {
+	res := ap.handle(ctx)
+	return ctx.CallNextHandler(res)
+}
+
+func (ap *ArtifactProxy) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	w := ctx.Response()
+	if r.Method() != http.MethodGet && r.Method() != http.MethodHead {
+		w.Header().Add("Allow", "GET, HEAD")
+		w.SetStatusCode(http.StatusMethodNotAllowed)
+		return resultMethodNotAllowed
+	}
+
+	p := path.Clean("/" + r.Path())
+	// dotfiles of the cache are its own bookkeeping
+	if p == "/" || strings.Contains(p, "/.") {
+		w.SetStatusCode(http.StatusNotFound)
+		return resultNotFound
+	}
+
+	if ap.spec.Mode != modeGoProxy {
+		suffix := ap.spec.ChecksumSuffix
+		if suffix == "" {
+			suffix = defaultChecksumSuffix
+		}
+		if artifact := strings.TrimSuffix(p, suffix); artifact != p && !ap.mutable(artifact) {
+			return ap.serveChecksum(ctx, artifact)
+		}
+	}
+
+	if res := ap.ensure(ctx, p); res != "" {
+		return res
+	}
+	return ap.serveFile(ctx, p)
+}
+
+// mutable reports whether the artifact of p may change at the origin.
+func (ap *ArtifactProxy) mutable(p string) bool {
+	if ap.spec.Mode == modeGoProxy {
+		return strings.HasSuffix(p, "/@v/list") || strings.HasSuffix(p, "/@latest") ||
+			(strings.HasPrefix(p, "/sumdb/") && strings.HasSuffix(p, "/latest"))
+	}
+	for _, g := range ap.spec.MutablePaths {
+		if matched, _ := path.Match(g, strings.TrimPrefix(p, "/")); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ensure makes sure the artifact of p is cached and fresh, it returns
+// a result if the request is answered already.
+func (ap *ArtifactProxy) ensure(ctx context.HTTPContext, p string) string {
+	w := ctx.Response()
+	info, cached := ap.cache.stat(p)
+	if cached && (!ap.mutable(p) || time.Since(info.ModTime()) < ap.mutableTTL) {
+		atomic.AddUint64(&ap.hits, 1)
+		return ""
+	}
+
+	atomic.AddUint64(&ap.misses, 1)
+	if ap.disk != nil && ap.disk.Paused() {
+		if cached {
+			atomic.AddUint64(&ap.staleServed, 1)
+			ctx.AddTag("artifact proxy: disk below low watermark, serving stale")
+			return ""
+		}
+		ctx.AddTag("artifact proxy: disk below low watermark")
+		w.SetStatusCode(http.StatusInsufficientStorage)
+		return resultDiskFull
+	}
+	stdctx := ctx.Request().Std().Context()
+	err := ap.cache.fetch(stdctx, p)
+	switch {
+	case err == nil:
+		return ""
+	case util.ClientGone(stdctx, nil):
+		atomic.AddUint64(&ap.clientDisconnects, 1)
+		ctx.AddTag("artifact proxy: client disconnected")
+		w.SetStatusCode(context.EGStatusClientClosedRequest)
+		return resultClientDisconnected
+	case errors.Is(err, errNotFound):
+		// the go command falls back to the next proxy on 404 and 410
+		w.SetStatusCode(http.StatusNotFound)
+		return resultNotFound
+	case cached:
+		atomic.AddUint64(&ap.staleServed, 1)
+		ctx.AddTag(fmt.Sprintf("artifact proxy: serving stale: %v", err))
+		return ""
+	default:
+		atomic.AddUint64(&ap.originFailed, 1)
+		logger.Warn("pull artifact failed", zap.String("path", p), zap.Error(err))
+		ctx.AddTag(fmt.Sprintf("artifact proxy: %v", err))
+		w.SetStatusCode(http.StatusBadGateway)
+		return resultOriginFailed
+	}
+}
+
+func (ap *ArtifactProxy) serveFile(ctx context.HTTPContext, p string) string {
+	w := ctx.Response()
+	f, err := os.Open(ap.cache.filename(p))
+	if err != nil {
+		ctx.AddTag(fmt.Sprintf("artifact proxy: %v", err))
+		w.SetStatusCode(http.StatusInternalServerError)
+		return resultOriginFailed
+	}
+	ctx.OnFinish(func() { f.Close() })
+
+	w.Header().Set("Content-Type", ap.contentType(p))
+	if ap.mutable(p) {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(ap.mutableTTL.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+	w.SetStatusCode(http.StatusOK)
+	if ctx.Request().Method() == http.MethodGet {
+		w.SetBody(f)
+	}
+	return ""
+}
+
+func (ap *ArtifactProxy) serveChecksum(ctx context.HTTPContext, artifact string) string {
+	if res := ap.ensure(ctx, artifact); res != "" {
+		return res
+	}
+	w := ctx.Response()
+	sum, err := ap.cache.checksum(artifact)
+	if err != nil {
+		ctx.AddTag(fmt.Sprintf("artifact proxy: checksum: %v", err))
+		w.SetStatusCode(http.StatusInternalServerError)
+		return resultOriginFailed
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.SetStatusCode(http.StatusOK)
+	if ctx.Request().Method() == http.MethodGet {
+		w.SetBody(strings.NewReader(sum + "  " + path.Base(artifact) + "\n"))
+	}
+	return ""
+}
+
+func (ap *ArtifactProxy) contentType(p string) string {
+	if ap.spec.Mode == modeGoProxy {
+		switch {
+		case strings.HasSuffix(p, ".info"), strings.HasSuffix(p, "/@latest"):
+			return "application/json"
+		case strings.HasSuffix(p, ".zip"):
+			return "application/zip"
+		}
+		return "text/plain; charset=utf-8"
+	}
+	if t := mime.TypeByExtension(filepath.Ext(p)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// Status returns Status generated by Runtime.
+func (ap *ArtifactProxy) Status() interface{} {
+	s := &Status{
+		Hits:              atomic.LoadUint64(&ap.hits),
+		Misses:            atomic.LoadUint64(&ap.misses),
+		StaleServed:       atomic.LoadUint64(&ap.staleServed),
+		OriginFailed:      atomic.LoadUint64(&ap.originFailed),
+		ClientDisconnects: atomic.LoadUint64(&ap.clientDisconnects),
+		Evicted:           atomic.LoadUint64(&ap.evicted),
+	}
+	if ap.disk != nil {
+		s.Disk = ap.disk.Status()
+	}
+	return s
+}
+
+// Close closes ArtifactProxy.
+func (ap *ArtifactProxy) Close() {
+	if ap.disk != nil {
+		ap.disk.Close()
+	}
+	if ap.diskEvents != nil {
+		ap.diskEvents.Close()
+	}
+}
@@ -0,0 +1,218 @@
+package artifactproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FucAttaCk/gateway/cryptopolicy"
+	"github.com/FucAttaCk/gateway/util"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+// errNotFound is returned for artifacts the origin doesn't have.
+var errNotFound = errors.New("not found at origin")
+
+type (
+	// cache keeps the artifacts pulled from the origin on disk, the
+	// request path is the path of the file in the cache directory.
+	cache struct {
+		dir     string
+		origin  *url.URL
+		client  *http.Client
+		maxSize int64
+
+		mu       sync.Mutex
+		inflight map[string]*pull
+	}
+
+	// pull is a pull from the origin in progress, requests for the
+	// same artifact wait for it instead of pulling again.
+	pull struct {
+		done chan struct{}
+		err  error
+	}
+)
+
+func newCache(dir string, origin *url.URL, timeout time.Duration, maxSize int64) *cache {
+	return &cache{
+		dir:      dir,
+		origin:   origin,
+		client:   cryptopolicy.NewHTTPClient(timeout),
+		maxSize:  maxSize,
+		inflight: make(map[string]*pull),
+	}
+}
+
+// filename returns the file caching the artifact of p.
+func (c *cache) filename(p string) string {
+	return util.SanitizedPathJoin(c.dir, p)
+}
+
+// stat returns the cached file of p, if any.
+func (c *cache) stat(p string) (os.FileInfo, bool) {
+	info, err := os.Stat(c.filename(p))
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, false
+	}
+	return info, true
+}
+
+// fetch pulls the artifact of p from the origin into the cache.
+// Concurrent fetches of the same artifact share one pull. It stops
+// waiting with the error of ctx when ctx is done, the pull goes on
+// for the next requests of the artifact.
+func (c *cache) fetch(ctx context.Context, p string) error {
+	c.mu.Lock()
+	pl, ok := c.inflight[p]
+	if !ok {
+		pl = &pull{done: make(chan struct{})}
+		c.inflight[p] = pl
+		go c.run(p, pl)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-pl.done:
+		return pl.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *cache) run(p string, pl *pull) {
+	pl.err = c.pull(p)
+
+	c.mu.Lock()
+	delete(c.inflight, p)
+	c.mu.Unlock()
+	close(pl.done)
+}
+
+func (c *cache) pull(p string) error {
+	u := *c.origin
+	u.Path = strings.TrimRight(u.Path, "/") + p
+	resp, err := c.client.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return errNotFound
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("origin answered %s", resp.Status)
+	}
+	if resp.ContentLength > c.maxSize {
+		return fmt.Errorf("artifact of %d bytes is too large", resp.ContentLength)
+	}
+
+	filename := c.filename(p)
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), ".pull-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, io.LimitReader(resp.Body, c.maxSize+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if n > c.maxSize {
+		return fmt.Errorf("artifact is larger than %d bytes", c.maxSize)
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return fmt.Errorf("artifact truncated at %d of %d bytes", n, resp.ContentLength)
+	}
+	// readers never see a partial artifact
+	return os.Rename(tmp.Name(), filename)
+}
+
+// checksum returns the hex encoded SHA-256 of the cached file of p,
+// it is cached next to the file and computed again when the file is
+// newer.
+func (c *cache) checksum(p string) (string, error) {
+	filename := c.filename(p)
+	info, err := os.Stat(filename)
+	if err != nil {
+		return "", err
+	}
+	sumFile := filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename)+".sha256")
+	if sumInfo, err := os.Stat(sumFile); err == nil && !sumInfo.ModTime().Before(info.ModTime()) {
+		if data, err := os.ReadFile(sumFile); err == nil {
+			return string(data), nil
+		}
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	// best effort, it is computed again otherwise
+	os.WriteFile(sumFile, []byte(sum), 0o644)
+	return sum, nil
+}
+
+// evict removes the artifacts pulled longest ago, with their
+// checksums, until n bytes are freed. It returns the number of
+// artifacts removed.
+func (c *cache) evict(n int64) int {
+	type artifact struct {
+		filename string
+		size     int64
+		modTime  time.Time
+	}
+	var artifacts []artifact
+	filepath.WalkDir(c.dir, func(filename string, d fs.DirEntry, err error) error {
+		// dotfiles are pulls in progress and checksums
+		if err != nil || !d.Type().IsRegular() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			artifacts = append(artifacts, artifact{filename, info.Size(), info.ModTime()})
+		}
+		return nil
+	})
+	sort.Slice(artifacts, func(i, j int) bool {
+		return artifacts[i].modTime.Before(artifacts[j].modTime)
+	})
+
+	removed := 0
+	for _, a := range artifacts {
+		if n <= 0 {
+			break
+		}
+		if err := os.Remove(a.filename); err != nil {
+			logger.Warn("evict artifact failed", zap.String("filename", a.filename), zap.Error(err))
+			continue
+		}
+		os.Remove(filepath.Join(filepath.Dir(a.filename), "."+filepath.Base(a.filename)+".sha256"))
+		n -= a.size
+		removed++
+	}
+	return removed
+}
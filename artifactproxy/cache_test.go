@@ -0,0 +1,142 @@
+package artifactproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	var pulls int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&pulls, 1)
+		switch r.URL.Path {
+		case "/repo/a/1.0/a-1.0.jar":
+			time.Sleep(10 * time.Millisecond)
+			w.Write([]byte("hello"))
+		case "/repo/slow.jar":
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte("slow"))
+		case "/repo/big.bin":
+			w.Write(make([]byte, 100))
+		case "/repo/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer origin.Close()
+
+	u, _ := url.Parse(origin.URL + "/repo/")
+	c := newCache(t.TempDir(), u, time.Second, 10)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.fetch(context.Background(), "/a/1.0/a-1.0.jar"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&pulls); n != 1 {
+		t.Errorf("concurrent fetches pulled %d times, want 1", n)
+	}
+	if data, err := os.ReadFile(c.filename("/a/1.0/a-1.0.jar")); err != nil || string(data) != "hello" {
+		t.Errorf("cached %q, %v", data, err)
+	}
+	if _, ok := c.stat("/a/1.0/a-1.0.jar"); !ok {
+		t.Error("artifact isn't cached")
+	}
+
+	const helloSum = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	for i := 0; i < 2; i++ {
+		if sum, err := c.checksum("/a/1.0/a-1.0.jar"); err != nil || sum != helloSum {
+			t.Errorf("checksum %q, %v", sum, err)
+		}
+	}
+
+	// a client going away stops waiting, not the pull
+	gone, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.fetch(gone, "/slow.jar"); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled fetch: %v", err)
+	}
+	if err := c.fetch(context.Background(), "/slow.jar"); err != nil {
+		t.Error(err)
+	}
+	if n := atomic.LoadInt32(&pulls); n != 2 {
+		t.Errorf("fetches after a canceled one pulled %d times, want 2", n)
+	}
+
+	if err := c.fetch(context.Background(), "/missing"); !errors.Is(err, errNotFound) {
+		t.Errorf("missing artifact: %v", err)
+	}
+	if err := c.fetch(context.Background(), "/broken"); err == nil || errors.Is(err, errNotFound) {
+		t.Errorf("broken origin: %v", err)
+	}
+	if err := c.fetch(context.Background(), "/big.bin"); err == nil {
+		t.Error("artifact over maxSize is cached")
+	}
+	if _, ok := c.stat("/big.bin"); ok {
+		t.Error("artifact over maxSize is cached")
+	}
+}
+
+func TestMutable(t *testing.T) {
+	ap := &ArtifactProxy{spec: &Spec{Mode: modeGoProxy}}
+	for p, want := range map[string]bool{
+		"/golang.org/x/net/@v/list":             true,
+		"/golang.org/x/net/@latest":             true,
+		"/golang.org/x/net/@v/v0.1.0.zip":       false,
+		"/golang.org/x/net/@v/v0.1.0.info":      false,
+		"/sumdb/sum.golang.org/latest":          true,
+		"/sumdb/sum.golang.org/tile/8/0/001":    false,
+		"/sumdb/sum.golang.org/lookup/a@v1.0.0": false,
+	} {
+		if got := ap.mutable(p); got != want {
+			t.Errorf("goproxy mutable(%q) = %v, want %v", p, got, want)
+		}
+	}
+
+	ap = &ArtifactProxy{spec: &Spec{MutablePaths: []string{"*/*/maven-metadata.xml"}}}
+	if !ap.mutable("/org/a/maven-metadata.xml") || ap.mutable("/org/a/1.0/a-1.0.jar") {
+		t.Error("generic mutable paths not matched")
+	}
+}
+
+func TestCacheEvict(t *testing.T) {
+	dir := t.TempDir()
+	c := newCache(dir, &url.URL{}, time.Second, 10)
+	now := time.Now()
+	for i, p := range []string{"/old.jar", "/a/mid.jar", "/new.jar"} {
+		filename := c.filename(p)
+		os.MkdirAll(filepath.Dir(filename), 0o755)
+		if err := os.WriteFile(filename, []byte("12345"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(filename, now, now.Add(time.Duration(i)*time.Hour))
+	}
+	if _, err := c.checksum("/old.jar"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := c.evict(6); n != 2 {
+		t.Errorf("evicted %d artifacts, want 2", n)
+	}
+	for p, want := range map[string]bool{"/old.jar": false, "/.old.jar.sha256": false, "/a/mid.jar": false, "/new.jar": true} {
+		if _, err := os.Stat(c.filename(p)); (err == nil) != want {
+			t.Errorf("%s kept: %v, want %v", p, err == nil, want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package baggage
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/openzipkin/zipkin-go"
+)
+
+const (
+	// Kind is the kind of Baggage.
+	Kind = "Baggage"
+
+	// The prefix of the span tags of baggage entries.
+	spanTagPrefix = "baggage."
+)
+
+var results []string
+
+func init() {
+	httppipeline.Register(&Baggage{})
+}
+
+type (
+	// Spec is the spec of Baggage.
+	Spec struct {
+		// The entries added to the baggage of requests.
+		Entries []*EntrySpec
+		// Globs of the keys removed from the incoming baggage, e.g.
+		// the entries only the gateway may set.
+		Strip []string
+		// Globs of the keys included in the access log tags and the
+		// trace span of requests. Default: the keys of Entries.
+		Log []string
+	}
+
+	// EntrySpec is a baggage entry added to requests.
+	EntrySpec struct {
+		Key string
+		// The value, e.g. the experiment variant this pipeline
+		// serves.
+		Value string
+		// The request header the value is taken from instead, e.g.
+		// X-Tenant. The entry isn't added if the header is absent.
+		Header string
+		// Replace the value a client sent. Default: keep it.
+		Override bool
+	}
+
+	// Baggage propagates W3C Baggage to upstreams, it adds entries
+	// like the tenant to the baggage of requests and records the
+	// entries in logs and traces. Other filters add entries with Set.
+	Baggage struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+		log        []string
+
+		invalid uint64
+		added   uint64
+	}
+
+	// Status is the status of Baggage.
+	Status struct {
+		Invalid uint64 `yaml:"invalid"`
+		Added   uint64 `yaml:"added"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	for _, e := range spec.Entries {
+		if err := e.validate(); err != nil {
+			return err
+		}
+	}
+	for _, g := range append(spec.Strip, spec.Log...) {
+		if _, err := path.Match(g, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %v", g, err)
+		}
+	}
+	return nil
+}
+
+func (spec *EntrySpec) validate() error {
+	if !isToken(spec.Key) {
+		return fmt.Errorf("invalid entry key %q", spec.Key)
+	}
+	if (spec.Value == "") == (spec.Header == "") {
+		return fmt.Errorf("entry %s: exactly one of value and header is required", spec.Key)
+	}
+	return nil
+}
+
+// Kind returns the kind of Baggage.
+func (bg *Baggage) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of Baggage.
+func (bg *Baggage) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of Baggage.
+func (bg *Baggage) Description() string {
+	return "Baggage propagates W3C Baggage upstream, adding entries and recording them in logs and traces."
+}
+
+// Results returns the results of Baggage.
+func (bg *Baggage) Results() []string {
+	return results
+}
+
+// Init initializes Baggage.
+func (bg *Baggage) Init(filterSpec *httppipeline.FilterSpec) {
+	bg.filterSpec = filterSpec
+	bg.spec = filterSpec.FilterSpec().(*Spec)
+	bg.log = bg.spec.Log
+	if len(bg.log) == 0 {
+		for _, e := range bg.spec.Entries {
+			bg.log = append(bg.log, e.Key)
+		}
+	}
+}
+
+// Inherit inherits previous generation of Baggage.
+func (bg *Baggage) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	bg.Init(filterSpec)
+}
+
+// Handle handles HTTP request
+func (bg *Baggage) Handle(ctx context.HTTPContext) string {
+	bg.handle(ctx)
+	return ctx.CallNextHandler("")
+}
+
+func (bg *Baggage) handle(ctx context.HTTPContext) {
+	r := ctx.Request()
+	b, invalid := Parse(r.Header().GetAll(HeaderName)...)
+	if invalid > 0 {
+		atomic.AddUint64(&bg.invalid, uint64(invalid))
+	}
+	if len(bg.spec.Strip) > 0 {
+		kept := b[:0]
+		for _, m := range b {
+			if !matchAny(bg.spec.Strip, m.Key) {
+				kept = append(kept, m)
+			}
+		}
+		b = kept
+	}
+
+	for _, e := range bg.spec.Entries {
+		if _, ok := b.Get(e.Key); ok && !e.Override {
+			continue
+		}
+		value := e.Value
+		if e.Header != "" {
+			value = r.Header().Get(e.Header)
+			if value == "" {
+				continue
+			}
+		}
+		b = b.Set(Member{Key: e.Key, Value: value})
+		atomic.AddUint64(&bg.added, 1)
+	}
+	// the proxy forwards the request headers upstream
+	setHeader(ctx, b)
+
+	// logged at the end, so entries set by later filters are included
+	ctx.OnFinish(func() {
+		bg.record(ctx)
+	})
+}
+
+// record adds the logged entries to the tags and the span of ctx.
+func (bg *Baggage) record(ctx context.HTTPContext) {
+	var logged []string
+	span := zipkin.SpanFromContext(ctx)
+	for _, m := range FromRequest(ctx) {
+		if !matchAny(bg.log, m.Key) {
+			continue
+		}
+		logged = append(logged, m.Key+"="+m.Value)
+		if span != nil {
+			span.Tag(spanTagPrefix+m.Key, m.Value)
+		}
+	}
+	if len(logged) > 0 {
+		sort.Strings(logged)
+		ctx.AddTag("baggage " + strings.Join(logged, ","))
+	}
+}
+
+// matchAny reports whether key matches any of globs.
+func matchAny(globs []string, key string) bool {
+	for _, g := range globs {
+		if matched, _ := path.Match(g, key); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns Status generated by Runtime.
+func (bg *Baggage) Status() interface{} {
+	return &Status{
+		Invalid: atomic.LoadUint64(&bg.invalid),
+		Added:   atomic.LoadUint64(&bg.added),
+	}
+}
+
+// Close closes Baggage.
+func (bg *Baggage) Close() {}
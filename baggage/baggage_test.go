@@ -0,0 +1,69 @@
+package baggage
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestParse(t *testing.T) {
+	b, invalid := Parse("userId=alice, serverNode = DF%2028 ;ttl=30", "bad key=1,isProduction=false,novalue")
+	if invalid != 2 {
+		t.Errorf("invalid = %d, want 2", invalid)
+	}
+	if v, _ := b.Get("serverNode"); v != "DF 28" {
+		t.Errorf("serverNode = %q, want %q", v, "DF 28")
+	}
+	if want := "userId=alice,serverNode=DF%2028;ttl=30,isProduction=false"; b.String() != want {
+		t.Errorf("String() = %q, want %q", b.String(), want)
+	}
+
+	var big List
+	for i := 0; i < 100; i++ {
+		big = append(big, Member{Key: "k" + strings.Repeat("x", i), Value: "v"})
+	}
+	if n := len(strings.Split(big.String(), ",")); n != maxMembers {
+		t.Errorf("%d members encoded, want %d", n, maxMembers)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	spec := &Spec{
+		Entries: []*EntrySpec{
+			{Key: "tenant", Header: "X-Tenant", Override: true},
+			{Key: "variant", Value: "b"},
+		},
+		Strip: []string{"internal.*"},
+	}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	bg := &Baggage{spec: spec, log: []string{"tenant", "variant"}}
+
+	header := httpheader.New(http.Header{
+		"Baggage":  {"tenant=spoofed,internal.role=admin,variant=a,trace=1"},
+		"X-Tenant": {"acme"},
+	})
+	var tags []string
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return header }
+	ctx.MockedAddTag = func(tag string) { tags = append(tags, tag) }
+
+	bg.handle(ctx)
+	if want := "tenant=acme,variant=a,trace=1"; header.Get(HeaderName) != want {
+		t.Errorf("baggage = %q, want %q", header.Get(HeaderName), want)
+	}
+
+	// entries set by later filters are forwarded and logged too
+	Set(ctx, "variant", "c d")
+	if v, _ := Get(ctx, "variant"); v != "c d" {
+		t.Errorf("variant = %q, want %q", v, "c d")
+	}
+	ctx.Finish()
+	if len(tags) != 1 || tags[0] != "baggage tenant=acme,variant=c d" {
+		t.Errorf("tags = %q", tags)
+	}
+}
@@ -0,0 +1,192 @@
+package baggage
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/context"
+)
+
+const (
+	// HeaderName is the header baggage is propagated in.
+	HeaderName = "baggage"
+
+	// The limits of the W3C Baggage spec, members beyond them are
+	// dropped so upstreams don't reject the request.
+	maxMembers = 64
+	maxBytes   = 8192
+)
+
+type (
+	// Member is one list-member of baggage.
+	Member struct {
+		Key   string
+		Value string
+		// The raw properties after the value, e.g. ttl=30, without
+		// the leading semicolon.
+		Properties string
+	}
+
+	// List is the ordered list-members of a baggage header.
+	List []Member
+)
+
+// Parse parses the values of baggage headers, malformed members are
+// skipped and counted in invalid.
+func Parse(values ...string) (b List, invalid int) {
+	for _, v := range values {
+		for _, raw := range strings.Split(v, ",") {
+			raw = strings.TrimSpace(raw)
+			if raw == "" {
+				continue
+			}
+			m, ok := parseMember(raw)
+			if !ok {
+				invalid++
+				continue
+			}
+			b = b.Set(m)
+		}
+	}
+	return b, invalid
+}
+
+func parseMember(raw string) (Member, bool) {
+	pair, props, _ := strings.Cut(raw, ";")
+	key, value, ok := strings.Cut(pair, "=")
+	if !ok {
+		return Member{}, false
+	}
+	key = strings.TrimSpace(key)
+	if !isToken(key) {
+		return Member{}, false
+	}
+	value, err := url.PathUnescape(strings.TrimSpace(value))
+	if err != nil {
+		return Member{}, false
+	}
+	return Member{Key: key, Value: value, Properties: strings.TrimSpace(props)}, true
+}
+
+// Get returns the value of key.
+func (b List) Get(key string) (string, bool) {
+	for _, m := range b {
+		if m.Key == key {
+			return m.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set returns b with m, replacing the member of the same key in place.
+func (b List) Set(m Member) List {
+	for i := range b {
+		if b[i].Key == m.Key {
+			b[i] = m
+			return b
+		}
+	}
+	return append(b, m)
+}
+
+// Del returns b without the member of key.
+func (b List) Del(key string) List {
+	result := b[:0]
+	for _, m := range b {
+		if m.Key != key {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// String encodes b as a header value within the limits of the spec.
+func (b List) String() string {
+	var sb strings.Builder
+	n := 0
+	for _, m := range b {
+		if n == maxMembers {
+			break
+		}
+		s := m.Key + "=" + escape(m.Value)
+		if m.Properties != "" {
+			s += ";" + m.Properties
+		}
+		size := len(s)
+		if n > 0 {
+			size++
+		}
+		if sb.Len()+size > maxBytes {
+			continue
+		}
+		if n > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(s)
+		n++
+	}
+	return sb.String()
+}
+
+// escape percent-encodes the bytes of value outside baggage-octet.
+func escape(value string) string {
+	const hex = "0123456789ABCDEF"
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x21 || c > 0x7e || c == '"' || c == ',' || c == ';' || c == '\\' || c == '%' {
+			sb.WriteByte('%')
+			sb.WriteByte(hex[c>>4])
+			sb.WriteByte(hex[c&0xf])
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// isToken reports whether s is an RFC 7230 token.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			continue
+		}
+		if !strings.ContainsRune("!#$%&'*+-.^_`|~", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// FromRequest returns the baggage of the request of ctx.
+func FromRequest(ctx context.HTTPContext) List {
+	b, _ := Parse(ctx.Request().Header().GetAll(HeaderName)...)
+	return b
+}
+
+// Get returns the baggage value of key of the request of ctx, so
+// filters can act on entries like the tenant.
+func Get(ctx context.HTTPContext, key string) (string, bool) {
+	return FromRequest(ctx).Get(key)
+}
+
+// Set sets the baggage entry key of the request of ctx, it is
+// forwarded upstream with the request. Filters use it to append
+// entries, e.g. the experiment variant they picked.
+func Set(ctx context.HTTPContext, key, value string) {
+	b := FromRequest(ctx).Set(Member{Key: key, Value: value})
+	setHeader(ctx, b)
+}
+
+func setHeader(ctx context.HTTPContext, b List) {
+	h := ctx.Request().Header()
+	if s := b.String(); s != "" {
+		h.Set(HeaderName, s)
+	} else {
+		h.Del(HeaderName)
+	}
+}
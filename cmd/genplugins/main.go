@@ -0,0 +1,90 @@
+// Command genplugins generates the Go file compiling the out-of-tree
+// filters listed in a plugin list into the server. The list holds one
+// import path per line, blank lines and lines starting with # are
+// ignored. The modules of the listed packages must be required in
+// go.mod, e.g. by go get.
+//
+//	go run ./cmd/genplugins -list cmd/server/plugins.txt -o cmd/server/plugins.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	list := flag.String("list", "plugins.txt", "the plugin list")
+	out := flag.String("o", "plugins.go", "the generated file")
+	pkg := flag.String("package", "main", "the package of the generated file")
+	flag.Parse()
+
+	if err := run(*list, *out, *pkg); err != nil {
+		fmt.Fprintf(os.Stderr, "genplugins: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(list, out, pkg string) error {
+	f, err := os.Open(list)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	paths, err := parseList(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", list, err)
+	}
+	src, err := generate(pkg, list, paths)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(out, src, 0o644)
+}
+
+// parseList returns the sorted, deduplicated import paths of r.
+func parseList(r io.Reader) ([]string, error) {
+	seen := map[string]bool{}
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		p := strings.TrimSpace(scanner.Text())
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+		if strings.ContainsAny(p, " \t\"") {
+			return nil, fmt.Errorf("line %d: invalid import path %q", line, p)
+		}
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func generate(pkg, list string, paths []string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "// Code generated by genplugins from %s. DO NOT EDIT.\n\n", list)
+	fmt.Fprintf(buf, "package %s\n", pkg)
+	if len(paths) > 0 {
+		buf.WriteString("\nimport (\n")
+		for _, p := range paths {
+			fmt.Fprintf(buf, "\t_ %s\n", strconv.Quote(p))
+		}
+		buf.WriteString(")\n")
+	}
+	return format.Source(buf.Bytes())
+}
@@ -0,0 +1,99 @@
+// Command gwtest runs declarative contract tests against a gateway,
+// it exits with 1 if any case fails. The cases run against a live
+// gateway:
+//
+//	go run ./cmd/gwtest -url http://localhost:10080 tests/*.yaml
+//
+// or against the filters of an HTTPPipeline spec in process, which
+// may use the filters of this repository:
+//
+//	go run ./cmd/gwtest -pipeline pipeline.yaml tests/*.yaml
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	_ "github.com/FucAttaCk/gateway/adaptiveblocker"
+	_ "github.com/FucAttaCk/gateway/artifactproxy"
+	_ "github.com/FucAttaCk/gateway/baggage"
+	_ "github.com/FucAttaCk/gateway/enroll"
+	_ "github.com/FucAttaCk/gateway/esi"
+	_ "github.com/FucAttaCk/gateway/expectcontinue"
+	_ "github.com/FucAttaCk/gateway/fileserver"
+	"github.com/FucAttaCk/gateway/gwtest"
+	_ "github.com/FucAttaCk/gateway/ipreputation"
+	_ "github.com/FucAttaCk/gateway/journal"
+	_ "github.com/FucAttaCk/gateway/longpoll"
+	_ "github.com/FucAttaCk/gateway/prerender"
+	_ "github.com/FucAttaCk/gateway/presign"
+	_ "github.com/FucAttaCk/gateway/querynorm"
+	_ "github.com/FucAttaCk/gateway/responsediff"
+	_ "github.com/FucAttaCk/gateway/tokenservice"
+	_ "github.com/FucAttaCk/gateway/webdav"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func main() {
+	baseURL := flag.String("url", "", "the base URL of a running gateway")
+	pipeline := flag.String("pipeline", "", "an HTTPPipeline spec to run the cases against in process")
+	verbose := flag.Bool("v", false, "print the passed cases too")
+	flag.Parse()
+
+	ok, err := run(*baseURL, *pipeline, *verbose, flag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gwtest: %v\n", err)
+		os.Exit(2)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func run(baseURL, pipeline string, verbose bool, files []string) (bool, error) {
+	if (baseURL == "") == (pipeline == "") {
+		return false, fmt.Errorf("exactly one of -url and -pipeline is required")
+	}
+	if len(files) == 0 {
+		return false, fmt.Errorf("no case files")
+	}
+	suites := make([]*gwtest.Suite, 0, len(files))
+	for _, f := range files {
+		s, err := gwtest.Load(f)
+		if err != nil {
+			return false, err
+		}
+		suites = append(suites, s)
+	}
+
+	transport := http.DefaultTransport
+	if pipeline != "" {
+		logger.InitNop()
+		p, err := gwtest.LoadPipeline(pipeline)
+		if err != nil {
+			return false, err
+		}
+		defer p.Close()
+		transport = gwtest.HandlerTransport(p)
+		baseURL = "http://gateway.test"
+	}
+
+	passed, failed := 0, 0
+	for _, s := range suites {
+		for _, r := range gwtest.Run(s, baseURL, transport) {
+			if r.Passed() {
+				passed++
+				if !verbose {
+					continue
+				}
+			} else {
+				failed++
+			}
+			fmt.Println(r)
+		}
+	}
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
+	return failed == 0, nil
+}
@@ -15,14 +15,34 @@
  * limitations under the License.
  */
 
+//go:generate go run ../genplugins -list plugins.txt -o plugins.go
+
 package main
 
 import (
 	"log"
 	"os"
+	"strconv"
 	"sync"
 
+	_ "github.com/FucAttaCk/gateway/adaptiveblocker"
+	_ "github.com/FucAttaCk/gateway/artifactproxy"
+	_ "github.com/FucAttaCk/gateway/baggage"
+	"github.com/FucAttaCk/gateway/cryptopolicy"
+	_ "github.com/FucAttaCk/gateway/enroll"
+	_ "github.com/FucAttaCk/gateway/esi"
+	_ "github.com/FucAttaCk/gateway/expectcontinue"
 	_ "github.com/FucAttaCk/gateway/fileserver"
+	_ "github.com/FucAttaCk/gateway/ipreputation"
+	_ "github.com/FucAttaCk/gateway/journal"
+	_ "github.com/FucAttaCk/gateway/longpoll"
+	_ "github.com/FucAttaCk/gateway/prerender"
+	_ "github.com/FucAttaCk/gateway/presign"
+	_ "github.com/FucAttaCk/gateway/querynorm"
+	_ "github.com/FucAttaCk/gateway/responsediff"
+	_ "github.com/FucAttaCk/gateway/tokenservice"
+	_ "github.com/FucAttaCk/gateway/webdav"
+	"github.com/coreos/go-systemd/v22/daemon"
 	"github.com/megaease/easegress/pkg/api"
 	"github.com/megaease/easegress/pkg/cluster"
 	"github.com/megaease/easegress/pkg/common"
@@ -56,6 +76,11 @@ func main() {
 	logger.Init(opt)
 	defer logger.Sync()
 	logger.Infof("%s", version.Long)
+	if cryptopolicy.Strict {
+		posture := cryptopolicy.CurrentPosture()
+		logger.Infof("crypto policy %s: tls %s, cipher suites %v, signature algorithms %v",
+			posture.Mode, posture.TLSMinVersion, posture.CipherSuites, posture.SignatureAlgorithms)
+	}
 
 	if opt.SignalUpgrade {
 		pid, err := pidfile.Read(opt)
@@ -101,11 +126,19 @@ func main() {
 
 	apiServer := api.MustNewServer(opt, cls, super, profile)
 
+	go func() {
+		<-super.FirstHandleDone()
+		// MAINPID lets systemd follow the process across graceful updates,
+		// it requires NotifyAccess=all in the unit file.
+		sdNotify(daemon.SdNotifyReady + "\nMAINPID=" + strconv.Itoa(os.Getpid()))
+	}()
+
 	if graceupdate.CallOriProcessTerm(super.FirstHandleDone()) {
 		pidfile.Write(opt)
 	}
 
 	closeCls := func() {
+		sdNotify(daemon.SdNotifyReloading)
 		wg := &sync.WaitGroup{}
 		wg.Add(2)
 		apiServer.Close(wg)
@@ -115,6 +148,7 @@ func main() {
 	restartCls := func() {
 		cls.StartServer()
 		apiServer = api.MustNewServer(opt, cls, super, profile)
+		sdNotify(daemon.SdNotifyReady)
 	}
 	if err := graceupdate.NotifySigUsr2(closeCls, restartCls); err != nil {
 		log.Printf("failed to notify signal: %v", err)
@@ -133,6 +167,7 @@ func main() {
 		os.Exit(255)
 	}()
 	logger.Infof("%s signal received, closing easegress", sig)
+	sdNotify(daemon.SdNotifyStopping)
 
 	wg := &sync.WaitGroup{}
 	wg.Add(4)
@@ -142,3 +177,11 @@ func main() {
 	profile.Close(wg)
 	wg.Wait()
 }
+
+// sdNotify reports the service state to systemd, it does nothing
+// when the process is not started by systemd with Type=notify.
+func sdNotify(state string) {
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		logger.Warnf("sd_notify %q failed: %v", state, err)
+	}
+}
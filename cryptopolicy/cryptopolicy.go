@@ -0,0 +1,118 @@
+// Package cryptopolicy restricts the cryptography used by the gateway.
+// Builds with the fips tag only allow an approved set of TLS versions,
+// cipher suites and signature algorithms, and configurations asking for
+// anything else are rejected when they are validated.
+//
+// The policy constrains configuration only, it doesn't replace the Go
+// crypto implementation with a validated module.
+package cryptopolicy
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Posture describes the active policy.
+type Posture struct {
+	Mode                string   `yaml:"mode"`
+	TLSMinVersion       string   `yaml:"tlsMinVersion"`
+	CipherSuites        []string `yaml:"cipherSuites"`
+	SignatureAlgorithms []string `yaml:"signatureAlgorithms"`
+}
+
+var (
+	approvedCipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}
+	approvedCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+
+	approvedSignatureAlgorithms = map[string]bool{
+		"HS256": true, "HS384": true, "HS512": true,
+		"ES256": true, "ES384": true,
+		"PS256": true, "RS256": true,
+	}
+)
+
+// CheckSignatureAlgorithm returns an error if the JWS algorithm is not
+// approved by the policy.
+func CheckSignatureAlgorithm(alg string) error {
+	if Strict && !approvedSignatureAlgorithms[alg] {
+		return fmt.Errorf("signature algorithm %s is not allowed by the %s crypto policy", alg, mode())
+	}
+	return nil
+}
+
+// CheckPublicKey returns an error if the type of key is not approved
+// by the policy, e.g. for the keys of certificates the gateway issues.
+func CheckPublicKey(key crypto.PublicKey) error {
+	if _, ok := key.(ed25519.PublicKey); Strict && ok {
+		return fmt.Errorf("Ed25519 keys are not allowed by the %s crypto policy", mode())
+	}
+	return nil
+}
+
+// CheckInsecureSkipVerify returns an error if skipping certificate
+// verification is not allowed by the policy.
+func CheckInsecureSkipVerify(skip bool) error {
+	if Strict && skip {
+		return fmt.Errorf("skipping certificate verification is not allowed by the %s crypto policy", mode())
+	}
+	return nil
+}
+
+// ApplyTLS restricts conf to the approved versions, cipher suites and
+// curves.
+func ApplyTLS(conf *tls.Config) {
+	if !Strict {
+		return
+	}
+	// the cipher suites of TLS 1.3 can't be restricted, so stay
+	// on TLS 1.2
+	conf.MinVersion = tls.VersionTLS12
+	conf.MaxVersion = tls.VersionTLS12
+	conf.CipherSuites = approvedCipherSuites
+	conf.CurvePreferences = approvedCurves
+}
+
+// NewHTTPClient returns an HTTP client with timeout whose TLS
+// connections are restricted by the policy. Every client the gateway
+// creates to talk to other services should come from here.
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	tlsConf := &tls.Config{}
+	ApplyTLS(tlsConf)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConf
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// CurrentPosture returns the active policy.
+func CurrentPosture() *Posture {
+	p := &Posture{Mode: mode()}
+	if !Strict {
+		return p
+	}
+	p.TLSMinVersion = "1.2"
+	for _, id := range approvedCipherSuites {
+		p.CipherSuites = append(p.CipherSuites, tls.CipherSuiteName(id))
+	}
+	for alg := range approvedSignatureAlgorithms {
+		p.SignatureAlgorithms = append(p.SignatureAlgorithms, alg)
+	}
+	sort.Strings(p.SignatureAlgorithms)
+	return p
+}
+
+func mode() string {
+	if Strict {
+		return "fips"
+	}
+	return "default"
+}
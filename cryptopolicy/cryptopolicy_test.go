@@ -0,0 +1,33 @@
+package cryptopolicy
+
+import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPolicy(t *testing.T) {
+	conf := NewHTTPClient(time.Second).Transport.(*http.Transport).TLSClientConfig
+	err := CheckSignatureAlgorithm("none")
+	edKey, _, _ := ed25519.GenerateKey(nil)
+	if Strict {
+		if conf.MinVersion != tls.VersionTLS12 || len(conf.CipherSuites) == 0 {
+			t.Errorf("tls config not restricted")
+		}
+		if err == nil {
+			t.Errorf("algorithm none allowed")
+		}
+		if CheckInsecureSkipVerify(true) == nil {
+			t.Errorf("insecure skip verify allowed")
+		}
+		if CheckPublicKey(edKey) == nil {
+			t.Errorf("Ed25519 key allowed")
+		}
+		return
+	}
+	if conf.MinVersion != 0 || err != nil || CheckInsecureSkipVerify(true) != nil || CheckPublicKey(edKey) != nil {
+		t.Errorf("default policy restricts configuration")
+	}
+}
@@ -0,0 +1,6 @@
+//go:build fips
+
+package cryptopolicy
+
+// Strict is true in builds with the fips tag.
+const Strict = true
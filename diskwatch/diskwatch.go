@@ -0,0 +1,220 @@
+// Package diskwatch watches the free space of the file system holding
+// a path against low and high watermarks, so the filters writing to
+// disk can pause before the disk fills up and resume once space is
+// freed.
+package diskwatch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/FucAttaCk/gateway/eventbus"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+// Topic is the event bus topic announcing watermark crossings, so disk
+// caches can evict aggressively while a disk is paused.
+const Topic = "diskwatch.watermark"
+
+const (
+	defaultInterval = 10 * time.Second
+	defaultLow      = "5%"
+)
+
+type (
+	// Spec describes the watermarks of the disk holding a path. The
+	// watermarks are sizes like 512MiB or 10GiB, or percentages of the
+	// disk like 10%.
+	Spec struct {
+		// Writing pauses when the free space drops below this.
+		// Default: 5%.
+		LowWatermark string
+		// Writing resumes when the free space is back above this.
+		// Default: LowWatermark.
+		HighWatermark string
+		// How often the free space is checked, e.g. 30s. Default: 10s.
+		Interval string
+	}
+
+	// Event is published on Topic when a watcher pauses or resumes.
+	Event struct {
+		Path   string
+		Paused bool
+		Free   uint64
+		Total  uint64
+		// Resume is the free space writes resume at, the high
+		// watermark in bytes.
+		Resume uint64
+	}
+
+	// Watcher checks the free space of a path in the background.
+	Watcher struct {
+		path      string
+		low, high watermark
+		paused    int32
+		free      uint64
+		total     uint64
+		done      chan struct{}
+		wg        sync.WaitGroup
+	}
+
+	// Status is the status of a Watcher.
+	Status struct {
+		Path   string `yaml:"path"`
+		Paused bool   `yaml:"paused"`
+		Free   uint64 `yaml:"free"`
+		Total  uint64 `yaml:"total"`
+	}
+
+	watermark struct {
+		bytes   uint64
+		percent float64
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	for _, w := range []string{spec.LowWatermark, spec.HighWatermark} {
+		if w == "" {
+			continue
+		}
+		if _, err := parseWatermark(w); err != nil {
+			return err
+		}
+	}
+	if spec.Interval != "" {
+		if d, err := time.ParseDuration(spec.Interval); err != nil || d <= 0 {
+			return fmt.Errorf("invalid interval %q", spec.Interval)
+		}
+	}
+	return nil
+}
+
+var units = []struct {
+	suffix string
+	size   uint64
+}{
+	{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+	{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3}, {"B", 1},
+}
+
+func parseWatermark(s string) (watermark, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || p < 0 || p > 100 {
+			return watermark{}, fmt.Errorf("invalid watermark %q", s)
+		}
+		return watermark{percent: p}, nil
+	}
+	size := uint64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, size = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return watermark{}, fmt.Errorf("invalid watermark %q", s)
+	}
+	return watermark{bytes: n * size}, nil
+}
+
+func (w watermark) bytesOf(total uint64) uint64 {
+	if w.percent > 0 {
+		return uint64(float64(total) * w.percent / 100)
+	}
+	return w.bytes
+}
+
+// New starts watching the disk holding path.
+func New(path string, spec *Spec) *Watcher {
+	low, err := parseWatermark(spec.LowWatermark)
+	if spec.LowWatermark == "" || err != nil {
+		low, _ = parseWatermark(defaultLow)
+	}
+	high := low
+	if spec.HighWatermark != "" {
+		if h, err := parseWatermark(spec.HighWatermark); err == nil {
+			high = h
+		}
+	}
+	interval := defaultInterval
+	if d, err := time.ParseDuration(spec.Interval); err == nil && d > 0 {
+		interval = d
+	}
+
+	w := &Watcher{path: path, low: low, high: high, done: make(chan struct{})}
+	w.check()
+	w.wg.Add(1)
+	go w.run(interval)
+	return w
+}
+
+func (w *Watcher) run(interval time.Duration) {
+	defer w.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *Watcher) check() {
+	free, total, err := diskUsage(w.path)
+	if err != nil {
+		logger.Warn("check free disk space failed", zap.String("path", w.path), zap.Error(err))
+		return
+	}
+	atomic.StoreUint64(&w.free, free)
+	atomic.StoreUint64(&w.total, total)
+
+	switch {
+	case free < w.low.bytesOf(total) && atomic.CompareAndSwapInt32(&w.paused, 0, 1):
+		logger.Warn("free disk space below low watermark, pausing writes",
+			zap.String("path", w.path), zap.Uint64("free", free), zap.Uint64("total", total))
+	case free >= w.high.bytesOf(total) && atomic.CompareAndSwapInt32(&w.paused, 1, 0):
+		logger.Info("free disk space above high watermark, resuming writes",
+			zap.String("path", w.path), zap.Uint64("free", free), zap.Uint64("total", total))
+	default:
+		return
+	}
+	topic().Publish(&Event{Path: w.path, Paused: w.Paused(), Free: free, Total: total, Resume: w.high.bytesOf(total)})
+}
+
+func topic() *eventbus.Topic[*Event] {
+	return eventbus.Get[*Event](Topic)
+}
+
+// Paused reports whether the free space dropped below the low
+// watermark and didn't get back above the high one yet.
+func (w *Watcher) Paused() bool {
+	return atomic.LoadInt32(&w.paused) == 1
+}
+
+// Status returns the status of the watcher.
+func (w *Watcher) Status() *Status {
+	return &Status{
+		Path:   w.path,
+		Paused: w.Paused(),
+		Free:   atomic.LoadUint64(&w.free),
+		Total:  atomic.LoadUint64(&w.total),
+	}
+}
+
+// Close stops the watcher.
+func (w *Watcher) Close() {
+	close(w.done)
+	w.wg.Wait()
+}
@@ -0,0 +1,47 @@
+package diskwatch
+
+import "testing"
+
+func TestParseWatermark(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want uint64
+	}{
+		{"10%", 100},
+		{"512MiB", 512 << 20},
+		{"2 GB", 2e9},
+		{"4096", 4096},
+	} {
+		w, err := parseWatermark(c.s)
+		if err != nil {
+			t.Fatalf("%s: %v", c.s, err)
+		}
+		if got := w.bytesOf(1000); got != c.want {
+			t.Errorf("%s: %d bytes, want %d", c.s, got, c.want)
+		}
+	}
+	for _, s := range []string{"", "150%", "-1", "10XB"} {
+		if _, err := parseWatermark(s); err == nil {
+			t.Errorf("invalid watermark %q accepted", s)
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	events := make(chan *Event, 1)
+	sub := topic().Subscribe(0, func(ev *Event) { events <- ev })
+	defer sub.Close()
+
+	dir := t.TempDir()
+	w := New(dir, &Spec{LowWatermark: "100%"})
+	defer w.Close()
+	if w.Status().Total == 0 {
+		t.Skip("free disk space not available")
+	}
+	if !w.Paused() {
+		t.Fatal("not paused below the low watermark")
+	}
+	if ev := <-events; !ev.Paused || ev.Path != dir || ev.Resume != ev.Total {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+}
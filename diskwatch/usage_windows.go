@@ -0,0 +1,18 @@
+//go:build windows
+
+package diskwatch
+
+import "golang.org/x/sys/windows"
+
+// diskUsage returns the space available to the caller and the size of
+// the volume holding path.
+func diskUsage(path string) (free, total uint64, err error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := windows.GetDiskFreeSpaceEx(p, &free, &total, nil); err != nil {
+		return 0, 0, err
+	}
+	return free, total, nil
+}
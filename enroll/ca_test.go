@@ -0,0 +1,93 @@
+package enroll
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCA(t *testing.T) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "devices CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func newCSR(t *testing.T, key interface{}, cn string) []byte {
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: []string{"device-1.example.com"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestSign(t *testing.T) {
+	c, err := loadCA(writeCA(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der := newCSR(t, key, "device-1")
+
+	// EST sends base64 encoded DER
+	csr, err := parseCSR([]byte(base64.StdEncoding.EncodeToString(der)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := c.sign(csr, 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.NotAfter.Equal(c.cert.NotAfter) {
+		t.Errorf("not after = %v, want capped to the CA's %v", cert.NotAfter, c.cert.NotAfter)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(c.cert)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("verify issued certificate: %v", err)
+	}
+	if cert.Subject.CommonName != "device-1" || len(cert.DNSNames) != 1 {
+		t.Errorf("subject = %v, DNS names = %v", cert.Subject, cert.DNSNames)
+	}
+
+	weak, _ := rsa.GenerateKey(rand.Reader, 1024)
+	if _, err := parseCSR(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: newCSR(t, weak, "weak")})); err == nil {
+		t.Error("1024 bit RSA key accepted")
+	}
+	if _, err := parseCSR(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: newCSR(t, key, "")})); err == nil {
+		t.Error("request without common name accepted")
+	}
+}
@@ -0,0 +1,425 @@
+package enroll
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/FucAttaCk/gateway/cryptopolicy"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// Kind is the kind of CertEnrollment.
+	Kind = "CertEnrollment"
+
+	defaultPathPrefix     = "/.well-known/est"
+	defaultValidity       = 30 * 24 * time.Hour
+	defaultWebhookTimeout = 10 * time.Second
+	maxRequestSize        = 64 << 10
+
+	operationEnroll   = "simpleenroll"
+	operationReenroll = "simplereenroll"
+
+	contentTypePEM = "application/pem-certificate-chain"
+
+	resultMethodNotAllowed = "methodNotAllowed"
+	resultInvalidRequest   = "invalidRequest"
+	resultDenied           = "denied"
+	resultFailed           = "failed"
+)
+
+var results = []string{resultMethodNotAllowed, resultInvalidRequest, resultDenied, resultFailed}
+
+func init() {
+	httppipeline.Register(&CertEnrollment{})
+}
+
+type (
+	// Spec is the spec of CertEnrollment.
+	Spec struct {
+		// The PEM files of the CA issuing the client certificates,
+		// configure the same certificate as the client CA of the
+		// servers verifying them.
+		CACertFile string
+		CAKeyFile  string
+		// The prefix of the endpoints: cacerts, simpleenroll and
+		// simplereenroll. Default: /.well-known/est.
+		PathPrefix string
+		// How long issued certificates are valid, e.g. 168h, at
+		// most as long as the CA. Default: 720h.
+		Validity string
+		// Enrollments are posted to this URL as JSON, and approved if
+		// it answers 2xx.
+		ApprovalWebhook string
+		// Default: 10s.
+		WebhookTimeout string
+		// Approve all enrollments without a webhook, only for
+		// networks where any device may enroll.
+		AutoApprove bool
+		// The file audit records of all enrollments are appended to,
+		// one JSON object per line.
+		AuditFile string
+	}
+
+	// CertEnrollment lets managed devices obtain client certificates
+	// with a minimal subset of EST (RFC 7030): certificate requests and
+	// certificates are exchanged PEM encoded, without PKCS#7.
+	CertEnrollment struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+		ca         *ca
+		validity   time.Duration
+		client     *http.Client
+
+		mu    sync.Mutex
+		audit *os.File
+
+		issued uint64
+		denied uint64
+	}
+
+	// Status is the status of CertEnrollment.
+	Status struct {
+		Issued uint64 `yaml:"issued"`
+		Denied uint64 `yaml:"denied"`
+	}
+
+	// Enrollment is what the approval webhook receives.
+	Enrollment struct {
+		Operation      string   `json:"operation"`
+		Subject        string   `json:"subject"`
+		DNSNames       []string `json:"dnsNames,omitempty"`
+		EmailAddresses []string `json:"emailAddresses,omitempty"`
+		URIs           []string `json:"uris,omitempty"`
+		// The hex encoded SHA-256 of the public key.
+		KeyID    string `json:"keyId"`
+		ClientIP string `json:"clientIP"`
+	}
+
+	// AuditRecord records the outcome of an enrollment.
+	AuditRecord struct {
+		Time time.Time `json:"time"`
+		Enrollment
+		Approved bool      `json:"approved"`
+		Reason   string    `json:"reason,omitempty"`
+		Serial   string    `json:"serial,omitempty"`
+		NotAfter time.Time `json:"notAfter,omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if _, err := loadCA(spec.CACertFile, spec.CAKeyFile); err != nil {
+		return fmt.Errorf("invalid CA: %v", err)
+	}
+	if spec.ApprovalWebhook == "" && !spec.AutoApprove {
+		return fmt.Errorf("approvalWebhook is required unless autoApprove is set")
+	}
+	if spec.ApprovalWebhook != "" {
+		u, err := url.Parse(spec.ApprovalWebhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid approval webhook %q", spec.ApprovalWebhook)
+		}
+	}
+	for _, d := range []struct{ name, value string }{
+		{"validity", spec.Validity},
+		{"webhookTimeout", spec.WebhookTimeout},
+	} {
+		if d.value == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d.value); err != nil || v <= 0 {
+			return fmt.Errorf("invalid %s %q", d.name, d.value)
+		}
+	}
+	if spec.AuditFile == "" {
+		return fmt.Errorf("auditFile is required")
+	}
+	return nil
+}
+
+func parseDuration(s string, defaultValue time.Duration) time.Duration {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return defaultValue
+	}
+	return d
+}
+
+// Kind returns the kind of CertEnrollment.
+func (ce *CertEnrollment) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of CertEnrollment.
+func (ce *CertEnrollment) DefaultSpec() interface{} {
+	return &Spec{PathPrefix: defaultPathPrefix}
+}
+
+// Description returns the description of CertEnrollment.
+func (ce *CertEnrollment) Description() string {
+	return "CertEnrollment issues client certificates to devices through a minimal EST endpoint."
+}
+
+// Results returns the results of CertEnrollment.
+func (ce *CertEnrollment) Results() []string {
+	return results
+}
+
+// Init initializes CertEnrollment.
+func (ce *CertEnrollment) Init(filterSpec *httppipeline.FilterSpec) {
+	ce.filterSpec = filterSpec
+	ce.spec = filterSpec.FilterSpec().(*Spec)
+	ce.validity = parseDuration(ce.spec.Validity, defaultValidity)
+	ce.client = cryptopolicy.NewHTTPClient(parseDuration(ce.spec.WebhookTimeout, defaultWebhookTimeout))
+
+	var err error
+	// the spec is validated already, but the files may have changed
+	if ce.ca, err = loadCA(ce.spec.CACertFile, ce.spec.CAKeyFile); err != nil {
+		logger.Error("load enrollment CA failed", zap.Error(err))
+	}
+	ce.audit, err = os.OpenFile(ce.spec.AuditFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		logger.Error("open enrollment audit file failed", zap.String("file", ce.spec.AuditFile), zap.Error(err))
+	}
+}
+
+// Inherit inherits previous generation of CertEnrollment.
+func (ce *CertEnrollment) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	ce.Init(filterSpec)
+}
+
+// Handle handles HTTP request
+func (ce *CertEnrollment) Handle(ctx context.HTTPContext) string {
+	res := ce.handle(ctx)
+	return ctx.CallNextHandler(res)
+}
+
+func (ce *CertEnrollment) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	w := ctx.Response()
+	prefix := strings.TrimRight(ce.spec.PathPrefix, "/")
+	if prefix == "" {
+		prefix = defaultPathPrefix
+	}
+
+	switch operation := strings.TrimPrefix(r.Path(), prefix+"/"); operation {
+	case "cacerts":
+		if r.Method() != http.MethodGet {
+			w.Header().Add("Allow", "GET")
+			w.SetStatusCode(http.StatusMethodNotAllowed)
+			return resultMethodNotAllowed
+		}
+		if ce.ca == nil {
+			w.SetStatusCode(http.StatusServiceUnavailable)
+			return resultFailed
+		}
+		w.Header().Set("Content-Type", contentTypePEM)
+		w.SetBody(bytes.NewReader(ce.ca.certPEM))
+		return ""
+	case operationEnroll, operationReenroll:
+		if r.Method() != http.MethodPost {
+			w.Header().Add("Allow", "POST")
+			w.SetStatusCode(http.StatusMethodNotAllowed)
+			return resultMethodNotAllowed
+		}
+		return ce.enroll(ctx, operation)
+	default:
+		w.SetStatusCode(http.StatusNotFound)
+		return resultInvalidRequest
+	}
+}
+
+func (ce *CertEnrollment) enroll(ctx context.HTTPContext, operation string) string {
+	r := ctx.Request()
+	w := ctx.Response()
+	if ce.ca == nil {
+		w.SetStatusCode(http.StatusServiceUnavailable)
+		return resultFailed
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body(), maxRequestSize))
+	if err != nil {
+		w.SetStatusCode(http.StatusBadRequest)
+		return resultInvalidRequest
+	}
+	csr, err := parseCSR(body)
+	if err != nil {
+		ctx.AddTag(fmt.Sprintf("enroll: invalid certificate request: %v", err))
+		w.SetStatusCode(http.StatusBadRequest)
+		w.SetBody(strings.NewReader(err.Error()))
+		return resultInvalidRequest
+	}
+
+	rec := &AuditRecord{
+		Time: time.Now(),
+		Enrollment: Enrollment{
+			Operation:      operation,
+			Subject:        csr.Subject.String(),
+			DNSNames:       csr.DNSNames,
+			EmailAddresses: csr.EmailAddresses,
+			URIs:           uriStrings(csr.URIs),
+			KeyID:          keyID(csr),
+			ClientIP:       r.RealIP(),
+		},
+	}
+
+	if operation == operationReenroll {
+		rec.Approved, rec.Reason = ce.verifyReenroll(r.Std(), csr)
+	}
+	if !rec.Approved {
+		approved, reason := ce.approve(&rec.Enrollment)
+		if operation == operationReenroll {
+			// the audit tells why it was approved like a new enrollment
+			why := "re-enrollment not verified: " + rec.Reason
+			if reason != "" {
+				why += ", " + reason
+			}
+			reason = why
+		}
+		rec.Approved, rec.Reason = approved, reason
+	}
+	if !rec.Approved {
+		atomic.AddUint64(&ce.denied, 1)
+		ce.record(rec)
+		ctx.AddTag(fmt.Sprintf("enroll: denied: %s", rec.Reason))
+		w.SetStatusCode(http.StatusForbidden)
+		return resultDenied
+	}
+
+	cert, err := ce.ca.sign(csr, ce.validity)
+	if err != nil {
+		rec.Approved, rec.Reason = false, fmt.Sprintf("sign failed: %v", err)
+		ce.record(rec)
+		logger.Error("sign client certificate failed", zap.String("subject", rec.Subject), zap.Error(err))
+		w.SetStatusCode(http.StatusInternalServerError)
+		return resultFailed
+	}
+	rec.Serial = cert.SerialNumber.Text(16)
+	rec.NotAfter = cert.NotAfter
+	atomic.AddUint64(&ce.issued, 1)
+	ce.record(rec)
+
+	w.Header().Set("Content-Type", contentTypePEM)
+	w.SetBody(bytes.NewReader(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+	return ""
+}
+
+// approve asks the webhook whether the enrollment is approved.
+func (ce *CertEnrollment) approve(e *Enrollment) (bool, string) {
+	if ce.spec.ApprovalWebhook == "" {
+		return ce.spec.AutoApprove, "auto approved"
+	}
+	data, _ := json.Marshal(e)
+	resp, err := ce.client.Post(ce.spec.ApprovalWebhook, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Sprintf("webhook failed: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxRequestSize))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return false, fmt.Sprintf("webhook answered %s", resp.Status)
+	}
+	return true, ""
+}
+
+// verifyReenroll approves renewing a certificate without the webhook
+// when the client presents a valid certificate of the CA for the same
+// subject and subject alternative names, anything else has to be
+// approved like a new enrollment. It returns why it doesn't approve.
+func (ce *CertEnrollment) verifyReenroll(r *http.Request, csr *x509.CertificateRequest) (bool, string) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return false, "no client certificate"
+	}
+	peer := r.TLS.PeerCertificates[0]
+	roots := x509.NewCertPool()
+	roots.AddCert(ce.ca.cert)
+	_, err := peer.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return false, fmt.Sprintf("client certificate: %v", err)
+	}
+	if peer.Subject.String() != csr.Subject.String() {
+		return false, "subject differs from the client certificate"
+	}
+	if !sameStrings(peer.DNSNames, csr.DNSNames) ||
+		!sameStrings(peer.EmailAddresses, csr.EmailAddresses) ||
+		!sameStrings(uriStrings(peer.URIs), uriStrings(csr.URIs)) {
+		return false, "subject alternative names differ from the client certificate"
+	}
+	return true, ""
+}
+
+func uriStrings(uris []*url.URL) []string {
+	s := make([]string, len(uris))
+	for i, u := range uris {
+		s[i] = u.String()
+	}
+	return s
+}
+
+// sameStrings reports whether a and b hold the same strings, in any
+// order.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := make(map[string]int, len(a))
+	for _, s := range a {
+		count[s]++
+	}
+	for _, s := range b {
+		if count[s] == 0 {
+			return false
+		}
+		count[s]--
+	}
+	return true
+}
+
+func (ce *CertEnrollment) record(rec *AuditRecord) {
+	if ce.audit == nil {
+		return
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+	if _, err := ce.audit.Write(append(data, '\n')); err != nil {
+		logger.Error("write enrollment audit record failed", zap.Error(err))
+	}
+}
+
+// Status returns Status generated by Runtime.
+func (ce *CertEnrollment) Status() interface{} {
+	return &Status{
+		Issued: atomic.LoadUint64(&ce.issued),
+		Denied: atomic.LoadUint64(&ce.denied),
+	}
+}
+
+// Close closes CertEnrollment.
+func (ce *CertEnrollment) Close() {
+	if ce.audit != nil {
+		ce.audit.Close()
+	}
+}
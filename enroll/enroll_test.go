@@ -0,0 +1,130 @@
+package enroll
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestVerifyReenroll(t *testing.T) {
+	issuer, err := loadCA(writeCA(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ce := &CertEnrollment{ca: issuer}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	spiffe, _ := url.Parse("spiffe://example.com/device-1")
+
+	request := func(dnsNames []string, uris []*url.URL) *x509.CertificateRequest {
+		der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: "device-1"},
+			DNSNames: dnsNames,
+			URIs:     uris,
+		}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return csr
+	}
+	peer, err := issuer.sign(request([]string{"a.example.com", "b.example.com"}, []*url.URL{spiffe}), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/.well-known/est/simplereenroll", nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{peer}}
+
+	other, _ := url.Parse("spiffe://example.com/admin")
+	for _, c := range []struct {
+		name     string
+		csr      *x509.CertificateRequest
+		approved bool
+	}{
+		{"same names", request([]string{"b.example.com", "a.example.com"}, []*url.URL{spiffe}), true},
+		{"extra dns name", request([]string{"a.example.com", "b.example.com", "admin.example.com"}, []*url.URL{spiffe}), false},
+		{"other uri", request([]string{"a.example.com", "b.example.com"}, []*url.URL{other}), false},
+		{"no names", request(nil, nil), false},
+	} {
+		if approved, reason := ce.verifyReenroll(r, c.csr); approved != c.approved {
+			t.Errorf("%s: approved = %v (%s), want %v", c.name, approved, reason, c.approved)
+		}
+	}
+}
+
+func TestReenrollFallsBackToApproval(t *testing.T) {
+	issuer, err := loadCA(writeCA(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var asked []string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Enrollment
+		json.NewDecoder(r.Body).Decode(&e)
+		asked = append(asked, e.Operation)
+	}))
+	defer webhook.Close()
+
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := os.OpenFile(auditFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ce := &CertEnrollment{
+		spec:     &Spec{ApprovalWebhook: webhook.URL},
+		ca:       issuer,
+		validity: time.Hour,
+		client:   webhook.Client(),
+		audit:    audit,
+	}
+	defer ce.Close()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "device-1"},
+	}, key)
+	// no client certificate, the webhook decides
+	req := httptest.NewRequest("POST", "/.well-known/est/simplereenroll", nil)
+	status := 0
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedBody = func() io.Reader {
+		return bytes.NewReader(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	}
+	ctx.MockedRequest.MockedStd = func() *http.Request { return req }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return httpheader.New(http.Header{}) }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { status = code }
+
+	if res := ce.enroll(ctx, operationReenroll); res != "" || status != 0 {
+		t.Fatalf("enroll() = %q, %d", res, status)
+	}
+	if len(asked) != 1 || asked[0] != operationReenroll {
+		t.Errorf("webhook asked for %q", asked)
+	}
+	data, _ := os.ReadFile(auditFile)
+	var rec AuditRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Approved || rec.Reason != "re-enrollment not verified: no client certificate" {
+		t.Errorf("audit record %+v", rec)
+	}
+}
@@ -0,0 +1,376 @@
+package esi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/FucAttaCk/gateway/cryptopolicy"
+	"github.com/FucAttaCk/gateway/util"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of ESI.
+	Kind = "ESI"
+
+	defaultMaxDepth     = 3
+	defaultMaxIncludes  = 32
+	defaultTimeout      = 5 * time.Second
+	defaultMaxBodySize  = 2 << 20
+	defaultMaxFragments = 1000
+
+	resultFragmentFailed = "fragmentFailed"
+	// the client went away while the fragments were fetched
+	resultClientDisconnected = "clientDisconnected"
+)
+
+var results = []string{resultFragmentFailed, resultClientDisconnected}
+
+func init() {
+	httppipeline.Register(&ESI{})
+}
+
+type (
+	// Spec is the spec of ESI.
+	Spec struct {
+		// Relative include sources are resolved against this URL,
+		// e.g. http://10.0.0.1:8080.
+		BaseURL string
+		// Hosts absolute include sources may point to, besides the
+		// host of BaseURL.
+		AllowedHosts []string
+		// Request headers passed on to fragment requests, e.g. Cookie
+		// for per-user fragments. Fragments are cached per value of
+		// these headers.
+		ForwardHeaders []string
+		// Only process responses with Surrogate-Control: content="ESI/1.0".
+		RequireSurrogateControl bool
+		// How deep fragments may include fragments. Default: 3.
+		MaxDepth int
+		// The maximum number of includes of a page. Default: 32.
+		MaxIncludes int
+		// The timeout of a fragment request, e.g. 1s. Default: 5s.
+		Timeout string
+		// Larger pages and fragments are not processed. Default: 2MiB.
+		MaxBodySize int64
+		// The maximum number of cached fragments. Default: 1000.
+		MaxFragments int
+	}
+
+	// ESI expands Edge Side Includes in the HTML responses of the
+	// filters after it, so mostly static pages can embed per-user
+	// fragments. Fragments are cached as their Cache-Control allows.
+	ESI struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+		baseURL    *url.URL
+		client     *http.Client
+		fragments  *lru.Cache
+
+		processed uint64
+		fetched   uint64
+		hits      uint64
+		failures  uint64
+		// requests whose client went away, they are no fragment
+		// failures
+		clientDisconnects uint64
+	}
+
+	fragment struct {
+		body    []byte
+		expires time.Time
+	}
+
+	// Status is the status of ESI.
+	Status struct {
+		Processed         uint64 `yaml:"processed"`
+		Fetched           uint64 `yaml:"fetched"`
+		Hits              uint64 `yaml:"hits"`
+		Failures          uint64 `yaml:"failures"`
+		Cached            int    `yaml:"cached"`
+		ClientDisconnects uint64 `yaml:"clientDisconnects"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	u, err := url.Parse(spec.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid base url %q", spec.BaseURL)
+	}
+	if spec.Timeout != "" {
+		if d, err := time.ParseDuration(spec.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q", spec.Timeout)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of ESI.
+func (e *ESI) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of ESI.
+func (e *ESI) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of ESI.
+func (e *ESI) Description() string {
+	return "ESI expands Edge Side Includes in HTML responses."
+}
+
+// Results returns the results of ESI.
+func (e *ESI) Results() []string {
+	return results
+}
+
+// Init initializes ESI.
+func (e *ESI) Init(filterSpec *httppipeline.FilterSpec) {
+	e.filterSpec = filterSpec
+	e.spec = filterSpec.FilterSpec().(*Spec)
+	e.baseURL, _ = url.Parse(e.spec.BaseURL)
+	timeout := defaultTimeout
+	if d, err := time.ParseDuration(e.spec.Timeout); err == nil && d > 0 {
+		timeout = d
+	}
+	e.client = cryptopolicy.NewHTTPClient(timeout)
+	size := e.spec.MaxFragments
+	if size <= 0 {
+		size = defaultMaxFragments
+	}
+	e.fragments, _ = lru.New(size)
+}
+
+// Inherit inherits previous generation of ESI, cached fragments are
+// kept.
+func (e *ESI) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	prev := previousGeneration.(*ESI)
+	e.Init(filterSpec)
+	for _, key := range prev.fragments.Keys() {
+		if v, ok := prev.fragments.Peek(key); ok {
+			e.fragments.Add(key, v)
+		}
+	}
+	prev.Close()
+}
+
+// Handle handles HTTP request, the response of the following filters
+// is processed once they are done.
+func (e *ESI) Handle(ctx context.HTTPContext) string {
+	res := ctx.CallNextHandler("")
+	if err := e.handle(ctx); err != nil {
+		if util.ClientGone(ctx.Request().Std().Context(), nil) {
+			atomic.AddUint64(&e.clientDisconnects, 1)
+			ctx.AddTag("esi: client disconnected")
+			ctx.Response().SetStatusCode(context.EGStatusClientClosedRequest)
+			return resultClientDisconnected
+		}
+		atomic.AddUint64(&e.failures, 1)
+		ctx.AddTag(fmt.Sprintf("esi: %v", err))
+		ctx.Response().SetStatusCode(http.StatusBadGateway)
+		ctx.Response().SetBody(strings.NewReader(""))
+		return resultFragmentFailed
+	}
+	return res
+}
+
+func (e *ESI) handle(ctx context.HTTPContext) error {
+	w := ctx.Response()
+	if w.Body() == nil || !strings.Contains(w.Header().Get("Content-Type"), "text/html") {
+		return nil
+	}
+	surrogate := w.Header().Get("Surrogate-Control")
+	if e.spec.RequireSurrogateControl && !strings.Contains(surrogate, "ESI/1.0") {
+		return nil
+	}
+
+	maxBodySize := e.maxBodySize()
+	body, err := io.ReadAll(io.LimitReader(w.Body(), maxBodySize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > maxBodySize {
+		// too large to process, send it as is
+		w.SetBody(io.MultiReader(bytes.NewReader(body), w.Body()))
+		return nil
+	}
+	if !hasESI(body) {
+		w.SetBody(bytes.NewReader(body))
+		return nil
+	}
+
+	p := &processor{
+		fetch:       func(src string) ([]byte, error) { return e.fetch(ctx, src) },
+		maxDepth:    e.spec.MaxDepth,
+		maxIncludes: e.spec.MaxIncludes,
+	}
+	if p.maxDepth <= 0 {
+		p.maxDepth = defaultMaxDepth
+	}
+	if p.maxIncludes <= 0 {
+		p.maxIncludes = defaultMaxIncludes
+	}
+	body, err = p.process(body, 0)
+	if err != nil {
+		return err
+	}
+	atomic.AddUint64(&e.processed, 1)
+
+	// the page changed, the upstream validators don't apply anymore
+	w.Header().Del("Surrogate-Control")
+	w.Header().Del("Content-Length")
+	w.Header().Del("Etag")
+	w.Header().Del("Last-Modified")
+	w.SetBody(bytes.NewReader(body))
+	return nil
+}
+
+func (e *ESI) maxBodySize() int64 {
+	if e.spec.MaxBodySize <= 0 {
+		return defaultMaxBodySize
+	}
+	return e.spec.MaxBodySize
+}
+
+// fetch returns the body of fragment src, from the cache if possible.
+func (e *ESI) fetch(ctx context.HTTPContext, src string) ([]byte, error) {
+	u, err := e.resolve(src)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	for _, h := range e.spec.ForwardHeaders {
+		for _, v := range ctx.Request().Header().Std().Values(h) {
+			header.Add(h, v)
+		}
+	}
+	key := fragmentKey(u.String(), e.spec.ForwardHeaders, header)
+	if v, ok := e.fragments.Get(key); ok {
+		f := v.(*fragment)
+		if time.Now().Before(f.expires) {
+			atomic.AddUint64(&e.hits, 1)
+			return f.body, nil
+		}
+		e.fragments.Remove(key)
+	}
+
+	// fetches stop with the client, nobody would see the page
+	req, err := http.NewRequestWithContext(ctx.Request().Std().Context(), http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	atomic.AddUint64(&e.fetched, 1)
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fragment %s: %s", u, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, e.maxBodySize()+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > e.maxBodySize() {
+		return nil, fmt.Errorf("fragment %s is too large", u)
+	}
+
+	if ttl := sharedMaxAge(resp.Header.Get("Cache-Control")); ttl > 0 {
+		e.fragments.Add(key, &fragment{body: body, expires: time.Now().Add(ttl)})
+	}
+	return body, nil
+}
+
+// fragmentKey returns the cache key of the fragment at rawURL fetched
+// with the forwarded header, a fragment fetched with the Cookie of one
+// user must not be served to another. The header values are hashed,
+// so the cache doesn't hold the credentials.
+func fragmentKey(rawURL string, forwarded []string, header http.Header) string {
+	if len(forwarded) == 0 {
+		return rawURL
+	}
+	h := sha256.New()
+	for _, name := range forwarded {
+		fmt.Fprintf(h, "%s\x00", name)
+		for _, v := range header.Values(name) {
+			fmt.Fprintf(h, "%s\x00", v)
+		}
+		h.Write([]byte{0xff})
+	}
+	return rawURL + " " + hex.EncodeToString(h.Sum(nil))
+}
+
+// resolve resolves src against the base URL and makes sure it doesn't
+// point to a host it shouldn't.
+func (e *ESI) resolve(src string) (*url.URL, error) {
+	u, err := e.baseURL.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("fragment %s: unsupported scheme", src)
+	}
+	if u.Host == e.baseURL.Host {
+		return u, nil
+	}
+	for _, h := range e.spec.AllowedHosts {
+		if strings.EqualFold(u.Host, h) {
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("fragment %s: host not allowed", src)
+}
+
+// sharedMaxAge returns how long a shared cache may keep a response
+// with cacheControl, per-user responses must be marked private.
+func sharedMaxAge(cacheControl string) time.Duration {
+	var maxAge, sMaxAge int64 = -1, -1
+	for _, d := range strings.Split(cacheControl, ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		switch {
+		case d == "private", d == "no-store", d == "no-cache":
+			return 0
+		case strings.HasPrefix(d, "s-maxage="):
+			sMaxAge, _ = strconv.ParseInt(d[len("s-maxage="):], 10, 64)
+		case strings.HasPrefix(d, "max-age="):
+			maxAge, _ = strconv.ParseInt(d[len("max-age="):], 10, 64)
+		}
+	}
+	if sMaxAge >= 0 {
+		maxAge = sMaxAge
+	}
+	if maxAge <= 0 {
+		return 0
+	}
+	return time.Duration(maxAge) * time.Second
+}
+
+// Status returns Status generated by Runtime.
+func (e *ESI) Status() interface{} {
+	return &Status{
+		Processed:         atomic.LoadUint64(&e.processed),
+		Fetched:           atomic.LoadUint64(&e.fetched),
+		Hits:              atomic.LoadUint64(&e.hits),
+		Failures:          atomic.LoadUint64(&e.failures),
+		Cached:            e.fragments.Len(),
+		ClientDisconnects: atomic.LoadUint64(&e.clientDisconnects),
+	}
+}
+
+// Close closes ESI.
+func (e *ESI) Close() {}
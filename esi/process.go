@@ -0,0 +1,104 @@
+package esi
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+)
+
+var (
+	// <esi:include src="..." alt="..." onerror="continue"/>
+	includeTag = regexp.MustCompile(`(?s)<esi:include\s+([^>]*?)\s*/?>(?:\s*</esi:include>)?`)
+	attr       = regexp.MustCompile(`([a-zA-Z]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	// <esi:remove>...</esi:remove> is the fallback for clients without ESI
+	removeTag = regexp.MustCompile(`(?s)<esi:remove>.*?</esi:remove>`)
+	// <!--esi ... --> hides ESI markup from clients without ESI
+	commentTag = regexp.MustCompile(`(?s)<!--esi(.*?)-->`)
+)
+
+var errTooManyIncludes = errors.New("too many includes")
+
+// fetcher returns the body of a fragment.
+type fetcher func(src string) ([]byte, error)
+
+// processor expands the ESI markup of a page.
+type processor struct {
+	fetch       fetcher
+	maxDepth    int
+	maxIncludes int
+	includes    int
+}
+
+// hasESI reports whether body contains ESI markup worth processing.
+func hasESI(body []byte) bool {
+	return bytes.Contains(body, []byte("<esi:")) || bytes.Contains(body, []byte("<!--esi"))
+}
+
+// process expands body, fragments are processed recursively up to the
+// max depth, deeper markup is removed.
+func (p *processor) process(body []byte, depth int) ([]byte, error) {
+	if !hasESI(body) {
+		return body, nil
+	}
+	body = commentTag.ReplaceAll(body, []byte("$1"))
+	body = removeTag.ReplaceAll(body, nil)
+
+	var err error
+	body = includeTag.ReplaceAllFunc(body, func(tag []byte) []byte {
+		if err != nil {
+			return nil
+		}
+		if depth >= p.maxDepth {
+			return nil
+		}
+		attrs := parseAttrs(includeTag.FindSubmatch(tag)[1])
+
+		var fragment []byte
+		fragment, err = p.include(attrs, depth)
+		if err != nil && attrs["onerror"] == "continue" {
+			err = nil
+		}
+		return fragment
+	})
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// include fetches the src of an include, or its alt if src fails.
+func (p *processor) include(attrs map[string]string, depth int) ([]byte, error) {
+	var err error
+	for _, src := range []string{attrs["src"], attrs["alt"]} {
+		if src == "" {
+			continue
+		}
+		if p.includes >= p.maxIncludes {
+			return nil, errTooManyIncludes
+		}
+		p.includes++
+
+		var fragment []byte
+		fragment, err = p.fetch(src)
+		if err != nil {
+			continue
+		}
+		return p.process(fragment, depth+1)
+	}
+	if err == nil {
+		err = errors.New("include without src")
+	}
+	return nil, err
+}
+
+func parseAttrs(s []byte) map[string]string {
+	attrs := map[string]string{}
+	for _, m := range attr.FindAllSubmatch(s, -1) {
+		value := m[2]
+		if value == nil {
+			value = m[3]
+		}
+		attrs[string(bytes.ToLower(m[1]))] = string(value)
+	}
+	return attrs
+}
@@ -0,0 +1,84 @@
+package esi
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcess(t *testing.T) {
+	fragments := map[string]string{
+		"/header": `<header><esi:include src="/user"/></header>`,
+		"/user":   `hello, alice`,
+		"/loop":   `[<esi:include src="/loop"/>]`,
+	}
+	fetch := func(src string) ([]byte, error) {
+		if f, ok := fragments[src]; ok {
+			return []byte(f), nil
+		}
+		return nil, errors.New("not found")
+	}
+
+	for _, c := range []struct {
+		page, want string
+		err        bool
+	}{
+		{`<esi:include src="/header" />body`, `<header>hello, alice</header>body`, false},
+		{`<esi:include src="/missing" alt="/user"></esi:include>`, `hello, alice`, false},
+		{`a<esi:include src="/missing" onerror="continue"/>b`, `ab`, false},
+		{`<esi:include src="/missing"/>`, ``, true},
+		{`<esi:remove>no esi</esi:remove><!--esi <esi:include src='/user'/> -->`, ` hello, alice `, false},
+		{`<esi:include src="/loop"/>`, `[[[]]]`, false},
+	} {
+		p := &processor{fetch: fetch, maxDepth: 3, maxIncludes: 10}
+		got, err := p.process([]byte(c.page), 0)
+		if (err != nil) != c.err {
+			t.Errorf("%s: error %v", c.page, err)
+			continue
+		}
+		if !c.err && string(got) != c.want {
+			t.Errorf("%s: got %q, want %q", c.page, got, c.want)
+		}
+	}
+}
+
+func TestSharedMaxAge(t *testing.T) {
+	for _, c := range []struct {
+		cacheControl string
+		want         time.Duration
+	}{
+		{"max-age=60", time.Minute},
+		{"public, max-age=60, s-maxage=10", 10 * time.Second},
+		{"private, max-age=60", 0},
+		{"no-store", 0},
+		{"", 0},
+	} {
+		if got := sharedMaxAge(c.cacheControl); got != c.want {
+			t.Errorf("sharedMaxAge(%q) = %v, want %v", c.cacheControl, got, c.want)
+		}
+	}
+}
+
+func TestFragmentKey(t *testing.T) {
+	const u = "http://10.0.0.1/fragments/cart"
+	if got := fragmentKey(u, nil, http.Header{"Cookie": {"session=alice"}}); got != u {
+		t.Errorf("key without forwarded headers = %q, want the url", got)
+	}
+
+	forwarded := []string{"Cookie", "Accept-Language"}
+	alice := fragmentKey(u, forwarded, http.Header{"Cookie": {"session=alice"}})
+	if alice == fragmentKey(u, forwarded, http.Header{"Cookie": {"session=bob"}}) {
+		t.Error("users share a fragment")
+	}
+	if alice == fragmentKey(u, forwarded, http.Header{"Cookie": {"session=alice"}, "Accept-Language": {"de"}}) {
+		t.Error("languages share a fragment")
+	}
+	if alice != fragmentKey(u, forwarded, http.Header{"Cookie": {"session=alice"}, "User-Agent": {"curl"}}) {
+		t.Error("headers not forwarded change the key")
+	}
+	if strings.Contains(alice, "alice") {
+		t.Errorf("key %q holds the cookie", alice)
+	}
+}
@@ -0,0 +1,192 @@
+// Package eventbus is a lightweight in-process publish/subscribe bus,
+// so subsystems like cache invalidation, config changes, security
+// events and long-poll wakeups can talk to each other without
+// importing each other.
+package eventbus
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const defaultQueueSize = 256
+
+type (
+	// Topic delivers the events of type T published on it to all of
+	// its subscribers.
+	Topic[T any] struct {
+		name      string
+		mutex     sync.RWMutex
+		subs      map[*Subscription[T]]struct{}
+		published uint64
+		dropped   uint64
+	}
+
+	// Subscription receives the events of a topic through a bounded
+	// queue and hands them to its handler one by one, in order.
+	Subscription[T any] struct {
+		topic   *Topic[T]
+		queue   chan T
+		done    chan struct{}
+		once    sync.Once
+		dropped uint64
+	}
+
+	// Message is a general purpose payload for topics that only need
+	// a key and some data, e.g. the path of an invalidated file.
+	Message struct {
+		Key         string
+		Data        []byte
+		ContentType string
+	}
+
+	// TopicStatus is the status of a topic.
+	TopicStatus struct {
+		Name        string `yaml:"name"`
+		Subscribers int    `yaml:"subscribers"`
+		Published   uint64 `yaml:"published"`
+		Dropped     uint64 `yaml:"dropped"`
+	}
+
+	topic interface {
+		status() *TopicStatus
+	}
+)
+
+var (
+	topicsMutex sync.Mutex
+	topics      = map[string]topic{}
+)
+
+// Get returns the topic with name, creating it on first use. The
+// publishers and the subscribers of a topic only share its name and
+// event type, Get panics if name is already used with another type.
+func Get[T any](name string) *Topic[T] {
+	topicsMutex.Lock()
+	defer topicsMutex.Unlock()
+	if t, ok := topics[name]; ok {
+		tt, ok := t.(*Topic[T])
+		if !ok {
+			panic(fmt.Errorf("eventbus: topic %s is used with another event type", name))
+		}
+		return tt
+	}
+	t := &Topic[T]{name: name, subs: map[*Subscription[T]]struct{}{}}
+	topics[name] = t
+	return t
+}
+
+// Status returns the status of all topics sorted by name.
+func Status() []*TopicStatus {
+	topicsMutex.Lock()
+	result := make([]*TopicStatus, 0, len(topics))
+	for _, t := range topics {
+		result = append(result, t.status())
+	}
+	topicsMutex.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Name returns the name of the topic.
+func (t *Topic[T]) Name() string {
+	return t.name
+}
+
+// Publish queues ev for every subscriber and returns how many got it.
+// It never blocks, the event is dropped for subscribers whose queue
+// is full.
+func (t *Topic[T]) Publish(ev T) int {
+	atomic.AddUint64(&t.published, 1)
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	n := 0
+	for s := range t.subs {
+		select {
+		case s.queue <- ev:
+			n++
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+			if atomic.AddUint64(&t.dropped, 1) == 1 {
+				logger.Warn("event bus subscriber queue full, dropping events",
+					zap.String("topic", t.name))
+			}
+		}
+	}
+	return n
+}
+
+// Subscribe calls handler for every event published on the topic
+// until the subscription is closed. Up to queueSize events are
+// buffered, default: 256.
+func (t *Topic[T]) Subscribe(queueSize int, handler func(T)) *Subscription[T] {
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	s := &Subscription[T]{
+		topic: t,
+		queue: make(chan T, queueSize),
+		done:  make(chan struct{}),
+	}
+	t.mutex.Lock()
+	t.subs[s] = struct{}{}
+	t.mutex.Unlock()
+
+	go s.run(handler)
+	return s
+}
+
+func (t *Topic[T]) status() *TopicStatus {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return &TopicStatus{
+		Name:        t.name,
+		Subscribers: len(t.subs),
+		Published:   atomic.LoadUint64(&t.published),
+		Dropped:     atomic.LoadUint64(&t.dropped),
+	}
+}
+
+func (s *Subscription[T]) run(handler func(T)) {
+	for {
+		select {
+		case ev := <-s.queue:
+			s.handle(handler, ev)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Subscription[T]) handle(handler func(T), ev T) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("event bus handler panicked",
+				zap.String("topic", s.topic.name), zap.Any("error", err))
+		}
+	}()
+	handler(ev)
+}
+
+// Dropped returns the number of events dropped because the queue of
+// the subscription was full.
+func (s *Subscription[T]) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops the subscription, queued events are discarded.
+func (s *Subscription[T]) Close() {
+	s.once.Do(func() {
+		s.topic.mutex.Lock()
+		delete(s.topic.subs, s)
+		s.topic.mutex.Unlock()
+		close(s.done)
+	})
+}
@@ -0,0 +1,60 @@
+package eventbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPublishSubscribe(t *testing.T) {
+	topic := Get[int]("test.publish")
+	got := make(chan int, 10)
+	sub := topic.Subscribe(0, func(v int) { got <- v })
+
+	for i := 1; i <= 3; i++ {
+		if n := topic.Publish(i); n != 1 {
+			t.Fatalf("published to %d subscribers, want 1", n)
+		}
+	}
+	for i := 1; i <= 3; i++ {
+		select {
+		case v := <-got:
+			if v != i {
+				t.Fatalf("got %d, want %d", v, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("event not delivered")
+		}
+	}
+
+	sub.Close()
+	if n := topic.Publish(4); n != 0 {
+		t.Fatalf("published to %d subscribers after close", n)
+	}
+}
+
+func TestBoundedQueue(t *testing.T) {
+	topic := Get[string]("test.bounded")
+	block := make(chan struct{})
+	sub := topic.Subscribe(1, func(string) { <-block })
+	defer sub.Close()
+	defer close(block)
+
+	// the first event may be taken by the handler already, after
+	// that the queue holds one and the rest are dropped
+	for i := 0; i < 5; i++ {
+		topic.Publish("x")
+	}
+	if d := sub.Dropped(); d < 3 {
+		t.Fatalf("dropped %d events, want at least 3", d)
+	}
+}
+
+func TestTypeMismatch(t *testing.T) {
+	Get[int]("test.mismatch")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic")
+		}
+	}()
+	Get[string]("test.mismatch")
+}
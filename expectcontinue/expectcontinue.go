@@ -0,0 +1,157 @@
+package expectcontinue
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of ExpectContinue.
+	Kind = "ExpectContinue"
+
+	// PolicyImmediate sends 100 Continue when the request reaches the
+	// filter.
+	PolicyImmediate = "immediate"
+	// PolicyDefer leaves 100 Continue to whoever reads the body
+	// first, usually the proxy, so the filters before that, like
+	// authentication, run before the client sends the body. It is
+	// what the server does without this filter too, the filter then
+	// only applies MaxContentLength.
+	PolicyDefer = "defer"
+	// PolicyReject answers requests expecting 100 Continue with 417.
+	PolicyReject = "reject"
+
+	resultExpectationFailed = "expectationFailed"
+	resultTooLarge          = "tooLarge"
+)
+
+var results = []string{resultExpectationFailed, resultTooLarge}
+
+func init() {
+	httppipeline.Register(&ExpectContinue{})
+}
+
+type (
+	// Spec is the spec of ExpectContinue.
+	Spec struct {
+		// immediate, defer or reject. Default: defer, the behavior
+		// of the server.
+		Policy string
+		// Requests declaring a larger body are answered with 413
+		// before the body is transferred. Default: 0, no limit.
+		MaxContentLength int64
+	}
+
+	// ExpectContinue decides when, or whether, requests carrying
+	// Expect: 100-continue are told to send their body, so large
+	// uploads aren't transferred before authentication fails.
+	ExpectContinue struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		continued uint64
+		rejected  uint64
+	}
+
+	// Status is the status of ExpectContinue.
+	Status struct {
+		Continued uint64 `yaml:"continued"`
+		Rejected  uint64 `yaml:"rejected"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	switch spec.Policy {
+	case "", PolicyImmediate, PolicyDefer, PolicyReject:
+	default:
+		return fmt.Errorf("invalid policy %q", spec.Policy)
+	}
+	if spec.MaxContentLength < 0 {
+		return fmt.Errorf("max content length must not be negative")
+	}
+	return nil
+}
+
+// Kind returns the kind of ExpectContinue.
+func (ec *ExpectContinue) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of ExpectContinue.
+func (ec *ExpectContinue) DefaultSpec() interface{} {
+	return &Spec{Policy: PolicyDefer}
+}
+
+// Description returns the description of ExpectContinue.
+func (ec *ExpectContinue) Description() string {
+	return "ExpectContinue controls the handling of Expect: 100-continue."
+}
+
+// Results returns the results of ExpectContinue.
+func (ec *ExpectContinue) Results() []string {
+	return results
+}
+
+// Init initializes ExpectContinue.
+func (ec *ExpectContinue) Init(filterSpec *httppipeline.FilterSpec) {
+	ec.filterSpec = filterSpec
+	ec.spec = filterSpec.FilterSpec().(*Spec)
+}
+
+// Inherit inherits previous generation of ExpectContinue.
+func (ec *ExpectContinue) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	ec.Init(filterSpec)
+}
+
+// Handle handles HTTP request
+func (ec *ExpectContinue) Handle(ctx context.HTTPContext) string {
+	res := ec.handle(ctx)
+	return ctx.CallNextHandler(res)
+}
+
+func (ec *ExpectContinue) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	if !strings.EqualFold(r.Header().Get("Expect"), "100-continue") {
+		return ""
+	}
+
+	w := ctx.Response()
+	if max := ec.spec.MaxContentLength; max > 0 && r.Std().ContentLength > max {
+		atomic.AddUint64(&ec.rejected, 1)
+		ctx.AddTag(fmt.Sprintf("expect continue: content length %d exceeds %d", r.Std().ContentLength, max))
+		w.SetStatusCode(http.StatusRequestEntityTooLarge)
+		return resultTooLarge
+	}
+
+	switch ec.spec.Policy {
+	case PolicyReject:
+		atomic.AddUint64(&ec.rejected, 1)
+		ctx.AddTag("expect continue rejected")
+		w.SetStatusCode(http.StatusExpectationFailed)
+		return resultExpectationFailed
+	case PolicyImmediate:
+		// the server writes 100 Continue on the first read of the
+		// body, an empty read transfers nothing else
+		r.Std().Body.Read(nil)
+		atomic.AddUint64(&ec.continued, 1)
+	}
+	return ""
+}
+
+// Status returns Status generated by Runtime.
+func (ec *ExpectContinue) Status() interface{} {
+	return &Status{
+		Continued: atomic.LoadUint64(&ec.continued),
+		Rejected:  atomic.LoadUint64(&ec.rejected),
+	}
+}
+
+// Close closes ExpectContinue.
+func (ec *ExpectContinue) Close() {}
@@ -0,0 +1,68 @@
+package expectcontinue
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+// body counts the reads of a request body.
+type body struct {
+	io.Reader
+	reads int
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	b.reads++
+	return b.Reader.Read(p)
+}
+
+func (b *body) Close() error { return nil }
+
+func TestHandle(t *testing.T) {
+	for _, c := range []struct {
+		name          string
+		spec          *Spec
+		expect        string
+		contentLength int64
+		result        string
+		status        int
+		reads         int
+	}{
+		{"defer", &Spec{Policy: PolicyDefer}, "100-continue", 10, "", 0, 0},
+		{"immediate", &Spec{Policy: PolicyImmediate}, "100-continue", 10, "", 0, 1},
+		{"immediate without expect", &Spec{Policy: PolicyImmediate}, "", 10, "", 0, 0},
+		{"reject", &Spec{Policy: PolicyReject}, "100-Continue", 10, resultExpectationFailed, http.StatusExpectationFailed, 0},
+		{"reject without expect", &Spec{Policy: PolicyReject}, "", 10, "", 0, 0},
+		{"too large", &Spec{Policy: PolicyImmediate, MaxContentLength: 5}, "100-continue", 10, resultTooLarge, http.StatusRequestEntityTooLarge, 0},
+		{"small enough", &Spec{MaxContentLength: 10}, "100-continue", 10, "", 0, 0},
+	} {
+		if err := c.spec.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		ec := &ExpectContinue{spec: c.spec}
+
+		req := httptest.NewRequest(http.MethodPut, "/upload", nil)
+		b := &body{Reader: strings.NewReader("0123456789")}
+		req.Body = b
+		req.ContentLength = c.contentLength
+		if c.expect != "" {
+			req.Header.Set("Expect", c.expect)
+		}
+		status := 0
+		ctx := &contexttest.MockedHTTPContext{}
+		ctx.MockedRequest.MockedStd = func() *http.Request { return req }
+		ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return httpheader.New(req.Header) }
+		ctx.MockedResponse.MockedSetStatusCode = func(code int) { status = code }
+
+		if res := ec.handle(ctx); res != c.result || status != c.status || b.reads != c.reads {
+			t.Errorf("%s: handle() = %q, status %d, %d reads, want %q, %d, %d reads",
+				c.name, res, status, b.reads, c.result, c.status, c.reads)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package fileserver
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/FucAttaCk/gateway/diskwatch"
+	"github.com/FucAttaCk/gateway/logsink"
+	"github.com/FucAttaCk/gateway/util"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	accessLogStdout = "stdout"
+	accessLogStderr = "stderr"
+
+	defaultAccessLogFormat = `{http.request.remote.host} - - [{time.now.common_log}] "{http.request.method} {http.request.uri} {http.request.proto}" {http.response.status} {http.response.size} {http.response.latency}`
+)
+
+// AccessLogSpec logs one line per request handled by the filter.
+type AccessLogSpec struct {
+	Enabled bool
+	// The line template. Placeholders: {http.request.method},
+	// {http.request.path}, {http.request.uri}, {http.request.proto},
+	// {http.request.host}, {http.request.remote.host},
+	// {http.request.header.*}, {http.response.status},
+	// {http.response.size}, {http.response.latency}, {result} and the
+	// global placeholders like {time.now.common_log}. Default: the
+	// common log format followed by the latency.
+	Format string
+	// stdout, stderr or the path of a file the lines are appended to.
+	// Default: stdout.
+	Output string
+	// Also send the lines to a syslog receiver or journald.
+	Syslog   *logsink.SyslogSpec
+	Journald *logsink.JournaldSpec
+	// Lines aren't appended to the Output file while the disk holding
+	// it is below its low watermark, they still go to the syslog
+	// receiver and journald.
+	DiskWatermarks *diskwatch.Spec
+}
+
+// accessLog writes access lines to its output and sinks.
+type accessLog struct {
+	format string
+	mu     sync.Mutex
+	out    io.Writer
+	file   *os.File
+	sinks  []logsink.Sink
+	disk   *diskwatch.Watcher
+	// lines not written to the file while the disk is paused
+	dropped uint64
+}
+
+// served is what the filter wrote to the response itself, bypassing
+// the response of the context.
+type served struct {
+	status int
+	bytes  int64
+}
+
+func (spec *AccessLogSpec) validate() error {
+	if spec.Syslog != nil && spec.Syslog.Address == "" {
+		return fmt.Errorf("access log: syslog address is required")
+	}
+	if spec.DiskWatermarks != nil {
+		return spec.DiskWatermarks.Validate()
+	}
+	return nil
+}
+
+func newAccessLog(spec *AccessLogSpec) (*accessLog, error) {
+	al := &accessLog{format: spec.Format}
+	if al.format == "" {
+		al.format = defaultAccessLogFormat
+	}
+	switch spec.Output {
+	case "", accessLogStdout:
+		al.out = os.Stdout
+	case accessLogStderr:
+		al.out = os.Stderr
+	default:
+		f, err := os.OpenFile(spec.Output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		al.out, al.file = f, f
+		if spec.DiskWatermarks != nil {
+			al.disk = diskwatch.New(filepath.Dir(spec.Output), spec.DiskWatermarks)
+		}
+	}
+	if spec.Syslog != nil {
+		s, err := logsink.NewSyslog(spec.Syslog, "local0")
+		if err != nil {
+			al.close()
+			return nil, err
+		}
+		al.sinks = append(al.sinks, s)
+	}
+	if spec.Journald != nil {
+		j, err := logsink.NewJournald(spec.Journald)
+		if err != nil {
+			al.close()
+			return nil, err
+		}
+		al.sinks = append(al.sinks, j)
+	}
+	return al, nil
+}
+
+// log logs the request of ctx when it is finished, so the status code
+// and size set by later filters are logged too.
+func (al *accessLog) log(ctx context.HTTPContext, start time.Time, result string, sv *served) {
+	ctx.OnFinish(func() {
+		r := ctx.Request()
+		status, size := sv.status, sv.bytes
+		if status == 0 {
+			status = ctx.Response().StatusCode()
+			size = int64(ctx.Response().Size())
+		}
+		latency := time.Since(start)
+
+		repl := util.NewReplacer()
+		repl.Set("http.request.method", r.Method())
+		repl.Set("http.request.path", r.Path())
+		repl.Set("http.request.uri", r.Std().RequestURI)
+		repl.Set("http.request.proto", r.Proto())
+		repl.Set("http.request.host", r.Host())
+		repl.Set("http.request.remote.host", r.RealIP())
+		repl.Set("http.response.status", status)
+		repl.Set("http.response.size", size)
+		repl.Set("http.response.latency", latency)
+		repl.Set("result", result)
+		repl.Map(func(key string) (any, bool) {
+			const prefix = "http.request.header."
+			if !strings.HasPrefix(key, prefix) {
+				return nil, false
+			}
+			return r.Header().Get(key[len(prefix):]), true
+		})
+		line := repl.ReplaceAll(al.format, "-")
+
+		if al.disk != nil && al.disk.Paused() {
+			atomic.AddUint64(&al.dropped, 1)
+		} else {
+			al.mu.Lock()
+			_, err := io.WriteString(al.out, line+"\n")
+			al.mu.Unlock()
+			if err != nil {
+				logger.Warn("write access log failed", zap.Error(err))
+			}
+		}
+
+		if len(al.sinks) == 0 {
+			return
+		}
+		rec := &logsink.Record{
+			Time:     start,
+			Severity: logsink.SeverityInfo,
+			MsgID:    "access",
+			Message:  line,
+			Fields: map[string]string{
+				"method":   r.Method(),
+				"path":     r.Path(),
+				"status":   strconv.Itoa(status),
+				"bytes":    strconv.FormatInt(size, 10),
+				"latency":  latency.String(),
+				"clientIP": r.RealIP(),
+			},
+		}
+		for _, s := range al.sinks {
+			if err := s.Write(rec); err != nil {
+				logger.Debug("send access log failed", zap.Error(err))
+			}
+		}
+	})
+}
+
+func (al *accessLog) close() {
+	if al.disk != nil {
+		al.disk.Close()
+	}
+	if al.file != nil {
+		al.file.Close()
+	}
+	for _, s := range al.sinks {
+		s.Close()
+	}
+}
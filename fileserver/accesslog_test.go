@@ -0,0 +1,62 @@
+package fileserver
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FucAttaCk/gateway/diskwatch"
+)
+
+func TestAccessLog(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "access.log")
+	al, err := newAccessLog(&AccessLogSpec{
+		Enabled: true,
+		Format:  "{http.request.remote.host} {http.request.method} {http.request.path} {http.response.status} {http.response.size} {result} {http.request.header.x-missing}",
+		Output:  out,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer al.close()
+
+	req := httptest.NewRequest("GET", "/app.js", nil)
+	req.RemoteAddr = "10.0.0.1:40000"
+	ctx, _ := newMockedContext(req)
+	al.log(ctx, time.Now(), "", &served{status: 206, bytes: 1024})
+	ctx.Finish()
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "10.0.0.1 GET /app.js 206 1024 - -\n"; string(data) != want {
+		t.Errorf("access log = %q, want %q", data, want)
+	}
+}
+
+func TestAccessLogDiskPaused(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "access.log")
+	al, err := newAccessLog(&AccessLogSpec{
+		Enabled:        true,
+		Output:         out,
+		DiskWatermarks: &diskwatch.Spec{LowWatermark: "100%"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer al.close()
+	if !al.disk.Paused() {
+		t.Skip("free disk space not available")
+	}
+
+	ctx, _ := newMockedContext(httptest.NewRequest("GET", "/app.js", nil))
+	al.log(ctx, time.Now(), "", &served{status: 200})
+	ctx.Finish()
+
+	if data, _ := os.ReadFile(out); len(data) != 0 || al.dropped != 1 {
+		t.Errorf("access log = %q, %d dropped, want no line", data, al.dropped)
+	}
+}
@@ -0,0 +1,178 @@
+package fileserver
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/FucAttaCk/gateway/secevent"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/megaease/easegress/pkg/context"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultBasicAuthRealm = "restricted"
+	// verified credentials are remembered, bcrypt is slow on purpose
+	basicAuthCacheSize = 1024
+)
+
+var (
+	// dummyHash is compared against the passwords of unknown users,
+	// so they take as long to reject as wrong passwords.
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// BasicAuthSpec protects path prefixes with HTTP Basic authentication.
+type BasicAuthSpec struct {
+	// Sent in the WWW-Authenticate header. Default: restricted.
+	Realm string
+	// The credentials by request path prefix, as user:bcrypt-hash,
+	// e.g. /internal: [ops:$2a$10$...]. The longest matching prefix
+	// applies, paths matching none are public.
+	Paths map[string][]string
+}
+
+type basicAuth struct {
+	realm string
+	// ordered by descending prefix length
+	rules    []*basicAuthRule
+	verified *lru.Cache
+}
+
+type basicAuthRule struct {
+	prefix string
+	users  map[string][]byte
+}
+
+func (spec *BasicAuthSpec) validate() error {
+	if strings.ContainsAny(spec.Realm, "\"\\\r\n") {
+		return fmt.Errorf("basic auth: invalid realm %q", spec.Realm)
+	}
+	for prefix, creds := range spec.Paths {
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("basic auth: prefix %q must start with /", prefix)
+		}
+		if len(creds) == 0 {
+			return fmt.Errorf("basic auth: prefix %s has no credentials", prefix)
+		}
+		for _, c := range creds {
+			user, hash, ok := strings.Cut(c, ":")
+			if !ok || user == "" {
+				return fmt.Errorf("basic auth: credentials of %s must be user:bcrypt-hash", prefix)
+			}
+			if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+				return fmt.Errorf("basic auth: invalid hash of user %s: %v", user, err)
+			}
+		}
+	}
+	return nil
+}
+
+func newBasicAuth(spec *BasicAuthSpec) *basicAuth {
+	if spec == nil || len(spec.Paths) == 0 {
+		return nil
+	}
+	ba := &basicAuth{realm: spec.Realm}
+	if ba.realm == "" {
+		ba.realm = defaultBasicAuthRealm
+	}
+	for prefix, creds := range spec.Paths {
+		rule := &basicAuthRule{
+			prefix: strings.TrimSuffix(prefix, "/"),
+			users:  make(map[string][]byte, len(creds)),
+		}
+		for _, c := range creds {
+			user, hash, _ := strings.Cut(c, ":")
+			rule.users[user] = []byte(hash)
+		}
+		ba.rules = append(ba.rules, rule)
+	}
+	sort.Slice(ba.rules, func(i, j int) bool {
+		return len(ba.rules[i].prefix) > len(ba.rules[j].prefix)
+	})
+	ba.verified, _ = lru.New(basicAuthCacheSize)
+	return ba
+}
+
+// rule returns the rule protecting reqPath, nil if it is public.
+func (ba *basicAuth) rule(reqPath string) *basicAuthRule {
+	// match the path the file is looked up with, so dot segments
+	// can't step around a prefix
+	p := path.Clean("/" + reqPath)
+	for _, r := range ba.rules {
+		if r.prefix == "" || p == r.prefix || strings.HasPrefix(p, r.prefix+"/") {
+			return r
+		}
+	}
+	return nil
+}
+
+// check returns the authenticated user of rule, ok is false if the
+// request has no valid credentials.
+func (ba *basicAuth) check(rule *basicAuthRule, r *http.Request) (string, bool) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	hash, known := rule.users[user]
+	if !known {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return "", false
+	}
+	key := sha256.Sum256([]byte(rule.prefix + "\x00" + user + "\x00" + password + "\x00" + string(hash)))
+	if _, ok := ba.verified.Get(key); ok {
+		return user, true
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return "", false
+	}
+	ba.verified.Add(key, struct{}{})
+	return user, true
+}
+
+// authenticate answers 401 unless the request may access reqPath, it
+// returns the result of the request if it is answered.
+func (fsrv *FileServer) authenticate(ctx context.HTTPContext, reqPath string) string {
+	rule := fsrv.basicAuth.rule(reqPath)
+	if rule == nil {
+		return ""
+	}
+	if user, ok := fsrv.basicAuth.check(rule, ctx.Request().Std()); ok {
+		ctx.AddTag("basic auth user " + user)
+		return ""
+	}
+	ctx.AddTag("basic auth failed")
+	reason, fields := "no credentials", map[string]string(nil)
+	if user, _, ok := ctx.Request().Std().BasicAuth(); ok {
+		reason, fields = "invalid credentials", map[string]string{"user": user}
+	}
+	fsrv.authFailure(ctx, reason, fields)
+	w := ctx.Response()
+	w.Header().Set("WWW-Authenticate", `Basic realm="`+fsrv.basicAuth.realm+`", charset="UTF-8"`)
+	w.SetStatusCode(http.StatusUnauthorized)
+	return resultUnauthorized
+}
+
+// authFailure reports a request denied for lack of valid credentials.
+func (fsrv *FileServer) authFailure(ctx context.HTTPContext, reason string, fields map[string]string) {
+	r := ctx.Request()
+	fsrv.events.Emit(&secevent.Event{
+		Type:     secevent.TypeAuthFailure,
+		Severity: 5,
+		Action:   "deny",
+		ClientIP: r.RealIP(),
+		Method:   r.Method(),
+		Path:     r.Path(),
+		Reason:   reason,
+		Fields:   fields,
+	})
+}
@@ -0,0 +1,56 @@
+package fileserver
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicAuth(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	spec := &BasicAuthSpec{Paths: map[string][]string{
+		"/internal/":     {"ops:" + string(hash)},
+		"/internal/docs": {"docs:" + string(hash)},
+	}}
+	if err := spec.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&BasicAuthSpec{Paths: map[string][]string{"/a": {"ops:plain"}}}).validate(); err == nil {
+		t.Error("plain password accepted")
+	}
+	ba := newBasicAuth(spec)
+
+	for _, c := range []struct {
+		path, user, password string
+		protected, ok        bool
+	}{
+		{"/index.html", "", "", false, false},
+		{"/internalx", "", "", false, false},
+		{"/internal", "ops", "s3cret", true, true},
+		{"/public/../internal/a", "", "", true, false},
+		{"/internal/a", "ops", "wrong", true, false},
+		{"/internal/a", "nobody", "s3cret", true, false},
+		{"/internal/docs/a", "ops", "s3cret", true, false},
+		{"/internal/docs/a", "docs", "s3cret", true, true},
+	} {
+		rule := ba.rule(c.path)
+		if (rule != nil) != c.protected {
+			t.Errorf("%s protected = %v, want %v", c.path, rule != nil, c.protected)
+			continue
+		}
+		if rule == nil {
+			continue
+		}
+		req := httptest.NewRequest("GET", c.path, nil)
+		if c.user != "" {
+			req.SetBasicAuth(c.user, c.password)
+		}
+		// twice, the second time from the cache
+		for i := 0; i < 2; i++ {
+			if _, ok := ba.check(rule, req); ok != c.ok {
+				t.Errorf("%s as %s: ok = %v, want %v", c.path, c.user, ok, c.ok)
+			}
+		}
+	}
+}
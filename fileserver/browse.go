@@ -0,0 +1,185 @@
+package fileserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+// BrowseSpec enables directory listings for directories without an
+// index file.
+type BrowseSpec struct {
+	// Show the size and modification time of files in HTML listings.
+	Details bool
+}
+
+// listingEntry is an entry of a directory listing, it is also the
+// JSON representation of the entry.
+type listingEntry struct {
+	Name    string    `json:"name"`
+	URL     string    `json:"url"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	IsDir   bool      `json:"isDir"`
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Path}}</title></head>
+<body>
+<h1>{{.Path}}</h1>
+<table>
+{{- if ne .Path "/"}}
+<tr><td><a href="../">../</a></td></tr>
+{{- end}}
+{{- range .Entries}}
+<tr><td><a href="{{.URL}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td>
+{{- if $.Details}}<td>{{if not .IsDir}}{{.Size}}{{end}}</td><td>{{.ModTime.UTC.Format "2006-01-02 15:04:05"}}</td>{{end}}</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// wantsJSON reports whether the client asked for a JSON listing, by
+// the format query parameter or the Accept header. Browsers accepting
+// JSON as well as HTML, or everything, get HTML.
+func wantsJSON(r context.HTTPRequest) bool {
+	if q, err := url.ParseQuery(r.Query()); err == nil && q.Get("format") != "" {
+		return q.Get("format") == "json"
+	}
+	accept := r.Header().Get("Accept")
+	q := acceptQuality(accept, "application/json")
+	return q > 0 && q > acceptQuality(accept, "text/html")
+}
+
+// acceptQuality returns the quality value an Accept header gives
+// mediaType, from the most specific media range matching it.
+func acceptQuality(header, mediaType string) float64 {
+	if strings.TrimSpace(header) == "" {
+		return 1
+	}
+	typ, _, _ := strings.Cut(mediaType, "/")
+	quality, specificity := 0.0, -1
+	for _, part := range strings.Split(header, ",") {
+		mediaRange, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		mediaRange = strings.ToLower(strings.TrimSpace(mediaRange))
+		s := -1
+		switch mediaRange {
+		case mediaType:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "q") {
+				if f, err := strconv.ParseFloat(value, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		quality, specificity = q, s
+	}
+	return quality
+}
+
+// browse writes the listing of directory dirname, hidden entries are
+// left out.
+func (fsrv *FileServer) browse(ctx context.HTTPContext, dirname string, m *mount) string {
+	r := ctx.Request()
+	w := ctx.Response()
+
+	method := r.Method()
+	if method != http.MethodGet && method != http.MethodHead {
+		w.Header().Add("Allow", "GET, HEAD")
+		w.SetStatusCode(http.StatusMethodNotAllowed)
+		return resultMethodNotAllowed
+	}
+
+	dirEntries, err := fs.ReadDir(fsrv.spec.fileSystem, dirname)
+	if err != nil {
+		logger.Debug("read directory failed", zap.String("path", dirname), zap.Error(err))
+		ctx.AddTag(err.Error())
+		w.SetStatusCode(http.StatusInternalServerError)
+		return resultErrHandleFile
+	}
+
+	base := r.Path()
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	entries := make([]*listingEntry, 0, len(dirEntries))
+	for _, d := range dirEntries {
+		if m.hidden(path.Join(dirname, d.Name())) || fsrv.dotName(d.Name()) {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		u := (&url.URL{Path: base + d.Name()}).EscapedPath()
+		if d.IsDir() {
+			u += "/"
+		}
+		entries = append(entries, &listingEntry{
+			Name:    d.Name(),
+			URL:     u,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			IsDir:   d.IsDir(),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir != entries[j].IsDir {
+			return entries[i].IsDir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+
+	w.Header().Add("Vary", "Accept")
+	if wantsJSON(r) {
+		data, err := json.Marshal(entries)
+		if err != nil {
+			ctx.AddTag(err.Error())
+			w.SetStatusCode(http.StatusInternalServerError)
+			return resultErrHandleFile
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.SetBody(bytes.NewReader(data))
+		return ""
+	}
+
+	var sb strings.Builder
+	err = listingTemplate.Execute(&sb, map[string]interface{}{
+		"Path":    base,
+		"Entries": entries,
+		"Details": fsrv.spec.Browse.Details,
+	})
+	if err != nil {
+		ctx.AddTag(err.Error())
+		w.SetStatusCode(http.StatusInternalServerError)
+		return resultErrHandleFile
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.SetBody(strings.NewReader(sb.String()))
+	return ""
+}
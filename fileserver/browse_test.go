@@ -0,0 +1,79 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBrowseJSON(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"list/sub", "list/.git"} {
+		os.MkdirAll(filepath.Join(root, dir), 0o755)
+	}
+	for _, name := range []string{"list/b.txt", "list/a b.txt", "list/secret.bak", "list/.env"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("12345"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsrv := &FileServer{spec: &Spec{Root: root, fileSystem: osFS{}, Hide: []string{"*.bak"}, Browse: &BrowseSpec{}}}
+	fsrv.mounts = fsrv.buildMounts()
+
+	listing := []string{"sub", "a b.txt", "b.txt"}
+	urls := []string{"/list/sub/", "/list/a%20b.txt", "/list/b.txt"}
+	for _, c := range []struct {
+		method, path, query, accept string
+		result                      string
+		status                      int
+		contentType                 string
+	}{
+		{"GET", "/list/", "format=json", "", "", 0, "application/json; charset=utf-8"},
+		{"GET", "/list/", "", "application/json", "", 0, "application/json; charset=utf-8"},
+		{"HEAD", "/list/", "", "text/html, application/json;q=0.9", "", 0, "text/html; charset=utf-8"},
+		{"GET", "/list/", "", "text/html;q=0.5, application/json", "", 0, "application/json; charset=utf-8"},
+		{"GET", "/list/", "", "application/json, */*", "", 0, "text/html; charset=utf-8"},
+		{"GET", "/list/", "", "application/*, text/*", "", 0, "text/html; charset=utf-8"},
+		{"GET", "/list/", "", "*/*", "", 0, "text/html; charset=utf-8"},
+		{"GET", "/list/", "", "application/json;q=0", "", 0, "text/html; charset=utf-8"},
+		{"GET", "/list", "format=json", "", "", 0, "application/json; charset=utf-8"},
+		{"GET", "/list/", "format=html", "application/json", "", 0, "text/html; charset=utf-8"},
+		{"GET", "/list/", "", "text/html", "", 0, "text/html; charset=utf-8"},
+		{"POST", "/list/", "format=json", "", resultMethodNotAllowed, http.StatusMethodNotAllowed, ""},
+	} {
+		req := httptest.NewRequest(c.method, "/", nil)
+		req.URL.Path, req.URL.RawQuery = c.path, c.query
+		req.Header.Set("Accept", c.accept)
+		ctx, rec := newMockedContext(req)
+
+		name := c.method + " " + c.path + "?" + c.query + " accept " + c.accept
+		res := fsrv.handle(ctx, &served{})
+		if res != c.result || rec.Code != c.status || rec.Header().Get("Content-Type") != c.contentType {
+			t.Errorf("%s: result %q, status %d, content type %q, want %q, %d, %q",
+				name, res, rec.Code, rec.Header().Get("Content-Type"), c.result, c.status, c.contentType)
+			continue
+		}
+		if c.contentType != "application/json; charset=utf-8" {
+			continue
+		}
+		var entries []*listingEntry
+		if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		var names, gotURLs []string
+		for _, e := range entries {
+			names = append(names, e.Name)
+			gotURLs = append(gotURLs, e.URL)
+			if e.IsDir != (e.Name == "sub") || (!e.IsDir && e.Size != 5) || e.ModTime.IsZero() {
+				t.Errorf("%s: unexpected entry %+v", name, e)
+			}
+		}
+		if !reflect.DeepEqual(names, listing) || !reflect.DeepEqual(gotURLs, urls) {
+			t.Errorf("%s: listed %q at %q, want %q at %q", name, names, gotURLs, listing, urls)
+		}
+	}
+}
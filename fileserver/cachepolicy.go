@@ -0,0 +1,111 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CachePolicySpec sets the caching headers of the files matching a
+// pattern, e.g. hashed assets get max-age=31536000, immutable while
+// HTML gets no-cache.
+type CachePolicySpec struct {
+	// A glob matched against the request path, ** matches any number
+	// of path segments. A glob without a slash is matched against the
+	// file name only, e.g. *.html.
+	Glob string
+	// A regular expression matched against the request path, it is
+	// used when Glob is empty.
+	Regex string
+	// The Cache-Control header, e.g. no-cache. It is built from
+	// MaxAge and Immutable if empty.
+	CacheControl string
+	// Default: no max-age.
+	MaxAge    string
+	Immutable bool
+	// Set Expires this long after the response, e.g. 24h.
+	Expires string
+}
+
+type cachePolicy struct {
+	spec         *CachePolicySpec
+	regexp       *regexp.Regexp
+	cacheControl string
+	expires      time.Duration
+}
+
+func (spec *CachePolicySpec) validate() error {
+	if spec.Glob == "" && spec.Regex == "" {
+		return fmt.Errorf("cache policy requires a glob or a regex")
+	}
+	if spec.Glob != "" {
+		if _, err := path.Match(strings.ReplaceAll(spec.Glob, "**", "*"), ""); err != nil {
+			return fmt.Errorf("invalid cache policy glob %q: %v", spec.Glob, err)
+		}
+	} else if _, err := regexp.Compile(spec.Regex); err != nil {
+		return fmt.Errorf("invalid cache policy regex %q: %v", spec.Regex, err)
+	}
+	for _, d := range []string{spec.MaxAge, spec.Expires} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d); err != nil {
+			return fmt.Errorf("invalid cache policy duration: %v", err)
+		}
+	}
+	return nil
+}
+
+func newCachePolicies(specs []*CachePolicySpec) []*cachePolicy {
+	policies := make([]*cachePolicy, 0, len(specs))
+	for _, spec := range specs {
+		p := &cachePolicy{spec: spec, cacheControl: spec.CacheControl}
+		if spec.Glob == "" {
+			p.regexp = regexp.MustCompile(spec.Regex)
+		}
+		if p.cacheControl == "" {
+			var directives []string
+			if spec.MaxAge != "" {
+				d, _ := time.ParseDuration(spec.MaxAge)
+				directives = append(directives, "max-age="+strconv.FormatInt(int64(d/time.Second), 10))
+			}
+			if spec.Immutable {
+				directives = append(directives, "immutable")
+			}
+			p.cacheControl = strings.Join(directives, ", ")
+		}
+		if spec.Expires != "" {
+			p.expires, _ = time.ParseDuration(spec.Expires)
+		}
+		policies = append(policies, p)
+	}
+	return policies
+}
+
+func (p *cachePolicy) match(reqPath string) bool {
+	if p.regexp != nil {
+		return p.regexp.MatchString(reqPath)
+	}
+	return matchGlob(p.spec.Glob, reqPath)
+}
+
+// setCacheHeaders sets the headers of the first policy matching the
+// request path.
+func (fsrv *FileServer) setCacheHeaders(h http.Header, reqPath string) {
+	for _, p := range fsrv.cachePolicies {
+		if !p.match(reqPath) {
+			continue
+		}
+		if p.cacheControl != "" {
+			h.Set("Cache-Control", p.cacheControl)
+		}
+		if p.expires > 0 {
+			h.Set("Expires", time.Now().Add(p.expires).UTC().Format(http.TimeFormat))
+		}
+		return
+	}
+}
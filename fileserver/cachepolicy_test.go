@@ -0,0 +1,33 @@
+package fileserver
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCachePolicies(t *testing.T) {
+	fsrv := &FileServer{cachePolicies: newCachePolicies([]*CachePolicySpec{
+		{Regex: `\.[0-9a-f]{8}\.(js|css)$`, MaxAge: "8760h", Immutable: true},
+		{Glob: "*.html", CacheControl: "no-cache"},
+		{Glob: "/downloads/*", Expires: "24h"},
+	})}
+
+	for _, c := range []struct {
+		path, cacheControl string
+		expires            bool
+	}{
+		{"/static/app.1a2b3c4d.js", "max-age=31536000, immutable", false},
+		{"/blog/index.html", "no-cache", false},
+		{"/downloads/a.zip", "", true},
+		{"/static/app.js", "", false},
+	} {
+		h := http.Header{}
+		fsrv.setCacheHeaders(h, c.path)
+		if got := h.Get("Cache-Control"); got != c.cacheControl {
+			t.Errorf("%s: Cache-Control = %q, want %q", c.path, got, c.cacheControl)
+		}
+		if got := h.Get("Expires") != ""; got != c.expires {
+			t.Errorf("%s: Expires set = %v, want %v", c.path, got, c.expires)
+		}
+	}
+}
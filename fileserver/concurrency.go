@@ -0,0 +1,162 @@
+package fileserver
+
+import (
+	stdcontext "context"
+	"sync"
+	"time"
+)
+
+type (
+	// transferLimiter bounds the file transfers in flight, in total
+	// and per client IP. Requests over the limits wait in a queue for
+	// up to maxWait.
+	transferLimiter struct {
+		maxTotal int
+		maxPerIP int
+		maxWait  time.Duration
+
+		mu    sync.Mutex
+		total int
+		perIP map[string]int
+		// closed and replaced whenever a transfer finishes
+		released chan struct{}
+		routes   map[string]*routeTransfers
+	}
+
+	// routeTransfers are the transfers of the requests of a route,
+	// the prefix of a mount.
+	routeTransfers struct {
+		inFlight  int
+		queued    int
+		rejected  uint64
+		queueWait latencyHistogram
+	}
+
+	// TransferStatus is the status of the transfers of a route.
+	TransferStatus struct {
+		InFlight int `yaml:"inFlight"`
+		// Queued is the number of requests waiting for a transfer
+		// to finish.
+		Queued   int    `yaml:"queued"`
+		Rejected uint64 `yaml:"rejected"`
+		// QueueWait is the time requests waited to start their
+		// transfer, including the ones rejected in the end.
+		QueueWait *LatencyStatus `yaml:"queueWait"`
+	}
+)
+
+func newTransferLimiter(maxTotal, maxPerIP int, maxWait time.Duration) *transferLimiter {
+	return &transferLimiter{
+		maxTotal: maxTotal,
+		maxPerIP: maxPerIP,
+		maxWait:  maxWait,
+		perIP:    make(map[string]int),
+		released: make(chan struct{}),
+		routes:   make(map[string]*routeTransfers),
+	}
+}
+
+// route returns the transfers of route, the caller must hold the mutex.
+func (l *transferLimiter) route(route string) *routeTransfers {
+	rt, ok := l.routes[route]
+	if !ok {
+		rt = &routeTransfers{}
+		l.routes[route] = rt
+	}
+	return rt
+}
+
+// acquire reports whether a transfer of route for ip may start, it
+// waits for a free slot until maxWait passes or ctx is done. If it
+// returns true, release must be called when the transfer is done.
+func (l *transferLimiter) acquire(ctx stdcontext.Context, route, ip string) bool {
+	start := time.Now()
+	var timer *time.Timer
+	queued := false
+
+	l.mu.Lock()
+	rt := l.route(route)
+	defer func() {
+		if queued {
+			rt.queued--
+			rt.queueWait.observe(time.Since(start))
+		}
+		l.mu.Unlock()
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		if l.tryAcquire(ip) {
+			rt.inFlight++
+			return true
+		}
+		if l.maxWait <= 0 {
+			rt.rejected++
+			return false
+		}
+		if !queued {
+			queued = true
+			rt.queued++
+			timer = time.NewTimer(l.maxWait)
+		}
+		released := l.released
+		l.mu.Unlock()
+		select {
+		case <-released:
+			l.mu.Lock()
+		case <-timer.C:
+			l.mu.Lock()
+			rt.rejected++
+			return false
+		case <-ctx.Done():
+			l.mu.Lock()
+			rt.rejected++
+			return false
+		}
+	}
+}
+
+// tryAcquire takes a slot for ip if there is one, the caller must hold
+// the mutex.
+func (l *transferLimiter) tryAcquire(ip string) bool {
+	if l.maxTotal > 0 && l.total >= l.maxTotal {
+		return false
+	}
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		return false
+	}
+	l.total++
+	l.perIP[ip]++
+	return true
+}
+
+func (l *transferLimiter) release(route, ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if n := l.perIP[ip] - 1; n > 0 {
+		l.perIP[ip] = n
+	} else {
+		delete(l.perIP, ip)
+	}
+	l.route(route).inFlight--
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+func (l *transferLimiter) status() map[string]*TransferStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	result := make(map[string]*TransferStatus, len(l.routes))
+	for route, rt := range l.routes {
+		result[route] = &TransferStatus{
+			InFlight:  rt.inFlight,
+			Queued:    rt.queued,
+			Rejected:  rt.rejected,
+			QueueWait: rt.queueWait.status(),
+		}
+	}
+	return result
+}
@@ -0,0 +1,64 @@
+package fileserver
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTransferLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := newTransferLimiter(3, 2, 0)
+	for i, c := range []struct {
+		ip   string
+		want bool
+	}{
+		{"10.0.0.1", true},
+		{"10.0.0.1", true},
+		{"10.0.0.1", false},
+		{"10.0.0.2", true},
+		{"10.0.0.3", false},
+	} {
+		if got := l.acquire(ctx, "/", c.ip); got != c.want {
+			t.Errorf("#%d acquire(%s) = %v, want %v", i, c.ip, got, c.want)
+		}
+	}
+	l.release("/", "10.0.0.1")
+	if !l.acquire(ctx, "/", "10.0.0.3") {
+		t.Error("a released transfer wasn't made available")
+	}
+	l.release("/", "10.0.0.2")
+	if _, ok := l.perIP["10.0.0.2"]; ok {
+		t.Error("idle client still tracked")
+	}
+	if s := l.status()["/"]; s.InFlight != 2 || s.Rejected != 2 {
+		t.Errorf("status = %+v, want 2 in flight and 2 rejected", s)
+	}
+}
+
+func TestTransferLimiterQueue(t *testing.T) {
+	ctx := context.Background()
+	l := newTransferLimiter(1, 0, time.Second)
+	if !l.acquire(ctx, "/docs", "a") {
+		t.Fatal("first transfer rejected")
+	}
+
+	acquired := make(chan bool)
+	go func() { acquired <- l.acquire(ctx, "/docs", "b") }()
+	for l.status()["/docs"].Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	l.release("/docs", "a")
+	if !<-acquired {
+		t.Fatal("queued transfer rejected")
+	}
+
+	l.maxWait = 20 * time.Millisecond
+	if l.acquire(ctx, "/docs", "c") {
+		t.Error("transfer acquired over the limit")
+	}
+	s := l.status()["/docs"]
+	if s.Queued != 0 || s.Rejected != 1 || s.QueueWait.Count != 2 {
+		t.Errorf("status = %+v", s)
+	}
+}
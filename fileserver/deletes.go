@@ -0,0 +1,93 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+// DeletesSpec lets clients delete files with DELETE. Like uploads, the
+// paths must be protected by BasicAuth or SignedURLs, unless
+// AllowAnonymous is set.
+type DeletesSpec struct {
+	// The directories of the root files may be deleted from, e.g.
+	// /artifacts. They are matched against the request path after
+	// StripPathPrefix and mounts. Deleting anything else answers 403.
+	Prefixes []string
+	// Accept deletes of paths neither BasicAuth nor SignedURLs
+	// protect, e.g. when a filter in front authenticates the
+	// clients. Default: false, they are answered with 403.
+	AllowAnonymous bool
+}
+
+func (spec *DeletesSpec) validate() error {
+	for _, p := range spec.Prefixes {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("deletes: prefix %q must start with /", p)
+		}
+	}
+	return nil
+}
+
+func (spec *DeletesSpec) match(p string) bool {
+	for _, prefix := range spec.Prefixes {
+		prefix = strings.TrimSuffix(path.Clean(prefix), "/") + "/"
+		if strings.HasPrefix(p, prefix) && len(p) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// delete deletes filename, the file rel of mount m. Directories are
+// never deleted.
+func (fsrv *FileServer) delete(ctx context.HTTPContext, m *mount, rel, filename string) string {
+	w := ctx.Response()
+	if !fsrv.spec.Deletes.AllowAnonymous && !fsrv.protected(ctx.Request().Path()) {
+		ctx.AddTag("delete: anonymous deletes not allowed")
+		fsrv.authFailure(ctx, "anonymous delete", nil)
+		w.SetStatusCode(http.StatusForbidden)
+		return resultUnauthorized
+	}
+	if !fsrv.spec.Deletes.match(rel) || strings.HasSuffix(rel, "/") {
+		ctx.AddTag("delete denied")
+		w.SetStatusCode(http.StatusForbidden)
+		return resultDeleteDenied
+	}
+	if m.hidden(filename) {
+		return fsrv.notFound(ctx)
+	}
+
+	info, err := os.Lstat(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fsrv.notFound(ctx)
+	}
+	if err == nil && info.IsDir() {
+		ctx.AddTag("delete denied: directory")
+		w.SetStatusCode(http.StatusForbidden)
+		return resultDeleteDenied
+	}
+	if err == nil {
+		err = os.Remove(filename)
+	}
+	if err != nil {
+		logger.Error("delete file failed", zap.String("filename", filename), zap.Error(err))
+		ctx.AddTag(fmt.Sprintf("delete failed: %v", err))
+		w.SetStatusCode(http.StatusInternalServerError)
+		return resultErrHandleFile
+	}
+
+	if fsrv.statCache != nil {
+		fsrv.statCache.forget(filename)
+	}
+	w.SetStatusCode(http.StatusNoContent)
+	return resultDeleted
+}
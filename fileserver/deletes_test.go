@@ -0,0 +1,34 @@
+package fileserver
+
+import "testing"
+
+func TestDeletesMatch(t *testing.T) {
+	spec := &DeletesSpec{Prefixes: []string{"/artifacts/", "/tmp"}}
+	for _, c := range []struct {
+		path string
+		want bool
+	}{
+		{"/artifacts/build-1.zip", true},
+		{"/tmp/a/b", true},
+		{"/artifacts/", false},
+		{"/artifactsx/a", false},
+		{"/index.html", false},
+	} {
+		if got := spec.match(c.path); got != c.want {
+			t.Errorf("match(%s) = %v, want %v", c.path, got, c.want)
+		}
+	}
+}
+
+func TestDeletesAuthentication(t *testing.T) {
+	deletes := &DeletesSpec{Prefixes: []string{"/artifacts"}}
+	if err := (&Spec{Deletes: deletes}).Validate(); err == nil {
+		t.Error("anonymous deletes accepted")
+	}
+	if err := (&Spec{Deletes: &DeletesSpec{Prefixes: []string{"/artifacts"}, AllowAnonymous: true}}).Validate(); err != nil {
+		t.Error(err)
+	}
+	if err := (&Spec{Deletes: deletes, SignedURLs: &SignedURLsSpec{Secret: "s3cret"}}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,59 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/context"
+)
+
+const (
+	// DotFilesIgnore answers requests for dotfiles with 404, as if
+	// they didn't exist.
+	DotFilesIgnore = "ignore"
+	// DotFilesDeny answers requests for dotfiles with 403.
+	DotFilesDeny = "deny"
+	// DotFilesAllow serves dotfiles like any other file.
+	DotFilesAllow = "allow"
+)
+
+func validateDotFiles(policy string) error {
+	switch policy {
+	case "", DotFilesIgnore, DotFilesDeny, DotFilesAllow:
+		return nil
+	}
+	return fmt.Errorf("invalid dot files policy %q", policy)
+}
+
+// dotName reports whether name is a dotfile or dot directory covered
+// by the DotFiles policy, .well-known is always served.
+func (fsrv *FileServer) dotName(name string) bool {
+	if fsrv.spec.DotFiles == DotFilesAllow {
+		return false
+	}
+	return strings.HasPrefix(name, ".") && name != "." && name != ".." && name != ".well-known"
+}
+
+// dotPath reports whether any element of rel, the request path relative
+// to the root, is covered by the DotFiles policy. The root itself may be
+// under a dot directory.
+func (fsrv *FileServer) dotPath(rel string) bool {
+	for _, name := range strings.Split(rel, "/") {
+		if fsrv.dotName(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// dotFile answers a request for a dotfile according to the DotFiles
+// policy.
+func (fsrv *FileServer) dotFile(ctx context.HTTPContext) string {
+	if fsrv.spec.DotFiles == DotFilesDeny {
+		ctx.AddTag("dotfile denied")
+		ctx.Response().SetStatusCode(http.StatusForbidden)
+		return resultErrPermission
+	}
+	return fsrv.notFound(ctx)
+}
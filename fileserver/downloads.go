@@ -0,0 +1,90 @@
+package fileserver
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/FucAttaCk/gateway/util"
+)
+
+// DownloadsSpec makes browsers save files instead of displaying them,
+// by sending Content-Disposition: attachment.
+type DownloadsSpec struct {
+	// Globs matched against the request path like the globs of
+	// cache policies, e.g. /reports/** or *.csv.
+	Globs []string
+	// A query parameter that forces the download of any file when its
+	// value is 1 or true, e.g. download.
+	Query string
+	// The name the file is saved as. Placeholders: {file.name},
+	// {file.base}, {file.ext}, {http.request.path},
+	// {http.request.host}, {http.request.query.*} and the global
+	// placeholders like {time.now.year}. Default: {file.name}.
+	Filename string
+}
+
+func (spec *DownloadsSpec) validate() error {
+	for _, g := range spec.Globs {
+		if _, err := path.Match(strings.ReplaceAll(g, "**", "*"), ""); err != nil {
+			return fmt.Errorf("invalid downloads glob %q: %v", g, err)
+		}
+	}
+	return nil
+}
+
+func (spec *DownloadsSpec) match(reqPath, rawQuery string) bool {
+	for _, g := range spec.Globs {
+		if matchGlob(g, reqPath) {
+			return true
+		}
+	}
+	if spec.Query == "" {
+		return false
+	}
+	query, _ := url.ParseQuery(rawQuery)
+	switch strings.ToLower(query.Get(spec.Query)) {
+	case "1", "true":
+		return true
+	}
+	return false
+}
+
+// setDownloadHeaders sets Content-Disposition if the request is for a
+// download, filename is the file being served.
+func (fsrv *FileServer) setDownloadHeaders(h http.Header, reqPath, host, rawQuery, filename string) {
+	spec := fsrv.spec.Downloads
+	if spec == nil || !spec.match(reqPath, rawQuery) {
+		return
+	}
+
+	name := filepath.Base(filename)
+	if spec.Filename != "" {
+		ext := filepath.Ext(name)
+		r := util.NewReplacer()
+		r.Set("file.name", name)
+		r.Set("file.base", strings.TrimSuffix(name, ext))
+		r.Set("file.ext", ext)
+		r.Set("http.request.path", reqPath)
+		r.Set("http.request.host", hostname(host))
+		r.Map(func(key string) (any, bool) {
+			const prefix = "http.request.query."
+			if !strings.HasPrefix(key, prefix) {
+				return nil, false
+			}
+			query, _ := url.ParseQuery(rawQuery)
+			return query.Get(key[len(prefix):]), true
+		})
+		// the name must not lead anywhere else on the client, and
+		// empty placeholders fall back to the name of the file
+		n := path.Base(strings.ReplaceAll(r.ReplaceAll(spec.Filename, ""), `\`, "/"))
+		if n != "." && n != "/" && strings.TrimSuffix(n, path.Ext(n)) != "" {
+			name = n
+		}
+	}
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+}
@@ -0,0 +1,30 @@
+package fileserver
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDownloadHeaders(t *testing.T) {
+	fsrv := &FileServer{spec: &Spec{Downloads: &DownloadsSpec{
+		Globs:    []string{"/reports/**"},
+		Query:    "download",
+		Filename: "{http.request.query.name}{file.ext}",
+	}}}
+
+	for _, c := range []struct {
+		path, query, want string
+	}{
+		{"/reports/2022/q1.csv", "name=sales", `attachment; filename=sales.csv`},
+		{"/img/a.png", "download=1", `attachment; filename=a.png`},
+		{"/img/a.png", "download=0", ""},
+		{"/reports/q1.csv", "name=../../etc/passwd", `attachment; filename=passwd.csv`},
+		{"/reports/q1.csv", "name=r%C3%A9sum%C3%A9", `attachment; filename*=utf-8''r%C3%A9sum%C3%A9.csv`},
+	} {
+		h := http.Header{}
+		fsrv.setDownloadHeaders(h, c.path, "example.com", c.query, "/srv"+c.path)
+		if got := h.Get("Content-Disposition"); got != c.want {
+			t.Errorf("%s?%s: Content-Disposition = %q, want %q", c.path, c.query, got, c.want)
+		}
+	}
+}
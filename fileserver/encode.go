@@ -0,0 +1,188 @@
+package fileserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+const defaultEncodeMinSize = 1024
+
+var (
+	defaultEncodings    = []string{"gzip"}
+	defaultEncodedTypes = []string{"text/*", "application/javascript", "application/json",
+		"application/xml", "application/wasm", "image/svg+xml"}
+
+	gzipWriters = sync.Pool{New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	}}
+	zstdWriters = sync.Pool{New: func() interface{} {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return w
+	}}
+)
+
+// EncodeResponsesSpec describes which responses are compressed on the
+// fly. Compressed responses don't support ranges and are never sent
+// with sendfile.
+type EncodeResponsesSpec struct {
+	// The encodings to offer, in order of preference, gzip and zstd
+	// are supported. Default: gzip.
+	Encodings []string
+	// Files smaller than this are sent as they are. Default: 1KiB.
+	MinSize int64
+	// The content types to compress, a trailing * matches any
+	// subtype. Default: text/*, application/javascript,
+	// application/json, application/xml, application/wasm and
+	// image/svg+xml.
+	ContentTypes []string
+}
+
+// encodingWriter compresses the body of 200 responses, other responses
+// are passed through.
+type encodingWriter struct {
+	http.ResponseWriter
+	encoding    string
+	enc         io.WriteCloser
+	wroteHeader bool
+}
+
+func (spec *EncodeResponsesSpec) validate() error {
+	for _, e := range spec.Encodings {
+		if e != "gzip" && e != "zstd" {
+			return fmt.Errorf("unsupported encoding %q", e)
+		}
+	}
+	return nil
+}
+
+// negotiate returns the encoding to use for a file of the content type
+// and size, or an empty string if it should be sent as it is.
+func (spec *EncodeResponsesSpec) negotiate(acceptEncoding, contentType string, size int64) string {
+	minSize := spec.MinSize
+	if minSize <= 0 {
+		minSize = defaultEncodeMinSize
+	}
+	if size < minSize || acceptEncoding == "" {
+		return ""
+	}
+
+	types := spec.ContentTypes
+	if len(types) == 0 {
+		types = defaultEncodedTypes
+	}
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.TrimSpace(contentType)
+	matched := false
+	for _, t := range types {
+		if t == contentType || (strings.HasSuffix(t, "*") && strings.HasPrefix(contentType, t[:len(t)-1])) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return ""
+	}
+
+	encodings := spec.Encodings
+	if len(encodings) == 0 {
+		encodings = defaultEncodings
+	}
+	accepted := parseAcceptEncoding(acceptEncoding)
+	for _, e := range encodings {
+		if q, ok := accepted[e]; ok && q > 0 {
+			return e
+		}
+		if q, ok := accepted["*"]; ok && q > 0 {
+			if _, listed := accepted[e]; !listed {
+				return e
+			}
+		}
+	}
+	return ""
+}
+
+// parseAcceptEncoding maps the codings of an Accept-Encoding header to
+// their quality values.
+func parseAcceptEncoding(header string) map[string]float64 {
+	accepted := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		params = strings.TrimSpace(params)
+		if strings.HasPrefix(params, "q=") {
+			if f, err := strconv.ParseFloat(params[2:], 64); err == nil {
+				q = f
+			}
+		}
+		accepted[coding] = q
+	}
+	return accepted
+}
+
+func newEncodingWriter(w http.ResponseWriter, encoding string) *encodingWriter {
+	return &encodingWriter{ResponseWriter: w, encoding: encoding}
+}
+
+func (ew *encodingWriter) WriteHeader(code int) {
+	if ew.wroteHeader {
+		return
+	}
+	ew.wroteHeader = true
+	if code == http.StatusOK {
+		h := ew.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", ew.encoding)
+		switch ew.encoding {
+		case "gzip":
+			gw := gzipWriters.Get().(*gzip.Writer)
+			gw.Reset(ew.ResponseWriter)
+			ew.enc = gw
+		case "zstd":
+			zw := zstdWriters.Get().(*zstd.Encoder)
+			zw.Reset(ew.ResponseWriter)
+			ew.enc = zw
+		}
+	}
+	ew.ResponseWriter.WriteHeader(code)
+}
+
+func (ew *encodingWriter) Write(p []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.enc == nil {
+		return ew.ResponseWriter.Write(p)
+	}
+	return ew.enc.Write(p)
+}
+
+// Close flushes the compressed stream and returns the encoder to its
+// pool.
+func (ew *encodingWriter) Close() error {
+	if ew.enc == nil {
+		return nil
+	}
+	err := ew.enc.Close()
+	switch enc := ew.enc.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(enc)
+	case *zstd.Encoder:
+		zstdWriters.Put(enc)
+	}
+	ew.enc = nil
+	return err
+}
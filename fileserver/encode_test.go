@@ -0,0 +1,62 @@
+package fileserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+func TestEncodeNegotiate(t *testing.T) {
+	spec := &EncodeResponsesSpec{Encodings: []string{"zstd", "gzip"}}
+	for _, c := range []struct {
+		accept, contentType string
+		size                int64
+		want                string
+	}{
+		{"gzip, deflate, br", "text/html; charset=utf-8", 4096, "gzip"},
+		{"gzip, zstd", "application/json", 4096, "zstd"},
+		{"zstd;q=0, gzip;q=0.5", "text/css", 4096, "gzip"},
+		{"*", "text/css", 4096, "zstd"},
+		{"gzip", "image/png", 4096, ""},
+		{"gzip", "text/plain", 100, ""},
+		{"", "text/plain", 4096, ""},
+	} {
+		if got := spec.negotiate(c.accept, c.contentType, c.size); got != c.want {
+			t.Errorf("negotiate(%q, %q, %d) = %q, want %q", c.accept, c.contentType, c.size, got, c.want)
+		}
+	}
+}
+
+func TestEncodingWriter(t *testing.T) {
+	body := strings.Repeat("hello, world\n", 1000)
+	rec := httptest.NewRecorder()
+	ew := newEncodingWriter(rec, "gzip")
+	req := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+	http.ServeContent(ew, req, "a.txt", time.Time{}, strings.NewReader(body))
+	if err := ew.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	if got := rec.Header().Get("Content-Length"); got != "" {
+		t.Errorf("unexpected Content-Length %s", got)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != body {
+		t.Errorf("decoded body mismatch")
+	}
+}
@@ -0,0 +1,95 @@
+package fileserver
+
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/FucAttaCk/gateway/util"
+	"github.com/cespare/xxhash/v2"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	etagModeModTime     = "modtime"
+	etagModeContentHash = "content-hash"
+
+	etagHashCacheSize = 10000
+)
+
+type etagHashKey struct {
+	name    string
+	modTime int64
+	size    int64
+}
+
+func validateEtag(spec *Spec) error {
+	switch spec.EtagMode {
+	case "", etagModeModTime, etagModeContentHash:
+	default:
+		return fmt.Errorf("invalid etag mode %q", spec.EtagMode)
+	}
+	// the suffix ends up inside a quoted string
+	if strings.ContainsAny(spec.EtagSuffix, "\"\\ \t") {
+		return fmt.Errorf("invalid etag suffix %q", spec.EtagSuffix)
+	}
+	return nil
+}
+
+// etag returns the Etag of the file formatted as configured, or an
+// empty string if Etags are disabled.
+func (fsrv *FileServer) etag(filename string, info fs.FileInfo) (string, error) {
+	if fsrv.spec.DisableEtag {
+		return "", nil
+	}
+	etag, err := fsrv.baseEtag(filename, info)
+	if err != nil {
+		return "", err
+	}
+	if fsrv.spec.EtagSuffix != "" {
+		etag = strings.TrimSuffix(etag, `"`) + "-" + fsrv.spec.EtagSuffix + `"`
+	}
+	if fsrv.spec.EtagWeak {
+		etag = "W/" + etag
+	}
+	return etag, nil
+}
+
+// baseEtag returns the strong Etag of the file according to the
+// configured mode. Content hashes are cached by name, modification
+// time and size, so each version of a file is read once.
+func (fsrv *FileServer) baseEtag(filename string, info fs.FileInfo) (string, error) {
+	if fsrv.etagHashes == nil {
+		// from the time on disk even if another one is served, a
+		// pinned or rounded time would keep the Etag of a changed
+		// file of the same size
+		return calculateEtag(info), nil
+	}
+
+	key := etagHashKey{name: filename, modTime: info.ModTime().UnixNano(), size: info.Size()}
+	if v, ok := fsrv.etagHashes.Get(key); ok {
+		return v.(string), nil
+	}
+
+	file, err := fsrv.openFile(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := xxhash.New()
+	if _, err := util.Copy(h, file); err != nil {
+		return "", err
+	}
+	etag := `"` + strconv.FormatUint(h.Sum64(), 36) + `"`
+	fsrv.etagHashes.Add(key, etag)
+	return etag, nil
+}
+
+func newEtagHashes(mode string) *lru.Cache {
+	if mode != etagModeContentHash {
+		return nil
+	}
+	c, _ := lru.New(etagHashCacheSize)
+	return c
+}
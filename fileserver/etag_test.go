@@ -0,0 +1,45 @@
+package fileserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEtag(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(filename, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	etagOf := func(spec *Spec) string {
+		spec.fileSystem = osFS{}
+		fsrv := &FileServer{spec: spec, etagHashes: newEtagHashes(spec.EtagMode)}
+		etag, err := fsrv.etag(filename, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return etag
+	}
+
+	if got, want := etagOf(&Spec{}), calculateEtag(info); got != want {
+		t.Errorf("default etag = %s, want %s", got, want)
+	}
+	hash := etagOf(&Spec{EtagMode: etagModeContentHash})
+	if hash == calculateEtag(info) || !strings.HasPrefix(hash, `"`) {
+		t.Errorf("unexpected content hash etag %s", hash)
+	}
+	if got, want := etagOf(&Spec{EtagMode: etagModeContentHash, EtagWeak: true, EtagSuffix: "v42"}),
+		"W/"+strings.TrimSuffix(hash, `"`)+`-v42"`; got != want {
+		t.Errorf("weak etag with suffix = %s, want %s", got, want)
+	}
+	if got := etagOf(&Spec{DisableEtag: true}); got != "" {
+		t.Errorf("disabled etag = %s", got)
+	}
+}
@@ -1,23 +1,31 @@
 package fileserver
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/FucAttaCk/gateway/diskwatch"
+	"github.com/FucAttaCk/gateway/secevent"
 	"github.com/FucAttaCk/gateway/util"
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
 	"github.com/nacos-group/nacos-sdk-go/common/logger"
 	"go.uber.org/zap"
 	"io"
 	"io/fs"
-	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -32,11 +40,32 @@ const (
 	resultErrPermission    = "errPermission"
 	resultErrHandleFile    = "errHandleFile"
 	resultMethodNotAllowed = "methodNotAllowed"
+	resultStalled          = "stalled"
+	resultFallthrough      = "fallthrough"
+	resultRedirect         = "redirect"
+	resultInvalidRange     = "invalidRange"
+	resultTooManyRequests  = "tooManyRequests"
+	resultInvalidUpload    = "invalidUpload"
+	resultTooLarge         = "tooLarge"
+	resultConflict         = "conflict"
+	resultDiskFull         = "diskFull"
+	resultDeleted          = "deleted"
+	resultDeleteDenied     = "deleteDenied"
+	resultUnauthorized     = "unauthorized"
+	resultQuarantined      = "quarantined"
+	resultInvalidSignature = "invalidSignature"
+	resultHotlinkBlocked   = "hotlinkBlocked"
+	// the client went away before the response was written, it is
+	// counted with status 499 instead of as a failure
+	resultClientDisconnected = "clientDisconnected"
 )
 
 var (
 	results = []string{resultIllegalADSPath, resultIllegalShortName, resultMethodNotAllowed,
-		resultNotFound, resultErrPermission, resultErrHandleFile}
+		resultNotFound, resultErrPermission, resultErrHandleFile, resultStalled, resultFallthrough, resultRedirect, resultInvalidRange,
+		resultTooManyRequests, resultInvalidUpload, resultTooLarge, resultConflict, resultDiskFull,
+		resultDeleted, resultDeleteDenied, resultUnauthorized, resultQuarantined,
+		resultInvalidSignature, resultHotlinkBlocked, resultClientDisconnected}
 	repl               = util.NewReplacer()
 	_    fs.StatFS     = (*osFS)(nil)
 	_    fs.GlobFS     = (*osFS)(nil)
@@ -64,17 +93,363 @@ type (
 		fileSystem    fs.FS
 		Root          string
 		Hide          []string
+		// How files and directories starting with a dot are served:
+		// ignore answers 404, deny answers 403 and allow serves them.
+		// .well-known is always served. Default: ignore.
+		DotFiles string
+		// Regular expressions hiding the files whose path or any path
+		// component matches, e.g. \.bak$|~$|^\..* hides backups and
+		// dotfiles.
+		HideRegex []string
 		// The names of files to try as index files if a folder is requested.
 		// Default: index.html, index.txt.
 		IndexNames []string
+		// Index names by request path, the first match wins over
+		// IndexNames and the index names of mounts.
+		IndexOverrides []*IndexOverrideSpec
+		// Files to read ahead in the background when the filter is
+		// initialized.
+		WarmUp *WarmUpSpec
+		// Persist per-path request statistics across restarts.
+		Stats *StatsSpec
+		// Serve large files from a memory mapping.
+		Mmap *MmapSpec
+		// Experimental: read files through io_uring, it requires
+		// a Linux build with the iouring tag.
+		IOUring bool
+		// Abort the response if sending the file makes no progress
+		// for this long, e.g. 30s. Setting it disables sendfile.
+		StallTimeout string
+		// Add a Server-Timing header with the time spent locating
+		// and opening the file.
+		ServerTiming bool
+		// Serve path prefixes from other roots, requests matching
+		// no mount are served from Root.
+		Mounts []*MountSpec
+		// List directories without an index file, as HTML or JSON.
+		Browse *BrowseSpec
+		// Replace the responses of results, the first match wins.
+		ResultOverrides []*ResultOverrideSpec
+		// Serve range requests through a read-ahead cache.
+		ReadAhead *ReadAheadSpec
+		// Expose the hash manifest of the served files.
+		Manifest *ManifestSpec
+		// Compress eligible responses on the fly.
+		EncodeResponses *EncodeResponsesSpec
+		// Return fallthrough without touching the response when the
+		// file is not found, so the next filter can handle it.
+		PassThroughOnNotFound bool
+		// Caching headers by path, the first match wins.
+		CachePolicies []*CachePolicySpec
+		// How Etags are computed, modtime derives them from the
+		// modification time and size, content-hash from a hash of
+		// the content. Default: modtime.
+		EtagMode string
+		// Send weak Etags, some CDNs require them.
+		EtagWeak bool
+		// Appended to every Etag, e.g. the deploy version, so a
+		// deploy invalidates all of them.
+		EtagSuffix string
+		// Don't send Etags at all, conditional requests are then
+		// answered from Last-Modified only.
+		DisableEtag bool
+		// Redirect directories without a trailing slash, and files
+		// with one, to their canonical URL.
+		CanonicalRedirects bool
+		// 301 or 308. Default: 308.
+		RedirectStatusCode int
+		// Removed from the request path before it is mapped to a
+		// file, e.g. /static serves /static/app.js from Root/app.js.
+		StripPathPrefix string
+		// Prepended to the request path after StripPathPrefix.
+		AddPathPrefix string
+		// Document roots by the request host, e.g.
+		// a.example.com: /srv/a. Root serves the other hosts.
+		Hosts map[string]string
+		// Keep small, frequently requested files in memory.
+		MemoryCache *MemoryCacheSpec
+		// Cache file metadata for a short time.
+		StatCache *StatCacheSpec
+		// Restrict byte-serving.
+		Ranges *RangesSpec
+		// Extra response headers of the files matching a pattern.
+		Headers []*HeaderRuleSpec
+		// Files sent as attachments.
+		Downloads *DownloadsSpec
+		// Log every request handled by the filter.
+		AccessLog *AccessLogSpec
+		// Limit the bandwidth of the served files.
+		Throttle *ThrottleSpec
+		// Requests for files beyond this many simultaneous transfers
+		// are answered with 429, in total and per client IP.
+		MaxConcurrentRequests int
+		MaxConcurrentPerIP    int
+		// How long requests over the concurrency limits wait for a
+		// transfer to finish before they are answered, e.g. 2s.
+		// Default: 0, they are answered right away.
+		MaxQueueWait string
+		// Accept files uploaded with PUT or POST.
+		Uploads *UploadsSpec
+		// Delete files with DELETE. Default: disabled, DELETE answers
+		// 405 like any other method not serving a file.
+		Deletes *DeletesSpec
+		// Render the matching files as templates.
+		Templates *TemplatesSpec
+		// Require credentials for path prefixes.
+		BasicAuth *BasicAuthSpec
+		// Require signed, expiring URLs for path prefixes.
+		SignedURLs *SignedURLsSpec
+		// Where failed authentications, invalid signed URLs and
+		// blocked hotlinks are reported.
+		SecurityEvents *secevent.Spec
+		// Content types of extensions the platform doesn't know.
+		MimeTypes *MimeTypesSpec
+		// Block requests for images and videos linked from other
+		// sites.
+		Referer *RefererSpec
+		// Override the modification times of the served files.
+		LastModified *LastModifiedSpec
 	}
 
 	FileServer struct {
 		filterSpec *httppipeline.FilterSpec
 		spec       *Spec
+		stats      *pathStats
+		ring       *uring
+		readAhead  *readAheadCache
+		memCache   *memoryCache
+		statCache  *statCache
+		mmaps      *mmapCache
+		accessLog  *accessLog
+		// shared by all responses when the bandwidth is limited
+		bandwidth *util.TokenBucket
+		transfers *transferLimiter
+		// the disks of the upload roots and quarantine by directory
+		uploadDisks map[string]*diskwatch.Watcher
+		scanner     *scanner
+
+		cachePolicies []*cachePolicy
+		headerRules   []*headerRule
+		etagHashes    *lru.Cache
+		// parsed templates by file, modification time and size
+		templates  *lru.Cache
+		basicAuth  *basicAuth
+		signedURLs *signedURLs
+		mimeTypes  *mimeTypes
+		events     *secevent.Stream
+
+		manifestHashes manifestHashes
+		manifests      manifestCache
+
+		stallTimeout time.Duration
+
+		requestSizes  sizeHistogram
+		responseSizes sizeHistogram
+		ttfb          latencyHistogram
+		latency       latencyHistogram
+		results       *resultCounter
+		statusCodes   statusCounter
+		bytesServed   uint64
+
+		// mounts ordered by descending prefix length, the last
+		// one is Root
+		mounts []*mount
+		// the roots of Hosts by lower case host name
+		hosts map[string]*mount
+		// index names by request path, they win over the mounts
+		indexOverrides []*indexOverride
+		// the modification times files are served with
+		modTimes *lastModified
+
+		warmUpStop chan struct{}
+		warmUpDone chan struct{}
 	}
 )
 
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.WarmUp != nil && spec.WarmUp.Timeout != "" {
+		if _, err := time.ParseDuration(spec.WarmUp.Timeout); err != nil {
+			return fmt.Errorf("invalid warm up timeout: %v", err)
+		}
+	}
+	if spec.StallTimeout != "" {
+		if _, err := time.ParseDuration(spec.StallTimeout); err != nil {
+			return fmt.Errorf("invalid stall timeout: %v", err)
+		}
+	}
+	if spec.MaxQueueWait != "" {
+		if _, err := time.ParseDuration(spec.MaxQueueWait); err != nil {
+			return fmt.Errorf("invalid max queue wait: %v", err)
+		}
+	}
+	prefixes := map[string]bool{}
+	for _, m := range spec.Mounts {
+		if err := m.validate(); err != nil {
+			return err
+		}
+		prefix := strings.TrimRight(m.Prefix, "/")
+		if prefixes[prefix] {
+			return fmt.Errorf("duplicated mount prefix %s", m.Prefix)
+		}
+		prefixes[prefix] = true
+	}
+	for _, o := range spec.ResultOverrides {
+		if err := o.validate(); err != nil {
+			return err
+		}
+	}
+	if spec.Manifest != nil {
+		if err := spec.Manifest.validate(); err != nil {
+			return err
+		}
+	}
+	if spec.EncodeResponses != nil {
+		if err := spec.EncodeResponses.validate(); err != nil {
+			return err
+		}
+	}
+	if _, err := compileHideRegex(spec.HideRegex); err != nil {
+		return err
+	}
+	if err := validateDotFiles(spec.DotFiles); err != nil {
+		return err
+	}
+	for _, p := range spec.CachePolicies {
+		if err := p.validate(); err != nil {
+			return err
+		}
+	}
+	for _, h := range spec.Headers {
+		if err := h.validate(); err != nil {
+			return err
+		}
+	}
+	for _, o := range spec.IndexOverrides {
+		if err := o.validate(); err != nil {
+			return err
+		}
+	}
+	if spec.LastModified != nil {
+		if err := spec.LastModified.validate(); err != nil {
+			return err
+		}
+	}
+	if spec.Referer != nil {
+		if err := spec.Referer.validate(); err != nil {
+			return err
+		}
+	}
+	if spec.MimeTypes != nil {
+		if err := spec.MimeTypes.validate(); err != nil {
+			return err
+		}
+	}
+	if spec.SignedURLs != nil {
+		if err := spec.SignedURLs.validate(); err != nil {
+			return err
+		}
+	}
+	if spec.BasicAuth != nil {
+		if err := spec.BasicAuth.validate(); err != nil {
+			return err
+		}
+	}
+	if spec.SecurityEvents != nil {
+		if err := spec.SecurityEvents.Validate(); err != nil {
+			return err
+		}
+	}
+	if spec.Templates != nil {
+		if err := spec.Templates.validate(); err != nil {
+			return err
+		}
+	}
+	if spec.Deletes != nil {
+		if err := spec.Deletes.validate(); err != nil {
+			return err
+		}
+		if !spec.Deletes.AllowAnonymous && !spec.authenticates() {
+			return fmt.Errorf("deletes: basic auth or signed urls are required unless allowAnonymous is set")
+		}
+	}
+	if spec.Uploads != nil {
+		if err := spec.Uploads.validate(); err != nil {
+			return err
+		}
+		if !spec.Uploads.AllowAnonymous && !spec.authenticates() {
+			return fmt.Errorf("uploads: basic auth or signed urls are required unless allowAnonymous is set")
+		}
+	}
+	if spec.Throttle != nil {
+		if err := spec.Throttle.validate(); err != nil {
+			return err
+		}
+	}
+	if spec.AccessLog != nil {
+		if err := spec.AccessLog.validate(); err != nil {
+			return err
+		}
+	}
+	if spec.Downloads != nil {
+		if err := spec.Downloads.validate(); err != nil {
+			return err
+		}
+	}
+	for _, prefix := range []string{spec.StripPathPrefix, spec.AddPathPrefix} {
+		if prefix != "" && !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("path prefix %q must start with /", prefix)
+		}
+	}
+	switch spec.RedirectStatusCode {
+	case 0, http.StatusMovedPermanently, http.StatusPermanentRedirect:
+	default:
+		return fmt.Errorf("invalid redirect status code %d", spec.RedirectStatusCode)
+	}
+	if err := validateEtag(spec); err != nil {
+		return err
+	}
+	if spec.MemoryCache != nil && spec.MemoryCache.TTL != "" {
+		if _, err := time.ParseDuration(spec.MemoryCache.TTL); err != nil {
+			return fmt.Errorf("invalid memory cache ttl: %v", err)
+		}
+	}
+	if spec.StatCache != nil && spec.StatCache.TTL != "" {
+		if _, err := time.ParseDuration(spec.StatCache.TTL); err != nil {
+			return fmt.Errorf("invalid stat cache ttl: %v", err)
+		}
+	}
+	if spec.IOUring && !uringSupported {
+		return fmt.Errorf("io_uring requires a linux build with the iouring tag")
+	}
+	if spec.Mmap != nil {
+		if err := spec.Mmap.validate(); err != nil {
+			return err
+		}
+	}
+	if spec.Stats != nil {
+		if spec.Stats.File == "" {
+			return fmt.Errorf("stats file is required")
+		}
+		if spec.Stats.Encryption != nil {
+			if err := spec.Stats.Encryption.Validate(); err != nil {
+				return err
+			}
+		}
+		if spec.Stats.FlushInterval != "" {
+			if _, err := time.ParseDuration(spec.Stats.FlushInterval); err != nil {
+				return fmt.Errorf("invalid stats flush interval: %v", err)
+			}
+		}
+		if spec.Stats.DiskWatermarks != nil {
+			if err := spec.Stats.DiskWatermarks.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // Kind returns the kind of FileServer.
 func (fsrv *FileServer) Kind() string {
 	return Kind
@@ -102,20 +477,109 @@ func (fsrv *FileServer) Results() []string {
 func (fsrv *FileServer) Init(filterSpec *httppipeline.FilterSpec) {
 	fsrv.filterSpec = filterSpec
 	fsrv.spec = filterSpec.FilterSpec().(*Spec)
+	fsrv.results = newResultCounter()
+	fsrv.mounts = fsrv.buildMounts()
+	fsrv.hosts = fsrv.buildHosts(fsrv.mounts[len(fsrv.mounts)-1])
+	fsrv.cachePolicies = newCachePolicies(fsrv.spec.CachePolicies)
+	fsrv.headerRules = newHeaderRules(fsrv.spec.Headers)
+	fsrv.indexOverrides = newIndexOverrides(fsrv.spec.IndexOverrides)
+	fsrv.etagHashes = newEtagHashes(fsrv.spec.EtagMode)
+	fsrv.templates = newTemplateCache(fsrv.spec.Templates)
+	fsrv.basicAuth = newBasicAuth(fsrv.spec.BasicAuth)
+	fsrv.events = secevent.New(filterSpec.Name(), fsrv.spec.SecurityEvents)
+	if fsrv.spec.SignedURLs != nil {
+		fsrv.signedURLs = newSignedURLs(fsrv.spec.SignedURLs)
+	}
+	if fsrv.spec.MimeTypes != nil {
+		fsrv.mimeTypes = newMimeTypes(fsrv.spec.MimeTypes)
+	}
+	if fsrv.spec.LastModified != nil {
+		fsrv.modTimes = newLastModified(fsrv.spec.LastModified)
+	}
+	if fsrv.spec.StallTimeout != "" {
+		fsrv.stallTimeout, _ = time.ParseDuration(fsrv.spec.StallTimeout)
+	}
+	if fsrv.spec.Stats != nil {
+		fsrv.stats = newPathStats(fsrv.spec.Stats)
+	}
+	if fsrv.spec.MemoryCache != nil {
+		fsrv.memCache = newMemoryCache(fsrv.spec.MemoryCache)
+	}
+	if fsrv.spec.StatCache != nil {
+		fsrv.statCache = newStatCache(fsrv.spec.StatCache, fsrv.spec.fileSystem)
+	}
+	if fsrv.spec.Mmap != nil {
+		fsrv.mmaps = newMmapCache(fsrv.spec.Mmap)
+	}
+	if fsrv.spec.AccessLog != nil && fsrv.spec.AccessLog.Enabled {
+		al, err := newAccessLog(fsrv.spec.AccessLog)
+		if err != nil {
+			logger.Error("open access log failed", zap.Error(err))
+		} else {
+			fsrv.accessLog = al
+		}
+	}
+	if t := fsrv.spec.Throttle; t != nil && t.MaxBytesPerSecond > 0 {
+		fsrv.bandwidth = util.NewTokenBucket(t.MaxBytesPerSecond, 0)
+	}
+	if u := fsrv.spec.Uploads; u != nil && u.DiskWatermarks != nil {
+		fsrv.uploadDisks = fsrv.watchUploadDisks(u)
+	}
+	if u := fsrv.spec.Uploads; u != nil && u.Scan != nil {
+		fsrv.scanner = newScanner(u.Scan)
+	}
+	if fsrv.spec.MaxConcurrentRequests > 0 || fsrv.spec.MaxConcurrentPerIP > 0 {
+		maxWait, _ := time.ParseDuration(fsrv.spec.MaxQueueWait)
+		fsrv.transfers = newTransferLimiter(fsrv.spec.MaxConcurrentRequests, fsrv.spec.MaxConcurrentPerIP, maxWait)
+	}
+	if fsrv.spec.ReadAhead != nil {
+		fsrv.readAhead = newReadAheadCache(fsrv.spec.ReadAhead)
+	}
+	if fsrv.spec.IOUring {
+		ring, err := newURing()
+		if err != nil {
+			logger.Warn("create io_uring failed, fall back to read", zap.Error(err))
+		} else {
+			fsrv.ring = ring
+		}
+	}
+	fsrv.startWarmUp()
 }
 
 // Inherit inherits previous generation of FileServer.
 func (fsrv *FileServer) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	// close the previous generation first, so its statistics
+	// are flushed before the new generation loads them
+	previousGeneration.Close()
 	fsrv.Init(filterSpec)
 }
 
 // Handle handles HTTP request
 func (fsrv *FileServer) Handle(ctx context.HTTPContext) string {
-	res := fsrv.handle(ctx)
+	fsrv.requestSizes.observe(ctx.Request().Std().ContentLength)
+	start := time.Now()
+	var sv served
+	var res string
+	if util.ClientGone(ctx.Request().Std().Context(), nil) {
+		// don't stat or open anything for nobody
+		res = fsrv.clientDisconnected(ctx)
+	} else {
+		res = fsrv.handle(ctx, &sv)
+	}
+	fsrv.latency.observe(time.Since(start))
+	if fsrv.stats != nil {
+		fsrv.stats.record(ctx.Request().Path(), res)
+	}
+	res = fsrv.overrideResult(ctx, res)
+	fsrv.results.record(res)
+	if fsrv.accessLog != nil {
+		fsrv.accessLog.log(ctx, start, res, &sv)
+	}
 	return ctx.CallNextHandler(res)
 }
 
-func (fsrv *FileServer) handle(ctx context.HTTPContext) string {
+func (fsrv *FileServer) handle(ctx context.HTTPContext, sv *served) string {
+	start := time.Now()
 	r := ctx.Request()
 	w := ctx.Response()
 	p := r.Path()
@@ -136,11 +600,37 @@ func (fsrv *FileServer) handle(ctx context.HTTPContext) string {
 		}
 	}
 
-	filesToHide := fsrv.transformHidePaths(repl)
-
-	root := repl.ReplaceAll(fsrv.spec.Root, ".")
+	// scanners post their verdicts with their own credentials
+	if fsrv.scanner != nil && strings.HasPrefix(p, fsrv.scanner.statusPrefix) {
+		return fsrv.handleStatus(ctx, p[len(fsrv.scanner.statusPrefix):])
+	}
+	if fsrv.basicAuth != nil {
+		if res := fsrv.authenticate(ctx, p); res != "" {
+			return res
+		}
+	}
+	if fsrv.signedURLs != nil {
+		if res := fsrv.checkSignature(ctx, p); res != "" {
+			return res
+		}
+	}
+	if fsrv.spec.Manifest != nil && p == fsrv.spec.Manifest.Path {
+		return fsrv.serveManifest(ctx)
+	}
+	m, rel := fsrv.match(r.Host(), fsrv.rewritePath(p))
+	if fsrv.dotPath(rel) {
+		return fsrv.dotFile(ctx)
+	}
+	if fsrv.spec.Uploads != nil && fsrv.spec.Uploads.match(r.Method(), rel) {
+		return fsrv.upload(ctx, m, rel, util.SanitizedPathJoin(m.root, rel))
+	}
+	if fsrv.spec.Deletes != nil && r.Method() == http.MethodDelete {
+		return fsrv.delete(ctx, m, rel, util.SanitizedPathJoin(m.root, rel))
+	}
+	filesToHide := m.hide
+	root := m.root
 
-	filename := util.SanitizedPathJoin(root, p)
+	filename := util.SanitizedPathJoin(root, rel)
 
 	logger.Debug("sanitized path join",
 		zap.String("site_root", root),
@@ -148,13 +638,18 @@ func (fsrv *FileServer) handle(ctx context.HTTPContext) string {
 		zap.String("result", filename))
 
 	// get information about the file
-	info, err := fs.Stat(fsrv.spec.fileSystem, filename)
+	info, err := fsrv.stat(filename)
 	if err != nil {
 		err = fsrv.mapDirOpenError(err, filename)
 		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid) {
-			ctx.AddTag("not found")
-			w.SetStatusCode(http.StatusNotFound)
-			return resultNotFound
+			// a file with a trailing slash doesn't stat on unix
+			if fsrv.spec.CanonicalRedirects && strings.HasSuffix(p, "/") && len(p) > 1 {
+				name := strings.TrimRight(filename, "/")
+				if info, err := fsrv.stat(name); err == nil && !info.IsDir() && !m.hidden(name) {
+					return fsrv.redirect(ctx, strings.TrimRight(p, "/"))
+				}
+			}
+			return fsrv.notFound(ctx)
 		} else if errors.Is(err, fs.ErrPermission) {
 			ctx.AddTag(err.Error())
 			w.SetStatusCode(http.StatusForbidden)
@@ -165,13 +660,18 @@ func (fsrv *FileServer) handle(ctx context.HTTPContext) string {
 		return resultErrHandleFile
 	}
 
+	dirRequested := info.IsDir()
+
 	// if the r mapped to a directory, see if
 	// there is an index file we can serve
-	if info.IsDir() && len(fsrv.spec.IndexNames) > 0 {
-		for _, indexPage := range fsrv.spec.IndexNames {
-			indexPage := repl.ReplaceAll(indexPage, "")
+	var indexNames []string
+	if info.IsDir() {
+		indexNames = fsrv.indexNames(m, p)
+	}
+	if len(indexNames) > 0 {
+		for _, indexPage := range indexNames {
 			indexPath := util.SanitizedPathJoin(filename, indexPage)
-			if fileHidden(indexPath, filesToHide) {
+			if m.hidden(indexPath) || fsrv.dotName(indexPage) {
 				// pretend this file doesn't exist
 				logger.Debug("hiding index file",
 					zap.String("filename", indexPath),
@@ -179,7 +679,7 @@ func (fsrv *FileServer) handle(ctx context.HTTPContext) string {
 				continue
 			}
 
-			indexInfo, err := fs.Stat(fsrv.spec.fileSystem, indexPath)
+			indexInfo, err := fsrv.stat(indexPath)
 			if err != nil {
 				continue
 			}
@@ -202,32 +702,71 @@ func (fsrv *FileServer) handle(ctx context.HTTPContext) string {
 	// if still referencing a directory, delegate
 	// to browse or return an error
 	if info.IsDir() {
+		if fsrv.spec.Browse != nil && !m.hidden(filename) {
+			if fsrv.spec.CanonicalRedirects && !strings.HasSuffix(p, "/") {
+				return fsrv.redirect(ctx, p+"/")
+			}
+			return fsrv.browse(ctx, filename, m)
+		}
 		logger.Debug("no index file in directory",
 			zap.String("path", filename),
-			zap.Strings("index_filenames", fsrv.spec.IndexNames))
-		ctx.AddTag("not found")
-		w.SetStatusCode(http.StatusNotFound)
-		return resultNotFound
+			zap.Strings("index_filenames", indexNames))
+		return fsrv.notFound(ctx)
 	}
 
 	// one last check to ensure the file isn't hidden (we might
 	// have changed the filename from when we last checked)
-	if fileHidden(filename, filesToHide) {
+	if m.hidden(filename) {
 		logger.Debug("hiding file",
 			zap.String("filename", filename),
 			zap.Strings("files_to_hide", filesToHide))
+		return fsrv.notFound(ctx)
+	}
 
-		ctx.AddTag("not found")
+	// redirect to the canonical URL, so relative links
+	// in index pages resolve against the directory
+	if fsrv.spec.CanonicalRedirects {
+		if dirRequested && !strings.HasSuffix(p, "/") {
+			return fsrv.redirect(ctx, p+"/")
+		}
+		if !dirRequested && strings.HasSuffix(p, "/") && len(p) > 1 {
+			return fsrv.redirect(ctx, strings.TrimRight(p, "/"))
+		}
+	}
 
-		w.SetStatusCode(http.StatusNotFound)
-		return resultNotFound
+	if fsrv.spec.Referer != nil {
+		if res := fsrv.checkReferer(ctx, filename); res != "" {
+			return res
+		}
+	}
+
+	if fsrv.transfers != nil {
+		ip := r.RealIP()
+		if !fsrv.transfers.acquire(r.Std().Context(), m.prefix, ip) {
+			// the client may have given up waiting in the queue
+			if util.ClientGone(r.Std().Context(), nil) {
+				return fsrv.clientDisconnected(ctx)
+			}
+			ctx.AddTag("too many concurrent transfers")
+			w.SetStatusCode(http.StatusTooManyRequests)
+			return resultTooManyRequests
+		}
+		defer fsrv.transfers.release(m.prefix, ip)
 	}
 
 	var file fs.File
 	var etag string
+	var content io.ReadSeeker
+
+	// serve hot files from memory
+	if fsrv.memCache != nil && fsrv.memCache.cacheable(info) {
+		if data, ok := fsrv.memCache.get(filename, info); ok {
+			content = bytes.NewReader(data)
+		}
+	}
 
-	// no precompressed file found, use the actual file
-	if file == nil {
+	// no cached file found, use the actual file
+	if content == nil {
 		logger.Debug("opening file", zap.String("filename", filename))
 
 		// open the file
@@ -236,9 +775,7 @@ func (fsrv *FileServer) handle(ctx context.HTTPContext) string {
 			err = fsrv.mapDirOpenError(err, filename)
 			if os.IsNotExist(err) {
 				logger.Debug("file not found", zap.String("filename", filename), zap.Error(err))
-				ctx.AddTag("not found")
-				w.SetStatusCode(http.StatusNotFound)
-				return resultNotFound
+				return fsrv.notFound(ctx)
 			} else if os.IsPermission(err) {
 				logger.Debug("permission denied", zap.String("filename", filename), zap.Error(err))
 
@@ -252,8 +789,24 @@ func (fsrv *FileServer) handle(ctx context.HTTPContext) string {
 			return resultErrHandleFile
 		}
 		defer file.Close()
+		content = file.(io.ReadSeeker)
 
-		etag = calculateEtag(info)
+		if fsrv.memCache != nil && fsrv.memCache.cacheable(info) {
+			data, err := fsrv.memCache.load(filename, info, file)
+			if err != nil {
+				ctx.AddTag(err.Error())
+				w.SetStatusCode(http.StatusInternalServerError)
+				return resultErrHandleFile
+			}
+			content = bytes.NewReader(data)
+		}
+	}
+
+	etag, err = fsrv.etag(filename, info)
+	if err != nil {
+		ctx.AddTag(err.Error())
+		w.SetStatusCode(http.StatusInternalServerError)
+		return resultErrHandleFile
 	}
 	method := ctx.Request().Method()
 	// at this point, we're serving a file; Go std lib supports only
@@ -266,12 +819,34 @@ func (fsrv *FileServer) handle(ctx context.HTTPContext) string {
 
 	}
 
+	modTime, size := info.ModTime(), info.Size()
+	stdReq := r.Std()
+	if fsrv.spec.Templates != nil && fsrv.spec.Templates.match(p) {
+		rendered, err := fsrv.render(ctx, m, filename, info, content)
+		if err != nil {
+			logger.Debug("render template failed", zap.String("filename", filename), zap.Error(err))
+			ctx.AddTag(fmt.Sprintf("render template: %v", err))
+			w.SetStatusCode(http.StatusInternalServerError)
+			return resultErrHandleFile
+		}
+		// the output depends on the request, so it can't be
+		// validated or served in ranges
+		content = bytes.NewReader(rendered)
+		etag, modTime, size = "", time.Time{}, int64(len(rendered))
+		stdReq = withoutRange(stdReq)
+	}
+
 	// set the Etag - note that a conditional If-None-Match r is handled
 	// by http.ServeContent below, which checks against this Etag value
-	w.Header().Set("Etag", etag)
+	if etag != "" {
+		w.Header().Set("Etag", etag)
+	}
+	fsrv.setCacheHeaders(w.Std().Header(), p)
+	fsrv.setRuleHeaders(w.Std().Header(), p)
+	fsrv.setDownloadHeaders(w.Std().Header(), p, r.Host(), r.Query(), filename)
 
 	if w.Header().Get("Content-Type") == "" {
-		mtyp := mime.TypeByExtension(filepath.Ext(filename))
+		mtyp := fsrv.typeByExtension(filepath.Ext(filename))
 		if mtyp == "" {
 			// do not allow Go to sniff the content-type; see https://www.youtube.com/watch?v=8t8JYpt0egE
 			w.Header().Del("Content-Type")
@@ -280,15 +855,182 @@ func (fsrv *FileServer) handle(ctx context.HTTPContext) string {
 		}
 	}
 
+	var encoding string
+	if fsrv.spec.EncodeResponses != nil {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding = fsrv.spec.EncodeResponses.negotiate(stdReq.Header.Get("Accept-Encoding"),
+			w.Header().Get("Content-Type"), size)
+	}
+	if encoding != "" {
+		// the encoded representation needs its own Etag, and ranges
+		// of it can't be served, so send it whole
+		if etag != "" {
+			etag = strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+			w.Header().Set("Etag", etag)
+		}
+		stdReq = withoutRange(stdReq)
+	}
+	// a Range the If-Range doesn't let apply is ignored, it mustn't be
+	// checked against the limits either
+	if stdReq.Header.Get("Range") != "" && !ifRangeMatches(stdReq.Header.Get("If-Range"), etag, fsrv.lastModified(modTime)) {
+		stdReq = withoutRange(stdReq)
+	}
+	if rs := fsrv.spec.Ranges; rs != nil {
+		if rs.Disable {
+			stdReq = withoutRange(stdReq)
+		} else if err := rs.check(stdReq.Header.Get("Range"), size); err != nil {
+			ctx.AddTag(err.Error())
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			w.SetStatusCode(http.StatusRequestedRangeNotSatisfiable)
+			return resultInvalidRange
+		}
+	}
+
+	if fsrv.spec.Mmap != nil && size >= fsrv.spec.Mmap.minSize() {
+		if f, ok := content.(*os.File); ok {
+			m, err := fsrv.mmaps.acquire(f, filename, fsrv.spec.Mmap)
+			if err != nil {
+				logger.Debug("mmap file failed, fall back to read",
+					zap.String("filename", filename), zap.Error(err))
+			} else {
+				defer fsrv.mmaps.release(m)
+				content = bytes.NewReader(m.data)
+			}
+		}
+	} else if fsrv.readAhead != nil && stdReq.Header.Get("Range") != "" {
+		if f, ok := content.(*os.File); ok {
+			content = fsrv.readAhead.newFile(f, filename, modTime.UnixNano(), size)
+		}
+	} else if fsrv.ring != nil {
+		if f, ok := content.(*os.File); ok {
+			content = fsrv.ring.newFile(f, size)
+		}
+	}
+
+	if t := fsrv.spec.Throttle; t != nil {
+		var buckets []*util.TokenBucket
+		if fsrv.bandwidth != nil {
+			buckets = append(buckets, fsrv.bandwidth)
+		}
+		if t.MaxBytesPerSecondPerRequest > 0 {
+			buckets = append(buckets, util.NewTokenBucket(t.MaxBytesPerSecondPerRequest, 0))
+		}
+		if len(buckets) > 0 {
+			content = util.NewThrottledReader(stdReq.Context(), content, buckets...)
+		}
+	}
+
+	var progress *util.ProgressReader
+	if fsrv.stallTimeout > 0 {
+		progress = util.NewProgressReader(content)
+		stop := util.WatchStall(progress, fsrv.stallTimeout)
+		defer stop()
+		content = progress
+		ctx.AddLazyTag(func() string {
+			return fmt.Sprintf("file copied %d bytes at %.0fB/s", progress.BytesRead(), progress.Throughput())
+		})
+	}
+
 	// let the standard library do what it does best; note, however,
 	// that errors generated by ServeContent are written immediately
 	// to the response, so we cannot handle them (but errors there
 	// are rare)
-	http.ServeContent(w.Std(), r.Std(), info.Name(), info.ModTime(), file.(io.ReadSeeker))
+	if fsrv.spec.ServerTiming {
+		w.Header().Add("Server-Timing", fmt.Sprintf("file;dur=%.3f", float64(time.Since(start))/float64(time.Millisecond)))
+	}
+
+	rw := newResponseWriter(w.Std())
+	rw.noRanges = fsrv.spec.Ranges != nil && fsrv.spec.Ranges.Disable
+	if encoding != "" {
+		ew := newEncodingWriter(rw, encoding)
+		http.ServeContent(ew, stdReq, info.Name(), fsrv.lastModified(modTime), content)
+		if err := ew.Close(); err != nil {
+			logger.Debug("close encoder failed", zap.String("filename", filename), zap.Error(err))
+		}
+	} else {
+		http.ServeContent(rw, stdReq, info.Name(), fsrv.lastModified(modTime), content)
+	}
+	sv.bytes = rw.written
+	fsrv.responseSizes.observe(rw.written)
+	atomic.AddUint64(&fsrv.bytesServed, uint64(rw.written))
+	if !rw.firstByte.IsZero() {
+		fsrv.ttfb.observe(rw.firstByte.Sub(start))
+	}
 
+	if progress != nil && errors.Is(progress.Err(), util.ErrStalled) {
+		sv.status = rw.status
+		fsrv.statusCodes.record(rw.status)
+		logger.Debug("file copy stalled",
+			zap.String("filename", filename),
+			zap.Int64("bytes", progress.BytesRead()))
+		ctx.AddTag("copy stalled")
+		return resultStalled
+	}
+	if util.ClientGone(stdReq.Context(), rw.err) {
+		logger.Debug("client disconnected",
+			zap.String("filename", filename),
+			zap.Int64("bytes", rw.written))
+		sv.status = context.EGStatusClientClosedRequest
+		fsrv.statusCodes.record(sv.status)
+		return fsrv.clientDisconnected(ctx)
+	}
+
+	sv.status = rw.status
+	fsrv.statusCodes.record(rw.status)
 	return ""
 }
 
+// clientDisconnected answers a request whose client went away, nobody
+// sees the response, so no more work is done for it.
+func (fsrv *FileServer) clientDisconnected(ctx context.HTTPContext) string {
+	ctx.AddTag("client disconnected")
+	ctx.Response().SetStatusCode(context.EGStatusClientClosedRequest)
+	return resultClientDisconnected
+}
+
+// notFound answers a request for a file that doesn't exist.
+func (fsrv *FileServer) notFound(ctx context.HTTPContext) string {
+	if fsrv.spec.PassThroughOnNotFound {
+		ctx.AddTag("not found, pass through")
+		return resultFallthrough
+	}
+	ctx.AddTag("not found")
+	ctx.Response().SetStatusCode(http.StatusNotFound)
+	return resultNotFound
+}
+
+// rewritePath applies StripPathPrefix and AddPathPrefix to request
+// path p, mounts are matched against the result. The strip prefix only
+// matches whole path segments.
+func (fsrv *FileServer) rewritePath(p string) string {
+	if strip := strings.TrimRight(fsrv.spec.StripPathPrefix, "/"); strip != "" && strings.HasPrefix(p, strip) {
+		if rest := p[len(strip):]; rest == "" || rest[0] == '/' {
+			p = "/" + strings.TrimLeft(rest, "/")
+		}
+	}
+	if add := strings.TrimRight(fsrv.spec.AddPathPrefix, "/"); add != "" {
+		p = add + p
+	}
+	return p
+}
+
+// redirect redirects the request to path p, keeping the query.
+func (fsrv *FileServer) redirect(ctx context.HTTPContext, p string) string {
+	// never let the location turn into a scheme relative URL
+	p = "/" + strings.TrimLeft(p, "/")
+	u := &url.URL{Path: p, RawQuery: ctx.Request().Query()}
+
+	code := fsrv.spec.RedirectStatusCode
+	if code == 0 {
+		code = http.StatusPermanentRedirect
+	}
+	w := ctx.Response()
+	w.Header().Set("Location", u.String())
+	w.SetStatusCode(code)
+	ctx.AddTag("redirect to canonical url " + p)
+	return resultRedirect
+}
+
 // calculateEtag produces a strong etag by default, although, for
 // efficiency reasons, it does not actually consume the contents
 // of the file to make a hash of all the bytes. ¯\_(ツ)_/¯
@@ -387,10 +1129,10 @@ func (fsrv *FileServer) mapDirOpenError(originalErr error, name string) error {
 	return originalErr
 }
 
-func (fsrv *FileServer) transformHidePaths(repl *util.Replacer) []string {
-	hide := make([]string, len(fsrv.spec.Hide))
-	for i := range fsrv.spec.Hide {
-		hide[i] = repl.ReplaceAll(fsrv.spec.Hide[i], "")
+func transformHidePaths(paths []string) []string {
+	hide := make([]string, len(paths))
+	for i := range paths {
+		hide[i] = repl.ReplaceAll(paths[i], "")
 		if strings.Contains(hide[i], separator) {
 			abs, err := filepath.Abs(hide[i])
 			if err == nil {
@@ -403,9 +1145,86 @@ func (fsrv *FileServer) transformHidePaths(repl *util.Replacer) []string {
 
 // Status returns Status generated by Runtime.
 func (fsrv *FileServer) Status() interface{} {
-	return nil
+	s := &Status{
+		Results:       fsrv.results.status(),
+		StatusCodes:   fsrv.statusCodes.status(),
+		BytesServed:   atomic.LoadUint64(&fsrv.bytesServed),
+		RequestSizes:  fsrv.requestSizes.status(),
+		ResponseSizes: fsrv.responseSizes.status(),
+		TTFB:          fsrv.ttfb.status(),
+		Latency:       fsrv.latency.status(),
+	}
+	if fsrv.memCache != nil {
+		s.MemoryCache = fsrv.memCache.status()
+	}
+	if fsrv.statCache != nil {
+		s.StatCache = fsrv.statCache.status()
+	}
+	if fsrv.mmaps != nil {
+		s.Mmap = fsrv.mmaps.status()
+	}
+	if fsrv.transfers != nil {
+		s.Transfers = fsrv.transfers.status()
+	}
+	var disks []*diskwatch.Watcher
+	for _, w := range fsrv.uploadDisks {
+		disks = append(disks, w)
+	}
+	if fsrv.stats != nil {
+		disks = append(disks, fsrv.stats.disk)
+	}
+	if fsrv.accessLog != nil {
+		disks = append(disks, fsrv.accessLog.disk)
+		s.AccessLogDropped = atomic.LoadUint64(&fsrv.accessLog.dropped)
+	}
+	for _, w := range disks {
+		if w != nil {
+			s.Disks = append(s.Disks, w.Status())
+		}
+	}
+	sort.Slice(s.Disks, func(i, j int) bool { return s.Disks[i].Path < s.Disks[j].Path })
+	return s
 }
 
 // Close closes FileServer.
 func (fsrv *FileServer) Close() {
+	fsrv.stopWarmUp()
+	if fsrv.stats != nil {
+		fsrv.stats.close()
+	}
+	if fsrv.ring != nil {
+		fsrv.ring.close()
+	}
+	if fsrv.statCache != nil {
+		fsrv.statCache.close()
+	}
+	if fsrv.mmaps != nil {
+		fsrv.mmaps.close()
+	}
+	if fsrv.accessLog != nil {
+		fsrv.accessLog.close()
+	}
+	for _, w := range fsrv.uploadDisks {
+		w.Close()
+	}
+	if fsrv.scanner != nil {
+		fsrv.scanner.close()
+	}
+	if fsrv.signedURLs != nil {
+		fsrv.signedURLs.close()
+	}
+	if fsrv.mimeTypes != nil {
+		fsrv.mimeTypes.close()
+	}
+	if fsrv.events != nil {
+		fsrv.events.Close()
+	}
+}
+
+// stat stats name through the stat cache if it is enabled.
+func (fsrv *FileServer) stat(name string) (fs.FileInfo, error) {
+	if fsrv.statCache != nil {
+		return fsrv.statCache.stat(fsrv.spec.fileSystem, name)
+	}
+	return fs.Stat(fsrv.spec.fileSystem, name)
 }
@@ -0,0 +1,330 @@
+package fileserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/FucAttaCk/gateway/keyring"
+	"github.com/FucAttaCk/gateway/util"
+	egcontext "github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+// newMockedContext returns a context serving req. The response is
+// recorded, its Code is the status set through the context, 0 if none.
+func newMockedContext(req *http.Request) (*contexttest.MockedHTTPContext, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	rec.Code = 0
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedRealIP = func() string {
+		host, _, _ := net.SplitHostPort(req.RemoteAddr)
+		return host
+	}
+	ctx.MockedRequest.MockedMethod = func() string { return req.Method }
+	ctx.MockedRequest.MockedHost = func() string { return req.Host }
+	ctx.MockedRequest.MockedPath = func() string { return req.URL.Path }
+	ctx.MockedRequest.MockedQuery = func() string { return req.URL.RawQuery }
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return httpheader.New(req.Header) }
+	ctx.MockedRequest.MockedBody = func() io.Reader { return req.Body }
+	ctx.MockedRequest.MockedStd = func() *http.Request { return req }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return httpheader.New(rec.Header()) }
+	ctx.MockedResponse.MockedStd = func() http.ResponseWriter { return rec }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { rec.Code = code }
+	ctx.MockedResponse.MockedSetBody = func(body io.Reader) { io.Copy(rec.Body, body) }
+	return ctx, rec
+}
+
+func TestPathStatsPersist(t *testing.T) {
+	spec := &StatsSpec{File: filepath.Join(t.TempDir(), "stats.json")}
+
+	ps := newPathStats(spec)
+	ps.record("/a.js", "")
+	ps.record("/b.css", "")
+	ps.record("/b.css", "")
+	ps.record("/missing", resultNotFound)
+	ps.record("/secret", resultErrPermission)
+	ps.close()
+
+	ps = newPathStats(spec)
+	defer ps.close()
+	if got, want := ps.topServed(10), []string{"/b.css", "/a.js"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("topServed() = %v, want %v", got, want)
+	}
+	if got := ps.NotFound["/missing"]; got != 1 {
+		t.Errorf("not found count = %d, want 1", got)
+	}
+	if _, ok := ps.Served["/secret"]; ok {
+		t.Errorf("unexpected stats for failed request")
+	}
+}
+
+func TestPathStatsEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	spec := &StatsSpec{
+		File:       filepath.Join(dir, "stats.json"),
+		Encryption: &keyring.Spec{File: filepath.Join(dir, "keys.json")},
+	}
+
+	ps := newPathStats(spec)
+	ps.record("/a.js", "")
+	ps.close()
+
+	data, err := os.ReadFile(spec.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !keyring.Sealed(data) {
+		t.Fatalf("stats written in clear")
+	}
+
+	ps = newPathStats(spec)
+	defer ps.close()
+	if got := ps.Served["/a.js"]; got != 1 {
+		t.Errorf("served count = %d, want 1", got)
+	}
+}
+
+func TestMountMatch(t *testing.T) {
+	fsrv := &FileServer{spec: &Spec{
+		Root: "/srv/www",
+		Mounts: []*MountSpec{
+			{Prefix: "/docs", Root: "/srv/docs"},
+			{Prefix: "/docs/api/", Root: "/srv/api"},
+		},
+		Hosts: map[string]string{"B.example.com": "/srv/b"},
+	}}
+	fsrv.mounts = fsrv.buildMounts()
+	fsrv.hosts = fsrv.buildHosts(fsrv.mounts[len(fsrv.mounts)-1])
+
+	for _, c := range []struct {
+		host, path, root, rel string
+	}{
+		{"", "/docs", "/srv/docs", "/"},
+		{"", "/docs/a.html", "/srv/docs", "/a.html"},
+		{"", "/docs/api/v1.json", "/srv/api", "/v1.json"},
+		{"", "/docsearch", "/srv/www", "/docsearch"},
+		{"", "/", "/srv/www", "/"},
+		{"b.example.com:8080", "/a.html", "/srv/b", "/a.html"},
+		{"b.example.com", "/docs/a.html", "/srv/docs", "/a.html"},
+		{"c.example.com", "/a.html", "/srv/www", "/a.html"},
+	} {
+		m, rel := fsrv.match(c.host, c.path)
+		if m.root != c.root || rel != c.rel {
+			t.Errorf("match(%q, %q) = %s, %s, want %s, %s", c.host, c.path, m.root, rel, c.root, c.rel)
+		}
+	}
+}
+
+func TestRewritePath(t *testing.T) {
+	fsrv := &FileServer{spec: &Spec{StripPathPrefix: "/static/", AddPathPrefix: "/v2"}}
+	for p, want := range map[string]string{
+		"/static":        "/v2/",
+		"/static/app.js": "/v2/app.js",
+		"/staticfoo":     "/v2/staticfoo",
+		"/other/a.css":   "/v2/other/a.css",
+	} {
+		if got := fsrv.rewritePath(p); got != want {
+			t.Errorf("rewritePath(%q) = %q, want %q", p, got, want)
+		}
+	}
+}
+
+func TestHideRegex(t *testing.T) {
+	fsrv := &FileServer{spec: &Spec{
+		Root:      "/srv/www",
+		HideRegex: []string{`\.bak$|~$|^\..*`},
+		Mounts: []*MountSpec{
+			{Prefix: "/raw", Root: "/srv/raw", HideRegex: []string{}},
+		},
+	}}
+	fsrv.mounts = fsrv.buildMounts()
+
+	for _, c := range []struct {
+		path   string
+		hidden bool
+	}{
+		{"/index.html", false},
+		{"/index.html.bak", true},
+		{"/notes.txt~", true},
+		{"/.git/config", true},
+		{"/a.b/c", false},
+		{"/raw/index.html.bak", false},
+	} {
+		m, rel := fsrv.match("", c.path)
+		filename := util.SanitizedPathJoin(m.root, rel)
+		if got := m.hidden(filename); got != c.hidden {
+			t.Errorf("%s: hidden = %v, want %v", c.path, got, c.hidden)
+		}
+	}
+}
+
+func TestDotPath(t *testing.T) {
+	fsrv := &FileServer{spec: &Spec{}}
+	for _, c := range []struct {
+		rel string
+		dot bool
+	}{
+		{"/index.html", false},
+		{"/.env", true},
+		{"/.git/config", true},
+		{"/a/.htaccess", true},
+		{"/.well-known/acme-challenge/x", false},
+		{"/a.b/c", false},
+	} {
+		if got := fsrv.dotPath(c.rel); got != c.dot {
+			t.Errorf("dotPath(%q) = %v, want %v", c.rel, got, c.dot)
+		}
+	}
+
+	fsrv.spec.DotFiles = DotFilesAllow
+	if fsrv.dotPath("/.env") {
+		t.Error("dotfile blocked with allow policy")
+	}
+}
+
+func TestResultCounter(t *testing.T) {
+	c := newResultCounter()
+	for _, res := range []string{"", "", resultNotFound, resultRedirect, ""} {
+		c.record(res)
+	}
+	s := c.status()
+	if s[resultServed] != 3 || s[resultNotFound] != 1 || s[resultRedirect] != 1 || s[resultErrPermission] != 0 {
+		t.Errorf("unexpected counts %v", s)
+	}
+}
+
+func TestIndexOverrides(t *testing.T) {
+	fsrv := &FileServer{spec: &Spec{
+		Root:       "/srv/www",
+		IndexNames: []string{"index.html"},
+		IndexOverrides: []*IndexOverrideSpec{
+			{Glob: "/legacy/**", IndexNames: []string{"default.htm", "index.htm"}},
+			{Glob: "/app", IndexNames: []string{"app.html"}},
+			{Glob: "/raw/**"},
+		},
+	}}
+	for _, o := range fsrv.spec.IndexOverrides {
+		if err := o.validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsrv.mounts = fsrv.buildMounts()
+	fsrv.indexOverrides = newIndexOverrides(fsrv.spec.IndexOverrides)
+	m := fsrv.mounts[0]
+
+	for p, want := range map[string]string{
+		"/":            "index.html",
+		"/legacy":      "default.htm,index.htm",
+		"/legacy/a/b/": "default.htm,index.htm",
+		"/app/":        "app.html",
+		"/app/sub/":    "index.html",
+		"/raw/":        "",
+	} {
+		if got := strings.Join(fsrv.indexNames(m, p), ","); got != want {
+			t.Errorf("index names of %s = %q, want %q", p, got, want)
+		}
+	}
+}
+
+// brokenPipe is the writer of a client that went away.
+type brokenPipe struct {
+	header http.Header
+}
+
+func (bp *brokenPipe) Header() http.Header         { return bp.header }
+func (bp *brokenPipe) WriteHeader(int)             {}
+func (bp *brokenPipe) Write(p []byte) (int, error) { return 0, syscall.EPIPE }
+
+func TestClientDisconnected(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fsrv := &FileServer{spec: &Spec{Root: root, fileSystem: osFS{}}, results: newResultCounter()}
+	fsrv.mounts = fsrv.buildMounts()
+
+	newContext := func(stdctx context.Context) (*contexttest.MockedHTTPContext, *int) {
+		w := &brokenPipe{header: http.Header{}}
+		ctx, rec := newMockedContext(httptest.NewRequest(http.MethodGet, "/a.txt", nil).WithContext(stdctx))
+		ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return httpheader.New(w.header) }
+		ctx.MockedResponse.MockedStd = func() http.ResponseWriter { return w }
+		return ctx, &rec.Code
+	}
+
+	// gone before the file is looked up
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx, status := newContext(canceled)
+	if res := fsrv.Handle(ctx); res != resultClientDisconnected || *status != egcontext.EGStatusClientClosedRequest {
+		t.Errorf("canceled request: result %q, status %d", res, *status)
+	}
+
+	// gone while the file is written
+	ctx, status = newContext(context.Background())
+	if res := fsrv.Handle(ctx); res != resultClientDisconnected || *status != egcontext.EGStatusClientClosedRequest {
+		t.Errorf("broken pipe: result %q, status %d", res, *status)
+	}
+	if n := fsrv.results.status()[resultClientDisconnected]; n != 2 {
+		t.Errorf("%d disconnects counted, want 2", n)
+	}
+	if codes := fsrv.statusCodes.status(); codes[http.StatusOK] != 0 || codes[egcontext.EGStatusClientClosedRequest] != 1 {
+		t.Errorf("status codes %v", codes)
+	}
+}
+
+func TestCanonicalRedirects(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "docs"), 0o755)
+	for _, name := range []string{"index.html", "a.txt", ".hidden.txt", "docs/index.html"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		path, query string
+		canonical   bool
+		code        int
+		result      string
+		status      int
+		location    string
+	}{
+		{"/docs", "", true, 0, resultRedirect, http.StatusPermanentRedirect, "/docs/"},
+		{"/docs", "v=2", true, 0, resultRedirect, http.StatusPermanentRedirect, "/docs/?v=2"},
+		{"/docs", "", true, http.StatusMovedPermanently, resultRedirect, http.StatusMovedPermanently, "/docs/"},
+		{"//docs", "", true, 0, resultRedirect, http.StatusPermanentRedirect, "/docs/"},
+		{"/docs/", "", true, 0, "", http.StatusOK, ""},
+		{"/", "", true, 0, "", http.StatusOK, ""},
+		{"/a.txt/", "", true, 0, resultRedirect, http.StatusPermanentRedirect, "/a.txt"},
+		{"/a.txt//", "q", true, 0, resultRedirect, http.StatusPermanentRedirect, "/a.txt?q"},
+		{"/a.txt", "", true, 0, "", http.StatusOK, ""},
+		{"/.hidden.txt/", "", true, 0, resultNotFound, http.StatusNotFound, ""},
+		{"/missing/", "", true, 0, resultNotFound, http.StatusNotFound, ""},
+		{"/docs", "", false, 0, "", http.StatusOK, ""},
+		{"/a.txt/", "", false, 0, resultNotFound, http.StatusNotFound, ""},
+	} {
+		fsrv := &FileServer{spec: &Spec{Root: root, fileSystem: osFS{}, IndexNames: []string{"index.html"},
+			Hide: []string{".hidden.txt"}, CanonicalRedirects: c.canonical, RedirectStatusCode: c.code}}
+		fsrv.mounts = fsrv.buildMounts()
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path, req.URL.RawQuery = c.path, c.query
+		ctx, rec := newMockedContext(req)
+
+		res := fsrv.handle(ctx, &served{})
+		if res != c.result || rec.Code != c.status || rec.Header().Get("Location") != c.location {
+			t.Errorf("%s?%s canonical=%v: result %q, status %d, location %q, want %q, %d, %q", c.path, c.query, c.canonical,
+				res, rec.Code, rec.Header().Get("Location"), c.result, c.status, c.location)
+		}
+	}
+}
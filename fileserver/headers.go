@@ -0,0 +1,143 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// HeaderRuleSpec adds response headers to the files matching a
+// pattern, e.g. Cross-Origin-Resource-Policy on fonts or X-Robots-Tag
+// on /private/**. Unlike cache policies, all matching rules apply, in
+// order.
+type HeaderRuleSpec struct {
+	// A glob matched against the request path, ** matches any number
+	// of path segments. A glob without a slash is matched against the
+	// file name only, e.g. *.woff2.
+	Glob string
+	// A regular expression matched against the request path, it is
+	// used when Glob is empty.
+	Regex string
+	// Headers to set, replacing existing values.
+	Set map[string]string
+	// Headers to add.
+	Add map[string]string
+	// Headers to remove.
+	Del []string
+}
+
+type headerRule struct {
+	spec   *HeaderRuleSpec
+	regexp *regexp.Regexp
+}
+
+func (spec *HeaderRuleSpec) validate() error {
+	if spec.Glob == "" && spec.Regex == "" {
+		return fmt.Errorf("header rule requires a glob or a regex")
+	}
+	if spec.Glob != "" {
+		if _, err := path.Match(strings.ReplaceAll(spec.Glob, "**", "*"), ""); err != nil {
+			return fmt.Errorf("invalid header rule glob %q: %v", spec.Glob, err)
+		}
+	} else if _, err := regexp.Compile(spec.Regex); err != nil {
+		return fmt.Errorf("invalid header rule regex %q: %v", spec.Regex, err)
+	}
+	for name := range spec.Set {
+		if !validHeaderName(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	for name := range spec.Add {
+		if !validHeaderName(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	return nil
+}
+
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+func newHeaderRules(specs []*HeaderRuleSpec) []*headerRule {
+	rules := make([]*headerRule, 0, len(specs))
+	for _, spec := range specs {
+		r := &headerRule{spec: spec}
+		if spec.Glob == "" {
+			r.regexp = regexp.MustCompile(spec.Regex)
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+func (r *headerRule) match(reqPath string) bool {
+	if r.regexp != nil {
+		return r.regexp.MatchString(reqPath)
+	}
+	return matchGlob(r.spec.Glob, reqPath)
+}
+
+// setRuleHeaders applies the header rules matching the request path.
+func (fsrv *FileServer) setRuleHeaders(h http.Header, reqPath string) {
+	for _, r := range fsrv.headerRules {
+		if !r.match(reqPath) {
+			continue
+		}
+		for _, name := range r.spec.Del {
+			h.Del(name)
+		}
+		for name, value := range r.spec.Set {
+			h.Set(name, repl.ReplaceAll(value, ""))
+		}
+		for name, value := range r.spec.Add {
+			h.Add(name, repl.ReplaceAll(value, ""))
+		}
+	}
+}
+
+// matchGlob matches reqPath against glob like path.Match, except that
+// a ** segment matches any number of path segments and a glob without
+// a slash is matched against the last element of reqPath.
+func matchGlob(glob, reqPath string) bool {
+	if !strings.Contains(glob, "/") {
+		matched, _ := path.Match(glob, path.Base(reqPath))
+		return matched
+	}
+	if !strings.Contains(glob, "**") {
+		matched, _ := path.Match(glob, reqPath)
+		return matched
+	}
+	return matchSegments(strings.Split(glob, "/"), strings.Split(reqPath, "/"))
+}
+
+func matchSegments(glob, segments []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			for i := len(segments); i >= 0; i-- {
+				if matchSegments(glob[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(glob[0], segments[0]); !matched {
+			return false
+		}
+		glob, segments = glob[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
@@ -0,0 +1,50 @@
+package fileserver
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	for _, c := range []struct {
+		glob, path string
+		want       bool
+	}{
+		{"*.woff2", "/fonts/a.woff2", true},
+		{"*.woff2", "/fonts/a.woff", false},
+		{"/private/**", "/private/a/b/c.txt", true},
+		{"/private/**", "/private", true},
+		{"/private/**", "/public/a.txt", false},
+		{"/**/*.map", "/static/js/app.js.map", true},
+		{"/**/*.map", "/app.js.map", true},
+		{"/static/*", "/static/js/app.js", false},
+	} {
+		if got := matchGlob(c.glob, c.path); got != c.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", c.glob, c.path, got, c.want)
+		}
+	}
+}
+
+func TestHeaderRules(t *testing.T) {
+	fsrv := &FileServer{headerRules: newHeaderRules([]*HeaderRuleSpec{
+		{Glob: "*.woff2", Set: map[string]string{"Cross-Origin-Resource-Policy": "cross-origin"}},
+		{Glob: "/private/**", Set: map[string]string{"X-Robots-Tag": "noindex"}, Del: []string{"Cache-Control"}},
+		{Regex: `^/private/fonts/`, Add: map[string]string{"X-Robots-Tag": "nofollow"}},
+	})}
+
+	h := http.Header{"Cache-Control": {"max-age=60"}}
+	fsrv.setRuleHeaders(h, "/private/fonts/a.woff2")
+	if got := h.Get("Cross-Origin-Resource-Policy"); got != "cross-origin" {
+		t.Errorf("Cross-Origin-Resource-Policy = %q", got)
+	}
+	if got := h.Values("X-Robots-Tag"); len(got) != 2 {
+		t.Errorf("X-Robots-Tag = %q, want noindex and nofollow", got)
+	}
+	if got := h.Get("Cache-Control"); got != "" {
+		t.Errorf("Cache-Control not removed: %q", got)
+	}
+
+	if err := (&HeaderRuleSpec{Glob: "*", Set: map[string]string{"Bad Name": "x"}}).validate(); err == nil {
+		t.Error("invalid header name accepted")
+	}
+}
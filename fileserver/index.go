@@ -0,0 +1,67 @@
+package fileserver
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// IndexOverrideSpec replaces the index names of the directories
+// matching a glob, e.g. /legacy/** uses default.htm.
+type IndexOverrideSpec struct {
+	// A glob matched against the request path of the directory, like
+	// the globs of header rules, e.g. /app/**.
+	Glob string
+	// The index names of the matching directories, empty disables
+	// index files there.
+	IndexNames []string
+}
+
+type indexOverride struct {
+	glob       string
+	indexNames []string
+}
+
+func (spec *IndexOverrideSpec) validate() error {
+	if spec.Glob == "" {
+		return fmt.Errorf("index override requires a glob")
+	}
+	if _, err := path.Match(strings.ReplaceAll(spec.Glob, "**", "*"), ""); err != nil {
+		return fmt.Errorf("invalid index override glob %q: %v", spec.Glob, err)
+	}
+	for _, name := range spec.IndexNames {
+		if name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("invalid index name %q", name)
+		}
+	}
+	return nil
+}
+
+func newIndexOverrides(specs []*IndexOverrideSpec) []*indexOverride {
+	overrides := make([]*indexOverride, 0, len(specs))
+	for _, spec := range specs {
+		overrides = append(overrides, &indexOverride{
+			glob:       spec.Glob,
+			indexNames: replaceAll(spec.IndexNames),
+		})
+	}
+	return overrides
+}
+
+// indexNames returns the index names of the directory reqPath of the
+// mount m, the first matching override wins.
+func (fsrv *FileServer) indexNames(m *mount, reqPath string) []string {
+	if len(fsrv.indexOverrides) == 0 {
+		return m.indexNames
+	}
+	dir := strings.TrimSuffix(reqPath, "/")
+	if dir == "" {
+		dir = "/"
+	}
+	for _, o := range fsrv.indexOverrides {
+		if matchGlob(o.glob, dir) {
+			return o.indexNames
+		}
+	}
+	return m.indexNames
+}
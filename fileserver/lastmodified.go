@@ -0,0 +1,103 @@
+package fileserver
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+// LastModifiedSpec sets the modification times files are served with,
+// by default their times on disk. Replicas rarely share those, so the
+// conditional requests of clients switching between them miss. The
+// modtime Etags still follow the times on disk, set EtagMode to
+// content-hash for Etags the replicas agree on.
+type LastModifiedSpec struct {
+	// Don't send Last-Modified, If-Modified-Since is then ignored.
+	Disable bool
+	// Truncate the times to this granularity, e.g. 1h. Files changed
+	// twice within it without changing size keep their validators.
+	Round string
+	// Serve every file as modified at this RFC 3339 time, e.g. the
+	// time of the deployment, or the name of the environment variable
+	// holding it when it starts with $.
+	Fixed string
+}
+
+type lastModified struct {
+	disable bool
+	round   time.Duration
+	fixed   time.Time
+}
+
+func (spec *LastModifiedSpec) validate() error {
+	n := 0
+	for _, set := range []bool{spec.Disable, spec.Round != "", spec.Fixed != ""} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		return fmt.Errorf("last modified: at most one of disable, round and fixed is allowed")
+	}
+	if spec.Round != "" {
+		d, err := time.ParseDuration(spec.Round)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("last modified: invalid round %q", spec.Round)
+		}
+	}
+	if spec.Fixed != "" && !strings.HasPrefix(spec.Fixed, "$") {
+		if _, err := time.Parse(time.RFC3339, spec.Fixed); err != nil {
+			return fmt.Errorf("last modified: invalid fixed time: %v", err)
+		}
+	}
+	return nil
+}
+
+func newLastModified(spec *LastModifiedSpec) *lastModified {
+	lm := &lastModified{disable: spec.Disable}
+	lm.round, _ = time.ParseDuration(spec.Round)
+	fixed := spec.Fixed
+	if strings.HasPrefix(fixed, "$") {
+		fixed = os.Getenv(fixed[1:])
+	}
+	if spec.Fixed == "" {
+		return lm
+	}
+	t, err := time.Parse(time.RFC3339, fixed)
+	if err != nil {
+		// the times on disk are still valid validators
+		logger.Error("invalid fixed last modified time, serve the file times",
+			zap.String("fixed", spec.Fixed), zap.Error(err))
+	}
+	lm.fixed = t
+	return lm
+}
+
+// modTime returns the modification time a file modified at t is
+// served with.
+func (lm *lastModified) modTime(t time.Time) time.Time {
+	switch {
+	case !lm.fixed.IsZero():
+		return lm.fixed
+	case lm.round > 0:
+		return t.Truncate(lm.round)
+	}
+	return t
+}
+
+// lastModified returns the Last-Modified time of a file modified at
+// t, zero if none is sent.
+func (fsrv *FileServer) lastModified(t time.Time) time.Time {
+	lm := fsrv.modTimes
+	if lm == nil || t.IsZero() {
+		return t
+	}
+	if lm.disable {
+		return time.Time{}
+	}
+	return lm.modTime(t)
+}
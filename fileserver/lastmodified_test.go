@@ -0,0 +1,70 @@
+package fileserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLastModified(t *testing.T) {
+	for _, spec := range []*LastModifiedSpec{
+		{Disable: true, Round: "1h"},
+		{Round: "1h", Fixed: "2024-05-01T12:00:00Z"},
+		{Round: "-1s"},
+		{Fixed: "yesterday"},
+	} {
+		if spec.validate() == nil {
+			t.Errorf("%+v is valid", spec)
+		}
+	}
+
+	disk := time.Date(2024, 5, 1, 12, 34, 56, 789, time.UTC)
+	t.Setenv("DEPLOYED_AT", "2024-04-30T08:00:00Z")
+	for _, c := range []struct {
+		spec *LastModifiedSpec
+		want time.Time
+	}{
+		{&LastModifiedSpec{}, disk},
+		{&LastModifiedSpec{Disable: true}, time.Time{}},
+		{&LastModifiedSpec{Round: "1h"}, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{&LastModifiedSpec{Fixed: "$DEPLOYED_AT"}, time.Date(2024, 4, 30, 8, 0, 0, 0, time.UTC)},
+		// a missing deployment time keeps the times on disk
+		{&LastModifiedSpec{Fixed: "$UNSET_DEPLOYED_AT"}, disk},
+	} {
+		if err := c.spec.validate(); err != nil {
+			t.Fatal(err)
+		}
+		fsrv := &FileServer{modTimes: newLastModified(c.spec)}
+		if got := fsrv.lastModified(disk); !got.Equal(c.want) {
+			t.Errorf("%+v: last modified %v, want %v", c.spec, got, c.want)
+		}
+	}
+
+	// a changed file of the same size gets a new Etag even if its
+	// Last-Modified stays
+	dir := t.TempDir()
+	var etags []string
+	for i, mtime := range []time.Time{disk, disk.Add(time.Hour)} {
+		filename := filepath.Join(dir, "a")
+		if err := os.WriteFile(filename, []byte("v"+string(rune('1'+i))), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filename, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsrv := &FileServer{spec: &Spec{}, modTimes: newLastModified(&LastModifiedSpec{Fixed: "2024-04-30T08:00:00Z"})}
+		etag, err := fsrv.etag(filename, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		etags = append(etags, etag)
+	}
+	if etags[0] == etags[1] {
+		t.Errorf("etags %v agree", etags)
+	}
+}
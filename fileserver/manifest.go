@@ -0,0 +1,250 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FucAttaCk/gateway/util"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const defaultManifestTTL = time.Minute
+
+// ManifestSpec exposes the hash manifest of the served root, so build
+// pipelines and CDNs can validate what is actually being served.
+type ManifestSpec struct {
+	// The request path of the manifest, e.g. /.well-known/manifest.json.
+	Path string
+	// Also compute the SHA-256 of every file, not only its Etag.
+	SHA256 bool
+	// How long a built manifest is served before the roots are
+	// walked again, e.g. 10s. Default: 1m.
+	TTL string
+}
+
+type (
+	manifestEntry struct {
+		Etag   string `json:"etag"`
+		SHA256 string `json:"sha256,omitempty"`
+	}
+
+	// manifestHashes caches the SHA-256 of files by their name, the
+	// cached hash is reused while the Etag is unchanged.
+	manifestHashes struct {
+		mutex  sync.Mutex
+		hashes map[string]manifestEntry
+	}
+
+	// manifestCache keeps the built manifests by the root serving
+	// the host they were requested for. Builds are serialized, so
+	// concurrent requests walk the roots once.
+	manifestCache struct {
+		mutex     sync.Mutex
+		manifests map[string]*builtManifest
+	}
+
+	builtManifest struct {
+		data    []byte
+		expires time.Time
+	}
+)
+
+func (spec *ManifestSpec) validate() error {
+	if !strings.HasPrefix(spec.Path, "/") {
+		return fmt.Errorf("manifest path %q must start with /", spec.Path)
+	}
+	if spec.TTL != "" {
+		if d, err := time.ParseDuration(spec.TTL); err != nil || d <= 0 {
+			return fmt.Errorf("invalid manifest ttl %q", spec.TTL)
+		}
+	}
+	return nil
+}
+
+// serveManifest writes the manifest of the files served to the host
+// of the request, mapping request paths to their Etag and SHA-256.
+// Hidden files are left out.
+func (fsrv *FileServer) serveManifest(ctx context.HTTPContext) string {
+	w := ctx.Response()
+	method := ctx.Request().Method()
+	if method != http.MethodGet && method != http.MethodHead {
+		w.Header().Add("Allow", "GET, HEAD")
+		w.SetStatusCode(http.StatusMethodNotAllowed)
+		return resultMethodNotAllowed
+	}
+
+	// the mounts the request host is served from, as in match
+	var mounts []*mount
+	for _, m := range fsrv.mounts {
+		if m.prefix == "/" {
+			break
+		}
+		mounts = append(mounts, m)
+	}
+	fallback := fsrv.mounts[len(fsrv.mounts)-1]
+	if hm, ok := fsrv.hosts[hostname(ctx.Request().Host())]; ok {
+		fallback = hm
+	}
+	mounts = append(mounts, fallback)
+	key := fallback.root
+	data, err := fsrv.manifests.get(key, func() ([]byte, error) {
+		return fsrv.buildManifest(mounts)
+	}, fsrv.manifestTTL())
+	if err != nil {
+		ctx.AddTag(err.Error())
+		w.SetStatusCode(http.StatusInternalServerError)
+		return resultErrHandleFile
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.SetBody(bytes.NewReader(data))
+	return ""
+}
+
+func (fsrv *FileServer) manifestTTL() time.Duration {
+	if d, err := time.ParseDuration(fsrv.spec.Manifest.TTL); err == nil && d > 0 {
+		return d
+	}
+	return defaultManifestTTL
+}
+
+// get returns the manifest of key, building it if it expired.
+func (c *manifestCache) get(key string, build func() ([]byte, error), ttl time.Duration) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if m, ok := c.manifests[key]; ok && time.Now().Before(m.expires) {
+		return m.data, nil
+	}
+	data, err := build()
+	if err != nil {
+		return nil, err
+	}
+	if c.manifests == nil {
+		c.manifests = map[string]*builtManifest{}
+	}
+	c.manifests[key] = &builtManifest{data: data, expires: time.Now().Add(ttl)}
+	return data, nil
+}
+
+func (fsrv *FileServer) buildManifest(mounts []*mount) ([]byte, error) {
+	start := time.Now()
+	manifest := map[string]manifestEntry{}
+	for _, m := range mounts {
+		if err := fsrv.addToManifest(manifest, m); err != nil {
+			return nil, err
+		}
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("built manifest",
+		zap.Int("files", len(manifest)),
+		zap.Duration("elapsed", time.Since(start)))
+	return data, nil
+}
+
+// publicPath returns the request path that is rewritten to p, the
+// inverse of rewritePath. It fails for paths no request reaches.
+func (fsrv *FileServer) publicPath(p string) (string, bool) {
+	if add := strings.TrimRight(fsrv.spec.AddPathPrefix, "/"); add != "" {
+		if !strings.HasPrefix(p, add+"/") {
+			return "", false
+		}
+		p = p[len(add):]
+	}
+	if strip := strings.TrimRight(fsrv.spec.StripPathPrefix, "/"); strip != "" {
+		p = strip + p
+	}
+	return p, true
+}
+
+func (fsrv *FileServer) addToManifest(manifest map[string]manifestEntry, m *mount) error {
+	root := util.SanitizedPathJoin(m.root, "/")
+	prefix := strings.TrimSuffix(m.prefix, "/")
+	return fs.WalkDir(fsrv.spec.fileSystem, root, func(filename string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if m.hidden(filename) || (filename != root && fsrv.dotName(d.Name())) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, filename)
+		if err != nil {
+			return nil
+		}
+
+		etag, err := fsrv.etag(filename, info)
+		if err != nil {
+			logger.Debug("compute etag failed", zap.String("filename", filename), zap.Error(err))
+			return nil
+		}
+		entry := manifestEntry{Etag: etag}
+		if fsrv.spec.Manifest.SHA256 {
+			entry.SHA256, err = fsrv.manifestHashes.sha256(fsrv, filename, entry.Etag)
+			if err != nil {
+				logger.Debug("hash file failed", zap.String("filename", filename), zap.Error(err))
+				return nil
+			}
+		}
+		// mounts are ordered by descending prefix length, paths
+		// already added are shadowed by a more specific mount
+		p, ok := fsrv.publicPath(prefix + "/" + filepath.ToSlash(rel))
+		if !ok {
+			return nil
+		}
+		if _, ok := manifest[p]; !ok {
+			manifest[p] = entry
+		}
+		return nil
+	})
+}
+
+func (h *manifestHashes) sha256(fsrv *FileServer, filename, etag string) (string, error) {
+	h.mutex.Lock()
+	e, ok := h.hashes[filename]
+	h.mutex.Unlock()
+	if ok && e.Etag == etag {
+		return e.SHA256, nil
+	}
+
+	file, err := fsrv.openFile(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	sum := sha256.New()
+	if _, err := util.Copy(sum, file); err != nil {
+		return "", err
+	}
+	e = manifestEntry{Etag: etag, SHA256: hex.EncodeToString(sum.Sum(nil))}
+
+	h.mutex.Lock()
+	if h.hashes == nil {
+		h.hashes = map[string]manifestEntry{}
+	}
+	h.hashes[filename] = e
+	h.mutex.Unlock()
+	return e.SHA256, nil
+}
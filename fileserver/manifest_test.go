@@ -0,0 +1,75 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	root, assets, site := t.TempDir(), t.TempDir(), t.TempDir()
+	for filename, content := range map[string]string{
+		filepath.Join(root, "site", "index.html"):  "index",
+		filepath.Join(root, "other", "secret.txt"): "not served",
+		filepath.Join(assets, "app.js"):            "app",
+		filepath.Join(site, "site", "a.html"):      "a",
+	} {
+		os.MkdirAll(filepath.Dir(filename), 0o755)
+		if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fsrv := &FileServer{spec: &Spec{
+		Root:            root,
+		fileSystem:      osFS{},
+		StripPathPrefix: "/static",
+		AddPathPrefix:   "/site",
+		Mounts:          []*MountSpec{{Prefix: "/site/assets", Root: assets}},
+		Hosts:           map[string]string{"a.example.com": site},
+		Manifest:        &ManifestSpec{Path: "/manifest.json", TTL: "1h"},
+	}}
+	if err := fsrv.spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	fsrv.mounts = fsrv.buildMounts()
+	fsrv.hosts = fsrv.buildHosts(fsrv.mounts[len(fsrv.mounts)-1])
+
+	manifest := func(host string) []string {
+		req := httptest.NewRequest(http.MethodGet, "/manifest.json", nil)
+		req.Host = host
+		ctx, rec := newMockedContext(req)
+		if res := fsrv.serveManifest(ctx); res != "" {
+			t.Fatalf("%s: result %q", host, res)
+		}
+		var m map[string]manifestEntry
+		if err := json.Unmarshal(rec.Body.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for p := range m {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	// request paths, files outside of AddPathPrefix are left out
+	if got, want := manifest("www.example.com"), []string{"/static/assets/app.js", "/static/index.html"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("manifest = %q, want %q", got, want)
+	}
+	if got, want := manifest("a.example.com"), []string{"/static/a.html", "/static/assets/app.js"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("host manifest = %q, want %q", got, want)
+	}
+
+	// built once per TTL
+	os.WriteFile(filepath.Join(root, "site", "new.html"), []byte("new"), 0o644)
+	if got := manifest("www.example.com"); len(got) != 2 {
+		t.Errorf("manifest rebuilt before the TTL: %q", got)
+	}
+}
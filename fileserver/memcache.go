@@ -0,0 +1,153 @@
+package fileserver
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/FucAttaCk/gateway/util"
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+const (
+	defaultMemoryCacheMaxSize     = 64 << 20
+	defaultMemoryCacheMaxFileSize = 1 << 20
+	defaultMemoryCacheTTL         = time.Minute
+
+	// the LRU is bounded by bytes, the entry limit only guards
+	// against a flood of empty files
+	memoryCacheMaxEntries = 1 << 20
+)
+
+type (
+	// MemoryCacheSpec describes the cache keeping small, frequently
+	// requested files in memory.
+	MemoryCacheSpec struct {
+		// The total size of cached files. Default: 64MiB.
+		MaxSize int64
+		// Larger files are never cached. Default: 1MiB.
+		MaxFileSize int64
+		// How long a file is served from memory before it is read
+		// again, e.g. 30s. Default: 1m. Changed files are read
+		// again immediately when their size or modification time
+		// changes.
+		TTL string
+	}
+
+	memoryCache struct {
+		mutex       sync.Mutex
+		lru         *simplelru.LRU
+		size        int64
+		maxSize     int64
+		maxFileSize int64
+		ttl         time.Duration
+
+		hits   uint64
+		misses uint64
+	}
+
+	memoryCacheEntry struct {
+		data    []byte
+		modTime time.Time
+		expires time.Time
+	}
+
+	// MemoryCacheStatus is the status of the memory cache.
+	MemoryCacheStatus struct {
+		Hits     uint64  `yaml:"hits"`
+		Misses   uint64  `yaml:"misses"`
+		HitRatio float64 `yaml:"hitRatio"`
+		Files    int     `yaml:"files"`
+		Size     int64   `yaml:"size"`
+		MaxSize  int64   `yaml:"maxSize"`
+	}
+)
+
+func newMemoryCache(spec *MemoryCacheSpec) *memoryCache {
+	c := &memoryCache{
+		maxSize:     spec.MaxSize,
+		maxFileSize: spec.MaxFileSize,
+		ttl:         defaultMemoryCacheTTL,
+	}
+	if c.maxSize <= 0 {
+		c.maxSize = defaultMemoryCacheMaxSize
+	}
+	if c.maxFileSize <= 0 {
+		c.maxFileSize = defaultMemoryCacheMaxFileSize
+	}
+	if spec.TTL != "" {
+		if d, err := time.ParseDuration(spec.TTL); err == nil && d > 0 {
+			c.ttl = d
+		}
+	}
+	c.lru, _ = simplelru.NewLRU(memoryCacheMaxEntries, func(_, value interface{}) {
+		c.size -= int64(len(value.(*memoryCacheEntry).data))
+	})
+	return c
+}
+
+func (c *memoryCache) cacheable(info fs.FileInfo) bool {
+	return info.Size() <= c.maxFileSize && info.Size() <= c.maxSize
+}
+
+// get returns the content of filename if the cached copy is still
+// fresh and matches info.
+func (c *memoryCache) get(filename string, info fs.FileInfo) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	v, ok := c.lru.Get(filename)
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	e := v.(*memoryCacheEntry)
+	if time.Now().After(e.expires) || !e.modTime.Equal(info.ModTime()) || int64(len(e.data)) != info.Size() {
+		c.lru.Remove(filename)
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+	return e.data, true
+}
+
+// load reads file, which must be filename, into the cache.
+func (c *memoryCache) load(filename string, info fs.FileInfo, file io.Reader) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, info.Size()))
+	if _, err := util.Copy(buf, file); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lru.Remove(filename)
+	for c.size+int64(len(data)) > c.maxSize {
+		if _, _, ok := c.lru.RemoveOldest(); !ok {
+			break
+		}
+	}
+	c.lru.Add(filename, &memoryCacheEntry{
+		data:    data,
+		modTime: info.ModTime(),
+		expires: time.Now().Add(c.ttl),
+	})
+	c.size += int64(len(data))
+	return data, nil
+}
+
+func (c *memoryCache) status() *MemoryCacheStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s := &MemoryCacheStatus{
+		Hits:    atomic.LoadUint64(&c.hits),
+		Misses:  atomic.LoadUint64(&c.misses),
+		Files:   c.lru.Len(),
+		Size:    c.size,
+		MaxSize: c.maxSize,
+	}
+	s.HitRatio = hitRatio(s.Hits, s.Misses)
+	return s
+}
@@ -0,0 +1,57 @@
+package fileserver
+
+import (
+	"bytes"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMemoryCache(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt": {Data: []byte(strings.Repeat("a", 600)), ModTime: time.Unix(1, 0)},
+		"b.txt": {Data: []byte(strings.Repeat("b", 600)), ModTime: time.Unix(1, 0)},
+	}
+	stat := func(name string) fs.FileInfo {
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+	c := newMemoryCache(&MemoryCacheSpec{MaxSize: 1000, MaxFileSize: 800})
+
+	info := stat("a.txt")
+	if _, ok := c.get("a.txt", info); ok {
+		t.Fatal("unexpected hit on empty cache")
+	}
+	f, _ := fsys.Open("a.txt")
+	if _, err := c.load("a.txt", info, f); err != nil {
+		t.Fatal(err)
+	}
+	data, ok := c.get("a.txt", info)
+	if !ok || !bytes.Equal(data, fsys["a.txt"].Data) {
+		t.Fatal("expected a hit with the file content")
+	}
+
+	// a changed file is read again
+	fsys["a.txt"].ModTime = time.Unix(2, 0)
+	if _, ok := c.get("a.txt", stat("a.txt")); ok {
+		t.Fatal("unexpected hit on modified file")
+	}
+
+	// the size limit evicts the oldest file
+	f, _ = fsys.Open("a.txt")
+	c.load("a.txt", stat("a.txt"), f)
+	f, _ = fsys.Open("b.txt")
+	c.load("b.txt", stat("b.txt"), f)
+	s := c.status()
+	if s.Files != 1 || s.Size != 600 {
+		t.Fatalf("unexpected status %+v", s)
+	}
+	if s.Hits != 1 || s.Misses != 2 {
+		t.Fatalf("unexpected counters %+v", s)
+	}
+}
@@ -0,0 +1,190 @@
+package fileserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+// MimeTypesSpec maps file extensions to content types, before the
+// types of the platform.
+type MimeTypesSpec struct {
+	// Content types by extension, e.g. .wasm: application/wasm.
+	Types map[string]string
+	// A file in the mime.types format, lines of a type followed by
+	// its extensions without dots. It is reloaded when it changes,
+	// Types take precedence over it.
+	File string
+	// Added to text types without a charset, e.g. utf-8.
+	Charset string
+}
+
+type mimeTypes struct {
+	spec  *MimeTypesSpec
+	types map[string]string
+
+	mu   sync.RWMutex
+	file map[string]string
+
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+func (spec *MimeTypesSpec) validate() error {
+	for ext, typ := range spec.Types {
+		if !strings.HasPrefix(ext, ".") {
+			return fmt.Errorf("mime types: extension %q must start with a dot", ext)
+		}
+		if _, _, err := mime.ParseMediaType(typ); err != nil {
+			return fmt.Errorf("mime types: invalid type %q of %s: %v", typ, ext, err)
+		}
+	}
+	if spec.Charset != "" && strings.ContainsAny(spec.Charset, " ;\"") {
+		return fmt.Errorf("mime types: invalid charset %q", spec.Charset)
+	}
+	return nil
+}
+
+func newMimeTypes(spec *MimeTypesSpec) *mimeTypes {
+	mt := &mimeTypes{spec: spec, types: make(map[string]string, len(spec.Types))}
+	for ext, typ := range spec.Types {
+		mt.types[strings.ToLower(ext)] = typ
+	}
+	if spec.File == "" {
+		return mt
+	}
+	if err := mt.load(); err != nil {
+		logger.Error("load mime types failed", zap.String("file", spec.File), zap.Error(err))
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Warn("create file watcher failed, mime types won't reload", zap.Error(err))
+		return mt
+	}
+	// editors and deploys replace the file, so watch its directory
+	if err := watcher.Add(filepath.Dir(spec.File)); err != nil {
+		logger.Warn("watch mime types failed, they won't reload", zap.Error(err))
+		watcher.Close()
+		return mt
+	}
+	mt.watcher = watcher
+	mt.done = make(chan struct{})
+	go mt.watch()
+	return mt
+}
+
+func (mt *mimeTypes) load() error {
+	f, err := os.Open(mt.spec.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	types, err := parseMimeTypes(f)
+	if err != nil {
+		return err
+	}
+	mt.mu.Lock()
+	mt.file = types
+	mt.mu.Unlock()
+	return nil
+}
+
+func (mt *mimeTypes) watch() {
+	name := filepath.Clean(mt.spec.File)
+	for {
+		select {
+		case ev, ok := <-mt.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(ev.Name) != name || ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			// a broken file keeps the previous types
+			if err := mt.load(); err != nil {
+				logger.Warn("reload mime types failed", zap.String("file", name), zap.Error(err))
+			} else {
+				logger.Info("mime types reloaded", zap.String("file", name))
+			}
+		case err, ok := <-mt.watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Warn("mime types watcher failed", zap.Error(err))
+		case <-mt.done:
+			return
+		}
+	}
+}
+
+// typeByExtension returns the content type of files with ext, empty if
+// it is unknown.
+func (mt *mimeTypes) typeByExtension(ext string) string {
+	ext = strings.ToLower(ext)
+	typ, ok := mt.types[ext]
+	if !ok {
+		mt.mu.RLock()
+		typ, ok = mt.file[ext]
+		mt.mu.RUnlock()
+	}
+	if !ok {
+		typ = mime.TypeByExtension(ext)
+	}
+	if typ == "" || mt.spec.Charset == "" || !strings.HasPrefix(typ, "text/") || strings.Contains(typ, "charset=") {
+		return typ
+	}
+	return typ + "; charset=" + mt.spec.Charset
+}
+
+// typeByExtension returns the content type of files with ext through
+// the MIME types of the spec if there are any.
+func (fsrv *FileServer) typeByExtension(ext string) string {
+	if fsrv.mimeTypes != nil {
+		return fsrv.mimeTypes.typeByExtension(ext)
+	}
+	return mime.TypeByExtension(ext)
+}
+
+func (mt *mimeTypes) close() {
+	if mt.watcher != nil {
+		close(mt.done)
+		mt.watcher.Close()
+	}
+}
+
+// parseMimeTypes parses the mime.types format, lines holding a type
+// followed by its extensions, # starts a comment.
+func parseMimeTypes(r io.Reader) (map[string]string, error) {
+	types := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if _, _, err := mime.ParseMediaType(fields[0]); err != nil {
+			return nil, fmt.Errorf("line %d: invalid type %q", line, fields[0])
+		}
+		for _, ext := range fields[1:] {
+			types["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = fields[0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return types, nil
+}
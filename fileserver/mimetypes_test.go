@@ -0,0 +1,54 @@
+package fileserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMimeTypes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mime.types")
+	if err := os.WriteFile(file, []byte("# custom\napplication/x-acme acme ACM\ntext/x-map map\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	spec := &MimeTypesSpec{
+		Types:   map[string]string{".MJS": "text/javascript", ".map": "application/json"},
+		File:    file,
+		Charset: "utf-8",
+	}
+	if err := spec.validate(); err != nil {
+		t.Fatal(err)
+	}
+	mt := newMimeTypes(spec)
+	defer mt.close()
+
+	for ext, want := range map[string]string{
+		".mjs":  "text/javascript; charset=utf-8",
+		".map":  "application/json",
+		".acm":  "application/x-acme",
+		".Acme": "application/x-acme",
+		".png":  "image/png",
+		".zzz":  "",
+	} {
+		if got := mt.typeByExtension(ext); got != want {
+			t.Errorf("type of %s = %q, want %q", ext, got, want)
+		}
+	}
+
+	// replaced like deploys do
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, []byte("application/x-acme2 acme\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for mt.typeByExtension(".acme") != "application/x-acme2" {
+		if time.Now().After(deadline) {
+			t.Fatal("mime types not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,151 @@
+package fileserver
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+const (
+	defaultMmapMinSize     = 4 << 20
+	defaultMmapMaxMappings = 64
+)
+
+// MmapSpec describes when files are served from a memory mapping
+// instead of buffered reads. The served files must be replaced
+// atomically, e.g. by renaming the new version over them: reading the
+// mapping of a file truncated in place kills the process with SIGBUS.
+type MmapSpec struct {
+	// Files smaller than this are read as usual. Default: 4MiB.
+	MinSize int64
+	// The madvise hint for the mapping, one of normal, sequential,
+	// random and willneed. Default: sequential.
+	Advice string
+	// The maximum number of files kept mapped between requests, so
+	// repeated range requests on the same file reuse its mapped
+	// pages. Default: 64.
+	MaxMappings int
+}
+
+type (
+	// mmapCache shares the mappings of files between requests. A
+	// mapping is released once it is evicted or replaced and the
+	// last request using it is done.
+	mmapCache struct {
+		mutex  sync.Mutex
+		lru    *simplelru.LRU
+		closed bool
+		bytes  int64
+	}
+
+	mapping struct {
+		data    []byte
+		info    fs.FileInfo
+		refs    int
+		evicted bool
+	}
+
+	// MmapStatus is the status of the shared mappings.
+	MmapStatus struct {
+		Mappings int   `yaml:"mappings"`
+		Bytes    int64 `yaml:"bytes"`
+	}
+)
+
+func (spec *MmapSpec) minSize() int64 {
+	if spec.MinSize <= 0 {
+		return defaultMmapMinSize
+	}
+	return spec.MinSize
+}
+
+func (spec *MmapSpec) validate() error {
+	switch strings.ToLower(spec.Advice) {
+	case "", "normal", "sequential", "random", "willneed":
+		return nil
+	}
+	return fmt.Errorf("invalid mmap advice %q", spec.Advice)
+}
+
+func (spec *MmapSpec) maxMappings() int {
+	if spec.MaxMappings <= 0 {
+		return defaultMmapMaxMappings
+	}
+	return spec.MaxMappings
+}
+
+func newMmapCache(spec *MmapSpec) *mmapCache {
+	c := &mmapCache{}
+	c.lru, _ = simplelru.NewLRU(spec.maxMappings(), func(_, value interface{}) {
+		m := value.(*mapping)
+		m.evicted = true
+		c.bytes -= int64(len(m.data))
+		if m.refs == 0 {
+			munmap(m.data)
+		}
+	})
+	return c
+}
+
+// acquire returns the mapping of f, which is filename, mapping it if
+// it isn't mapped yet or has changed. The caller must release it.
+func (c *mmapCache) acquire(f *os.File, filename string, spec *MmapSpec) (*mapping, error) {
+	// the open file is checked rather than a stat that may be cached,
+	// a mapping of another version of the file must not be reused
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if v, ok := c.lru.Get(filename); ok {
+		m := v.(*mapping)
+		if os.SameFile(m.info, info) && m.info.ModTime().Equal(info.ModTime()) && m.info.Size() == info.Size() {
+			m.refs++
+			return m, nil
+		}
+		c.lru.Remove(filename)
+	}
+
+	data, err := mmapFile(f, info.Size(), spec)
+	if err != nil {
+		return nil, err
+	}
+	m := &mapping{data: data, info: info, refs: 1}
+	if c.closed {
+		m.evicted = true
+		return m, nil
+	}
+	c.lru.Add(filename, m)
+	c.bytes += int64(len(data))
+	return m, nil
+}
+
+func (c *mmapCache) release(m *mapping) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	m.refs--
+	if m.refs == 0 && m.evicted {
+		munmap(m.data)
+	}
+}
+
+func (c *mmapCache) status() *MmapStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return &MmapStatus{Mappings: c.lru.Len(), Bytes: c.bytes}
+}
+
+// close unmaps all files, mappings still in use are unmapped when
+// their requests are done.
+func (c *mmapCache) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	c.lru.Purge()
+}
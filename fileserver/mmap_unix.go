@@ -0,0 +1,37 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package fileserver
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmapFile maps the whole file read-only and applies the madvise
+// hint of spec. The caller must release the mapping with munmap.
+func mmapFile(f *os.File, size int64, spec *MmapSpec) ([]byte, error) {
+	data, err := unix.Mmap(int(f.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	advice := unix.MADV_SEQUENTIAL
+	switch strings.ToLower(spec.Advice) {
+	case "normal":
+		advice = unix.MADV_NORMAL
+	case "random":
+		advice = unix.MADV_RANDOM
+	case "willneed":
+		advice = unix.MADV_WILLNEED
+	}
+	// the hint is only an optimization, ignore failures
+	unix.Madvise(data, advice)
+
+	return data, nil
+}
+
+func munmap(data []byte) error {
+	return unix.Munmap(data)
+}
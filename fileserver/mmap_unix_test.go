@@ -0,0 +1,73 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package fileserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMmapCacheSharesMappings(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "video.mp4")
+	if err := os.WriteFile(name, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	spec := &MmapSpec{}
+	c := newMmapCache(spec)
+	defer c.close()
+
+	acquire := func() *mapping {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		m, err := c.acquire(f, name, spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	m1 := acquire()
+	m2 := acquire()
+	if m1 != m2 || m1.refs != 2 {
+		t.Fatalf("mapping not shared")
+	}
+	c.release(m1)
+	c.release(m2)
+
+	// a modified file gets a new mapping
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(name, future, future)
+	m3 := acquire()
+	if m3 == m1 || !m1.evicted {
+		t.Fatalf("stale mapping reused")
+	}
+	if string(m3.data) != "0123456789" {
+		t.Fatalf("unexpected content %q", m3.data)
+	}
+
+	// a file renamed over it has the same size and time, but is
+	// another file
+	replacement := name + ".new"
+	if err := os.WriteFile(replacement, []byte("abcdefghij"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(replacement, future, future)
+	if err := os.Rename(replacement, name); err != nil {
+		t.Fatal(err)
+	}
+	m4 := acquire()
+	if m4 == m3 || string(m4.data) != "abcdefghij" {
+		t.Fatalf("mapping of the replaced file reused")
+	}
+	c.release(m4)
+	c.close()
+	if s := c.status(); s.Mappings != 0 || s.Bytes != 0 {
+		t.Fatalf("unexpected status %+v", s)
+	}
+	c.release(m3)
+}
@@ -0,0 +1,187 @@
+package fileserver
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MountSpec serves the requests under a path prefix from its own root,
+// so a single filter can host many small sites and share its caches.
+type MountSpec struct {
+	// The request path prefix, e.g. /docs. It matches /docs and
+	// /docs/... but not /docsearch.
+	Prefix string
+	// The root of the mount, the prefix is stripped from the request
+	// path before it is joined with the root.
+	Root string
+	// Files to hide. Default: the Hide of the filter.
+	Hide []string
+	// Default: the HideRegex of the filter.
+	HideRegex []string
+	// Default: the IndexNames of the filter.
+	IndexNames []string
+}
+
+// mount is a MountSpec with placeholders replaced, so requests don't
+// have to do it again.
+type mount struct {
+	prefix     string
+	root       string
+	hide       []string
+	hideRegex  []*regexp.Regexp
+	indexNames []string
+}
+
+func (spec *MountSpec) validate() error {
+	if !strings.HasPrefix(spec.Prefix, "/") {
+		return fmt.Errorf("mount prefix %q must start with /", spec.Prefix)
+	}
+	if spec.Root == "" {
+		return fmt.Errorf("mount %s: root is required", spec.Prefix)
+	}
+	if _, err := compileHideRegex(spec.HideRegex); err != nil {
+		return fmt.Errorf("mount %s: %v", spec.Prefix, err)
+	}
+	return nil
+}
+
+func compileHideRegex(exprs []string) ([]*regexp.Regexp, error) {
+	result := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hide regex %q: %v", expr, err)
+		}
+		result = append(result, re)
+	}
+	return result, nil
+}
+
+// buildMounts builds the mounts of the spec ordered by descending
+// prefix length, the last one is the root of the filter itself and
+// matches everything.
+func (fsrv *FileServer) buildMounts() []*mount {
+	spec := fsrv.spec
+	fallback := &mount{
+		prefix:     "/",
+		root:       repl.ReplaceAll(spec.Root, "."),
+		hide:       transformHidePaths(spec.Hide),
+		indexNames: replaceAll(spec.IndexNames),
+	}
+	// the spec is validated already
+	fallback.hideRegex, _ = compileHideRegex(spec.HideRegex)
+
+	mounts := make([]*mount, 0, len(spec.Mounts)+1)
+	for _, ms := range spec.Mounts {
+		m := &mount{
+			prefix:     strings.TrimRight(ms.Prefix, "/"),
+			root:       repl.ReplaceAll(ms.Root, "."),
+			hide:       fallback.hide,
+			hideRegex:  fallback.hideRegex,
+			indexNames: fallback.indexNames,
+		}
+		if ms.Hide != nil {
+			m.hide = transformHidePaths(ms.Hide)
+		}
+		if ms.HideRegex != nil {
+			m.hideRegex, _ = compileHideRegex(ms.HideRegex)
+		}
+		if ms.IndexNames != nil {
+			m.indexNames = replaceAll(ms.IndexNames)
+		}
+		mounts = append(mounts, m)
+	}
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return len(mounts[i].prefix) > len(mounts[j].prefix)
+	})
+	return append(mounts, fallback)
+}
+
+// buildHosts builds the document roots selected by the Host header,
+// they share Hide and IndexNames with Root.
+func (fsrv *FileServer) buildHosts(fallback *mount) map[string]*mount {
+	if len(fsrv.spec.Hosts) == 0 {
+		return nil
+	}
+	hosts := make(map[string]*mount, len(fsrv.spec.Hosts))
+	for host, root := range fsrv.spec.Hosts {
+		hosts[strings.ToLower(host)] = &mount{
+			prefix:     "/",
+			root:       repl.ReplaceAll(root, "."),
+			hide:       fallback.hide,
+			hideRegex:  fallback.hideRegex,
+			indexNames: fallback.indexNames,
+		}
+	}
+	return hosts
+}
+
+// match returns the mount serving request path p of host and the path
+// relative to its root. Mounts take precedence over Hosts.
+func (fsrv *FileServer) match(host, p string) (*mount, string) {
+	for _, m := range fsrv.mounts {
+		if m.prefix == "/" {
+			break
+		}
+		if !strings.HasPrefix(p, m.prefix) {
+			continue
+		}
+		rest := p[len(m.prefix):]
+		if rest == "" {
+			return m, "/"
+		}
+		if rest[0] == '/' {
+			return m, rest
+		}
+	}
+	if hm, ok := fsrv.hosts[hostname(host)]; ok {
+		return hm, p
+	}
+	return fsrv.mounts[len(fsrv.mounts)-1], p
+}
+
+// hidden reports whether filename is hidden by the Hide or HideRegex
+// of the mount.
+func (m *mount) hidden(filename string) bool {
+	if fileHidden(filename, m.hide) {
+		return true
+	}
+	if len(m.hideRegex) == 0 {
+		return false
+	}
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	components := strings.Split(filename, separator)
+	for _, re := range m.hideRegex {
+		if re.MatchString(filename) {
+			return true
+		}
+		for _, c := range components {
+			if c != "" && re.MatchString(c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hostname strips the port from the Host header.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func replaceAll(s []string) []string {
+	result := make([]string, len(s))
+	for i := range s {
+		result[i] = repl.ReplaceAll(s[i], "")
+	}
+	return result
+}
@@ -0,0 +1,86 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/context"
+)
+
+// ResultOverrideSpec replaces the response of a filter result, e.g.
+// answering notFound with 200 and a fallback JSON body.
+type ResultOverrideSpec struct {
+	// The result to override, see Results.
+	Result string
+	// Only override requests whose path has this prefix.
+	PathPrefix string
+	// The status code of the response. Default: keep it.
+	StatusCode int
+	// Replace the response body.
+	Body string
+	// Default: text/plain; charset=utf-8 if Body is set.
+	ContentType string
+	// Headers added to the response.
+	Headers map[string]string
+	// Report no result, so the pipeline carries on as if the
+	// request was served.
+	Clear bool
+}
+
+func (spec *ResultOverrideSpec) validate() error {
+	known := false
+	for _, r := range results {
+		if r == spec.Result {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return fmt.Errorf("unknown result %q to override", spec.Result)
+	}
+	if spec.StatusCode != 0 && (spec.StatusCode < 100 || spec.StatusCode > 599) {
+		return fmt.Errorf("invalid override status code %d", spec.StatusCode)
+	}
+	return nil
+}
+
+// overrideResult applies the first override matching the result and
+// the request path, and returns the result to report.
+func (fsrv *FileServer) overrideResult(ctx context.HTTPContext, result string) string {
+	if result == "" {
+		return result
+	}
+	p := ctx.Request().Path()
+	for _, o := range fsrv.spec.ResultOverrides {
+		if o.Result != result || !strings.HasPrefix(p, o.PathPrefix) {
+			continue
+		}
+
+		w := ctx.Response()
+		if o.StatusCode != 0 {
+			w.SetStatusCode(o.StatusCode)
+		}
+		if o.Body != "" {
+			contentType := o.ContentType
+			if contentType == "" {
+				contentType = "text/plain; charset=utf-8"
+			}
+			w.Header().Set("Content-Type", contentType)
+			w.SetBody(strings.NewReader(o.Body))
+		}
+		for k, v := range o.Headers {
+			w.Header().Set(k, v)
+		}
+		if result == resultMethodNotAllowed && o.StatusCode != 0 && o.StatusCode != http.StatusMethodNotAllowed {
+			w.Header().Del("Allow")
+		}
+
+		ctx.AddTag(fmt.Sprintf("result %s overridden", result))
+		if o.Clear {
+			return ""
+		}
+		return result
+	}
+	return result
+}
@@ -0,0 +1,156 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultMaxRanges = 16
+
+// RangesSpec restricts byte-serving, which is otherwise left entirely
+// to http.ServeContent.
+type RangesSpec struct {
+	// Ignore Range headers and always send whole files, responses
+	// carry Accept-Ranges: none.
+	Disable bool
+	// The maximum number of ranges of a request. Default: 16.
+	MaxRanges int
+	// Accept overlapping ranges, they are rejected by default since
+	// they only serve to amplify the response.
+	AllowOverlapping bool
+}
+
+type byteRange struct {
+	start, end int64 // end is exclusive
+}
+
+var errRangeAmplification = errors.New("ranges overlap")
+
+func (spec *RangesSpec) maxRanges() int {
+	if spec.MaxRanges <= 0 {
+		return defaultMaxRanges
+	}
+	return spec.MaxRanges
+}
+
+// check checks the Range header of a request for a file of size,
+// headers that ServeContent would reject or ignore anyway are left to
+// it.
+func (spec *RangesSpec) check(header string, size int64) error {
+	if header == "" {
+		return nil
+	}
+	ranges, err := parseRanges(header, size)
+	if err != nil {
+		return nil
+	}
+	if len(ranges) > spec.maxRanges() {
+		return fmt.Errorf("%d ranges exceed the limit of %d", len(ranges), spec.maxRanges())
+	}
+	if !spec.AllowOverlapping && overlapping(ranges) {
+		return errRangeAmplification
+	}
+	return nil
+}
+
+// parseRanges parses a Range header like http.ServeContent does.
+// Unsatisfiable ranges are skipped.
+func parseRanges(s string, size int64) ([]byteRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(s, prefix) {
+		return nil, errors.New("invalid range")
+	}
+	var ranges []byteRange
+	for _, ra := range strings.Split(s[len(prefix):], ",") {
+		ra = strings.TrimSpace(ra)
+		if ra == "" {
+			continue
+		}
+		i := strings.Index(ra, "-")
+		if i < 0 {
+			return nil, errors.New("invalid range")
+		}
+		first, last := strings.TrimSpace(ra[:i]), strings.TrimSpace(ra[i+1:])
+		var r byteRange
+		if first == "" {
+			// suffix range, the last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errors.New("invalid range")
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{start: size - n, end: size}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errors.New("invalid range")
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, end: size}
+			if last != "" {
+				end, err := strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, errors.New("invalid range")
+				}
+				if end < size-1 {
+					r.end = end + 1
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func overlapping(ranges []byteRange) bool {
+	if len(ranges) < 2 {
+		return false
+	}
+	sorted := append([]byteRange(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].start < sorted[j].start
+	})
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].start < sorted[i-1].end {
+			return true
+		}
+	}
+	return false
+}
+
+// ifRangeMatches reports whether the If-Range header ifRange lets the
+// Range of a request apply to the file with etag and modTime, like
+// http.ServeContent evaluates it: Etags compare strongly, dates to the
+// second.
+func ifRangeMatches(ifRange, etag string, modTime time.Time) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return etag != "" && !strings.HasPrefix(etag, "W/") && ifRange == etag
+	}
+	if modTime.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ifRange)
+	return err == nil && t.Unix() == modTime.Unix()
+}
+
+// withoutRange returns a copy of req without the headers asking for
+// ranges.
+func withoutRange(req *http.Request) *http.Request {
+	r := *req
+	r.Header = req.Header.Clone()
+	r.Header.Del("Range")
+	r.Header.Del("If-Range")
+	return &r
+}
@@ -0,0 +1,104 @@
+package fileserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRangesCheck(t *testing.T) {
+	spec := &RangesSpec{MaxRanges: 3}
+	for _, c := range []struct {
+		header string
+		ok     bool
+	}{
+		{"", true},
+		{"bytes=0-99", true},
+		{"bytes=0-9,20-29,40-49", true},
+		{"bytes=0-9,20-29,40-49,60-69", false},
+		{"bytes=0-99,50-149", false},
+		{"bytes=0-,0-", false},
+		{"bytes=-100,900-", false},
+		{"bytes=-100,0-99", true},
+		// left to ServeContent
+		{"items=0-1,0-1,0-1,0-1", true},
+		{"bytes=2000-", true},
+	} {
+		err := spec.check(c.header, 1000)
+		if (err == nil) != c.ok {
+			t.Errorf("check(%q) = %v, want ok %v", c.header, err, c.ok)
+		}
+	}
+
+	spec.AllowOverlapping = true
+	if err := spec.check("bytes=0-99,50-149", 1000); err != nil {
+		t.Errorf("overlapping ranges rejected: %v", err)
+	}
+}
+
+func TestIfRangeMismatch(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fsrv := &FileServer{spec: &Spec{Root: root, fileSystem: osFS{}, Ranges: &RangesSpec{MaxRanges: 1}}}
+	fsrv.mounts = fsrv.buildMounts()
+
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		ctx, rec := newMockedContext(req)
+		fsrv.handle(ctx, &served{})
+		return rec
+	}
+	etag := serve(nil).Header().Get("Etag")
+	if etag == "" {
+		t.Fatal("no etag")
+	}
+
+	for _, c := range []struct {
+		ifRange string
+		status  int
+		body    string
+	}{
+		{"", http.StatusRequestedRangeNotSatisfiable, ""},
+		{etag, http.StatusRequestedRangeNotSatisfiable, ""},
+		// the file changed, the client wants all of it
+		{`"stale"`, http.StatusOK, "0123456789"},
+		{"Mon, 02 Jan 2006 15:04:05 GMT", http.StatusOK, "0123456789"},
+	} {
+		rec := serve(http.Header{"Range": {"bytes=0-1,3-4"}, "If-Range": {c.ifRange}})
+		if rec.Code != c.status || (c.body != "" && rec.Body.String() != c.body) {
+			t.Errorf("If-Range %q: %d %q, want %d %q", c.ifRange, rec.Code, rec.Body.String(), c.status, c.body)
+		}
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	modTime := time.Date(2022, 5, 1, 10, 0, 0, 500, time.UTC)
+	for _, c := range []struct {
+		ifRange, etag string
+		want          bool
+	}{
+		{"", `"a"`, true},
+		{`"a"`, `"a"`, true},
+		{`"a"`, `"b"`, false},
+		{`W/"a"`, `W/"a"`, false},
+		{`"a"`, "", false},
+		{modTime.Format(http.TimeFormat), `"a"`, true},
+		{modTime.Add(time.Second).Format(http.TimeFormat), `"a"`, false},
+		{"yesterday", `"a"`, false},
+	} {
+		if got := ifRangeMatches(c.ifRange, c.etag, modTime); got != c.want {
+			t.Errorf("ifRangeMatches(%q, %q) = %v, want %v", c.ifRange, c.etag, got, c.want)
+		}
+	}
+	if ifRangeMatches(modTime.Format(http.TimeFormat), "", time.Time{}) {
+		t.Error("date matched without a modification time")
+	}
+}
@@ -0,0 +1,127 @@
+package fileserver
+
+import (
+	"errors"
+	"io"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	defaultReadAheadWindow     = 256 << 10
+	defaultReadAheadMaxWindows = 64
+)
+
+// ReadAheadSpec describes the read-ahead cache used by range requests.
+// Tile and video scrubbing clients send many small adjacent ranges, the
+// cache serves them from a few window sized reads instead of one read
+// per request.
+type ReadAheadSpec struct {
+	// The size of a read, ranges are served from windows aligned to
+	// it. Default: 256KiB.
+	Window int64
+	// The maximum number of windows kept in memory. Default: 64.
+	MaxWindows int
+}
+
+type (
+	readAheadCache struct {
+		window  int64
+		windows *lru.Cache
+	}
+
+	readAheadKey struct {
+		name    string
+		modTime int64
+		size    int64
+		index   int64
+	}
+
+	// readAheadFile reads a file through the read-ahead cache, it
+	// implements io.ReadSeeker so it can be handed to
+	// http.ServeContent.
+	readAheadFile struct {
+		cache   *readAheadCache
+		file    io.ReaderAt
+		name    string
+		modTime int64
+		size    int64
+		off     int64
+	}
+)
+
+func newReadAheadCache(spec *ReadAheadSpec) *readAheadCache {
+	window, maxWindows := spec.Window, spec.MaxWindows
+	if window <= 0 {
+		window = defaultReadAheadWindow
+	}
+	if maxWindows <= 0 {
+		maxWindows = defaultReadAheadMaxWindows
+	}
+	windows, _ := lru.New(maxWindows)
+	return &readAheadCache{window: window, windows: windows}
+}
+
+func (c *readAheadCache) newFile(f io.ReaderAt, name string, modTime, size int64) io.ReadSeeker {
+	return &readAheadFile{cache: c, file: f, name: name, modTime: modTime, size: size}
+}
+
+// load returns the window of key, reading it from f on a miss.
+func (c *readAheadCache) load(key readAheadKey, f io.ReaderAt) ([]byte, error) {
+	if v, ok := c.windows.Get(key); ok {
+		return v.([]byte), nil
+	}
+
+	off := key.index * c.window
+	n := key.size - off
+	if n > c.window {
+		n = c.window
+	}
+	buf := make([]byte, n)
+	if m, err := f.ReadAt(buf, off); m < len(buf) {
+		// the file shrank after it was stat'ed, don't cache
+		// the partial window
+		if err == nil || errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	c.windows.Add(key, buf)
+	return buf, nil
+}
+
+func (f *readAheadFile) Read(p []byte) (int, error) {
+	if f.off >= f.size {
+		return 0, io.EOF
+	}
+	key := readAheadKey{
+		name:    f.name,
+		modTime: f.modTime,
+		size:    f.size,
+		index:   f.off / f.cache.window,
+	}
+	data, err := f.cache.load(key, f.file)
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, data[f.off-key.index*f.cache.window:])
+	f.off += int64(n)
+	return n, nil
+}
+
+func (f *readAheadFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.off = offset
+	return offset, nil
+}
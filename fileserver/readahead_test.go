@@ -0,0 +1,44 @@
+package fileserver
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+type countingReaderAt struct {
+	r     *bytes.Reader
+	reads int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.reads++
+	return c.r.ReadAt(p, off)
+}
+
+func TestReadAheadCoalescesRanges(t *testing.T) {
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	src := &countingReaderAt{r: bytes.NewReader(data)}
+	cache := newReadAheadCache(&ReadAheadSpec{Window: 4096})
+
+	// adjacent small ranges, as sent by a scrubbing video player
+	for off := int64(0); off < int64(len(data)); off += 500 {
+		f := cache.newFile(src, "video.mp4", 1, int64(len(data)))
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, 500)
+		if _, err := io.ReadFull(f, got); err != nil {
+			t.Fatalf("read at %d: %v", off, err)
+		}
+		if !bytes.Equal(got, data[off:off+500]) {
+			t.Fatalf("unexpected content at %d", off)
+		}
+	}
+	if src.reads != 3 {
+		t.Errorf("file reads = %d, want 3", src.reads)
+	}
+}
@@ -0,0 +1,127 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/FucAttaCk/gateway/secevent"
+	"github.com/megaease/easegress/pkg/context"
+)
+
+var defaultHotlinkTypes = []string{"image/", "video/"}
+
+// RefererSpec protects files from hotlinking, requests for them with
+// a Referer of another site are blocked. The host of the request is
+// always allowed.
+type RefererSpec struct {
+	// The sites allowed to link to the files, e.g. example.com, or
+	// *.example.com for its subdomains.
+	AllowedDomains []string
+	// Allow requests without a Referer, e.g. typed URLs and clients
+	// hiding it.
+	AllowEmpty bool
+	// The protected content types, prefixes match too.
+	// Default: image/, video/.
+	ContentTypes []string
+	// Redirect blocked requests here, e.g. to a placeholder image,
+	// instead of answering 403.
+	RedirectURL string
+}
+
+func (spec *RefererSpec) validate() error {
+	for _, d := range spec.AllowedDomains {
+		if d == "" || strings.ContainsAny(d, "/:") || strings.Contains(strings.TrimPrefix(d, "*."), "*") {
+			return fmt.Errorf("referer: invalid domain %q", d)
+		}
+	}
+	if spec.RedirectURL != "" {
+		if _, err := url.Parse(spec.RedirectURL); err != nil {
+			return fmt.Errorf("referer: invalid redirect url %q: %v", spec.RedirectURL, err)
+		}
+	}
+	return nil
+}
+
+// protects reports whether files of contentType are protected.
+func (spec *RefererSpec) protects(contentType string) bool {
+	types := spec.ContentTypes
+	if len(types) == 0 {
+		types = defaultHotlinkTypes
+	}
+	for _, t := range types {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports whether a request to host with referer may fetch a
+// protected file.
+func (spec *RefererSpec) allowed(host, referer string) bool {
+	if referer == "" {
+		return spec.AllowEmpty
+	}
+	u, err := url.Parse(referer)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	from := hostname(u.Host)
+	if from == hostname(host) {
+		return true
+	}
+	for _, d := range spec.AllowedDomains {
+		d = strings.ToLower(d)
+		if strings.HasPrefix(d, "*.") {
+			if strings.HasSuffix(from, d[1:]) {
+				return true
+			}
+		} else if from == d {
+			return true
+		}
+	}
+	return false
+}
+
+// checkReferer blocks hotlinks to filename, it returns the result of
+// the request if it is answered.
+func (fsrv *FileServer) checkReferer(ctx context.HTTPContext, filename string) string {
+	spec := fsrv.spec.Referer
+	if !spec.protects(fsrv.typeByExtension(filepath.Ext(filename))) {
+		return ""
+	}
+	w := ctx.Response()
+	// caches must not hand a hotlink the file
+	w.Header().Add("Vary", "Referer")
+	r := ctx.Request()
+	if spec.allowed(r.Host(), r.Header().Get("Referer")) {
+		return ""
+	}
+	// the placeholder is served to hotlinks too, or they would loop
+	if u, _ := url.Parse(spec.RedirectURL); u != nil && u.Path == r.Path() && (u.Host == "" || hostname(u.Host) == hostname(r.Host())) {
+		return ""
+	}
+
+	ctx.AddTag("hotlink blocked")
+	fsrv.events.Emit(&secevent.Event{
+		Type:     secevent.TypeHotlink,
+		Severity: 3,
+		Action:   "block",
+		ClientIP: r.RealIP(),
+		Method:   r.Method(),
+		Path:     r.Path(),
+		Reason:   "linked from another site",
+		Fields:   map[string]string{"referer": r.Header().Get("Referer")},
+	})
+	if spec.RedirectURL != "" {
+		w.Header().Set("Location", spec.RedirectURL)
+		w.Header().Set("Cache-Control", "no-store")
+		w.SetStatusCode(http.StatusFound)
+	} else {
+		w.SetStatusCode(http.StatusForbidden)
+	}
+	return resultHotlinkBlocked
+}
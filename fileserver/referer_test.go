@@ -0,0 +1,35 @@
+package fileserver
+
+import "testing"
+
+func TestRefererAllowed(t *testing.T) {
+	spec := &RefererSpec{AllowedDomains: []string{"partner.com", "*.example.com"}}
+	if err := spec.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		host, referer string
+		want          bool
+	}{
+		{"cdn.example.org", "", false},
+		{"cdn.example.org", "https://cdn.example.org:443/page", true},
+		{"cdn.example.org", "https://partner.com/a", true},
+		{"cdn.example.org", "https://PARTNER.com./a", true},
+		{"cdn.example.org", "https://www.example.com/a", true},
+		{"cdn.example.org", "https://example.com/a", false},
+		{"cdn.example.org", "https://evilpartner.com/a", false},
+		{"cdn.example.org", "https://partner.com.evil.net/a", false},
+		{"cdn.example.org", "not a url", false},
+	} {
+		if got := spec.allowed(c.host, c.referer); got != c.want {
+			t.Errorf("allowed(%q, %q) = %v, want %v", c.host, c.referer, got, c.want)
+		}
+	}
+	spec.AllowEmpty = true
+	if !spec.allowed("cdn.example.org", "") {
+		t.Error("empty referer blocked")
+	}
+	if !spec.protects("image/png") || !spec.protects("video/mp4") || spec.protects("text/html; charset=utf-8") {
+		t.Error("default content types not protected")
+	}
+}
@@ -0,0 +1,71 @@
+package fileserver
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// responseWriter records the status code, the number of body bytes
+// written through it and when the first byte was written. It keeps
+// io.ReaderFrom so that serving an *os.File still uses sendfile.
+type responseWriter struct {
+	http.ResponseWriter
+	status    int
+	written   int64
+	firstByte time.Time
+	// err is the first error copying the body.
+	err error
+	// noRanges replaces the Accept-Ranges of ServeContent.
+	noRanges bool
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: w}
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
+	}
+	if rw.noRanges {
+		rw.Header().Set("Accept-Ranges", "none")
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	if rw.firstByte.IsZero() {
+		rw.firstByte = time.Now()
+	}
+	n, err := rw.ResponseWriter.Write(p)
+	rw.written += int64(n)
+	if rw.err == nil {
+		rw.err = err
+	}
+	return n, err
+}
+
+func (rw *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	if rw.firstByte.IsZero() {
+		rw.firstByte = time.Now()
+	}
+	var n int64
+	var err error
+	if rf, ok := rw.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(rw.ResponseWriter, src)
+	}
+	rw.written += n
+	if rw.err == nil {
+		rw.err = err
+	}
+	return n, err
+}
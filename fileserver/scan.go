@@ -0,0 +1,462 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FucAttaCk/gateway/cryptopolicy"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	scanPending  = "pending"
+	scanClean    = "clean"
+	scanInfected = "infected"
+	// the scanner couldn't be reached, the file stays quarantined
+	// and its submission is retried
+	scanFailed = "failed"
+	// the file was clean but its name was taken in the meantime, it
+	// is dropped like an infected one
+	scanConflict = "conflict"
+
+	defaultScanStatusPrefix  = "/_scans/"
+	defaultScanTimeout       = 30 * time.Second
+	defaultScanRetainResults = 24 * time.Hour
+
+	// the backoff between the attempts to submit a file
+	minScanBackoff = time.Second
+	maxScanBackoff = 5 * time.Minute
+)
+
+// ScanSpec quarantines uploads until a scanner reports them clean.
+// The upload is answered with 202 and the URL of its scan status, the
+// file is sent to the scanner, which posts its verdict back to that
+// URL as {"verdict": "clean"} or {"verdict": "infected"}.
+type ScanSpec struct {
+	// The upload path prefixes that are scanned, e.g. /incoming/public.
+	// Default: all uploads.
+	Prefixes []string
+	// Where files wait for their verdict, it must be on the file
+	// system of the uploads directory and outside of any root.
+	QuarantineDir string
+	// The file is POSTed to this URL, with its scan ID in X-Scan-ID
+	// and the URL to post the verdict to in X-Scan-Callback.
+	ScannerURL string
+	// The URL of the gateway the scanner posts the verdicts to, e.g.
+	// https://files.example.com. The callback URL is this URL with the
+	// status prefix and the scan ID appended, it is never taken from
+	// the request, which would send the token to any host a client
+	// names.
+	CallbackBaseURL string
+	// The timeout of sending the file to the scanner. Default: 30s.
+	ScannerTimeout string
+	// Verdicts must carry it as a bearer token.
+	CallbackToken string
+	// The request path prefix of the scan status URLs, matched
+	// before StripPathPrefix and mounts. Default: /_scans/.
+	StatusPrefix string
+	// How long the results of finished scans are kept. Default: 24h.
+	RetainResults string
+}
+
+// scanRecord is the state of the scan of an upload, it is kept next
+// to the quarantined file as <id>.json.
+type scanRecord struct {
+	ID            string    `json:"id"`
+	State         string    `json:"state"`
+	Path          string    `json:"path"`
+	Size          int64     `json:"size"`
+	ContentDigest string    `json:"contentDigest"`
+	Created       time.Time `json:"created"`
+	Updated       time.Time `json:"updated"`
+	Detail        string    `json:"detail,omitempty"`
+
+	// where the file is published and how
+	Filename  string `json:"filename"`
+	Overwrite string `json:"overwrite"`
+}
+
+type scanner struct {
+	spec         *ScanSpec
+	client       *http.Client
+	statusPrefix string
+	// serializes the updates of the records
+	mu sync.Mutex
+
+	// stops the submissions being retried
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (spec *ScanSpec) validate() error {
+	if spec.QuarantineDir == "" {
+		return fmt.Errorf("uploads: scan: quarantineDir is required")
+	}
+	if !strings.HasPrefix(spec.ScannerURL, "http://") && !strings.HasPrefix(spec.ScannerURL, "https://") {
+		return fmt.Errorf("uploads: scan: invalid scanner url %q", spec.ScannerURL)
+	}
+	if !strings.HasPrefix(spec.CallbackBaseURL, "http://") && !strings.HasPrefix(spec.CallbackBaseURL, "https://") {
+		return fmt.Errorf("uploads: scan: invalid callback base url %q", spec.CallbackBaseURL)
+	}
+	if spec.CallbackToken == "" {
+		return fmt.Errorf("uploads: scan: callbackToken is required")
+	}
+	if spec.StatusPrefix != "" && !strings.HasPrefix(spec.StatusPrefix, "/") {
+		return fmt.Errorf("uploads: scan: status prefix %q must start with /", spec.StatusPrefix)
+	}
+	for _, p := range spec.Prefixes {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("uploads: scan: prefix %q must start with /", p)
+		}
+	}
+	for _, d := range []struct{ name, value string }{
+		{"scanner timeout", spec.ScannerTimeout},
+		{"retain results", spec.RetainResults},
+	} {
+		if d.value == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d.value); err != nil {
+			return fmt.Errorf("uploads: scan: invalid %s: %v", d.name, err)
+		}
+	}
+	return nil
+}
+
+func newScanner(spec *ScanSpec) *scanner {
+	timeout := defaultScanTimeout
+	if d, err := time.ParseDuration(spec.ScannerTimeout); err == nil && d > 0 {
+		timeout = d
+	}
+	s := &scanner{
+		spec:         spec,
+		client:       cryptopolicy.NewHTTPClient(timeout),
+		statusPrefix: spec.StatusPrefix,
+		done:         make(chan struct{}),
+	}
+	if s.statusPrefix == "" {
+		s.statusPrefix = defaultScanStatusPrefix
+	}
+	s.statusPrefix = strings.TrimSuffix(s.statusPrefix, "/") + "/"
+	if err := os.MkdirAll(spec.QuarantineDir, 0o700); err != nil {
+		logger.Error("create quarantine dir failed", zap.String("dir", spec.QuarantineDir), zap.Error(err))
+	}
+	s.prune()
+	s.resubmit()
+	return s
+}
+
+// close stops retrying the submissions, the files whose submission
+// failed are submitted again by the next generation.
+func (s *scanner) close() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+// resubmit submits the quarantined files whose submission failed
+// before, e.g. by a previous generation or before a restart.
+func (s *scanner) resubmit() {
+	names, _ := filepath.Glob(filepath.Join(s.spec.QuarantineDir, "*.json"))
+	for _, name := range names {
+		id := strings.TrimSuffix(filepath.Base(name), ".json")
+		if rec, err := s.load(id); err == nil && rec.State == scanFailed {
+			s.start(id)
+		}
+	}
+}
+
+// callback returns the URL the scanner posts the verdict of scan id to.
+func (s *scanner) callback(id string) string {
+	return strings.TrimSuffix(s.spec.CallbackBaseURL, "/") + s.statusPrefix + id
+}
+
+// match reports whether the upload to the request path rel is scanned.
+func (s *scanner) match(rel string) bool {
+	if len(s.spec.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range s.spec.Prefixes {
+		prefix = strings.TrimSuffix(path.Clean(prefix), "/") + "/"
+		if strings.HasPrefix(rel, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// prune removes the records of the scans finished longer ago than
+// RetainResults.
+func (s *scanner) prune() {
+	retain := defaultScanRetainResults
+	if d, err := time.ParseDuration(s.spec.RetainResults); err == nil && d > 0 {
+		retain = d
+	}
+	names, _ := filepath.Glob(filepath.Join(s.spec.QuarantineDir, "*.json"))
+	for _, name := range names {
+		rec, err := s.load(strings.TrimSuffix(filepath.Base(name), ".json"))
+		if err == nil && rec.State != scanPending && rec.State != scanFailed && time.Since(rec.Updated) > retain {
+			os.Remove(name)
+		}
+	}
+}
+
+func (s *scanner) file(id string) string {
+	return filepath.Join(s.spec.QuarantineDir, id)
+}
+
+func (s *scanner) load(id string) (*scanRecord, error) {
+	data, err := os.ReadFile(s.file(id) + ".json")
+	if err != nil {
+		return nil, err
+	}
+	rec := &scanRecord{}
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (s *scanner) save(rec *scanRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	tmp := s.file(rec.ID) + ".json.tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file(rec.ID)+".json")
+}
+
+// quarantine moves the uploaded file tmp to the quarantine, sends it to
+// the scanner and answers 202.
+func (fsrv *FileServer) quarantine(ctx context.HTTPContext, tmp, filename string, size int64, digest string) string {
+	s := fsrv.scanner
+	r := ctx.Request()
+	w := ctx.Response()
+
+	var b [16]byte
+	rand.Read(b[:])
+	now := time.Now()
+	rec := &scanRecord{
+		ID:            hex.EncodeToString(b[:]),
+		State:         scanPending,
+		Path:          r.Path(),
+		Size:          size,
+		ContentDigest: digest,
+		Created:       now,
+		Updated:       now,
+		Filename:      filename,
+		Overwrite:     fsrv.spec.Uploads.Overwrite,
+	}
+	err := os.Rename(tmp, s.file(rec.ID))
+	if err == nil {
+		err = s.save(rec)
+	}
+	if err != nil {
+		os.Remove(s.file(rec.ID))
+		return fsrv.uploadFailed(ctx, filename, err)
+	}
+
+	s.start(rec.ID)
+
+	w.Header().Set("Location", s.statusPrefix+rec.ID)
+	w.Header().Set("Content-Digest", digest)
+	return s.writeRecord(ctx, rec, http.StatusAccepted)
+}
+
+// start submits the quarantined file id to the scanner in the
+// background, retrying with backoff until the scanner accepts it, the
+// verdict is in or the scanner is closed.
+func (s *scanner) start(id string) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		backoff := time.Duration(0)
+		for {
+			err := s.submit(id)
+			if err == nil {
+				s.setState(id, scanFailed, scanPending, "")
+				return
+			}
+			backoff = nextScanBackoff(backoff)
+			logger.Error("submit upload to scanner failed",
+				zap.String("id", id), zap.Duration("backoff", backoff), zap.Error(err))
+			if !s.setState(id, scanPending, scanFailed, err.Error()) &&
+				!s.setState(id, scanFailed, scanFailed, err.Error()) {
+				// the verdict is in or the record is gone
+				return
+			}
+			select {
+			case <-s.done:
+				return
+			case <-time.After(backoff):
+			}
+		}
+	}()
+}
+
+// setState changes the state of the record id from the state from to
+// the state to, it reports whether the record was in the state from.
+func (s *scanner) setState(id, from, to, detail string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, err := s.load(id)
+	if err != nil || rec.State != from {
+		return false
+	}
+	rec.State, rec.Detail, rec.Updated = to, detail, time.Now()
+	s.save(rec)
+	return true
+}
+
+func nextScanBackoff(d time.Duration) time.Duration {
+	d *= 2
+	if d < minScanBackoff {
+		return minScanBackoff
+	}
+	if d > maxScanBackoff {
+		return maxScanBackoff
+	}
+	return d
+}
+
+// submit sends the quarantined file id to the scanner.
+func (s *scanner) submit(id string) error {
+	f, err := os.Open(s.file(id))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	req, err := http.NewRequest(http.MethodPost, s.spec.ScannerURL, f)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Scan-ID", id)
+	req.Header.Set("X-Scan-Callback", s.callback(id))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("scanner answered %s", resp.Status)
+	}
+	return nil
+}
+
+// handleStatus answers the requests for the status URLs, GET returns
+// the record and POST sets the verdict.
+func (fsrv *FileServer) handleStatus(ctx context.HTTPContext, id string) string {
+	s := fsrv.scanner
+	r := ctx.Request()
+	w := ctx.Response()
+	if _, err := hex.DecodeString(id); err != nil || len(id) != 32 {
+		return fsrv.notFound(ctx)
+	}
+
+	switch r.Method() {
+	case http.MethodGet, http.MethodHead:
+		rec, err := s.load(id)
+		if err != nil {
+			return fsrv.notFound(ctx)
+		}
+		return s.writeRecord(ctx, rec, http.StatusOK)
+	case http.MethodPost:
+	default:
+		w.Header().Add("Allow", "GET, HEAD, POST")
+		w.SetStatusCode(http.StatusMethodNotAllowed)
+		return resultMethodNotAllowed
+	}
+
+	auth := r.Header().Get("Authorization")
+	if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+s.spec.CallbackToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.SetStatusCode(http.StatusUnauthorized)
+		return resultUnauthorized
+	}
+	var verdict struct {
+		Verdict string `json:"verdict"`
+		Detail  string `json:"detail"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body(), 64<<10)).Decode(&verdict); err != nil ||
+		(verdict.Verdict != scanClean && verdict.Verdict != scanInfected) {
+		ctx.AddTag("scan: invalid verdict")
+		w.SetStatusCode(http.StatusBadRequest)
+		return resultInvalidUpload
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, err := s.load(id)
+	if err != nil {
+		return fsrv.notFound(ctx)
+	}
+	if rec.State != scanPending && rec.State != scanFailed {
+		// the verdict is in already
+		w.SetStatusCode(http.StatusConflict)
+		return resultConflict
+	}
+
+	rec.State, rec.Detail, rec.Updated = verdict.Verdict, verdict.Detail, time.Now()
+	if verdict.Verdict == scanClean {
+		stored, err := storeUpload(s.file(id), rec.Filename, rec.Overwrite)
+		switch {
+		case errors.Is(err, fs.ErrExist):
+			rec.State = scanConflict
+		case err != nil:
+			return fsrv.uploadFailed(ctx, rec.Filename, err)
+		default:
+			if fsrv.statCache != nil {
+				fsrv.statCache.forget(stored)
+			}
+			rec.Path = path.Join(path.Dir(rec.Path), filepath.Base(stored))
+		}
+	} else {
+		logger.Warn("quarantined upload is infected",
+			zap.String("id", id), zap.String("path", rec.Path), zap.String("detail", verdict.Detail))
+	}
+	os.Remove(s.file(id))
+	if err := s.save(rec); err != nil {
+		return fsrv.uploadFailed(ctx, rec.Filename, err)
+	}
+	return s.writeRecord(ctx, rec, http.StatusOK)
+}
+
+func (s *scanner) writeRecord(ctx context.HTTPContext, rec *scanRecord, status int) string {
+	w := ctx.Response()
+	// where the file is stored is none of the client's business
+	data, _ := json.Marshal(struct {
+		*scanRecord
+		Filename  string `json:"filename,omitempty"`
+		Overwrite string `json:"overwrite,omitempty"`
+	}{scanRecord: rec})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.SetStatusCode(status)
+	if ctx.Request().Method() != http.MethodHead {
+		w.SetBody(bytes.NewReader(data))
+	}
+	if status == http.StatusAccepted {
+		return resultQuarantined
+	}
+	return ""
+}
@@ -0,0 +1,136 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+)
+
+func TestScan(t *testing.T) {
+	submitted := make(chan string, 1)
+	var attempts int32
+	scannerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			// the first submission fails and is retried
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		submitted <- r.Header.Get("X-Scan-ID") + " " + string(data) + " " + r.Header.Get("X-Scan-Callback")
+	}))
+	defer scannerServer.Close()
+
+	root := t.TempDir()
+	fsrv := &FileServer{spec: &Spec{Uploads: &UploadsSpec{Dir: "/incoming", Scan: &ScanSpec{
+		QuarantineDir:   filepath.Join(root, "quarantine"),
+		ScannerURL:      scannerServer.URL,
+		CallbackBaseURL: "https://files.example.org/",
+		CallbackToken:   "t0ken",
+	}}}}
+	if err := fsrv.spec.Uploads.validate(); err != nil {
+		t.Fatal(err)
+	}
+	fsrv.scanner = newScanner(fsrv.spec.Uploads.Scan)
+	defer fsrv.scanner.close()
+
+	serve := func(method, target, auth, body string, handle func(ctx *contexttest.MockedHTTPContext) string) (string, int, map[string]string) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		// the callback URL is not taken from the request
+		req.Host = "attacker.example.com"
+		ctx, rec := newMockedContext(req)
+		res := handle(ctx)
+		var out map[string]string
+		if rec.Body.Len() > 0 {
+			var fields map[string]interface{}
+			json.Unmarshal(rec.Body.Bytes(), &fields)
+			out = map[string]string{}
+			for k, v := range fields {
+				if s, ok := v.(string); ok {
+					out[k] = s
+				}
+			}
+		}
+		return res, rec.Code, out
+	}
+
+	upload := func(name string) string {
+		tmp := filepath.Join(fsrv.spec.Uploads.Scan.QuarantineDir, ".upload-"+name)
+		if err := os.WriteFile(tmp, []byte("content of "+name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		res, status, rec := serve("PUT", "/incoming/"+name, "", "", func(ctx *contexttest.MockedHTTPContext) string {
+			return fsrv.quarantine(ctx, tmp, filepath.Join(root, "incoming", name), 11, "sha-256=:x:")
+		})
+		if res != resultQuarantined || status != http.StatusAccepted || rec["state"] != scanPending || rec["filename"] != "" {
+			t.Fatalf("quarantine %s: %s %d %v", name, res, status, rec)
+		}
+		select {
+		case s := <-submitted:
+			if s != rec["id"]+" content of "+name+" https://files.example.org/_scans/"+rec["id"] {
+				t.Errorf("submitted %q", s)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("file not submitted to the scanner")
+		}
+		return rec["id"]
+	}
+	verdict := func(id, auth, body string) (string, int, map[string]string) {
+		return serve("POST", "/_scans/"+id, auth, body, func(ctx *contexttest.MockedHTTPContext) string {
+			return fsrv.handleStatus(ctx, id)
+		})
+	}
+
+	os.MkdirAll(filepath.Join(root, "incoming"), 0o755)
+	clean := upload("clean.txt")
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		rec, err := fsrv.scanner.load(clean)
+		if err == nil && rec.State == scanPending {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("record after the retried submission: %v, %v", rec, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "incoming", "clean.txt")); err == nil {
+		t.Error("file published before the verdict")
+	}
+	if res, status, _ := verdict(clean, "Bearer wrong", `{"verdict":"clean"}`); res != resultUnauthorized || status != 401 {
+		t.Errorf("wrong token: %s %d", res, status)
+	}
+	if _, status, rec := verdict(clean, "Bearer t0ken", `{"verdict":"clean"}`); status != 200 || rec["state"] != scanClean {
+		t.Errorf("clean verdict: %d %v", status, rec)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "incoming", "clean.txt")); err != nil || string(data) != "content of clean.txt" {
+		t.Errorf("published %q, %v", data, err)
+	}
+	if _, status, _ := verdict(clean, "Bearer t0ken", `{"verdict":"infected"}`); status != http.StatusConflict {
+		t.Errorf("second verdict: %d", status)
+	}
+
+	infected := upload("eicar.txt")
+	verdict(infected, "Bearer t0ken", `{"verdict":"infected","detail":"EICAR"}`)
+	if _, err := os.Stat(filepath.Join(root, "incoming", "eicar.txt")); err == nil {
+		t.Error("infected file published")
+	}
+	if _, err := os.Stat(fsrv.scanner.file(infected)); err == nil {
+		t.Error("infected file kept in the quarantine")
+	}
+	_, status, rec := serve("GET", "/_scans/"+infected, "", "", func(ctx *contexttest.MockedHTTPContext) string {
+		return fsrv.handleStatus(ctx, infected)
+	})
+	if status != 200 || rec["state"] != scanInfected || rec["detail"] != "EICAR" {
+		t.Errorf("status: %d %v", status, rec)
+	}
+}
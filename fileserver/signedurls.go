@@ -0,0 +1,182 @@
+package fileserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FucAttaCk/gateway/keyring"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	signedURLExpiresParam   = "expires"
+	signedURLSignatureParam = "sig"
+)
+
+// SignedURLsSpec serves paths only to URLs signed with a secret, so
+// private files can be linked to without sessions. The signature is
+// the base64url encoded HMAC-SHA256 of the request path, a newline
+// and the encoded query without sig, which must include expires, the
+// Unix time the URL expires at. SignURL signs URLs.
+type SignedURLsSpec struct {
+	// The request path prefixes requiring signatures, e.g. /private.
+	// Default: all paths.
+	Prefixes []string
+	// The secret, or the name of the environment variable holding it
+	// when it starts with $.
+	Secret string
+	// Sign with the keys of a keyring instead, URLs signed with any
+	// of its keys are valid so keys rotate smoothly.
+	Keyring *keyring.Spec
+}
+
+type signedURLs struct {
+	spec    *SignedURLsSpec
+	secret  []byte
+	keyring *keyring.Keyring
+}
+
+func (spec *SignedURLsSpec) validate() error {
+	if (spec.Secret == "") == (spec.Keyring == nil) {
+		return fmt.Errorf("signed urls: exactly one of secret and keyring is required")
+	}
+	for _, p := range spec.Prefixes {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("signed urls: prefix %q must start with /", p)
+		}
+	}
+	if spec.Keyring != nil {
+		return spec.Keyring.Validate()
+	}
+	return nil
+}
+
+func newSignedURLs(spec *SignedURLsSpec) *signedURLs {
+	su := &signedURLs{spec: spec}
+	if spec.Keyring != nil {
+		kr, err := keyring.Open(spec.Keyring)
+		if err != nil {
+			// no key verifies, so nothing is served unsigned
+			logger.Error("open signed urls keyring failed", zap.Error(err))
+		}
+		su.keyring = kr
+		return su
+	}
+	secret := spec.Secret
+	if strings.HasPrefix(secret, "$") {
+		secret = os.Getenv(secret[1:])
+	}
+	su.secret = []byte(secret)
+	return su
+}
+
+// SignURL returns the query of the URL of p signed with secret, which
+// expires at expires. The query may carry other parameters, they are
+// signed too.
+func SignURL(secret []byte, p string, query url.Values, expires time.Time) string {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Del(signedURLSignatureParam)
+	q.Set(signedURLExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	sig := signature(secret, p, q.Encode())
+	q.Set(signedURLSignatureParam, sig)
+	return q.Encode()
+}
+
+func signature(secret []byte, p, canonicalQuery string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(p + "\n" + canonicalQuery))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (su *signedURLs) match(reqPath string) bool {
+	if len(su.spec.Prefixes) == 0 {
+		return true
+	}
+	// match the path the file is looked up with
+	p := path.Clean("/" + reqPath)
+	for _, prefix := range su.spec.Prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// verify returns why the URL of reqPath with rawQuery isn't validly
+// signed at now, nil if it is.
+func (su *signedURLs) verify(reqPath, rawQuery string, now time.Time) error {
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return fmt.Errorf("invalid query: %v", err)
+	}
+	sig := q.Get(signedURLSignatureParam)
+	if sig == "" {
+		return fmt.Errorf("no signature")
+	}
+	expires, err := strconv.ParseInt(q.Get(signedURLExpiresParam), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid expires")
+	}
+	q.Del(signedURLSignatureParam)
+	canonical := q.Encode()
+
+	var secrets [][]byte
+	if su.keyring != nil {
+		for _, k := range su.keyring.Keys() {
+			secrets = append(secrets, k.Secret)
+		}
+	} else if len(su.secret) > 0 {
+		secrets = append(secrets, su.secret)
+	}
+	valid := false
+	for _, secret := range secrets {
+		if hmac.Equal([]byte(sig), []byte(signature(secret, reqPath, canonical))) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("invalid signature")
+	}
+	// checked after the signature, so expired URLs can't be forged
+	if now.Unix() >= expires {
+		return fmt.Errorf("expired")
+	}
+	return nil
+}
+
+// checkSignature answers 403 unless the URL of the request is validly
+// signed, it returns the result of the request if it is answered.
+func (fsrv *FileServer) checkSignature(ctx context.HTTPContext, reqPath string) string {
+	if !fsrv.signedURLs.match(reqPath) {
+		return ""
+	}
+	if err := fsrv.signedURLs.verify(reqPath, ctx.Request().Query(), time.Now()); err != nil {
+		ctx.AddTag(fmt.Sprintf("signed url: %v", err))
+		fsrv.authFailure(ctx, "signed url: "+err.Error(), nil)
+		ctx.Response().SetStatusCode(http.StatusForbidden)
+		return resultInvalidSignature
+	}
+	return ""
+}
+
+func (su *signedURLs) close() {
+	if su.keyring != nil {
+		su.keyring.Close()
+	}
+}
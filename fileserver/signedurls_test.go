@@ -0,0 +1,45 @@
+package fileserver
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSignedURLs(t *testing.T) {
+	t.Setenv("MEDIA_SECRET", "s3cret")
+	spec := &SignedURLsSpec{Prefixes: []string{"/private/"}, Secret: "$MEDIA_SECRET"}
+	if err := spec.validate(); err != nil {
+		t.Fatal(err)
+	}
+	su := newSignedURLs(spec)
+	now := time.Unix(1700000000, 0)
+	expires := now.Add(time.Hour)
+	signed := SignURL([]byte("s3cret"), "/private/a.mp4", url.Values{"download": {"1"}}, expires)
+
+	if su.match("/public/a.mp4") || !su.match("/private/a.mp4") || !su.match("/x/../private/a.mp4") {
+		t.Error("prefixes not matched")
+	}
+	if err := su.verify("/private/a.mp4", signed, now); err != nil {
+		t.Errorf("signed url rejected: %v", err)
+	}
+	if err := su.verify("/private/a.mp4", signed, expires); err == nil {
+		t.Error("expired url accepted")
+	}
+	if err := su.verify("/private/b.mp4", signed, now); err == nil {
+		t.Error("url of another path accepted")
+	}
+	q, _ := url.ParseQuery(signed)
+	q.Set("download", "2")
+	if err := su.verify("/private/a.mp4", q.Encode(), now); err == nil {
+		t.Error("tampered query accepted")
+	}
+	q.Set("download", "1")
+	q.Set("expires", "9999999999")
+	if err := su.verify("/private/a.mp4", q.Encode(), now); err == nil {
+		t.Error("extended expiry accepted")
+	}
+	if err := su.verify("/private/a.mp4", "", now); err == nil {
+		t.Error("unsigned url accepted")
+	}
+}
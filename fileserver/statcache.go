@@ -0,0 +1,199 @@
+package fileserver
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/hashicorp/golang-lru/simplelru"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultStatCacheTTL        = time.Second
+	defaultStatCacheMaxEntries = 10000
+	maxWatchedDirs             = 1024
+)
+
+type (
+	// StatCacheSpec describes the cache of file metadata, so hot paths
+	// don't stat the file and probe the index names on every request.
+	StatCacheSpec struct {
+		// How long a result is used, e.g. 500ms. Default: 1s.
+		TTL string
+		// The maximum number of cached paths. Default: 10000.
+		MaxEntries int
+		// Drop cached results as soon as the file system reports a
+		// change, instead of waiting for the TTL. Only the local file
+		// system can be watched, up to 1024 directories.
+		Watch bool
+	}
+
+	statCache struct {
+		mutex   sync.Mutex
+		lru     *simplelru.LRU
+		ttl     time.Duration
+		watcher *fsnotify.Watcher
+		watched map[string]struct{}
+		done    chan struct{}
+
+		hits   uint64
+		misses uint64
+	}
+
+	statCacheEntry struct {
+		info    fs.FileInfo
+		err     error
+		expires time.Time
+	}
+
+	// StatCacheStatus is the status of the stat cache.
+	StatCacheStatus struct {
+		Hits     uint64  `yaml:"hits"`
+		Misses   uint64  `yaml:"misses"`
+		HitRatio float64 `yaml:"hitRatio"`
+		Entries  int     `yaml:"entries"`
+		Watched  int     `yaml:"watched"`
+	}
+)
+
+func newStatCache(spec *StatCacheSpec, fsys fs.FS) *statCache {
+	c := &statCache{ttl: defaultStatCacheTTL}
+	if spec.TTL != "" {
+		if d, err := time.ParseDuration(spec.TTL); err == nil && d > 0 {
+			c.ttl = d
+		}
+	}
+	size := spec.MaxEntries
+	if size <= 0 {
+		size = defaultStatCacheMaxEntries
+	}
+	c.lru, _ = simplelru.NewLRU(size, nil)
+
+	if !spec.Watch {
+		return c
+	}
+	switch fsys.(type) {
+	case osFS, *osFS:
+	default:
+		logger.Warn("stat cache can only watch the local file system")
+		return c
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Warn("create file watcher failed, stat cache relies on ttl", zap.Error(err))
+		return c
+	}
+	c.watcher = watcher
+	c.watched = map[string]struct{}{}
+	c.done = make(chan struct{})
+	go c.watch()
+	return c
+}
+
+// stat returns the cached result of fs.Stat(fsys, name), only
+// successes and not-exist errors are cached.
+func (c *statCache) stat(fsys fs.FS, name string) (fs.FileInfo, error) {
+	now := time.Now()
+	c.mutex.Lock()
+	if v, ok := c.lru.Get(name); ok {
+		e := v.(*statCacheEntry)
+		if now.Before(e.expires) {
+			c.mutex.Unlock()
+			atomic.AddUint64(&c.hits, 1)
+			return e.info, e.err
+		}
+		c.lru.Remove(name)
+	}
+	c.mutex.Unlock()
+	atomic.AddUint64(&c.misses, 1)
+
+	info, err := fs.Stat(fsys, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return info, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lru.Add(name, &statCacheEntry{info: info, err: err, expires: now.Add(c.ttl)})
+	c.addWatch(filepath.Dir(name))
+	return info, err
+}
+
+// forget drops the cached results of name and its directory, after
+// the filter changed them itself.
+func (c *statCache) forget(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lru.Remove(name)
+	c.lru.Remove(filepath.Dir(name))
+}
+
+// addWatch watches dir for changes, the caller must hold the mutex.
+func (c *statCache) addWatch(dir string) {
+	if c.watcher == nil || len(c.watched) >= maxWatchedDirs {
+		return
+	}
+	if _, ok := c.watched[dir]; ok {
+		return
+	}
+	if err := c.watcher.Add(dir); err != nil {
+		logger.Debug("watch directory failed", zap.String("dir", dir), zap.Error(err))
+		return
+	}
+	c.watched[dir] = struct{}{}
+}
+
+func (c *statCache) watch() {
+	for {
+		select {
+		case ev, ok := <-c.watcher.Events:
+			if !ok {
+				return
+			}
+			c.mutex.Lock()
+			c.lru.Remove(ev.Name)
+			c.lru.Remove(filepath.Dir(ev.Name))
+			if ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				delete(c.watched, ev.Name)
+			}
+			c.mutex.Unlock()
+		case err, ok := <-c.watcher.Errors:
+			if !ok {
+				return
+			}
+			// events may have been lost, start over
+			logger.Warn("file watcher failed, clearing stat cache", zap.Error(err))
+			c.mutex.Lock()
+			c.lru.Purge()
+			c.mutex.Unlock()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *statCache) status() *StatCacheStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s := &StatCacheStatus{
+		Hits:    atomic.LoadUint64(&c.hits),
+		Misses:  atomic.LoadUint64(&c.misses),
+		Entries: c.lru.Len(),
+		Watched: len(c.watched),
+	}
+	s.HitRatio = hitRatio(s.Hits, s.Misses)
+	return s
+}
+
+func (c *statCache) close() {
+	if c.watcher != nil {
+		close(c.done)
+		c.watcher.Close()
+	}
+}
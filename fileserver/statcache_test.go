@@ -0,0 +1,59 @@
+package fileserver
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatCache(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.txt")
+	c := newStatCache(&StatCacheSpec{TTL: "1h"}, osFS{})
+	defer c.close()
+
+	if _, err := c.stat(osFS{}, name); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := os.WriteFile(name, []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// the not-exist result is cached
+	if _, err := c.stat(osFS{}, name); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if s := c.status(); s.Hits != 1 || s.Misses != 1 {
+		t.Fatalf("unexpected status %+v", s)
+	}
+}
+
+func TestStatCacheWatch(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.txt")
+	c := newStatCache(&StatCacheSpec{TTL: "1h", Watch: true}, osFS{})
+	defer c.close()
+	if c.watcher == nil {
+		t.Skip("file watcher not supported")
+	}
+
+	if _, err := c.stat(osFS{}, name); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := os.WriteFile(name, []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, err := c.stat(osFS{}, name)
+		if err == nil && info.Size() == 1 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stat cache not invalidated by the watcher")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,242 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/FucAttaCk/gateway/diskwatch"
+	"github.com/FucAttaCk/gateway/keyring"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultStatsFlushInterval = time.Minute
+	defaultStatsMaxPaths      = 10000
+)
+
+type (
+	// StatsSpec describes where per-path statistics are persisted,
+	// so they survive restarts and rolling deploys.
+	StatsSpec struct {
+		// The file to load statistics from and flush them to.
+		File string
+		// How often statistics are flushed, e.g. 30s. Default: 1m.
+		FlushInterval string
+		// The maximum number of distinct paths tracked. Default: 10000.
+		MaxPaths int
+		// Encrypt the file with keys of this keyring. Files written
+		// without encryption are still loaded.
+		Encryption *keyring.Spec
+		// Flushes are skipped while the disk holding File is below
+		// its low watermark, the statistics are flushed once it is
+		// back above the high watermark.
+		DiskWatermarks *diskwatch.Spec
+	}
+
+	// pathStats counts requests per request path.
+	pathStats struct {
+		mutex    sync.Mutex
+		file     string
+		maxPaths int
+		dirty    bool
+		done     chan struct{}
+		wg       sync.WaitGroup
+		keyring  *keyring.Keyring
+		disk     *diskwatch.Watcher
+
+		Served   map[string]uint64 `json:"served"`
+		NotFound map[string]uint64 `json:"notFound"`
+	}
+)
+
+func (spec *StatsSpec) flushInterval() time.Duration {
+	if spec.FlushInterval == "" {
+		return defaultStatsFlushInterval
+	}
+	d, err := time.ParseDuration(spec.FlushInterval)
+	if err != nil || d <= 0 {
+		return defaultStatsFlushInterval
+	}
+	return d
+}
+
+// newPathStats loads the persisted statistics of spec and starts
+// flushing them periodically.
+func newPathStats(spec *StatsSpec) *pathStats {
+	ps := &pathStats{
+		file:     spec.File,
+		maxPaths: spec.MaxPaths,
+		done:     make(chan struct{}),
+		Served:   map[string]uint64{},
+		NotFound: map[string]uint64{},
+	}
+	if ps.maxPaths <= 0 {
+		ps.maxPaths = defaultStatsMaxPaths
+	}
+	if spec.Encryption != nil {
+		kr, err := keyring.Open(spec.Encryption)
+		if err != nil {
+			// never fall back to writing the statistics in clear
+			logger.Error("open path stats keyring failed, stats are not persisted",
+				zap.String("file", ps.file), zap.Error(err))
+			ps.file = ""
+		}
+		ps.keyring = kr
+	}
+	ps.load()
+	if ps.file != "" && spec.DiskWatermarks != nil {
+		ps.disk = diskwatch.New(filepath.Dir(ps.file), spec.DiskWatermarks)
+	}
+
+	ps.wg.Add(1)
+	go ps.run(spec.flushInterval())
+	return ps
+}
+
+func (ps *pathStats) load() {
+	if ps.file == "" {
+		return
+	}
+	data, err := os.ReadFile(ps.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("read path stats failed", zap.String("file", ps.file), zap.Error(err))
+		}
+		return
+	}
+	if keyring.Sealed(data) {
+		if ps.keyring == nil {
+			logger.Warn("path stats are encrypted but no keyring is configured", zap.String("file", ps.file))
+			return
+		}
+		if data, err = ps.keyring.Unseal(data); err != nil {
+			logger.Warn("decrypt path stats failed", zap.String("file", ps.file), zap.Error(err))
+			return
+		}
+	}
+	if err := json.Unmarshal(data, ps); err != nil {
+		logger.Warn("decode path stats failed", zap.String("file", ps.file), zap.Error(err))
+	}
+	if ps.Served == nil {
+		ps.Served = map[string]uint64{}
+	}
+	if ps.NotFound == nil {
+		ps.NotFound = map[string]uint64{}
+	}
+}
+
+func (ps *pathStats) run(interval time.Duration) {
+	defer ps.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ps.flush()
+		case <-ps.done:
+			ps.flush()
+			return
+		}
+	}
+}
+
+// flush writes the statistics to a temporary file and renames it,
+// so a crash never leaves a truncated file behind.
+func (ps *pathStats) flush() {
+	ps.mutex.Lock()
+	if !ps.dirty || ps.file == "" {
+		ps.mutex.Unlock()
+		return
+	}
+	if ps.disk != nil && ps.disk.Paused() {
+		// still dirty, flushed once the disk resumes
+		ps.mutex.Unlock()
+		logger.Warn("disk below low watermark, path stats not flushed", zap.String("file", ps.file))
+		return
+	}
+	data, err := json.Marshal(ps)
+	ps.dirty = false
+	ps.mutex.Unlock()
+	if err != nil {
+		logger.Warn("encode path stats failed", zap.Error(err))
+		return
+	}
+	if ps.keyring != nil {
+		if data, err = ps.keyring.Seal(data); err != nil {
+			logger.Warn("encrypt path stats failed", zap.Error(err))
+			return
+		}
+	}
+
+	tmp := ps.file + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(ps.file), 0o755); err != nil {
+		logger.Warn("create path stats dir failed", zap.String("file", ps.file), zap.Error(err))
+		return
+	}
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		logger.Warn("write path stats failed", zap.String("file", tmp), zap.Error(err))
+		return
+	}
+	if err := os.Rename(tmp, ps.file); err != nil {
+		logger.Warn("rename path stats failed", zap.String("file", ps.file), zap.Error(err))
+	}
+}
+
+func (ps *pathStats) record(p, result string) {
+	var m map[string]uint64
+	switch result {
+	case "":
+		m = ps.Served
+	case resultNotFound, resultFallthrough:
+		m = ps.NotFound
+	default:
+		return
+	}
+
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if _, ok := m[p]; !ok && len(m) >= ps.maxPaths {
+		return
+	}
+	m[p]++
+	ps.dirty = true
+}
+
+// topServed returns at most n paths ordered by descending count.
+func (ps *pathStats) topServed(n int) []string {
+	ps.mutex.Lock()
+	paths := make([]string, 0, len(ps.Served))
+	for p := range ps.Served {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		ci, cj := ps.Served[paths[i]], ps.Served[paths[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return paths[i] < paths[j]
+	})
+	ps.mutex.Unlock()
+
+	if len(paths) > n {
+		paths = paths[:n]
+	}
+	return paths
+}
+
+func (ps *pathStats) close() {
+	close(ps.done)
+	ps.wg.Wait()
+	if ps.disk != nil {
+		ps.disk.Close()
+	}
+	if ps.keyring != nil {
+		ps.keyring.Close()
+	}
+}
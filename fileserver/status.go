@@ -0,0 +1,231 @@
+package fileserver
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/FucAttaCk/gateway/diskwatch"
+)
+
+// sizeBuckets are the upper bounds of the body size histograms,
+// the last bucket counts everything above them.
+var sizeBuckets = [...]int64{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20}
+
+// latencyBuckets are the upper bounds of the latency histograms,
+// the last bucket counts everything above them.
+var latencyBuckets = [...]time.Duration{
+	50 * time.Microsecond, 100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	25 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+type (
+	// Status is the status of FileServer.
+	Status struct {
+		// Results counts the requests by result, served counts the
+		// requests answered without a result.
+		Results map[string]uint64 `yaml:"results"`
+		// StatusCodes counts the responses written for served files
+		// by status code, e.g. 200, 206 and 304.
+		StatusCodes map[int]uint64 `yaml:"statusCodes"`
+		// BytesServed is the number of file bytes written.
+		BytesServed   uint64               `yaml:"bytesServed"`
+		RequestSizes  *SizeHistogramStatus `yaml:"requestSizes"`
+		ResponseSizes *SizeHistogramStatus `yaml:"responseSizes"`
+		// TTFB is the time from the filter receiving the request to
+		// the first byte of the file being written.
+		TTFB *LatencyStatus `yaml:"ttfb"`
+		// Latency is the total time spent serving the request.
+		Latency *LatencyStatus `yaml:"latency"`
+		// MemoryCache is set if the memory cache is enabled.
+		MemoryCache *MemoryCacheStatus `yaml:"memoryCache,omitempty"`
+		// StatCache is set if the stat cache is enabled.
+		StatCache *StatCacheStatus `yaml:"statCache,omitempty"`
+		// Mmap is set if memory-mapped serving is enabled.
+		Mmap *MmapStatus `yaml:"mmap,omitempty"`
+		// Transfers are the transfers by mount prefix, if the
+		// concurrency is limited.
+		Transfers map[string]*TransferStatus `yaml:"transfers,omitempty"`
+		// Disks are the disks written to, if disk watermarks are
+		// configured.
+		Disks []*diskwatch.Status `yaml:"disks,omitempty"`
+		// AccessLogDropped counts the access lines not written to
+		// the file while its disk was below the low watermark.
+		AccessLogDropped uint64 `yaml:"accessLogDropped,omitempty"`
+	}
+
+	// LatencyStatus summarizes a latency histogram, percentiles are
+	// the upper bound of the bucket they fall in.
+	LatencyStatus struct {
+		Count uint64  `yaml:"count"`
+		Mean  float64 `yaml:"meanMs"`
+		P50   float64 `yaml:"p50Ms"`
+		P99   float64 `yaml:"p99Ms"`
+	}
+
+	latencyHistogram struct {
+		count  uint64
+		sum    uint64
+		counts [len(latencyBuckets) + 1]uint64
+	}
+
+	// SizeHistogramStatus is the snapshot of a body size histogram.
+	SizeHistogramStatus struct {
+		Count   uint64        `yaml:"count"`
+		Sum     uint64        `yaml:"sum"`
+		Buckets []*SizeBucket `yaml:"buckets"`
+	}
+
+	// SizeBucket counts the bodies not larger than UpperBound,
+	// an UpperBound of -1 stands for infinity.
+	SizeBucket struct {
+		UpperBound int64  `yaml:"upperBound"`
+		Count      uint64 `yaml:"count"`
+	}
+
+	sizeHistogram struct {
+		count  uint64
+		sum    uint64
+		counts [len(sizeBuckets) + 1]uint64
+	}
+
+	// resultCounter counts the requests by result.
+	resultCounter struct {
+		counts []uint64
+	}
+
+	// statusCounter counts the responses by status code.
+	statusCounter struct {
+		mutex  sync.Mutex
+		counts map[int]uint64
+	}
+)
+
+const resultServed = "served"
+
+func newResultCounter() *resultCounter {
+	return &resultCounter{counts: make([]uint64, len(results)+1)}
+}
+
+func (c *resultCounter) record(result string) {
+	for i, r := range results {
+		if r == result {
+			atomic.AddUint64(&c.counts[i+1], 1)
+			return
+		}
+	}
+	atomic.AddUint64(&c.counts[0], 1)
+}
+
+func (c *resultCounter) status() map[string]uint64 {
+	s := map[string]uint64{resultServed: atomic.LoadUint64(&c.counts[0])}
+	for i, r := range results {
+		s[r] = atomic.LoadUint64(&c.counts[i+1])
+	}
+	return s
+}
+
+func (c *statusCounter) record(code int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.counts == nil {
+		c.counts = map[int]uint64{}
+	}
+	c.counts[code]++
+}
+
+func (c *statusCounter) status() map[int]uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s := make(map[int]uint64, len(c.counts))
+	for code, n := range c.counts {
+		s[code] = n
+	}
+	return s
+}
+
+// hitRatio returns the share of hits among all lookups.
+func hitRatio(hits, misses uint64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+func (h *sizeHistogram) observe(size int64) {
+	if size < 0 {
+		return
+	}
+	i := 0
+	for i < len(sizeBuckets) && size > sizeBuckets[i] {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, uint64(size))
+}
+
+func (h *sizeHistogram) status() *SizeHistogramStatus {
+	s := &SizeHistogramStatus{
+		Count: atomic.LoadUint64(&h.count),
+		Sum:   atomic.LoadUint64(&h.sum),
+	}
+	for i := range h.counts {
+		bound := int64(-1)
+		if i < len(sizeBuckets) {
+			bound = sizeBuckets[i]
+		}
+		s.Buckets = append(s.Buckets, &SizeBucket{UpperBound: bound, Count: atomic.LoadUint64(&h.counts[i])})
+	}
+	return s
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, uint64(d))
+}
+
+func (h *latencyHistogram) status() *LatencyStatus {
+	var counts [len(latencyBuckets) + 1]uint64
+	var count uint64
+	for i := range h.counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+		count += counts[i]
+	}
+	s := &LatencyStatus{Count: count}
+	if count == 0 {
+		return s
+	}
+	s.Mean = float64(atomic.LoadUint64(&h.sum)) / float64(atomic.LoadUint64(&h.count)) / float64(time.Millisecond)
+	s.P50 = latencyPercentile(&counts, count, 0.5)
+	s.P99 = latencyPercentile(&counts, count, 0.99)
+	return s
+}
+
+// latencyPercentile returns the upper bound in milliseconds of the
+// bucket holding percentile p, the overflow bucket reports the
+// largest bound.
+func latencyPercentile(counts *[len(latencyBuckets) + 1]uint64, total uint64, p float64) float64 {
+	rank := uint64(float64(total)*p + 0.5)
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range counts {
+		seen += c
+		if seen >= rank {
+			if i == len(latencyBuckets) {
+				i--
+			}
+			return float64(latencyBuckets[i]) / float64(time.Millisecond)
+		}
+	}
+	return float64(latencyBuckets[len(latencyBuckets)-1]) / float64(time.Millisecond)
+}
@@ -0,0 +1,169 @@
+package fileserver
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/FucAttaCk/gateway/util"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/megaease/easegress/pkg/context"
+)
+
+const (
+	defaultTemplateMaxSize = 1 << 20
+	templateCacheSize      = 256
+)
+
+// TemplatesSpec renders the matching files with html/template before
+// they are sent. Templates read placeholders with
+// {{placeholder "http.request.host"}} and include other files of
+// the same root with {{include "/header.html"}}.
+type TemplatesSpec struct {
+	// Globs of the rendered files, like the globs of header rules.
+	// Default: *.tmpl.html.
+	Globs []string
+	// Larger templates and included files fail with 500, as they
+	// are read into memory. Default: 1MiB.
+	MaxSize int64
+}
+
+func (spec *TemplatesSpec) validate() error {
+	for _, g := range spec.Globs {
+		if _, err := path.Match(strings.ReplaceAll(g, "**", "*"), ""); err != nil {
+			return fmt.Errorf("invalid template glob %q: %v", g, err)
+		}
+	}
+	return nil
+}
+
+func (spec *TemplatesSpec) match(reqPath string) bool {
+	if len(spec.Globs) == 0 {
+		return matchGlob("*.tmpl.html", reqPath)
+	}
+	for _, g := range spec.Globs {
+		if matchGlob(g, reqPath) {
+			return true
+		}
+	}
+	return false
+}
+
+func (spec *TemplatesSpec) maxSize() int64 {
+	if spec.MaxSize > 0 {
+		return spec.MaxSize
+	}
+	return defaultTemplateMaxSize
+}
+
+func newTemplateCache(spec *TemplatesSpec) *lru.Cache {
+	if spec == nil {
+		return nil
+	}
+	c, _ := lru.New(templateCacheSize)
+	return c
+}
+
+// render executes the template in content, filename is the template
+// file of the mount m.
+func (fsrv *FileServer) render(ctx context.HTTPContext, m *mount, filename string, info fs.FileInfo, content io.Reader) ([]byte, error) {
+	spec := fsrv.spec.Templates
+	key := fmt.Sprintf("%s\x00%d\x00%d", filename, info.ModTime().UnixNano(), info.Size())
+	var tmpl *template.Template
+	if v, ok := fsrv.templates.Get(key); ok {
+		tmpl = v.(*template.Template)
+	} else {
+		text, err := readAtMost(content, spec.maxSize())
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err = template.New(path.Base(filename)).Funcs(templateFuncs).Parse(string(text))
+		if err != nil {
+			return nil, err
+		}
+		fsrv.templates.Add(key, tmpl)
+	}
+
+	r := ctx.Request()
+	repl := util.NewReplacer()
+	repl.Set("http.request.method", r.Method())
+	repl.Set("http.request.path", r.Path())
+	repl.Set("http.request.uri", r.Std().RequestURI)
+	repl.Set("http.request.host", r.Host())
+	repl.Set("http.request.remote.host", r.RealIP())
+	repl.Set("file.name", path.Base(filename))
+	repl.Map(func(key string) (any, bool) {
+		switch {
+		case strings.HasPrefix(key, "http.request.header."):
+			return r.Header().Get(key[len("http.request.header."):]), true
+		case strings.HasPrefix(key, "http.request.query."):
+			query, _ := url.ParseQuery(r.Query())
+			return query.Get(key[len("http.request.query."):]), true
+		}
+		return nil, false
+	})
+
+	// the functions are bound to the request when it is executed
+	tmpl, err := tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	tmpl.Funcs(template.FuncMap{
+		"placeholder": func(key string) string {
+			s, _ := repl.GetString(key)
+			return s
+		},
+		"include": func(name string) (template.HTML, error) {
+			return fsrv.include(m, name)
+		},
+	})
+
+	buf := util.GetBuffer()
+	defer util.PutBuffer(buf)
+	if err := tmpl.Execute(buf, nil); err != nil {
+		return nil, err
+	}
+	// the buffer goes back to the pool, the caller gets a copy
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// templateFuncs are placeholders for the functions bound to requests,
+// so templates parse before they are executed.
+var templateFuncs = template.FuncMap{
+	"placeholder": func(string) string { return "" },
+	"include":     func(string) (template.HTML, error) { return "", nil },
+}
+
+// include returns the content of the file name of the mount m as is,
+// files the mount hides can't be included.
+func (fsrv *FileServer) include(m *mount, name string) (template.HTML, error) {
+	filename := util.SanitizedPathJoin(m.root, name)
+	if m.hidden(filename) || fsrv.dotPath(path.Clean("/"+name)) {
+		return "", fmt.Errorf("include %s: %w", name, fs.ErrNotExist)
+	}
+	f, err := fsrv.openFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("include %s: %w", name, err)
+	}
+	defer f.Close()
+	data, err := readAtMost(f, fsrv.spec.Templates.maxSize())
+	if err != nil {
+		return "", fmt.Errorf("include %s: %w", name, err)
+	}
+	return template.HTML(data), nil
+}
+
+func readAtMost(r io.Reader, n int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, n+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > n {
+		return nil, fmt.Errorf("file is larger than %d bytes", n)
+	}
+	return data, nil
+}
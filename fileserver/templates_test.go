@@ -0,0 +1,73 @@
+package fileserver
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"page.tmpl.html": `<h1>{{placeholder "http.request.host"}}</h1>{{include "/header.html"}}<p>{{placeholder "http.request.query.q"}}</p>`,
+		"header.html":    "<nav>home</nav>",
+		".secret":        "secret",
+	} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fsrv := &FileServer{spec: &Spec{Root: root, fileSystem: &osFS{}, Templates: &TemplatesSpec{}}}
+	fsrv.mounts = fsrv.buildMounts()
+	fsrv.templates = newTemplateCache(fsrv.spec.Templates)
+	m := fsrv.mounts[0]
+
+	render := func(name string) (string, error) {
+		filename := filepath.Join(root, name)
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		ctx, _ := newMockedContext(httptest.NewRequest("GET", "/"+name+"?q=%3Cb%3E", nil))
+		out, err := fsrv.render(ctx, m, filename, info, f)
+		return string(out), err
+	}
+
+	// twice, the second time from the cache
+	for i := 0; i < 2; i++ {
+		out, err := render("page.tmpl.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "<h1>example.com</h1><nav>home</nav><p>&lt;b&gt;</p>"; out != want {
+			t.Errorf("render = %q, want %q", out, want)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "bad.tmpl.html"), []byte(`{{include "/.secret"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := render("bad.tmpl.html"); err == nil || strings.Contains(out, "secret") {
+		t.Errorf("dotfile included: %q, %v", out, err)
+	}
+}
+
+func TestTemplatesMatch(t *testing.T) {
+	spec := &TemplatesSpec{}
+	if !spec.match("/a/index.tmpl.html") || spec.match("/a/index.html") {
+		t.Error("default glob not matched")
+	}
+	spec.Globs = []string{"/pages/**/*.html"}
+	if !spec.match("/pages/a/b.html") || spec.match("/b.html") {
+		t.Error("globs not matched")
+	}
+}
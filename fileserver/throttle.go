@@ -0,0 +1,20 @@
+package fileserver
+
+import "fmt"
+
+// ThrottleSpec limits the bandwidth of the served files, so large
+// downloads don't starve the other traffic of the gateway. Throttled
+// files aren't sent with sendfile.
+type ThrottleSpec struct {
+	// Bytes per second shared by all the responses of the filter.
+	MaxBytesPerSecond int64
+	// Bytes per second of every single response.
+	MaxBytesPerSecondPerRequest int64
+}
+
+func (spec *ThrottleSpec) validate() error {
+	if spec.MaxBytesPerSecond < 0 || spec.MaxBytesPerSecondPerRequest < 0 {
+		return fmt.Errorf("throttle: bytes per second must not be negative")
+	}
+	return nil
+}
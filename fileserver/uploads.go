@@ -0,0 +1,292 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/FucAttaCk/gateway/diskwatch"
+	"github.com/FucAttaCk/gateway/util"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	overwriteReject  = "reject"
+	overwriteReplace = "replace"
+	overwriteRename  = "rename"
+
+	defaultUploadMaxSize = 100 << 20
+	maxUploadRenames     = 1000
+)
+
+// UploadsSpec lets clients store files with PUT or POST, the request
+// body is the content of the file. Uploads must be protected by
+// BasicAuth or SignedURLs, unless anonymous uploads are allowed.
+type UploadsSpec struct {
+	// The directory of the root files are uploaded to, e.g. /incoming.
+	// It is also the request path prefix of uploads, after
+	// StripPathPrefix and mounts, so the uploaded files are served
+	// from where they were uploaded to.
+	Dir string
+	// Larger uploads are rejected with 413. Default: 100MiB.
+	MaxSize int64
+	// The allowed file extensions, e.g. .png. Default: all.
+	Extensions []string
+	// What happens when the file exists: reject answers 409, replace
+	// overwrites the file and rename stores the upload as name-1.ext,
+	// name-2.ext... Default: reject.
+	Overwrite string
+	// Uploads are rejected with 507 while the disk holding the root
+	// they go to, or the quarantine directory of Scan, is below its
+	// low watermark. Every root of Root, Mounts and Hosts is watched.
+	DiskWatermarks *diskwatch.Spec
+	// Quarantine uploads until a scanner reports them clean.
+	Scan *ScanSpec
+	// Accept uploads to paths neither BasicAuth nor SignedURLs
+	// protect, e.g. when a filter in front authenticates the
+	// uploaders. Default: false, they are answered with 403.
+	AllowAnonymous bool
+}
+
+func (spec *UploadsSpec) validate() error {
+	if !strings.HasPrefix(spec.Dir, "/") || path.Clean(spec.Dir) == "/" {
+		return fmt.Errorf("uploads: dir %q must be a subdirectory starting with /", spec.Dir)
+	}
+	switch spec.Overwrite {
+	case "", overwriteReject, overwriteReplace, overwriteRename:
+	default:
+		return fmt.Errorf("uploads: invalid overwrite %q", spec.Overwrite)
+	}
+	for _, ext := range spec.Extensions {
+		if !strings.HasPrefix(ext, ".") {
+			return fmt.Errorf("uploads: extension %q must start with a dot", ext)
+		}
+	}
+	if spec.Scan != nil {
+		if err := spec.Scan.validate(); err != nil {
+			return err
+		}
+	}
+	if spec.DiskWatermarks != nil {
+		return spec.DiskWatermarks.Validate()
+	}
+	return nil
+}
+
+// match reports whether a request for p with method is an upload.
+func (spec *UploadsSpec) match(method, p string) bool {
+	if method != http.MethodPut && method != http.MethodPost {
+		return false
+	}
+	prefix := path.Clean(spec.Dir) + "/"
+	return strings.HasPrefix(p, prefix) && len(p) > len(prefix) && !strings.HasSuffix(p, "/")
+}
+
+// authenticates reports whether any path must authenticate, writes
+// are refused otherwise unless they allow anonymous clients.
+func (spec *Spec) authenticates() bool {
+	return (spec.BasicAuth != nil && len(spec.BasicAuth.Paths) > 0) || spec.SignedURLs != nil
+}
+
+// protected reports whether requests for reqPath must authenticate,
+// they did so already when the upload is handled.
+func (fsrv *FileServer) protected(reqPath string) bool {
+	return (fsrv.basicAuth != nil && fsrv.basicAuth.rule(reqPath) != nil) ||
+		(fsrv.signedURLs != nil && fsrv.signedURLs.match(reqPath))
+}
+
+func (spec *UploadsSpec) allowed(name string) bool {
+	if len(spec.Extensions) == 0 {
+		return true
+	}
+	ext := filepath.Ext(name)
+	for _, e := range spec.Extensions {
+		if strings.EqualFold(e, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func (spec *UploadsSpec) maxSize() int64 {
+	if spec.MaxSize > 0 {
+		return spec.MaxSize
+	}
+	return defaultUploadMaxSize
+}
+
+// upload stores the body of the request as filename, the file rel of
+// mount m. The body is written to a temporary file first, so readers
+// never see a partial upload.
+func (fsrv *FileServer) upload(ctx context.HTTPContext, m *mount, rel, filename string) string {
+	spec := fsrv.spec.Uploads
+	r := ctx.Request()
+	w := ctx.Response()
+
+	if !spec.AllowAnonymous && !fsrv.protected(r.Path()) {
+		ctx.AddTag("upload: anonymous uploads not allowed")
+		fsrv.authFailure(ctx, "anonymous upload", nil)
+		w.SetStatusCode(http.StatusForbidden)
+		return resultUnauthorized
+	}
+	if m.hidden(filename) || !spec.allowed(filename) {
+		ctx.AddTag("upload: file type not allowed")
+		w.SetStatusCode(http.StatusUnsupportedMediaType)
+		return resultInvalidUpload
+	}
+	if cl := r.Std().ContentLength; cl > spec.maxSize() {
+		w.SetStatusCode(http.StatusRequestEntityTooLarge)
+		return resultTooLarge
+	}
+	scan := fsrv.scanner != nil && fsrv.scanner.match(rel)
+	if fsrv.uploadDiskPaused(m.root) || (scan && fsrv.uploadDiskPaused(spec.Scan.QuarantineDir)) {
+		ctx.AddTag("upload: disk below low watermark")
+		w.SetStatusCode(http.StatusInsufficientStorage)
+		return resultDiskFull
+	}
+	exists := false
+	if _, err := os.Stat(filename); err == nil {
+		exists = true
+		if spec.Overwrite == "" || spec.Overwrite == overwriteReject {
+			w.SetStatusCode(http.StatusConflict)
+			return resultConflict
+		}
+	}
+
+	body, err := util.NewDigestReader(r.Body(), r.Header().Std())
+	if err != nil {
+		ctx.AddTag(fmt.Sprintf("upload: %v", err))
+		w.SetStatusCode(http.StatusBadRequest)
+		return resultInvalidUpload
+	}
+
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fsrv.uploadFailed(ctx, filename, err)
+	}
+	tmpDir := dir
+	if scan {
+		tmpDir = fsrv.spec.Uploads.Scan.QuarantineDir
+	}
+	// dotfiles are never served, so the partial upload isn't either
+	tmp, err := os.CreateTemp(tmpDir, ".upload-*")
+	if err != nil {
+		return fsrv.uploadFailed(ctx, filename, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, io.LimitReader(body, spec.maxSize()+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	switch {
+	case errors.Is(err, util.ErrDigestMismatch):
+		ctx.AddTag(fmt.Sprintf("upload: %v", err))
+		w.SetStatusCode(http.StatusBadRequest)
+		return resultInvalidUpload
+	case err != nil && util.ClientGone(r.Std().Context(), err):
+		return fsrv.clientDisconnected(ctx)
+	case err != nil:
+		return fsrv.uploadFailed(ctx, filename, err)
+	case n > spec.maxSize():
+		w.SetStatusCode(http.StatusRequestEntityTooLarge)
+		return resultTooLarge
+	}
+	if scan {
+		return fsrv.quarantine(ctx, tmp.Name(), filename, n, body.ContentDigest())
+	}
+
+	stored, err := storeUpload(tmp.Name(), filename, spec.Overwrite)
+	if errors.Is(err, fs.ErrExist) {
+		w.SetStatusCode(http.StatusConflict)
+		return resultConflict
+	}
+	if err != nil {
+		return fsrv.uploadFailed(ctx, filename, err)
+	}
+	if fsrv.statCache != nil {
+		fsrv.statCache.forget(stored)
+	}
+
+	if stored != filename {
+		location := path.Join(path.Dir(r.Path()), filepath.Base(stored))
+		w.Header().Set("Location", location)
+	}
+	w.Header().Set("Content-Digest", body.ContentDigest())
+	if exists && stored == filename {
+		w.SetStatusCode(http.StatusNoContent)
+	} else {
+		w.SetStatusCode(http.StatusCreated)
+	}
+	return ""
+}
+
+// watchUploadDisks watches the disk of every directory uploads are
+// written to, keyed by the directory.
+func (fsrv *FileServer) watchUploadDisks(spec *UploadsSpec) map[string]*diskwatch.Watcher {
+	disks := map[string]*diskwatch.Watcher{}
+	watch := func(dir string) {
+		if _, ok := disks[dir]; !ok {
+			disks[dir] = diskwatch.New(dir, spec.DiskWatermarks)
+		}
+	}
+	for _, m := range fsrv.mounts {
+		watch(m.root)
+	}
+	for _, hm := range fsrv.hosts {
+		watch(hm.root)
+	}
+	if spec.Scan != nil {
+		watch(spec.Scan.QuarantineDir)
+	}
+	return disks
+}
+
+// uploadDiskPaused reports whether the disk of upload directory dir
+// is below its low watermark.
+func (fsrv *FileServer) uploadDiskPaused(dir string) bool {
+	w, ok := fsrv.uploadDisks[dir]
+	return ok && w.Paused()
+}
+
+func (fsrv *FileServer) uploadFailed(ctx context.HTTPContext, filename string, err error) string {
+	logger.Error("store upload failed", zap.String("filename", filename), zap.Error(err))
+	ctx.AddTag(fmt.Sprintf("upload failed: %v", err))
+	ctx.Response().SetStatusCode(http.StatusInternalServerError)
+	return resultErrHandleFile
+}
+
+// storeUpload moves the temporary file tmp to filename and returns
+// where it ended up. Without replacing, the file is linked to its new
+// name, which fails atomically if the name is taken.
+func storeUpload(tmp, filename, overwrite string) (string, error) {
+	if overwrite == overwriteReplace {
+		return filename, os.Rename(tmp, filename)
+	}
+	err := os.Link(tmp, filename)
+	if err == nil || overwrite != overwriteRename || !errors.Is(err, fs.ErrExist) {
+		return filename, err
+	}
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	for i := 1; i <= maxUploadRenames; i++ {
+		name := base + "-" + strconv.Itoa(i) + ext
+		err := os.Link(tmp, name)
+		if err == nil {
+			return name, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return name, err
+		}
+	}
+	return filename, fs.ErrExist
+}
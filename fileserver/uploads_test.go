@@ -0,0 +1,131 @@
+package fileserver
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/FucAttaCk/gateway/diskwatch"
+	"github.com/FucAttaCk/gateway/util"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUploadsMatch(t *testing.T) {
+	spec := &UploadsSpec{Dir: "/incoming/", Extensions: []string{".png"}}
+	for _, c := range []struct {
+		method, path string
+		want         bool
+	}{
+		{"PUT", "/incoming/a.png", true},
+		{"POST", "/incoming/2022/a.png", true},
+		{"GET", "/incoming/a.png", false},
+		{"PUT", "/incoming/", false},
+		{"PUT", "/incomingx/a.png", false},
+		{"PUT", "/a.png", false},
+	} {
+		if got := spec.match(c.method, c.path); got != c.want {
+			t.Errorf("match(%s, %s) = %v, want %v", c.method, c.path, got, c.want)
+		}
+	}
+	if !spec.allowed("a.PNG") || spec.allowed("a.exe") {
+		t.Error("extensions not applied")
+	}
+}
+
+func TestStoreUpload(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "a.txt")
+	write := func(content string) string {
+		tmp := filepath.Join(dir, ".upload-tmp")
+		if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.Remove(tmp) })
+		return tmp
+	}
+
+	if stored, err := storeUpload(write("1"), filename, overwriteReject); err != nil || stored != filename {
+		t.Fatalf("first upload: %s, %v", stored, err)
+	}
+	if _, err := storeUpload(write("2"), filename, overwriteReject); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("reject: %v", err)
+	}
+	if stored, err := storeUpload(write("3"), filename, overwriteRename); err != nil || stored != filepath.Join(dir, "a-1.txt") {
+		t.Errorf("rename: %s, %v", stored, err)
+	}
+	if _, err := storeUpload(write("4"), filename, overwriteReplace); err != nil {
+		t.Errorf("replace: %v", err)
+	}
+	if data, _ := os.ReadFile(filename); string(data) != "4" {
+		t.Errorf("content = %q, want 4", data)
+	}
+}
+
+func TestUploadsAuthentication(t *testing.T) {
+	uploads := &UploadsSpec{Dir: "/incoming"}
+	if err := (&Spec{Uploads: uploads}).Validate(); err == nil {
+		t.Error("anonymous uploads accepted")
+	}
+	if err := (&Spec{Uploads: &UploadsSpec{Dir: "/incoming", AllowAnonymous: true}}).Validate(); err != nil {
+		t.Error(err)
+	}
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	spec := &Spec{
+		Uploads:   uploads,
+		BasicAuth: &BasicAuthSpec{Paths: map[string][]string{"/incoming/private": {"ops:" + string(hash)}}},
+	}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	fsrv := &FileServer{spec: spec, basicAuth: newBasicAuth(spec.BasicAuth)}
+	if !fsrv.protected("/incoming/private/a.png") || fsrv.protected("/incoming/a.png") {
+		t.Error("protected paths not matched")
+	}
+}
+
+func TestUploadDisks(t *testing.T) {
+	root, assets, site := t.TempDir(), t.TempDir(), t.TempDir()
+	fsrv := &FileServer{spec: &Spec{
+		Root:       root,
+		fileSystem: osFS{},
+		Mounts:     []*MountSpec{{Prefix: "/assets", Root: assets}},
+		Hosts:      map[string]string{"a.example.com": site},
+		Uploads:    &UploadsSpec{Dir: "/", AllowAnonymous: true, DiskWatermarks: &diskwatch.Spec{LowWatermark: "0%"}},
+	}}
+	fsrv.mounts = fsrv.buildMounts()
+	fsrv.hosts = fsrv.buildHosts(fsrv.mounts[len(fsrv.mounts)-1])
+	fsrv.uploadDisks = fsrv.watchUploadDisks(fsrv.spec.Uploads)
+	defer func() {
+		for _, w := range fsrv.uploadDisks {
+			w.Close()
+		}
+	}()
+	if len(fsrv.uploadDisks) != 3 {
+		t.Fatalf("%d upload disks watched, want 3", len(fsrv.uploadDisks))
+	}
+
+	// only the disk of the assets mount is full
+	fsrv.uploadDisks[assets].Close()
+	fsrv.uploadDisks[assets] = diskwatch.New(assets, &diskwatch.Spec{LowWatermark: "100%"})
+	if !fsrv.uploadDisks[assets].Paused() {
+		t.Skip("free disk space not available")
+	}
+
+	upload := func(target string) (string, int) {
+		ctx, rec := newMockedContext(httptest.NewRequest(http.MethodPut, target, strings.NewReader("data")))
+		m, rel := fsrv.match("", target)
+		return fsrv.upload(ctx, m, rel, util.SanitizedPathJoin(m.root, rel)), rec.Code
+	}
+	if res, status := upload("/assets/a.txt"); res != resultDiskFull || status != http.StatusInsufficientStorage {
+		t.Errorf("upload to the full disk: %q, %d", res, status)
+	}
+	if res, status := upload("/a.txt"); res != "" || status != http.StatusCreated {
+		t.Errorf("upload to root: %q, %d", res, status)
+	}
+}
@@ -0,0 +1,332 @@
+//go:build linux && iouring
+
+package fileserver
+
+import (
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The layout of the structures below follows linux/io_uring.h.
+
+const (
+	uringEntries = 256
+
+	uringOffSQRing = 0
+	uringOffCQRing = 0x8000000
+	uringOffSQEs   = 0x10000000
+
+	uringOpNop  = 0
+	uringOpRead = 22
+
+	uringEnterGetEvents = 1
+
+	// user data of the NOP submitted by close to wake the reaper
+	uringCloseUserData = ^uint64(0)
+)
+
+type (
+	uringSQOffsets struct {
+		head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+		resv2                                                           uint64
+	}
+
+	uringCQOffsets struct {
+		head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+		resv2                                                           uint64
+	}
+
+	uringParams struct {
+		sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFD uint32
+		resv                                                                   [3]uint32
+		sqOff                                                                  uringSQOffsets
+		cqOff                                                                  uringCQOffsets
+	}
+
+	uringSQE struct {
+		opcode      uint8
+		flags       uint8
+		ioprio      uint16
+		fd          int32
+		off         uint64
+		addr        uint64
+		len         uint32
+		rwFlags     uint32
+		userData    uint64
+		bufIndex    uint16
+		personality uint16
+		spliceFDIn  int32
+		pad         [2]uint64
+	}
+
+	uringCQE struct {
+		userData uint64
+		res      int32
+		flags    uint32
+	}
+
+	// uring is a minimal io_uring instance used to read files. Submissions
+	// are serialized by a mutex while a dedicated goroutine reaps
+	// completions and hands them back to the waiting callers.
+	uring struct {
+		fd     int
+		sqRing []byte
+		cqRing []byte
+		sqeMem []byte
+
+		sqHead, sqTail, sqMask *uint32
+		sqArray                []uint32
+		sqes                   []uringSQE
+		cqHead, cqTail, cqMask *uint32
+		cqes                   []uringCQE
+
+		slots   chan struct{}
+		mutex   sync.Mutex
+		nextID  uint64
+		pending map[uint64]chan int32
+		done    chan struct{}
+	}
+)
+
+const uringSupported = true
+
+var errURingClosed = errors.New("io_uring closed")
+
+func newURing() (*uring, error) {
+	var p uringParams
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uringEntries, uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("io_uring_setup", errno)
+	}
+
+	r := &uring{
+		fd:      int(fd),
+		slots:   make(chan struct{}, p.sqEntries),
+		pending: map[uint64]chan int32{},
+		done:    make(chan struct{}),
+	}
+
+	var err error
+	sqSize := int(p.sqOff.array + p.sqEntries*4)
+	if r.sqRing, err = unix.Mmap(r.fd, uringOffSQRing, sqSize,
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		r.unmap()
+		return nil, err
+	}
+	cqSize := int(p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(uringCQE{})))
+	if r.cqRing, err = unix.Mmap(r.fd, uringOffCQRing, cqSize,
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		r.unmap()
+		return nil, err
+	}
+	sqeSize := int(p.sqEntries) * int(unsafe.Sizeof(uringSQE{}))
+	if r.sqeMem, err = unix.Mmap(r.fd, uringOffSQEs, sqeSize,
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		r.unmap()
+		return nil, err
+	}
+
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.tail]))
+	r.sqMask = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.array])), p.sqEntries)
+	r.sqes = unsafe.Slice((*uringSQE)(unsafe.Pointer(&r.sqeMem[0])), p.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.tail]))
+	r.cqMask = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*uringCQE)(unsafe.Pointer(&r.cqRing[p.cqOff.cqes])), p.cqEntries)
+
+	go r.reap()
+	return r, nil
+}
+
+func (r *uring) unmap() {
+	for _, m := range [][]byte{r.sqRing, r.cqRing, r.sqeMem} {
+		if m != nil {
+			unix.Munmap(m)
+		}
+	}
+	unix.Close(r.fd)
+}
+
+func (r *uring) enter(toSubmit, minComplete, flags uint32) error {
+	for {
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd),
+			uintptr(toSubmit), uintptr(minComplete), uintptr(flags), 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return os.NewSyscallError("io_uring_enter", errno)
+		}
+		return nil
+	}
+}
+
+// submit queues one SQE filled by fill and waits for its completion.
+// The memory referenced by the SQE must stay alive until it returns.
+func (r *uring) submit(fill func(sqe *uringSQE)) (int32, error) {
+	select {
+	case r.slots <- struct{}{}:
+	case <-r.done:
+		return 0, errURingClosed
+	}
+	defer func() { <-r.slots }()
+
+	ch := make(chan int32, 1)
+
+	r.mutex.Lock()
+	r.nextID++
+	id := r.nextID
+	tail := atomic.LoadUint32(r.sqTail)
+	idx := tail & atomic.LoadUint32(r.sqMask)
+	sqe := &r.sqes[idx]
+	*sqe = uringSQE{}
+	fill(sqe)
+	sqe.userData = id
+	r.sqArray[idx] = idx
+	atomic.StoreUint32(r.sqTail, tail+1)
+	r.pending[id] = ch
+	err := r.enter(1, 0, 0)
+	if err != nil {
+		delete(r.pending, id)
+	}
+	r.mutex.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	return <-ch, nil
+}
+
+// reap waits for completions and dispatches them until the close
+// NOP is seen.
+func (r *uring) reap() {
+	for {
+		if err := r.enter(0, 1, uringEnterGetEvents); err != nil {
+			select {
+			case <-r.done:
+				return
+			default:
+				continue
+			}
+		}
+
+		head := atomic.LoadUint32(r.cqHead)
+		tail := atomic.LoadUint32(r.cqTail)
+		mask := atomic.LoadUint32(r.cqMask)
+		closed := false
+		for ; head != tail; head++ {
+			cqe := r.cqes[head&mask]
+			if cqe.userData == uringCloseUserData {
+				closed = true
+				continue
+			}
+			r.mutex.Lock()
+			ch := r.pending[cqe.userData]
+			delete(r.pending, cqe.userData)
+			r.mutex.Unlock()
+			if ch != nil {
+				ch <- cqe.res
+			}
+		}
+		atomic.StoreUint32(r.cqHead, head)
+
+		if closed {
+			r.unmap()
+			return
+		}
+	}
+}
+
+// pread reads into buf from fd at offset off.
+func (r *uring) pread(fd int, buf []byte, off int64) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	res, err := r.submit(func(sqe *uringSQE) {
+		sqe.opcode = uringOpRead
+		sqe.fd = int32(fd)
+		sqe.off = uint64(off)
+		sqe.addr = uint64(uintptr(unsafe.Pointer(&buf[0])))
+		sqe.len = uint32(len(buf))
+	})
+	runtime.KeepAlive(buf)
+	if err != nil {
+		return 0, err
+	}
+	if res < 0 {
+		return 0, os.NewSyscallError("io_uring read", syscall.Errno(-res))
+	}
+	return int(res), nil
+}
+
+// close stops the ring once all in-flight reads have completed.
+func (r *uring) close() {
+	for i := 0; i < cap(r.slots); i++ {
+		r.slots <- struct{}{}
+	}
+	close(r.done)
+
+	r.mutex.Lock()
+	tail := atomic.LoadUint32(r.sqTail)
+	idx := tail & atomic.LoadUint32(r.sqMask)
+	r.sqes[idx] = uringSQE{opcode: uringOpNop, userData: uringCloseUserData}
+	r.sqArray[idx] = idx
+	atomic.StoreUint32(r.sqTail, tail+1)
+	r.enter(1, 0, 0)
+	r.mutex.Unlock()
+}
+
+// uringFile reads a file through the ring, it implements io.ReadSeeker
+// so it can be handed to http.ServeContent.
+type uringFile struct {
+	ring *uring
+	file *os.File
+	size int64
+	off  int64
+}
+
+func (r *uring) newFile(f *os.File, size int64) io.ReadSeeker {
+	return &uringFile{ring: r, file: f, size: size}
+}
+
+func (f *uringFile) Read(p []byte) (int, error) {
+	if f.off >= f.size {
+		return 0, io.EOF
+	}
+	if rest := f.size - f.off; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := f.ring.pread(int(f.file.Fd()), p, f.off)
+	f.off += int64(n)
+	if err == nil && n == 0 {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *uringFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.off = offset
+	return offset, nil
+}
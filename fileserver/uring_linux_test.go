@@ -0,0 +1,74 @@
+//go:build linux && iouring
+
+package fileserver
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTempFile(tb testing.TB, size int) *os.File {
+	name := filepath.Join(tb.TempDir(), "data")
+	if err := os.WriteFile(name, bytes.Repeat([]byte("0123456789abcdef"), size/16), 0o644); err != nil {
+		tb.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestURingRead(t *testing.T) {
+	ring, err := newURing()
+	if err != nil {
+		t.Skipf("io_uring unavailable: %v", err)
+	}
+	defer ring.close()
+
+	f := writeTempFile(t, 1<<20)
+	want, _ := io.ReadAll(f)
+	got, err := io.ReadAll(ring.newFile(f, int64(len(want))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("content mismatch, got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func benchmarkRead(b *testing.B, open func(f *os.File, size int64) io.ReadSeeker) {
+	const size = 4 << 20
+	f := writeTempFile(b, size)
+	b.SetBytes(size)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		buf := make([]byte, 32<<10)
+		for pb.Next() {
+			r := open(f, size)
+			r.Seek(0, io.SeekStart)
+			if _, err := io.CopyBuffer(io.Discard, struct{ io.Reader }{r}, buf); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+func BenchmarkReadStandard(b *testing.B) {
+	benchmarkRead(b, func(f *os.File, size int64) io.ReadSeeker {
+		return io.NewSectionReader(f, 0, size)
+	})
+}
+
+func BenchmarkReadIOUring(b *testing.B) {
+	ring, err := newURing()
+	if err != nil {
+		b.Skipf("io_uring unavailable: %v", err)
+	}
+	defer ring.close()
+	benchmarkRead(b, ring.newFile)
+}
@@ -0,0 +1,25 @@
+//go:build !(linux && iouring)
+
+package fileserver
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// uringSupported reports whether the io_uring read path is compiled
+// in, it requires Linux and the iouring build tag.
+const uringSupported = false
+
+type uring struct{}
+
+func newURing() (*uring, error) {
+	return nil, errors.New("io_uring support is not compiled in")
+}
+
+func (r *uring) newFile(f *os.File, size int64) io.ReadSeeker {
+	return f
+}
+
+func (r *uring) close() {}
@@ -0,0 +1,154 @@
+package fileserver
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/FucAttaCk/gateway/util"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const defaultWarmUpTimeout = 10 * time.Second
+
+var errWarmUpStopped = errors.New("warm up stopped")
+
+// WarmUpSpec describes the files to read ahead in the background
+// once the filter is initialized, so the first requests don't pay for
+// cold caches. The memory and stat caches are filled too if enabled.
+type WarmUpSpec struct {
+	// Request paths to read, directories are walked recursively.
+	Paths []string
+	// Also read the most requested paths recorded by Stats
+	// during previous runs, up to this many.
+	TopPaths int
+	// Upper bound of the whole warm-up, e.g. 30s. Default: 10s.
+	Timeout string
+}
+
+func (spec *WarmUpSpec) timeout() time.Duration {
+	if spec.Timeout == "" {
+		return defaultWarmUpTimeout
+	}
+	d, err := time.ParseDuration(spec.Timeout)
+	if err != nil {
+		return defaultWarmUpTimeout
+	}
+	return d
+}
+
+// startWarmUp starts warming up the configured paths, requests are
+// served meanwhile.
+func (fsrv *FileServer) startWarmUp() {
+	spec := fsrv.spec.WarmUp
+	if spec == nil {
+		return
+	}
+	paths := spec.Paths
+	if spec.TopPaths > 0 && fsrv.stats != nil {
+		paths = append(paths[:len(paths):len(paths)], fsrv.stats.topServed(spec.TopPaths)...)
+	}
+	if len(paths) == 0 {
+		return
+	}
+	fsrv.warmUpStop = make(chan struct{})
+	fsrv.warmUpDone = make(chan struct{})
+	go func() {
+		defer close(fsrv.warmUpDone)
+		fsrv.warmUp(paths)
+	}()
+}
+
+// stopWarmUp stops the warm-up and waits for it to return, so it
+// doesn't fill caches being closed.
+func (fsrv *FileServer) stopWarmUp() {
+	if fsrv.warmUpStop == nil {
+		return
+	}
+	close(fsrv.warmUpStop)
+	<-fsrv.warmUpDone
+	fsrv.warmUpStop = nil
+}
+
+// warmUp reads paths once so their content is in the page cache, and
+// the caches of the filter, before most of the traffic arrives.
+// Hidden files are skipped and errors are only logged, warm-up is
+// best effort.
+func (fsrv *FileServer) warmUp(paths []string) {
+	spec := fsrv.spec.WarmUp
+	start := time.Now()
+	deadline := start.Add(spec.timeout())
+	var files int
+	var bytes int64
+	for _, p := range paths {
+		m, rel := fsrv.match("", fsrv.rewritePath(repl.ReplaceAll(p, "")))
+		name := util.SanitizedPathJoin(m.root, rel)
+		err := fs.WalkDir(fsrv.spec.fileSystem, name, func(filename string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			select {
+			case <-fsrv.warmUpStop:
+				return errWarmUpStopped
+			default:
+			}
+			if time.Now().After(deadline) {
+				return fs.SkipDir
+			}
+			if m.hidden(filename) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+			n, err := fsrv.readThrough(filename)
+			if err != nil {
+				logger.Debug("warm up file failed", zap.String("filename", filename), zap.Error(err))
+				return nil
+			}
+			files++
+			bytes += n
+			return nil
+		})
+		if errors.Is(err, errWarmUpStopped) {
+			logger.Info("warm up stopped", zap.Int("files", files))
+			return
+		}
+		if err != nil {
+			logger.Warn("warm up path failed", zap.String("path", p), zap.Error(err))
+		}
+		if time.Now().After(deadline) {
+			logger.Warn("warm up timed out", zap.Duration("timeout", spec.timeout()))
+			break
+		}
+	}
+
+	logger.Info("warm up finished",
+		zap.Int("files", files),
+		zap.Int64("bytes", bytes),
+		zap.Duration("elapsed", time.Since(start)))
+}
+
+// readThrough reads the whole file through the caches the requests
+// for it use.
+func (fsrv *FileServer) readThrough(filename string) (int64, error) {
+	info, err := fsrv.stat(filename)
+	if err != nil {
+		return 0, err
+	}
+	file, err := fsrv.openFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	if fsrv.memCache != nil && fsrv.memCache.cacheable(info) {
+		data, err := fsrv.memCache.load(filename, info, file)
+		return int64(len(data)), err
+	}
+	return util.Copy(io.Discard, file)
+}
@@ -0,0 +1,47 @@
+package fileserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWarmUp(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "css"), 0o755)
+	for _, name := range []string{"index.html", "css/a.css", "css/.hidden.css"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("content of "+name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spec := &Spec{
+		Root:        root,
+		fileSystem:  osFS{},
+		Hide:        []string{".hidden.css"},
+		WarmUp:      &WarmUpSpec{Paths: []string{"/index.html", "/css"}},
+		MemoryCache: &MemoryCacheSpec{},
+		StatCache:   &StatCacheSpec{},
+	}
+	fsrv := &FileServer{spec: spec}
+	fsrv.mounts = fsrv.buildMounts()
+	fsrv.memCache = newMemoryCache(spec.MemoryCache)
+	fsrv.statCache = newStatCache(spec.StatCache, spec.fileSystem)
+	defer fsrv.statCache.close()
+
+	fsrv.startWarmUp()
+	<-fsrv.warmUpDone
+	if n := fsrv.memCache.status().Files; n != 2 {
+		t.Errorf("%d files in the memory cache, want 2", n)
+	}
+	if n := fsrv.statCache.status().Entries; n != 2 {
+		t.Errorf("%d entries in the stat cache, want 2", n)
+	}
+	info, err := fsrv.stat(filepath.Join(root, "css/a.css"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fsrv.memCache.get(filepath.Join(root, "css/a.css"), info); !ok {
+		t.Error("warmed up file not served from memory")
+	}
+	fsrv.stopWarmUp()
+}
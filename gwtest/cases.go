@@ -0,0 +1,159 @@
+// Package gwtest runs declarative contract tests against a gateway.
+// A suite is a YAML file of requests and the responses expected for
+// them, it runs against a live gateway or an in-process pipeline, so
+// route owners can catch configuration regressions in CI:
+//
+//	name: static site
+//	cases:
+//	- name: index is served
+//	  request:
+//	    path: /
+//	  expect:
+//	    status: 200
+//	    headers:
+//	      Content-Type: text/html; charset=utf-8
+//	    bodyContains: [<title>Home</title>]
+package gwtest
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	// Suite is a file of cases.
+	Suite struct {
+		Name  string  `yaml:"name"`
+		Cases []*Case `yaml:"cases"`
+	}
+
+	// Case is a request and the response expected for it.
+	Case struct {
+		Name    string  `yaml:"name"`
+		Request Request `yaml:"request"`
+		Expect  Expect  `yaml:"expect"`
+	}
+
+	// Request is the request of a case.
+	Request struct {
+		// Default: GET.
+		Method string `yaml:"method"`
+		// The path and query, e.g. /search?q=a.
+		Path string `yaml:"path"`
+		// Default: the host of the target.
+		Host    string            `yaml:"host"`
+		Headers map[string]string `yaml:"headers"`
+		Body    string            `yaml:"body"`
+	}
+
+	// Expect is the response expected for a request, empty fields
+	// aren't checked.
+	Expect struct {
+		Status int `yaml:"status"`
+		// Headers with exactly these values.
+		Headers map[string]string `yaml:"headers"`
+		// Headers with values matching these regular expressions.
+		HeaderPatterns map[string]string `yaml:"headerPatterns"`
+		// Headers that must not be sent.
+		AbsentHeaders []string `yaml:"absentHeaders"`
+		// The exact body.
+		Body *string `yaml:"body"`
+		// Strings the body contains.
+		BodyContains []string `yaml:"bodyContains"`
+		// A regular expression the body matches.
+		BodyPattern string `yaml:"bodyPattern"`
+	}
+)
+
+// Load reads the suite in filename.
+func Load(filename string) (*Suite, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	suite := &Suite{}
+	if err := yaml.UnmarshalStrict(data, suite); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if suite.Name == "" {
+		suite.Name = filename
+	}
+	if err := suite.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return suite, nil
+}
+
+// Validate validates Suite.
+func (s *Suite) Validate() error {
+	for i, c := range s.Cases {
+		if c.Name == "" {
+			return fmt.Errorf("case %d: name is required", i)
+		}
+		if !strings.HasPrefix(c.Request.Path, "/") {
+			return fmt.Errorf("case %s: path %q must start with /", c.Name, c.Request.Path)
+		}
+		if c.Request.Method != "" && strings.ToUpper(c.Request.Method) != c.Request.Method {
+			return fmt.Errorf("case %s: method %q must be upper case", c.Name, c.Request.Method)
+		}
+		for name, p := range c.Expect.HeaderPatterns {
+			if _, err := regexp.Compile(p); err != nil {
+				return fmt.Errorf("case %s: invalid pattern of header %s: %v", c.Name, name, err)
+			}
+		}
+		if _, err := regexp.Compile(c.Expect.BodyPattern); err != nil {
+			return fmt.Errorf("case %s: invalid body pattern: %v", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// check returns the differences of the response from e.
+func (e *Expect) check(status int, header http.Header, body []byte) []string {
+	var failures []string
+	if e.Status != 0 && status != e.Status {
+		failures = append(failures, fmt.Sprintf("status is %d, want %d", status, e.Status))
+	}
+	for name, want := range e.Headers {
+		if got := header.Get(name); got != want {
+			failures = append(failures, fmt.Sprintf("header %s is %q, want %q", name, got, want))
+		}
+	}
+	for name, p := range e.HeaderPatterns {
+		// validated already
+		re := regexp.MustCompile(p)
+		if got := header.Get(name); !re.MatchString(got) {
+			failures = append(failures, fmt.Sprintf("header %s is %q, want a match of %s", name, got, p))
+		}
+	}
+	for _, name := range e.AbsentHeaders {
+		if _, ok := header[http.CanonicalHeaderKey(name)]; ok {
+			failures = append(failures, fmt.Sprintf("header %s is sent, want none", name))
+		}
+	}
+	if e.Body != nil && string(body) != *e.Body {
+		failures = append(failures, fmt.Sprintf("body is %q, want %q", abbreviate(body), *e.Body))
+	}
+	for _, s := range e.BodyContains {
+		if !strings.Contains(string(body), s) {
+			failures = append(failures, fmt.Sprintf("body %q doesn't contain %q", abbreviate(body), s))
+		}
+	}
+	if e.BodyPattern != "" && !regexp.MustCompile(e.BodyPattern).Match(body) {
+		failures = append(failures, fmt.Sprintf("body %q doesn't match %s", abbreviate(body), e.BodyPattern))
+	}
+	return failures
+}
+
+func abbreviate(body []byte) string {
+	const max = 200
+	if len(body) <= max {
+		return string(body)
+	}
+	return string(body[:max]) + "..."
+}
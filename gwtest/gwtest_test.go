@@ -0,0 +1,103 @@
+package gwtest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/FucAttaCk/gateway/fileserver"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	os.Exit(m.Run())
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "www")
+	os.Mkdir(root, 0o755)
+	if err := os.WriteFile(filepath.Join(root, "index.html"), []byte("<title>Home</title>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) string {
+		filename := filepath.Join(dir, name)
+		content = strings.ReplaceAll(content, "ROOT", root)
+		if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+
+	suite, err := Load(write("cases.yaml", `
+name: static site
+cases:
+- name: index is served
+  request:
+    path: /
+  expect:
+    status: 200
+    headers:
+      Content-Type: text/html; charset=utf-8
+    headerPatterns:
+      Last-Modified: GMT$
+    bodyContains: [<title>Home</title>]
+- name: missing file
+  request:
+    path: /missing.html
+  expect:
+    status: 404
+- name: wrong expectation
+  request:
+    method: HEAD
+    path: /index.html
+  expect:
+    status: 404
+    absentHeaders: [Content-Type]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(target string, results []*Result) {
+		if len(results) != 3 {
+			t.Fatalf("%s: %d results, want 3", target, len(results))
+		}
+		for _, r := range results[:2] {
+			if !r.Passed() {
+				t.Errorf("%s: %v", target, r)
+			}
+		}
+		if r := results[2]; r.Passed() || len(r.Failures) != 2 {
+			t.Errorf("%s: wrong expectation: %v", target, r)
+		}
+	}
+
+	p, err := LoadPipeline(write("pipeline.yaml", `
+name: static
+kind: HTTPPipeline
+flow:
+- filter: files
+filters:
+- name: files
+  kind: FileServer
+  root: ROOT
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	check("in process", Run(suite, "http://gateway.test", HandlerTransport(p)))
+
+	server := httptest.NewServer(p)
+	defer server.Close()
+	check("live", Run(suite, server.URL, http.DefaultTransport))
+
+	if _, err := Load(write("bad.yaml", "cases:\n- name: a\n  request:\n    path: a\n")); err == nil {
+		t.Error("relative path accepted")
+	}
+}
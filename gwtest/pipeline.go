@@ -0,0 +1,88 @@
+package gwtest
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"gopkg.in/yaml.v2"
+)
+
+// Pipeline runs filters in process, without a gateway. The filters run
+// in order until one of them returns a result, flows and jumpIfs are
+// not supported. Filters depending on the cluster don't work, and the
+// kinds of the filters must be registered, e.g. by importing their
+// packages. The gateway logger must be initialized, e.g. with
+// logger.InitNop.
+type Pipeline struct {
+	filters []httppipeline.Filter
+}
+
+// LoadPipeline creates the Pipeline of the filters of the HTTPPipeline
+// spec in filename.
+func LoadPipeline(filename string) (*Pipeline, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	spec := struct {
+		Filters []map[string]interface{} `yaml:"filters"`
+	}{}
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	p, err := NewPipeline(spec.Filters)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return p, nil
+}
+
+// NewPipeline creates a Pipeline of the filters specs, as in the
+// filters of an HTTPPipeline spec.
+func NewPipeline(specs []map[string]interface{}) (*Pipeline, error) {
+	p := &Pipeline{}
+	for i, raw := range specs {
+		spec, err := httppipeline.NewFilterSpec(raw, nil)
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("filter %d: %v", i, err)
+		}
+		root := spec.RootFilter()
+		filter := reflect.New(reflect.TypeOf(root).Elem()).Interface().(httppipeline.Filter)
+		filter.Init(spec)
+		p.filters = append(p.filters, filter)
+	}
+	return p, nil
+}
+
+// ServeHTTP serves the request with the filters.
+func (p *Pipeline) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := context.New(w, r, tracing.NoopTracing, "gwtest")
+	index := -1
+	var next func(lastResult string) string
+	next = func(lastResult string) string {
+		if lastResult != "" {
+			return lastResult
+		}
+		index++
+		if index == len(p.filters) {
+			return ""
+		}
+		return p.filters[index].Handle(ctx)
+	}
+	ctx.SetHandlerCaller(next)
+	next("")
+	ctx.Finish()
+}
+
+// Close closes the filters.
+func (p *Pipeline) Close() {
+	for _, f := range p.filters {
+		f.Close()
+	}
+}
@@ -0,0 +1,116 @@
+package gwtest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxBodySize bounds the response bodies read for checking.
+const maxBodySize = 16 << 20
+
+type (
+	// Result is the result of a case.
+	Result struct {
+		Suite    string
+		Case     string
+		Duration time.Duration
+		// How the response differs from the expected one.
+		Failures []string
+		// The request failed.
+		Err error
+	}
+
+	// handlerTransport serves requests with a handler in process.
+	handlerTransport struct {
+		handler http.Handler
+	}
+)
+
+// Passed reports whether the case passed.
+func (r *Result) Passed() bool {
+	return r.Err == nil && len(r.Failures) == 0
+}
+
+func (r *Result) String() string {
+	switch {
+	case r.Err != nil:
+		return fmt.Sprintf("FAIL %s: %s: %v", r.Suite, r.Case, r.Err)
+	case len(r.Failures) > 0:
+		return fmt.Sprintf("FAIL %s: %s:\n\t%s", r.Suite, r.Case, strings.Join(r.Failures, "\n\t"))
+	}
+	return fmt.Sprintf("ok   %s: %s (%v)", r.Suite, r.Case, r.Duration.Round(time.Millisecond))
+}
+
+// HandlerTransport returns a transport serving the requests with h,
+// e.g. a Pipeline, instead of sending them.
+func HandlerTransport(h http.Handler) http.RoundTripper {
+	return &handlerTransport{handler: h}
+}
+
+func (t *handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.handler.ServeHTTP(rec, req)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// Run runs the cases of suite against the gateway at baseURL, e.g.
+// http://localhost:10080, with transport. Redirects are not followed,
+// they are checked like any other response.
+func Run(suite *Suite, baseURL string, transport http.RoundTripper) []*Result {
+	results := make([]*Result, 0, len(suite.Cases))
+	for _, c := range suite.Cases {
+		start := time.Now()
+		r := &Result{Suite: suite.Name, Case: c.Name}
+		status, header, body, err := do(&c.Request, baseURL, transport)
+		r.Duration = time.Since(start)
+		if err != nil {
+			r.Err = err
+		} else {
+			r.Failures = c.Expect.check(status, header, body)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+func do(r *Request, baseURL string, transport http.RoundTripper) (int, http.Header, []byte, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/") + r.Path)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequest(method, u.String(), strings.NewReader(r.Body))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	for name, value := range r.Headers {
+		req.Header.Set(name, value)
+	}
+	if r.Host != "" {
+		req.Host = r.Host
+	}
+	// handlers in process see the request as if it was received,
+	// transports ignore it
+	req.RemoteAddr = "127.0.0.1:1"
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return resp.StatusCode, resp.Header, body, nil
+}
@@ -0,0 +1,224 @@
+package ipreputation
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/FucAttaCk/gateway/cryptopolicy"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	formatPlain     = "plain"
+	formatAbuseIPDB = "abuseipdb"
+
+	defaultRefreshInterval = time.Hour
+	fetchTimeout           = time.Minute
+)
+
+// feed keeps the latest ipSet loaded from one list and refreshes it
+// in the background. A failed refresh keeps the previous set.
+type feed struct {
+	spec   *ListSpec
+	client *http.Client
+	set    atomic.Value
+	// when the set was loaded, in Unix nanoseconds
+	loaded int64
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// newFeed starts the feed of spec. The set of prev, the feed of the
+// previous generation, is taken over if it loads the same list, so a
+// reload neither serves an empty list until the fetch lands nor
+// fetches remote lists before they are due.
+func newFeed(spec *ListSpec, prev *feed) *feed {
+	f := &feed{
+		spec:   spec,
+		client: cryptopolicy.NewHTTPClient(fetchTimeout),
+		done:   make(chan struct{}),
+	}
+	f.set.Store(newIPSet())
+	if prev != nil && prev.spec.sameSource(spec) {
+		f.set.Store(prev.ipSet())
+		f.loaded = atomic.LoadInt64(&prev.loaded)
+	}
+	// local files are cheap to read, load them before taking traffic
+	if spec.File != "" {
+		f.refresh()
+	}
+
+	f.wg.Add(1)
+	go f.run()
+	return f
+}
+
+func (f *feed) ipSet() *ipSet {
+	return f.set.Load().(*ipSet)
+}
+
+func (f *feed) run() {
+	defer f.wg.Done()
+
+	interval := f.spec.refreshInterval()
+	if f.spec.File == "" {
+		// a list taken over is fetched when it is due
+		due := time.Until(time.Unix(0, atomic.LoadInt64(&f.loaded)).Add(interval))
+		if due > 0 {
+			select {
+			case <-time.After(due):
+			case <-f.done:
+				return
+			}
+		}
+		f.refresh()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.refresh()
+		case <-f.done:
+			return
+		}
+	}
+}
+
+func (f *feed) refresh() {
+	set, n, err := f.load()
+	if err != nil {
+		logger.Warn("load ip reputation list failed", zap.String("list", f.spec.Name), zap.Error(err))
+		return
+	}
+	f.set.Store(set)
+	atomic.StoreInt64(&f.loaded, time.Now().UnixNano())
+	logger.Info("ip reputation list loaded", zap.String("list", f.spec.Name), zap.Int("entries", n))
+}
+
+// sameSource reports whether spec loads the same set as other.
+func (spec *ListSpec) sameSource(other *ListSpec) bool {
+	return spec.Name == other.Name && spec.File == other.File && spec.URL == other.URL &&
+		spec.Format == other.Format && spec.APIKey == other.APIKey && spec.MinConfidence == other.MinConfidence
+}
+
+func (f *feed) load() (*ipSet, int, error) {
+	var r io.ReadCloser
+	if f.spec.File != "" {
+		file, err := os.Open(f.spec.File)
+		if err != nil {
+			return nil, 0, err
+		}
+		r = file
+	} else {
+		body, err := f.fetch()
+		if err != nil {
+			return nil, 0, err
+		}
+		r = body
+	}
+	defer r.Close()
+
+	if f.spec.Format == formatAbuseIPDB {
+		return parseAbuseIPDB(r, f.spec.MinConfidence)
+	}
+	return parsePlain(r)
+}
+
+func (f *feed) fetch() (io.ReadCloser, error) {
+	u := f.spec.URL
+	if f.spec.Format == formatAbuseIPDB && f.spec.MinConfidence > 0 {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return nil, err
+		}
+		q := parsed.Query()
+		q.Set("confidenceMinimum", strconv.Itoa(f.spec.MinConfidence))
+		parsed.RawQuery = q.Encode()
+		u = parsed.String()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if f.spec.Format == formatAbuseIPDB {
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Key", f.spec.APIKey)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// parsePlain reads one IP address or CIDR prefix per line, text after
+// '#' or ';' is a comment. Malformed lines are skipped.
+func parsePlain(r io.Reader) (*ipSet, int, error) {
+	set := newIPSet()
+	n := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if set.add(fields[0]) {
+			n++
+		}
+	}
+	return set, n, scanner.Err()
+}
+
+// parseAbuseIPDB reads the JSON response of the AbuseIPDB blacklist
+// endpoint, entries below minConfidence are skipped.
+func parseAbuseIPDB(r io.Reader, minConfidence int) (*ipSet, int, error) {
+	var body struct {
+		Data []struct {
+			IPAddress            string `json:"ipAddress"`
+			AbuseConfidenceScore int    `json:"abuseConfidenceScore"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r).Decode(&body); err != nil {
+		return nil, 0, err
+	}
+
+	set := newIPSet()
+	n := 0
+	for _, d := range body.Data {
+		if d.AbuseConfidenceScore < minConfidence {
+			continue
+		}
+		if set.add(d.IPAddress) {
+			n++
+		}
+	}
+	return set, n, nil
+}
+
+func (f *feed) close() {
+	close(f.done)
+	f.wg.Wait()
+}
@@ -0,0 +1,47 @@
+package ipreputation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFeedInherit(t *testing.T) {
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Write([]byte("203.0.113.0/24\n"))
+	}))
+	defer srv.Close()
+
+	addr := netip.MustParseAddr("203.0.113.7")
+	spec := &ListSpec{Name: "remote", URL: srv.URL}
+	f := newFeed(spec, nil)
+	for deadline := time.Now().Add(5 * time.Second); !f.ipSet().contains(addr); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("list not loaded")
+		}
+	}
+	f.close()
+
+	// the next generation serves the list at once and doesn't fetch
+	// it before it is due
+	next := newFeed(&ListSpec{Name: "remote", URL: srv.URL, Action: actionTag}, f)
+	defer next.close()
+	if !next.ipSet().contains(addr) {
+		t.Error("list not taken over")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("list fetched %d times, want 1", n)
+	}
+
+	other := newFeed(&ListSpec{Name: "remote", URL: srv.URL + "/other"}, f)
+	defer other.close()
+	if other.ipSet().contains(addr) {
+		t.Error("list of another source taken over")
+	}
+}
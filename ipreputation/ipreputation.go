@@ -0,0 +1,239 @@
+package ipreputation
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/FucAttaCk/gateway/secevent"
+	"github.com/FucAttaCk/gateway/util"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of IPReputation.
+	Kind = "IPReputation"
+
+	actionBlock     = "block"
+	actionChallenge = "challenge"
+	actionTag       = "tag"
+
+	resultBlocked   = "blocked"
+	resultChallenge = "challenge"
+)
+
+var results = []string{resultBlocked, resultChallenge}
+
+func init() {
+	httppipeline.Register(&IPReputation{})
+}
+
+type (
+	// Spec is the spec of IPReputation.
+	Spec struct {
+		// Lists are consulted in order, the first list containing
+		// the client IP decides the action.
+		Lists []*ListSpec
+		// Where matches are reported.
+		SecurityEvents *secevent.Spec
+		// The proxies in front of the gateway, IPs or CIDR prefixes.
+		// The client IP is the peer address of the connection,
+		// X-Forwarded-For and X-Real-Ip are only believed from these
+		// proxies. Default: none.
+		TrustedProxies []string
+	}
+
+	// ListSpec describes one reputation or block list feed.
+	ListSpec struct {
+		Name string
+		// Exactly one of File and URL must be set.
+		File string
+		URL  string
+		// plain (one IP or CIDR per line) or abuseipdb (the JSON
+		// response of the AbuseIPDB blacklist API). Default: plain.
+		Format string
+		// The API key sent in the Key header for abuseipdb feeds.
+		APIKey string
+		// Skip abuseipdb entries with a lower confidence score.
+		MinConfidence int
+		// How often the list is reloaded, e.g. 30m. Default: 1h.
+		RefreshInterval string
+		// block rejects the request with 403, challenge returns the
+		// challenge result so the pipeline can jump to a challenge
+		// filter, tag only tags the request. Default: block.
+		Action string
+	}
+
+	// IPReputation checks the client IP against reputation feeds.
+	IPReputation struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+		feeds      []*feed
+		events     *secevent.Stream
+		proxies    []netip.Prefix
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.SecurityEvents != nil {
+		if err := spec.SecurityEvents.Validate(); err != nil {
+			return err
+		}
+	}
+	if _, err := util.ParseTrustedProxies(spec.TrustedProxies); err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, l := range spec.Lists {
+		if l.Name == "" {
+			return fmt.Errorf("list name is required")
+		}
+		if names[l.Name] {
+			return fmt.Errorf("duplicated list name %s", l.Name)
+		}
+		names[l.Name] = true
+
+		if (l.File == "") == (l.URL == "") {
+			return fmt.Errorf("list %s: exactly one of file and url is required", l.Name)
+		}
+		switch l.Format {
+		case "", formatPlain, formatAbuseIPDB:
+		default:
+			return fmt.Errorf("list %s: invalid format %s", l.Name, l.Format)
+		}
+		switch l.Action {
+		case "", actionBlock, actionChallenge, actionTag:
+		default:
+			return fmt.Errorf("list %s: invalid action %s", l.Name, l.Action)
+		}
+		if l.RefreshInterval != "" {
+			if _, err := time.ParseDuration(l.RefreshInterval); err != nil {
+				return fmt.Errorf("list %s: invalid refresh interval: %v", l.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (spec *ListSpec) refreshInterval() time.Duration {
+	d, err := time.ParseDuration(spec.RefreshInterval)
+	if err != nil || d <= 0 {
+		return defaultRefreshInterval
+	}
+	return d
+}
+
+// Kind returns the kind of IPReputation.
+func (ir *IPReputation) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of IPReputation.
+func (ir *IPReputation) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of IPReputation.
+func (ir *IPReputation) Description() string {
+	return "IPReputation blocks, challenges or tags clients listed in IP reputation feeds."
+}
+
+// Results returns the results of IPReputation.
+func (ir *IPReputation) Results() []string {
+	return results
+}
+
+// Init initializes IPReputation.
+func (ir *IPReputation) Init(filterSpec *httppipeline.FilterSpec) {
+	ir.init(filterSpec, nil)
+}
+
+// init initializes IPReputation, taking over the lists of prev, the
+// previous generation, if it is set.
+func (ir *IPReputation) init(filterSpec *httppipeline.FilterSpec, prev *IPReputation) {
+	ir.filterSpec = filterSpec
+	ir.spec = filterSpec.FilterSpec().(*Spec)
+	for _, l := range ir.spec.Lists {
+		var prevFeed *feed
+		if prev != nil {
+			for _, f := range prev.feeds {
+				if f.spec.Name == l.Name {
+					prevFeed = f
+				}
+			}
+		}
+		ir.feeds = append(ir.feeds, newFeed(l, prevFeed))
+	}
+	ir.events = secevent.New(filterSpec.Name(), ir.spec.SecurityEvents)
+	ir.proxies, _ = util.ParseTrustedProxies(ir.spec.TrustedProxies)
+}
+
+// Inherit inherits previous generation of IPReputation, the loaded
+// lists are kept until they are due for a refresh.
+func (ir *IPReputation) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	prev := previousGeneration.(*IPReputation)
+	prev.Close()
+	ir.init(filterSpec, prev)
+}
+
+// Handle handles HTTP request
+func (ir *IPReputation) Handle(ctx context.HTTPContext) string {
+	res := ir.handle(ctx)
+	return ctx.CallNextHandler(res)
+}
+
+func (ir *IPReputation) handle(ctx context.HTTPContext) string {
+	addr, err := netip.ParseAddr(util.ClientIP(ctx.Request().Std(), ir.proxies))
+	if err != nil {
+		return ""
+	}
+
+	for _, f := range ir.feeds {
+		if !f.ipSet().contains(addr) {
+			continue
+		}
+
+		ev := &secevent.Event{
+			Type:     secevent.TypeBlocklist,
+			ClientIP: addr.String(),
+			Method:   ctx.Request().Method(),
+			Path:     ctx.Request().Path(),
+			Reason:   "listed in " + f.spec.Name,
+		}
+		switch f.spec.Action {
+		case actionTag:
+			ev.Action, ev.Severity = actionTag, 2
+			ir.events.Emit(ev)
+			ctx.AddTag(fmt.Sprintf("ip reputation: %s listed in %s", addr, f.spec.Name))
+			continue
+		case actionChallenge:
+			ev.Action, ev.Severity = actionChallenge, 4
+			ir.events.Emit(ev)
+			ctx.AddTag(fmt.Sprintf("ip reputation: challenge %s listed in %s", addr, f.spec.Name))
+			return resultChallenge
+		default:
+			ev.Action, ev.Severity = actionBlock, 6
+			ir.events.Emit(ev)
+			ctx.AddTag(fmt.Sprintf("ip reputation: block %s listed in %s", addr, f.spec.Name))
+			ctx.Response().SetStatusCode(http.StatusForbidden)
+			return resultBlocked
+		}
+	}
+	return ""
+}
+
+// Status returns Status generated by Runtime.
+func (ir *IPReputation) Status() interface{} {
+	return nil
+}
+
+// Close closes IPReputation.
+func (ir *IPReputation) Close() {
+	for _, f := range ir.feeds {
+		f.close()
+	}
+	ir.events.Close()
+}
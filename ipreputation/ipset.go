@@ -0,0 +1,93 @@
+package ipreputation
+
+import (
+	"net/netip"
+	"strings"
+)
+
+type (
+	// ipSet is a set of IP prefixes stored in a binary radix trie, one
+	// trie per address family. Lookups cost at most 32 or 128 steps no
+	// matter how many prefixes the feed contains.
+	ipSet struct {
+		v4, v6 *trieNode
+	}
+
+	trieNode struct {
+		children [2]*trieNode
+		terminal bool
+	}
+)
+
+func newIPSet() *ipSet {
+	return &ipSet{v4: &trieNode{}, v6: &trieNode{}}
+}
+
+// add parses s as an IP address or a CIDR prefix and adds it to the set.
+func (s *ipSet) add(str string) bool {
+	var prefix netip.Prefix
+	if strings.Contains(str, "/") {
+		p, err := netip.ParsePrefix(str)
+		if err != nil {
+			return false
+		}
+		prefix = p.Masked()
+	} else {
+		addr, err := netip.ParseAddr(str)
+		if err != nil {
+			return false
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+
+	addr := prefix.Addr().Unmap()
+	if prefix.Addr().Is4In6() {
+		bits := prefix.Bits() - 96
+		if bits < 0 {
+			return false
+		}
+		prefix = netip.PrefixFrom(addr, bits)
+	}
+
+	node := s.v6
+	if addr.Is4() {
+		node = s.v4
+	}
+	raw := addr.AsSlice()
+	for i := 0; i < prefix.Bits(); i++ {
+		if node.terminal {
+			// a shorter prefix already covers this one
+			return true
+		}
+		bit := raw[i/8] >> (7 - i%8) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode{}
+		}
+		node = node.children[bit]
+	}
+	if !node.terminal {
+		node.terminal = true
+		node.children = [2]*trieNode{}
+	}
+	return true
+}
+
+// contains reports whether addr is covered by any prefix in the set.
+func (s *ipSet) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	node := s.v6
+	if addr.Is4() {
+		node = s.v4
+	}
+	raw := addr.AsSlice()
+	for i := 0; node != nil; i++ {
+		if node.terminal {
+			return true
+		}
+		if i == len(raw)*8 {
+			return false
+		}
+		node = node.children[raw[i/8]>>(7-i%8)&1]
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package ipreputation
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestIPSet(t *testing.T) {
+	set, n, err := parsePlain(strings.NewReader(`
+# comment
+10.0.0.0/8
+192.168.1.7 ; single address
+::ffff:172.16.0.0/108
+2001:db8::/32
+not-an-ip
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("loaded %d entries, want 4", n)
+	}
+
+	cases := map[string]bool{
+		"10.1.2.3":           true,
+		"11.0.0.1":           false,
+		"192.168.1.7":        true,
+		"192.168.1.8":        false,
+		"::ffff:10.9.9.9":    true,
+		"172.16.200.1":       true,
+		"172.32.0.1":         false,
+		"2001:db8:1::1":      true,
+		"2001:db9::1":        false,
+		"::ffff:192.168.1.7": true,
+		"fe80::1":            false,
+	}
+	for ip, want := range cases {
+		if got := set.contains(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("contains(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
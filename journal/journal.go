@@ -0,0 +1,425 @@
+package journal
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/FucAttaCk/gateway/diskwatch"
+	"github.com/FucAttaCk/gateway/keyring"
+	"github.com/FucAttaCk/gateway/util"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// Kind is the kind of WriteJournal.
+	Kind = "WriteJournal"
+
+	defaultMaxBodySize = 1 << 20
+	defaultMaxEntries  = 10000
+	defaultDedupHeader = "Idempotency-Key"
+	defaultDedupWindow = 24 * time.Hour
+	defaultMaxLag      = 5 * time.Minute
+	defaultTimeout     = 10 * time.Second
+
+	resultJournaled   = "journaled"
+	resultTooLarge    = "tooLarge"
+	resultJournalFull = "journalFull"
+	resultFailed      = "failed"
+	resultDiskFull    = "diskFull"
+)
+
+var results = []string{resultJournaled, resultTooLarge, resultJournalFull, resultFailed, resultDiskFull}
+
+// defaultDedupScope keeps the dedup keys of different routes and
+// users apart.
+var defaultDedupScope = util.RequestHasher{Method: true, Path: true, Headers: []string{"Authorization"}}
+
+func init() {
+	httppipeline.Register(&WriteJournal{})
+}
+
+type (
+	// Spec is the spec of WriteJournal.
+	Spec struct {
+		// The directory of the journal, it must not be shared with
+		// another WriteJournal.
+		Dir string
+		// The URL the journaled requests are replayed to, e.g.
+		// http://10.0.0.1:8080. The request path is appended to it.
+		Upstream string
+		// Methods to journal, the others pass through. Default:
+		// POST and PUT.
+		Methods []string
+		// Larger requests are rejected with 413. Default: 1MiB.
+		MaxBodySize int64
+		// Requests are rejected with 503 when this many are pending.
+		// Default: 10000.
+		MaxEntries int
+		// Requests with the same value of this header are journaled
+		// once. Default: Idempotency-Key.
+		DedupHeader string
+		// The values of the DedupHeader are scoped by these
+		// attributes, so clients choosing the same value don't
+		// swallow each other's requests. The body is never included.
+		// Default: the method, the path and the Authorization header.
+		DedupScope *util.RequestHasher
+		// Requests without the DedupHeader are keyed by these
+		// attributes, e.g. the path and the body, when set.
+		DedupBy *util.RequestHasher
+		// How long the key of a replayed request is remembered, e.g.
+		// 1h. Default: 24h.
+		DedupWindow string
+		// An alarm is logged when the oldest pending request is older
+		// than this, e.g. 1m. Default: 5m.
+		MaxLag string
+		// The timeout of a replayed request, e.g. 30s. Default: 10s.
+		Timeout string
+		// Requests are rejected with 507 while the disk holding Dir
+		// is below its low watermark.
+		DiskWatermarks *diskwatch.Spec
+		// Encrypt the entries with keys of this keyring, they hold
+		// the request headers and bodies. Entries written without
+		// encryption are still replayed. Entries that can't be
+		// decrypted, e.g. pending longer than the grace period of
+		// their key, are moved to the dead directory of the journal,
+		// so the grace period should outlast the upstream outages.
+		Encryption *keyring.Spec
+	}
+
+	// WriteJournal accepts write requests for a flaky upstream into a
+	// write-ahead journal, answers them with 202 and replays them in
+	// order once the upstream accepts them.
+	WriteJournal struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+		store      *store
+		replayer   *replayer
+		disk       *diskwatch.Watcher
+		methods    map[string]bool
+		// hashes the scope and the value of the dedup header
+		dedupHeader *util.RequestHasher
+
+		accepted   uint64
+		duplicates uint64
+	}
+
+	// Status is the status of WriteJournal.
+	Status struct {
+		Pending    int       `yaml:"pending"`
+		Oldest     time.Time `yaml:"oldest,omitempty"`
+		Lagging    bool      `yaml:"lagging"`
+		Accepted   uint64    `yaml:"accepted"`
+		Duplicates uint64    `yaml:"duplicates"`
+		Replayed   uint64    `yaml:"replayed"`
+		Dropped    uint64    `yaml:"dropped"`
+		Failures   uint64    `yaml:"failures"`
+		// Entries that couldn't be decrypted or decoded, they are
+		// moved to the dead directory of the journal.
+		DeadLettered uint64 `yaml:"deadLettered"`
+		// Disk is set if disk watermarks are configured.
+		Disk *diskwatch.Status `yaml:"disk,omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.Dir == "" {
+		return fmt.Errorf("dir is required")
+	}
+	u, err := url.Parse(spec.Upstream)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid upstream %q", spec.Upstream)
+	}
+	for _, d := range []struct{ name, value string }{
+		{"dedupWindow", spec.DedupWindow},
+		{"maxLag", spec.MaxLag},
+		{"timeout", spec.Timeout},
+	} {
+		if d.value == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d.value); err != nil || v <= 0 {
+			return fmt.Errorf("invalid %s %q", d.name, d.value)
+		}
+	}
+	if spec.DiskWatermarks != nil {
+		if err := spec.DiskWatermarks.Validate(); err != nil {
+			return err
+		}
+	}
+	if spec.Encryption != nil {
+		return spec.Encryption.Validate()
+	}
+	return nil
+}
+
+func parseDuration(s string, defaultValue time.Duration) time.Duration {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return defaultValue
+	}
+	return d
+}
+
+// Kind returns the kind of WriteJournal.
+func (wj *WriteJournal) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of WriteJournal.
+func (wj *WriteJournal) DefaultSpec() interface{} {
+	return &Spec{
+		Methods:     []string{http.MethodPost, http.MethodPut},
+		DedupHeader: defaultDedupHeader,
+	}
+}
+
+// Description returns the description of WriteJournal.
+func (wj *WriteJournal) Description() string {
+	return "WriteJournal journals write requests and replays them to the upstream in order."
+}
+
+// Results returns the results of WriteJournal.
+func (wj *WriteJournal) Results() []string {
+	return results
+}
+
+// Init initializes WriteJournal.
+func (wj *WriteJournal) Init(filterSpec *httppipeline.FilterSpec) {
+	wj.init(filterSpec, nil)
+}
+
+// Inherit inherits previous generation of WriteJournal. Requests of
+// the previous generation may still append, so the journal is shared
+// with it unless it moved to another directory; reopening it could
+// hand out the same sequence numbers twice.
+func (wj *WriteJournal) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	prev := previousGeneration.(*WriteJournal)
+	if prev.store == nil || prev.spec.Dir != filterSpec.FilterSpec().(*Spec).Dir {
+		prev.Close()
+		wj.init(filterSpec, nil)
+		return
+	}
+	prev.stop()
+	wj.init(filterSpec, prev.store)
+}
+
+// init initializes WriteJournal with the store s of the previous
+// generation, a nil s opens the journal.
+func (wj *WriteJournal) init(filterSpec *httppipeline.FilterSpec, s *store) {
+	wj.filterSpec = filterSpec
+	wj.spec = filterSpec.FilterSpec().(*Spec)
+	wj.methods = map[string]bool{}
+	for _, m := range wj.spec.Methods {
+		wj.methods[strings.ToUpper(m)] = true
+	}
+
+	wj.dedupHeader = newDedupHasher(wj.spec)
+
+	dedupWindow := parseDuration(wj.spec.DedupWindow, defaultDedupWindow)
+	if s != nil {
+		if err := s.reconfigure(dedupWindow, wj.spec.Encryption); err != nil {
+			// requests are answered with 500 rather than written in
+			// clear
+			logger.Error("open journal keyring failed", zap.String("dir", wj.spec.Dir), zap.Error(err))
+			return
+		}
+	} else {
+		var kr *keyring.Keyring
+		var err error
+		if wj.spec.Encryption != nil {
+			if kr, err = keyring.Open(wj.spec.Encryption); err != nil {
+				logger.Error("open journal keyring failed", zap.String("dir", wj.spec.Dir), zap.Error(err))
+				return
+			}
+		}
+		if s, err = openStore(wj.spec.Dir, dedupWindow, kr); err != nil {
+			// requests are answered with 500 rather than lost
+			logger.Error("open journal failed", zap.String("dir", wj.spec.Dir), zap.Error(err))
+			if kr != nil {
+				kr.Close()
+			}
+			return
+		}
+	}
+	wj.store = s
+	if wj.spec.DiskWatermarks != nil {
+		wj.disk = diskwatch.New(wj.spec.Dir, wj.spec.DiskWatermarks)
+	}
+	upstream, _ := url.Parse(wj.spec.Upstream)
+	wj.replayer = newReplayer(filterSpec.Name(), s, upstream,
+		parseDuration(wj.spec.Timeout, defaultTimeout), parseDuration(wj.spec.MaxLag, defaultMaxLag))
+	wj.replayer.notify()
+}
+
+// newDedupHasher returns the hasher of the scope and the value of the
+// dedup header.
+func newDedupHasher(spec *Spec) *util.RequestHasher {
+	scope := defaultDedupScope
+	if spec.DedupScope != nil {
+		scope = *spec.DedupScope
+	}
+	dedupHeader := spec.DedupHeader
+	if dedupHeader == "" {
+		dedupHeader = defaultDedupHeader
+	}
+	scope.Headers = append(append([]string(nil), scope.Headers...), dedupHeader)
+	// the key is looked up before the body is read
+	scope.Body = false
+	return &scope
+}
+
+// Handle handles HTTP request
+func (wj *WriteJournal) Handle(ctx context.HTTPContext) string {
+	res := wj.handle(ctx)
+	return ctx.CallNextHandler(res)
+}
+
+func (wj *WriteJournal) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	if !wj.methods[r.Method()] {
+		return ""
+	}
+	w := ctx.Response()
+	if wj.store == nil {
+		ctx.AddTag("journal not available")
+		w.SetStatusCode(http.StatusInternalServerError)
+		return resultFailed
+	}
+
+	dedupHeader := wj.spec.DedupHeader
+	if dedupHeader == "" {
+		dedupHeader = defaultDedupHeader
+	}
+	key := ""
+	if r.Header().Get(dedupHeader) != "" {
+		key = wj.dedupHeader.Key(r.Std(), nil)
+		if seq, ok := wj.store.lookup(key); ok {
+			return wj.duplicate(ctx, seq)
+		}
+	}
+
+	maxEntries := wj.spec.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxEntries
+	}
+	if wj.disk != nil && wj.disk.Paused() {
+		ctx.AddTag("journal: disk below low watermark")
+		w.SetStatusCode(http.StatusInsufficientStorage)
+		return resultDiskFull
+	}
+	if pending, _ := wj.store.stats(); pending >= maxEntries {
+		ctx.AddTag("journal full")
+		w.SetStatusCode(http.StatusServiceUnavailable)
+		return resultJournalFull
+	}
+
+	maxBodySize := wj.spec.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultMaxBodySize
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body(), maxBodySize+1))
+	if err != nil {
+		ctx.AddTag(fmt.Sprintf("journal: read body failed: %v", err))
+		w.SetStatusCode(http.StatusBadRequest)
+		return resultFailed
+	}
+	if int64(len(body)) > maxBodySize {
+		w.SetStatusCode(http.StatusRequestEntityTooLarge)
+		return resultTooLarge
+	}
+	if key == "" && wj.spec.DedupBy != nil {
+		digest := sha256.Sum256(body)
+		key = wj.spec.DedupBy.Key(r.Std(), digest[:])
+		if seq, ok := wj.store.lookup(key); ok {
+			return wj.duplicate(ctx, seq)
+		}
+	}
+
+	e := &entry{
+		Time:     time.Now(),
+		Method:   r.Method(),
+		Path:     r.Path(),
+		RawQuery: r.Query(),
+		Header:   r.Header().Std().Clone(),
+		Body:     body,
+		Key:      key,
+	}
+	duplicate, err := wj.store.append(e)
+	if err != nil {
+		logger.Error("append journal entry failed", zap.String("dir", wj.spec.Dir), zap.Error(err))
+		ctx.AddTag(fmt.Sprintf("journal: append failed: %v", err))
+		w.SetStatusCode(http.StatusInternalServerError)
+		return resultFailed
+	}
+	if duplicate {
+		return wj.duplicate(ctx, e.Seq)
+	}
+	atomic.AddUint64(&wj.accepted, 1)
+	wj.replayer.notify()
+
+	w.Header().Set("X-Journal-Id", strconv.FormatUint(e.Seq, 10))
+	w.SetStatusCode(http.StatusAccepted)
+	return resultJournaled
+}
+
+// duplicate answers a request journaled already as entry seq, which is
+// 0 if it is replayed already.
+func (wj *WriteJournal) duplicate(ctx context.HTTPContext, seq uint64) string {
+	atomic.AddUint64(&wj.duplicates, 1)
+	ctx.AddTag("journal: duplicate request")
+	w := ctx.Response()
+	if seq != 0 {
+		w.Header().Set("X-Journal-Id", strconv.FormatUint(seq, 10))
+	}
+	w.SetStatusCode(http.StatusAccepted)
+	return resultJournaled
+}
+
+// Status returns Status generated by Runtime.
+func (wj *WriteJournal) Status() interface{} {
+	s := &Status{
+		Accepted:   atomic.LoadUint64(&wj.accepted),
+		Duplicates: atomic.LoadUint64(&wj.duplicates),
+	}
+	if wj.store != nil {
+		s.Pending, s.Oldest = wj.store.stats()
+		s.Lagging = atomic.LoadInt32(&wj.replayer.lagging) == 1
+		s.Replayed = atomic.LoadUint64(&wj.replayer.replayed)
+		s.Dropped = atomic.LoadUint64(&wj.replayer.dropped)
+		s.Failures = atomic.LoadUint64(&wj.replayer.failures)
+		s.DeadLettered = atomic.LoadUint64(&wj.store.deadLettered)
+	}
+	if wj.disk != nil {
+		s.Disk = wj.disk.Status()
+	}
+	return s
+}
+
+// Close closes WriteJournal.
+func (wj *WriteJournal) Close() {
+	wj.stop()
+	if wj.store != nil {
+		wj.store.close()
+	}
+}
+
+// stop stops replaying, the store stays open for the next generation.
+func (wj *WriteJournal) stop() {
+	if wj.replayer != nil {
+		wj.replayer.close()
+	}
+	if wj.disk != nil {
+		wj.disk.Close()
+	}
+}
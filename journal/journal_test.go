@@ -0,0 +1,233 @@
+package journal
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/FucAttaCk/gateway/keyring"
+)
+
+func TestStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openStore(dir, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "a"} {
+		e := &entry{Time: time.Now(), Method: http.MethodPost, Path: "/orders", Key: key}
+		if _, err := s.append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, _ := s.stats(); n != 2 {
+		t.Fatalf("%d entries pending, want 2", n)
+	}
+
+	s, err = openStore(dir, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := s.first()
+	if err != nil || e.Key != "a" || e.Seq != 1 {
+		t.Fatalf("unexpected first entry %+v, %v", e, err)
+	}
+	if err := s.remove(e); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.lookup("a"); !ok {
+		t.Error("replayed key forgotten")
+	}
+	e = &entry{Time: time.Now(), Method: http.MethodPost, Path: "/orders", Key: "c"}
+	if _, err := s.append(e); err != nil || e.Seq != 3 {
+		t.Fatalf("appended seq %d, %v, want 3", e.Seq, err)
+	}
+}
+
+func TestStoreEncryption(t *testing.T) {
+	dir := t.TempDir()
+	spec := &keyring.Spec{File: filepath.Join(t.TempDir(), "keys.json")}
+	kr, err := keyring.Open(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := openStore(dir, time.Hour, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &entry{Time: time.Now(), Method: http.MethodPost, Path: "/orders",
+		Header: http.Header{"Authorization": {"Bearer secret"}}, Body: []byte("card=4111")}
+	if _, err := s.append(e); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(s.filename(e.Seq))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") || strings.Contains(string(data), "card") {
+		t.Errorf("entry written in clear: %s", data)
+	}
+
+	// a new generation may change the settings of the keyring
+	if err := s.reconfigure(time.Hour, &keyring.Spec{File: spec.File, GracePeriod: "48h"}); err != nil {
+		t.Fatal(err)
+	}
+	if e, err := s.first(); err != nil || string(e.Body) != "card=4111" {
+		t.Fatalf("entry unreadable after reconfigure: %+v, %v", e, err)
+	}
+	s.close()
+	if _, err := s.append(&entry{Time: time.Now()}); err == nil {
+		t.Error("appended to a closed journal")
+	}
+
+	kr, err = keyring.Open(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = openStore(dir, time.Hour, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err = s.first()
+	if err != nil || e.Header.Get("Authorization") != "Bearer secret" || string(e.Body) != "card=4111" {
+		t.Fatalf("unexpected first entry %+v, %v", e, err)
+	}
+	s.close()
+
+	// an entry whose key is gone is moved aside instead of failing
+	// the journal
+	other, err := keyring.Open(&keyring.Spec{File: filepath.Join(t.TempDir(), "other.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = openStore(dir, time.Hour, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	if n, _ := s.stats(); n != 0 || s.deadLettered != 1 {
+		t.Errorf("%d pending and %d dead letters, want 0 and 1", n, s.deadLettered)
+	}
+	if _, err := os.Stat(filepath.Join(dir, deadLetterDir, filepath.Base(s.filename(e.Seq)))); err != nil {
+		t.Error(err)
+	}
+	next := &entry{Time: time.Now(), Method: http.MethodPost, Path: "/orders"}
+	if _, err := s.append(next); err != nil || next.Seq <= e.Seq {
+		t.Fatalf("appended seq %d, %v, want more than %d", next.Seq, err, e.Seq)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	s, err := openStore(t.TempDir(), time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	for _, body := range []string{"1", "2"} {
+		s.append(&entry{Time: time.Now(), Method: http.MethodPut, Path: "/items", Body: []byte(body), Key: "k" + body})
+	}
+	os.WriteFile(s.filename(1), []byte("garbage"), 0o600)
+
+	// the unreadable head entry doesn't block the one behind it
+	e, err := s.first()
+	if err != nil || e == nil || string(e.Body) != "2" {
+		t.Fatalf("first entry %+v, %v, want 2", e, err)
+	}
+	if n, _ := s.stats(); n != 1 || s.deadLettered != 1 {
+		t.Errorf("%d pending and %d dead letters, want 1 and 1", n, s.deadLettered)
+	}
+	if _, ok := s.lookup("k1"); ok {
+		t.Error("dedup key of the dead letter kept")
+	}
+}
+
+func TestDedupScope(t *testing.T) {
+	h := newDedupHasher(&Spec{})
+	request := func(method, target, auth, key string) string {
+		r := httptest.NewRequest(method, target, nil)
+		r.Header.Set("Authorization", auth)
+		r.Header.Set(defaultDedupHeader, key)
+		return h.Key(r, nil)
+	}
+
+	key := request(http.MethodPost, "/orders", "Bearer alice", "1")
+	if request(http.MethodPost, "/orders?page=2", "Bearer alice", "1") != key {
+		t.Error("same request keyed differently")
+	}
+	for _, other := range []string{
+		request(http.MethodPost, "/orders", "Bearer alice", "2"),
+		request(http.MethodPost, "/orders", "Bearer bob", "1"),
+		request(http.MethodPost, "/payments", "Bearer alice", "1"),
+		request(http.MethodPut, "/orders", "Bearer alice", "1"),
+	} {
+		if other == key {
+			t.Error("requests of different scopes share a dedup key")
+		}
+	}
+}
+
+func TestReplayInOrder(t *testing.T) {
+	var mutex sync.Mutex
+	var bodies []string
+	var failing int32 = 1
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		bodies = append(bodies, r.URL.Path+" "+string(body))
+		mutex.Unlock()
+	}))
+	defer upstream.Close()
+
+	s, err := openStore(t.TempDir(), time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"1", "2", "3"} {
+		s.append(&entry{Time: time.Now(), Method: http.MethodPut, Path: "/items", Body: []byte(body)})
+	}
+	u, _ := url.Parse(upstream.URL + "/api")
+	r := newReplayer("test", s, u, time.Second, time.Hour)
+	defer r.close()
+	r.notify()
+
+	// the first attempt fails, the retry after the backoff succeeds
+	time.Sleep(100 * time.Millisecond)
+	atomic.StoreInt32(&failing, 0)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if n, _ := s.stats(); n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("journal not replayed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	want := []string{"/api/items 1", "/api/items 2", "/api/items 3"}
+	if len(bodies) != len(want) {
+		t.Fatalf("replayed %q, want %q", bodies, want)
+	}
+	for i := range want {
+		if bodies[i] != want[i] {
+			t.Fatalf("replayed %q, want %q", bodies, want)
+		}
+	}
+	if atomic.LoadUint64(&r.failures) == 0 {
+		t.Error("failure not counted")
+	}
+}
@@ -0,0 +1,204 @@
+package journal
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/FucAttaCk/gateway/cryptopolicy"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// hopHeaders are not forwarded on replay.
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// replayer sends the journaled requests to the upstream in order,
+// retrying with backoff until the upstream accepts them.
+type replayer struct {
+	store    *store
+	upstream *url.URL
+	client   *http.Client
+	maxLag   time.Duration
+	name     string
+
+	replayed uint64
+	dropped  uint64
+	failures uint64
+	lagging  int32
+
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newReplayer(name string, s *store, upstream *url.URL, timeout, maxLag time.Duration) *replayer {
+	r := &replayer{
+		store:    s,
+		upstream: upstream,
+		client:   cryptopolicy.NewHTTPClient(timeout),
+		maxLag:   maxLag,
+		name:     name,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go r.run()
+	return r
+}
+
+// notify wakes the replayer after a new entry was appended.
+func (r *replayer) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *replayer) run() {
+	defer r.wg.Done()
+
+	backoff := time.Duration(0)
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		if backoff == 0 {
+			select {
+			case <-r.wake:
+			case <-ticker.C:
+				r.store.expire()
+			case <-r.done:
+				return
+			}
+		} else {
+			select {
+			case <-time.After(backoff):
+			case <-r.done:
+				return
+			}
+		}
+
+		backoff = r.drain(backoff)
+	}
+}
+
+// drain replays entries until the journal is empty or the upstream
+// fails, it returns the backoff before the next attempt.
+func (r *replayer) drain(backoff time.Duration) time.Duration {
+	for {
+		select {
+		case <-r.done:
+			return 0
+		default:
+		}
+		r.checkLag()
+
+		e, err := r.store.first()
+		if err != nil {
+			logger.Error("read journal entry failed", zap.String("journal", r.name), zap.Error(err))
+			return nextBackoff(backoff)
+		}
+		if e == nil {
+			return 0
+		}
+
+		retry, err := r.send(e)
+		if err != nil && retry {
+			atomic.AddUint64(&r.failures, 1)
+			backoff = nextBackoff(backoff)
+			logger.Warn("replay journal entry failed, will retry",
+				zap.String("journal", r.name), zap.Uint64("seq", e.Seq),
+				zap.Duration("backoff", backoff), zap.Error(err))
+			return backoff
+		}
+		if err != nil {
+			// the upstream rejected the request itself, retrying
+			// won't help and would block the entries behind it
+			atomic.AddUint64(&r.dropped, 1)
+			logger.Error("upstream rejected journal entry, dropping it",
+				zap.String("journal", r.name), zap.Uint64("seq", e.Seq), zap.Error(err))
+		} else {
+			atomic.AddUint64(&r.replayed, 1)
+		}
+		if err := r.store.remove(e); err != nil {
+			logger.Error("remove journal entry failed", zap.String("journal", r.name), zap.Error(err))
+			return nextBackoff(backoff)
+		}
+		backoff = 0
+	}
+}
+
+// send replays e, retry tells whether a failure is worth retrying.
+func (r *replayer) send(e *entry) (retry bool, err error) {
+	u := *r.upstream
+	u.Path = strings.TrimRight(u.Path, "/") + e.Path
+	u.RawQuery = e.RawQuery
+	req, err := http.NewRequest(e.Method, u.String(), bytes.NewReader(e.Body))
+	if err != nil {
+		return false, err
+	}
+	if e.Header != nil {
+		req.Header = e.Header.Clone()
+	}
+	for _, h := range hopHeaders {
+		req.Header.Del(h)
+	}
+	req.Header.Set("X-Journal-Id", fmt.Sprint(e.Seq))
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 400:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode >= 500:
+		return true, fmt.Errorf("upstream answered %s", resp.Status)
+	}
+	return false, fmt.Errorf("upstream answered %s", resp.Status)
+}
+
+// checkLag raises the alarm when the oldest entry waits longer than
+// the max lag, and clears it when the journal catches up.
+func (r *replayer) checkLag() {
+	_, oldest := r.store.stats()
+	lagging := !oldest.IsZero() && time.Since(oldest) > r.maxLag
+	if lagging && atomic.CompareAndSwapInt32(&r.lagging, 0, 1) {
+		logger.Error("journal replay lags behind",
+			zap.String("journal", r.name), zap.Duration("maxLag", r.maxLag), zap.Time("oldest", oldest))
+	} else if !lagging && atomic.CompareAndSwapInt32(&r.lagging, 1, 0) {
+		logger.Info("journal replay caught up", zap.String("journal", r.name))
+	}
+}
+
+func nextBackoff(d time.Duration) time.Duration {
+	d *= 2
+	if d < minBackoff {
+		return minBackoff
+	}
+	if d > maxBackoff {
+		return maxBackoff
+	}
+	return d
+}
+
+func (r *replayer) close() {
+	close(r.done)
+	r.wg.Wait()
+}
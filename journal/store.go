@@ -0,0 +1,376 @@
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/FucAttaCk/gateway/keyring"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	entrySuffix = ".json"
+	// entries that can't be read are moved to this directory of the
+	// journal, e.g. when their key left the grace period of the
+	// keyring during a long outage of the upstream
+	deadLetterDir = "dead"
+)
+
+// errUnreadable is returned for entries that can't be decrypted or
+// decoded, reading them again won't help.
+var errUnreadable = errors.New("unreadable journal entry")
+
+type (
+	// entry is one accepted request, stored in a file named by its
+	// sequence number.
+	entry struct {
+		Seq      uint64      `json:"seq"`
+		Time     time.Time   `json:"time"`
+		Method   string      `json:"method"`
+		Path     string      `json:"path"`
+		RawQuery string      `json:"rawQuery,omitempty"`
+		Header   http.Header `json:"header"`
+		Body     []byte      `json:"body,omitempty"`
+		Key      string      `json:"key,omitempty"`
+	}
+
+	// store keeps the pending entries in a directory, one file per
+	// entry, so appending and removing never rewrite other entries.
+	// It outlives the generations of its WriteJournal, so requests
+	// still running in an old generation append to the same sequence.
+	store struct {
+		dir     string
+		mutex   sync.Mutex
+		closed  bool
+		pending []uint64
+		oldest  time.Time
+		next    uint64
+		// dedup keys of pending and recently replayed entries
+		keys        map[string]uint64
+		replayed    map[string]time.Time
+		dedupWindow time.Duration
+
+		// entries are sealed with the keyring if it is set, they
+		// carry credentials like Authorization and Cookie
+		keyringMutex sync.RWMutex
+		keyring      *keyring.Keyring
+
+		deadLettered uint64
+	}
+)
+
+// openStore opens the journal in dir, the store takes over kr.
+func openStore(dir string, dedupWindow time.Duration, kr *keyring.Keyring) (*store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	s := &store{
+		dir:         dir,
+		next:        1,
+		keys:        map[string]uint64{},
+		replayed:    map[string]time.Time{},
+		dedupWindow: dedupWindow,
+		keyring:     kr,
+	}
+
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range names {
+		name := d.Name()
+		if d.IsDir() || !strings.HasSuffix(name, entrySuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, entrySuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		if seq >= s.next {
+			s.next = seq + 1
+		}
+		e, err := s.read(seq)
+		if errors.Is(err, errUnreadable) {
+			s.deadLetter(seq, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if e.Key != "" {
+			s.keys[e.Key] = seq
+		}
+		if len(s.pending) == 0 || e.Time.Before(s.oldest) {
+			s.oldest = e.Time
+		}
+		s.pending = append(s.pending, seq)
+	}
+	sort.Slice(s.pending, func(i, j int) bool {
+		return s.pending[i] < s.pending[j]
+	})
+	return s, nil
+}
+
+func (s *store) filename(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, entrySuffix))
+}
+
+// lookup returns the sequence number of the entry with dedup key,
+// it is 0 if the entry is replayed already.
+func (s *store) lookup(key string) (uint64, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lookupLocked(key)
+}
+
+func (s *store) lookupLocked(key string) (uint64, bool) {
+	if seq, ok := s.keys[key]; ok {
+		return seq, true
+	}
+	if t, ok := s.replayed[key]; ok {
+		if time.Since(t) < s.dedupWindow {
+			return 0, true
+		}
+		delete(s.replayed, key)
+	}
+	return 0, false
+}
+
+// append persists e and assigns its sequence number. The entry is
+// synced to disk before append returns, so an accepted request
+// survives a crash. If an entry with the same dedup key exists,
+// nothing is appended and e gets its sequence number.
+func (s *store) append(e *entry) (duplicate bool, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return false, fmt.Errorf("journal closed")
+	}
+	if e.Key != "" {
+		if seq, ok := s.lookupLocked(e.Key); ok {
+			e.Seq = seq
+			return true, nil
+		}
+	}
+	e.Seq = s.next
+	data, err := json.Marshal(e)
+	if err != nil {
+		return false, err
+	}
+	if kr := s.currentKeyring(); kr != nil {
+		if data, err = kr.Seal(data); err != nil {
+			return false, err
+		}
+	}
+
+	name := s.filename(e.Seq)
+	tmp := name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return false, err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+
+	s.next++
+	if len(s.pending) == 0 {
+		s.oldest = e.Time
+	}
+	s.pending = append(s.pending, e.Seq)
+	if e.Key != "" {
+		s.keys[e.Key] = e.Seq
+	}
+	return false, nil
+}
+
+func (s *store) read(seq uint64) (*entry, error) {
+	data, err := os.ReadFile(s.filename(seq))
+	if err != nil {
+		return nil, err
+	}
+	if keyring.Sealed(data) {
+		kr := s.currentKeyring()
+		if kr == nil {
+			return nil, fmt.Errorf("%w %d: encrypted but no keyring is configured", errUnreadable, seq)
+		}
+		if data, err = kr.Unseal(data); err != nil {
+			return nil, fmt.Errorf("%w %d: decrypt: %v", errUnreadable, seq, err)
+		}
+	}
+	e := &entry{}
+	if err := json.Unmarshal(data, e); err != nil {
+		return nil, fmt.Errorf("%w %d: decode: %v", errUnreadable, seq, err)
+	}
+	return e, nil
+}
+
+// first returns the oldest pending entry, or nil if there is none.
+// Unreadable entries are moved to the dead letter directory on the
+// way, so they don't block the entries behind them.
+func (s *store) first() (*entry, error) {
+	for {
+		s.mutex.Lock()
+		if len(s.pending) == 0 {
+			s.mutex.Unlock()
+			return nil, nil
+		}
+		seq := s.pending[0]
+		s.mutex.Unlock()
+
+		e, err := s.read(seq)
+		if !errors.Is(err, errUnreadable) {
+			return e, err
+		}
+		s.deadLetter(seq, err)
+
+		s.mutex.Lock()
+		if len(s.pending) > 0 && s.pending[0] == seq {
+			s.pending = s.pending[1:]
+		}
+		for key, keySeq := range s.keys {
+			if keySeq == seq {
+				delete(s.keys, key)
+			}
+		}
+		s.oldest = time.Time{}
+		if len(s.pending) > 0 {
+			if next, err := s.read(s.pending[0]); err == nil {
+				s.oldest = next.Time
+			}
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// deadLetter moves the unreadable entry seq out of the journal, where
+// it can be inspected and moved back once its key is restored.
+func (s *store) deadLetter(seq uint64, cause error) {
+	atomic.AddUint64(&s.deadLettered, 1)
+	name := s.filename(seq)
+	dir := filepath.Join(s.dir, deadLetterDir)
+	err := os.MkdirAll(dir, 0o700)
+	if err == nil {
+		err = os.Rename(name, filepath.Join(dir, filepath.Base(name)))
+	}
+	if err != nil {
+		logger.Error("move unreadable journal entry failed",
+			zap.String("dir", s.dir), zap.Uint64("seq", seq), zap.NamedError("cause", cause), zap.Error(err))
+		return
+	}
+	logger.Error("moved unreadable journal entry to the dead letter directory",
+		zap.String("dir", s.dir), zap.Uint64("seq", seq), zap.Error(cause))
+}
+
+// remove removes e once it is replayed.
+func (s *store) remove(e *entry) error {
+	if err := os.Remove(s.filename(e.Seq)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.pending) > 0 && s.pending[0] == e.Seq {
+		s.pending = s.pending[1:]
+	}
+	if e.Key != "" {
+		delete(s.keys, e.Key)
+		s.replayed[e.Key] = time.Now()
+	}
+	s.oldest = time.Time{}
+	if len(s.pending) > 0 {
+		if next, err := s.read(s.pending[0]); err == nil {
+			s.oldest = next.Time
+		}
+	}
+	return nil
+}
+
+// stats returns the number of pending entries and the time the oldest
+// one was accepted.
+func (s *store) stats() (int, time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.pending), s.oldest
+}
+
+// expire forgets the dedup keys of entries replayed before the dedup
+// window.
+func (s *store) expire() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, t := range s.replayed {
+		if time.Since(t) >= s.dedupWindow {
+			delete(s.replayed, key)
+		}
+	}
+}
+
+func (s *store) currentKeyring() *keyring.Keyring {
+	s.keyringMutex.RLock()
+	defer s.keyringMutex.RUnlock()
+	return s.keyring
+}
+
+// reconfigure applies the settings of a new generation and reopens
+// the keyring, its settings may have changed. Appends wait meanwhile,
+// so no entry is written in clear. The store is closed if the keyring
+// can't be opened.
+func (s *store) reconfigure(dedupWindow time.Duration, spec *keyring.Spec) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.dedupWindow = dedupWindow
+
+	s.keyringMutex.Lock()
+	defer s.keyringMutex.Unlock()
+	if s.keyring != nil {
+		s.keyring.Close()
+		s.keyring = nil
+	}
+	if spec == nil {
+		return nil
+	}
+	kr, err := keyring.Open(spec)
+	if err != nil {
+		s.closed = true
+		return err
+	}
+	s.keyring = kr
+	return nil
+}
+
+// close closes the store, appending fails afterwards.
+func (s *store) close() {
+	s.mutex.Lock()
+	s.closed = true
+	s.mutex.Unlock()
+
+	s.keyringMutex.Lock()
+	kr := s.keyring
+	s.keyring = nil
+	s.keyringMutex.Unlock()
+	if kr != nil {
+		kr.Close()
+	}
+}
@@ -0,0 +1,331 @@
+// Package keyring keeps the versioned secret keys the filters sign and
+// encrypt with. Keyrings are shared by all filters opening the same
+// file, rotated on a schedule, and keep retired keys valid for
+// verification during a grace period.
+package keyring
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultGracePeriod = 24 * time.Hour
+	defaultKeySize     = 32
+	minKeySize         = 16
+	// Seal stores the length of the key ID in one byte
+	maxKeyIDLength = 255
+
+	checkInterval = time.Minute
+)
+
+var (
+	registryMutex sync.Mutex
+	registry      = map[string]*Keyring{}
+)
+
+type (
+	// Spec describes a keyring.
+	Spec struct {
+		// The JSON file holding the keys, it is created with a
+		// fresh key if it doesn't exist.
+		File string
+		// How often a new key is generated, e.g. 720h. Default: 0,
+		// keys are only rotated by editing the file.
+		RotationInterval string
+		// How long a retired key stays valid for verification, e.g.
+		// 48h. Default: 24h.
+		GracePeriod string
+		// The size of generated keys in bytes. Default: 32.
+		KeySize int
+	}
+
+	// Key is a version of the secret.
+	Key struct {
+		ID      string    `json:"id"`
+		Secret  []byte    `json:"secret"`
+		Created time.Time `json:"created"`
+		// Zero for the current key.
+		Retired time.Time `json:"retired,omitempty"`
+	}
+
+	// Keyring is a set of versioned keys, the newest active key is
+	// used for signing.
+	Keyring struct {
+		file     string
+		rotation time.Duration
+		grace    time.Duration
+		keySize  int
+
+		mutex   sync.RWMutex
+		keys    []*Key
+		modTime time.Time
+		refs    int
+		done    chan struct{}
+		wg      sync.WaitGroup
+	}
+
+	keyFile struct {
+		Keys []*Key `json:"keys"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.File == "" {
+		return fmt.Errorf("keyring file is required")
+	}
+	for _, d := range []string{spec.RotationInterval, spec.GracePeriod} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v < 0 {
+			return fmt.Errorf("invalid keyring duration %q", d)
+		}
+	}
+	if spec.KeySize != 0 && spec.KeySize < minKeySize {
+		return fmt.Errorf("keyring key size must be at least %d bytes", minKeySize)
+	}
+	return nil
+}
+
+// Open returns the keyring of the spec, filters opening the same file
+// share one keyring. Every Open must be paired with a Close. Opening a
+// file that is open with other settings fails, release the keyring
+// first to change them.
+func Open(spec *Spec) (*Keyring, error) {
+	file, err := filepath.Abs(spec.File)
+	if err != nil {
+		return nil, err
+	}
+
+	kr := &Keyring{
+		file:    file,
+		grace:   defaultGracePeriod,
+		keySize: spec.KeySize,
+		refs:    1,
+		done:    make(chan struct{}),
+	}
+	if spec.RotationInterval != "" {
+		kr.rotation, _ = time.ParseDuration(spec.RotationInterval)
+	}
+	if spec.GracePeriod != "" {
+		kr.grace, _ = time.ParseDuration(spec.GracePeriod)
+	}
+	if kr.keySize == 0 {
+		kr.keySize = defaultKeySize
+	}
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if open, ok := registry[file]; ok {
+		if open.rotation != kr.rotation || open.grace != kr.grace || open.keySize != kr.keySize {
+			return nil, fmt.Errorf("keyring %s is open with other settings", spec.File)
+		}
+		open.refs++
+		return open, nil
+	}
+
+	if err := kr.load(); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err := kr.rotate(time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	kr.wg.Add(1)
+	go kr.run()
+	registry[file] = kr
+	return kr, nil
+}
+
+// Current returns the key to sign with.
+func (kr *Keyring) Current() *Key {
+	kr.mutex.RLock()
+	defer kr.mutex.RUnlock()
+	for _, k := range kr.keys {
+		if k.Retired.IsZero() {
+			return k
+		}
+	}
+	return kr.keys[0]
+}
+
+// Lookup returns the key of id if it is current or retired within the
+// grace period.
+func (kr *Keyring) Lookup(id string) (*Key, bool) {
+	kr.mutex.RLock()
+	defer kr.mutex.RUnlock()
+	now := time.Now()
+	for _, k := range kr.keys {
+		if k.ID != id {
+			continue
+		}
+		if !k.Retired.IsZero() && now.Sub(k.Retired) > kr.grace {
+			return nil, false
+		}
+		return k, true
+	}
+	return nil, false
+}
+
+// Keys returns the current key followed by the retired keys still in
+// their grace period.
+func (kr *Keyring) Keys() []*Key {
+	kr.mutex.RLock()
+	defer kr.mutex.RUnlock()
+	now := time.Now()
+	keys := make([]*Key, 0, len(kr.keys))
+	for _, k := range kr.keys {
+		if k.Retired.IsZero() || now.Sub(k.Retired) <= kr.grace {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Close releases the keyring, the last Close stops its rotation.
+func (kr *Keyring) Close() {
+	registryMutex.Lock()
+	kr.refs--
+	last := kr.refs == 0
+	if last {
+		delete(registry, kr.file)
+	}
+	registryMutex.Unlock()
+
+	if last {
+		close(kr.done)
+		kr.wg.Wait()
+	}
+}
+
+func (kr *Keyring) run() {
+	defer kr.wg.Done()
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			kr.check(time.Now())
+		case <-kr.done:
+			return
+		}
+	}
+}
+
+// check picks up keys rotated by other processes and rotates the key
+// when it is due.
+func (kr *Keyring) check(now time.Time) {
+	if info, err := os.Stat(kr.file); err == nil && !info.ModTime().Equal(kr.modTime) {
+		if err := kr.load(); err != nil {
+			logger.Warn("reload keyring failed", zap.String("file", kr.file), zap.Error(err))
+		}
+	}
+	if kr.rotation <= 0 || now.Sub(kr.Current().Created) < kr.rotation {
+		return
+	}
+	if err := kr.rotate(now); err != nil {
+		logger.Warn("rotate keyring failed", zap.String("file", kr.file), zap.Error(err))
+	}
+}
+
+func (kr *Keyring) load() error {
+	data, err := os.ReadFile(kr.file)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(kr.file)
+	if err != nil {
+		return err
+	}
+	var kf keyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return fmt.Errorf("decode keyring %s: %v", kr.file, err)
+	}
+	if len(kf.Keys) == 0 {
+		return fmt.Errorf("keyring %s has no keys", kr.file)
+	}
+	for _, k := range kf.Keys {
+		if k.ID == "" || len(k.ID) > maxKeyIDLength {
+			return fmt.Errorf("keyring %s: key ids must have 1 to %d bytes", kr.file, maxKeyIDLength)
+		}
+	}
+
+	kr.mutex.Lock()
+	kr.keys = kf.Keys
+	kr.modTime = info.ModTime()
+	kr.mutex.Unlock()
+	return nil
+}
+
+// rotate retires the current key, drops the keys past their grace
+// period and saves a new current key.
+func (kr *Keyring) rotate(now time.Time) error {
+	secret := make([]byte, kr.keySize)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	key := &Key{
+		// unique even if rotated twice within a second
+		ID:      strconv.FormatInt(now.Unix(), 36) + "-" + hex.EncodeToString(suffix),
+		Secret:  secret,
+		Created: now,
+	}
+
+	kr.mutex.Lock()
+	keys := []*Key{key}
+	for _, k := range kr.keys {
+		if k.Retired.IsZero() {
+			retired := *k
+			retired.Retired = now
+			k = &retired
+		}
+		if now.Sub(k.Retired) <= kr.grace {
+			keys = append(keys, k)
+		}
+	}
+	kr.keys = keys
+	data, err := json.MarshalIndent(&keyFile{Keys: keys}, "", "  ")
+	kr.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// write a temporary file and rename it, so other processes never
+	// read a truncated keyring
+	if err := os.MkdirAll(filepath.Dir(kr.file), 0o700); err != nil {
+		return err
+	}
+	tmp := kr.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, kr.file); err != nil {
+		return err
+	}
+	if info, err := os.Stat(kr.file); err == nil {
+		kr.mutex.Lock()
+		kr.modTime = info.ModTime()
+		kr.mutex.Unlock()
+	}
+	logger.Info("keyring rotated", zap.String("file", kr.file), zap.String("key", key.ID))
+	return nil
+}
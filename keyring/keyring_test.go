@@ -0,0 +1,126 @@
+package keyring
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotation(t *testing.T) {
+	spec := &Spec{File: filepath.Join(t.TempDir(), "keys.json"), GracePeriod: "1h"}
+	kr, err := Open(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kr.Close()
+
+	if shared, err := Open(spec); err != nil || shared != kr {
+		t.Fatalf("Open() of the same file returned another keyring")
+	} else {
+		shared.Close()
+	}
+	if _, err := Open(&Spec{File: spec.File, GracePeriod: "2h"}); err == nil {
+		t.Errorf("keyring opened with conflicting settings")
+	}
+
+	first := kr.Current()
+	if len(first.Secret) != defaultKeySize {
+		t.Errorf("key size = %d, want %d", len(first.Secret), defaultKeySize)
+	}
+
+	now := first.Created.Add(time.Hour)
+	if err := kr.rotate(now); err != nil {
+		t.Fatal(err)
+	}
+	if kr.Current().ID == first.ID {
+		t.Fatalf("current key not rotated")
+	}
+	if _, ok := kr.Lookup(first.ID); !ok {
+		t.Errorf("retired key not valid within grace period")
+	}
+
+	// the first key is past its grace period at the next rotation
+	if err := kr.rotate(now.Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kr.Lookup(first.ID); ok {
+		t.Errorf("retired key valid after grace period")
+	}
+	if n := len(kr.Keys()); n != 2 {
+		t.Errorf("got %d keys, want 2", n)
+	}
+
+	// a reloaded keyring sees the same keys
+	current := kr.Current().ID
+	if err := kr.load(); err != nil {
+		t.Fatal(err)
+	}
+	if kr.Current().ID != current {
+		t.Errorf("reloaded current key = %s, want %s", kr.Current().ID, current)
+	}
+}
+
+func TestSeal(t *testing.T) {
+	kr, err := Open(&Spec{File: filepath.Join(t.TempDir(), "keys.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kr.Close()
+
+	sealed, err := kr.Seal([]byte("secret stats"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Sealed(sealed) {
+		t.Fatalf("sealed data not recognized")
+	}
+
+	// data sealed before a rotation is readable during the grace period
+	if err := kr.rotate(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := kr.Unseal(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "secret stats" {
+		t.Errorf("Unseal() = %q", data)
+	}
+
+	sealed[len(sealed)-1] ^= 1
+	if _, err := kr.Unseal(sealed); err == nil {
+		t.Errorf("tampered data unsealed")
+	}
+}
+
+func TestKeyIDLength(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.json")
+	id := strings.Repeat("k", 256)
+	keys := `{"keys":[{"id":"` + id + `","secret":"MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=","created":"2024-05-01T00:00:00Z"}]}`
+	if err := os.WriteFile(file, []byte(keys), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if kr, err := Open(&Spec{File: file}); err == nil {
+		kr.Close()
+		t.Fatalf("key id of %d bytes accepted", len(id))
+	}
+
+	keys = strings.Replace(keys, id, id[1:], 1)
+	if err := os.WriteFile(file, []byte(keys), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	kr, err := Open(&Spec{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kr.Close()
+	sealed, err := kr.Seal([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := kr.Unseal(sealed); err != nil || string(data) != "data" {
+		t.Errorf("Unseal() = %q, %v", data, err)
+	}
+}
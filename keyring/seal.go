@@ -0,0 +1,80 @@
+package keyring
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+)
+
+// sealMagic starts every sealed blob, it tells them apart from data
+// written before encryption was enabled.
+var sealMagic = []byte("GWK1")
+
+// Sealed reports whether data was produced by Seal.
+func Sealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealMagic)
+}
+
+// Seal encrypts data with AES-256-GCM and the current key. The key ID
+// is stored with the ciphertext, so data sealed before a rotation can
+// be unsealed during the grace period.
+func (kr *Keyring) Seal(data []byte) ([]byte, error) {
+	key := kr.Current()
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(sealMagic)+1+len(key.ID)+len(nonce))
+	header = append(header, sealMagic...)
+	header = append(header, byte(len(key.ID)))
+	header = append(header, key.ID...)
+	header = append(header, nonce...)
+	// the header is authenticated along with the data
+	return aead.Seal(header, nonce, data, header), nil
+}
+
+// Unseal decrypts data produced by Seal.
+func (kr *Keyring) Unseal(data []byte) ([]byte, error) {
+	if !Sealed(data) || len(data) < len(sealMagic)+1 {
+		return nil, fmt.Errorf("data is not sealed")
+	}
+	idLen := int(data[len(sealMagic)])
+	idEnd := len(sealMagic) + 1 + idLen
+	if len(data) < idEnd {
+		return nil, fmt.Errorf("truncated sealed data")
+	}
+	id := string(data[len(sealMagic)+1 : idEnd])
+	key, ok := kr.Lookup(id)
+	if !ok {
+		return nil, fmt.Errorf("unknown or expired key %s", id)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < idEnd+aead.NonceSize() {
+		return nil, fmt.Errorf("truncated sealed data")
+	}
+	header := data[:idEnd+aead.NonceSize()]
+	nonce := data[idEnd : idEnd+aead.NonceSize()]
+	return aead.Open(nil, nonce, data[len(header):], header)
+}
+
+// newAEAD derives the encryption key from the secret, so the same
+// secret used for signing never doubles as a raw AES key.
+func newAEAD(key *Key) (cipher.AEAD, error) {
+	derived := sha256.Sum256(append([]byte("gateway keyring seal\x00"), key.Secret...))
+	block, err := aes.NewCipher(derived[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,71 @@
+package logsink
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/journal"
+)
+
+type (
+	// JournaldSpec describes the local systemd journal as destination.
+	JournaldSpec struct {
+		// SYSLOG_IDENTIFIER of the entries. Default: gateway.
+		Identifier string
+	}
+
+	// Journald is a Sink writing records to the systemd journal, the
+	// fields become journal fields in upper case.
+	Journald struct {
+		identifier string
+	}
+)
+
+// NewJournald creates a Journald sink, it fails when the journal
+// socket is not available.
+func NewJournald(spec *JournaldSpec) (*Journald, error) {
+	if !journal.Enabled() {
+		return nil, fmt.Errorf("systemd journal is not available")
+	}
+	identifier := spec.Identifier
+	if identifier == "" {
+		identifier = "gateway"
+	}
+	return &Journald{identifier: identifier}, nil
+}
+
+// Write implements Sink.
+func (j *Journald) Write(rec *Record) error {
+	vars := map[string]string{
+		"SYSLOG_IDENTIFIER": j.identifier,
+	}
+	if rec.MsgID != "" {
+		vars["MESSAGE_ID_NAME"] = rec.MsgID
+	}
+	for k, v := range rec.Fields {
+		if name := journalFieldName(k); name != "" {
+			vars[name] = v
+		}
+	}
+	return journal.Send(rec.Message, journal.Priority(rec.Severity), vars)
+}
+
+// journalFieldName converts k to a valid journal field name: upper
+// case letters, digits and underscores, not starting with one.
+func journalFieldName(k string) string {
+	var sb strings.Builder
+	for _, c := range k {
+		switch {
+		case c >= 'a' && c <= 'z':
+			sb.WriteRune(c - 'a' + 'A')
+		case c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			sb.WriteRune(c)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+	return strings.TrimLeft(sb.String(), "_0123456789")
+}
+
+// Close implements Sink.
+func (j *Journald) Close() {}
@@ -0,0 +1,38 @@
+// Package logsink delivers structured log records to destinations
+// other than local files, for hosts that forbid writing logs locally.
+package logsink
+
+import (
+	"time"
+)
+
+// Syslog severities, also used as journald priorities.
+const (
+	SeverityEmergency = iota
+	SeverityAlert
+	SeverityCritical
+	SeverityError
+	SeverityWarning
+	SeverityNotice
+	SeverityInfo
+	SeverityDebug
+)
+
+type (
+	// Record is one log record.
+	Record struct {
+		Time     time.Time
+		Severity int
+		// A short identifier of the kind of record, e.g. access.
+		MsgID   string
+		Message string
+		// Structured data attached to the record.
+		Fields map[string]string
+	}
+
+	// Sink delivers records, implementations are goroutine-safe.
+	Sink interface {
+		Write(rec *Record) error
+		Close()
+	}
+)
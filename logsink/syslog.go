@@ -0,0 +1,235 @@
+package logsink
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FucAttaCk/gateway/cryptopolicy"
+)
+
+const (
+	dialTimeout = 5 * time.Second
+
+	// the private enterprise number used for structured data IDs,
+	// 32473 is reserved for documentation by RFC 5612
+	sdEnterpriseID = "32473"
+)
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+type (
+	// SyslogSpec describes a syslog receiver, messages follow RFC 5424
+	// and use octet counting framing (RFC 6587) on streams.
+	SyslogSpec struct {
+		// udp, tcp or tls. Default: udp.
+		Network string
+		// host:port of the receiver.
+		Address string
+		// The APP-NAME of the messages. Default: gateway.
+		AppName string
+		// The facility name, e.g. auth or local0. Default: the
+		// default of the log using the sink.
+		Facility string
+		// PEM file of the CA to verify the receiver with, for tls.
+		// Default: the system roots.
+		CAFile string
+		// Skip verifying the receiver certificate, for tls.
+		InsecureSkipVerify bool
+	}
+
+	// Syslog is a Sink sending records to a syslog receiver. A broken
+	// connection is dropped and dialed again on the next record.
+	Syslog struct {
+		spec     *SyslogSpec
+		facility int
+		hostname string
+		tlsConf  *tls.Config
+
+		mutex sync.Mutex
+		conn  net.Conn
+	}
+)
+
+// Validate validates SyslogSpec.
+func (spec *SyslogSpec) Validate() error {
+	switch spec.Network {
+	case "", "udp", "tcp", "tls":
+	default:
+		return fmt.Errorf("invalid syslog network %s", spec.Network)
+	}
+	if spec.Address == "" {
+		return fmt.Errorf("syslog address is required")
+	}
+	if spec.Facility != "" {
+		if _, ok := facilities[spec.Facility]; !ok {
+			return fmt.Errorf("invalid syslog facility %s", spec.Facility)
+		}
+	}
+	return cryptopolicy.CheckInsecureSkipVerify(spec.InsecureSkipVerify)
+}
+
+// NewSyslog creates a Syslog sink, defaultFacility is used when the
+// spec doesn't name one.
+func NewSyslog(spec *SyslogSpec, defaultFacility string) (*Syslog, error) {
+	facility, ok := facilities[spec.Facility]
+	if !ok {
+		facility = facilities[defaultFacility]
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	s := &Syslog{spec: spec, facility: facility, hostname: hostname}
+	if spec.Network == "tls" {
+		host, _, _ := net.SplitHostPort(spec.Address)
+		s.tlsConf = &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: spec.InsecureSkipVerify,
+		}
+		cryptopolicy.ApplyTLS(s.tlsConf)
+		if spec.CAFile != "" {
+			pem, err := os.ReadFile(spec.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificate found in %s", spec.CAFile)
+			}
+			s.tlsConf.RootCAs = pool
+		}
+	}
+	return s, nil
+}
+
+func (s *Syslog) dial() (net.Conn, error) {
+	switch s.spec.Network {
+	case "tls":
+		return tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", s.spec.Address, s.tlsConf)
+	case "tcp":
+		return net.DialTimeout("tcp", s.spec.Address, dialTimeout)
+	default:
+		return net.DialTimeout("udp", s.spec.Address, dialTimeout)
+	}
+}
+
+// Format returns rec as an RFC 5424 message.
+func (s *Syslog) Format(rec *Record) []byte {
+	appName := s.spec.AppName
+	if appName == "" {
+		appName = "gateway"
+	}
+	msgID := rec.MsgID
+	if msgID == "" {
+		msgID = "-"
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "<%d>1 %s %s %s %d %s ",
+		s.facility*8+rec.Severity,
+		rec.Time.UTC().Format(time.RFC3339Nano),
+		s.hostname, appName, os.Getpid(), msgID)
+	writeStructuredData(&msg, msgID, rec.Fields)
+	if rec.Message != "" {
+		msg.WriteByte(' ')
+		msg.WriteString(rec.Message)
+	}
+	return msg.Bytes()
+}
+
+// writeStructuredData writes fields as one SD-ELEMENT, or the nil
+// value when there are none.
+func writeStructuredData(buf *bytes.Buffer, msgID string, fields map[string]string) {
+	if len(fields) == 0 {
+		buf.WriteByte('-')
+		return
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	id := "gateway"
+	if msgID != "-" {
+		id = sdName(msgID)
+	}
+	buf.WriteString("[" + id + "@" + sdEnterpriseID)
+	for _, k := range keys {
+		buf.WriteByte(' ')
+		buf.WriteString(sdName(k))
+		buf.WriteString(`="`)
+		buf.WriteString(sdValueEscaper.Replace(fields[k]))
+		buf.WriteByte('"')
+	}
+	buf.WriteByte(']')
+}
+
+var sdValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// sdName drops the characters SD-NAME doesn't allow and truncates it
+// to 32 characters.
+func sdName(s string) string {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s) && len(b) < 32; i++ {
+		c := s[i]
+		if c > 32 && c < 127 && c != '=' && c != ']' && c != '"' && c != ' ' && c != '@' {
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
+
+// Write implements Sink.
+func (s *Syslog) Write(rec *Record) error {
+	msg := s.Format(rec)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	var err error
+	if s.spec.Network == "tcp" || s.spec.Network == "tls" {
+		_, err = s.conn.Write(append([]byte(strconv.Itoa(len(msg))+" "), msg...))
+	} else {
+		_, err = s.conn.Write(msg)
+	}
+	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+// Close implements Sink.
+func (s *Syslog) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
@@ -0,0 +1,48 @@
+package logsink
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSyslogWrite(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	s, err := NewSyslog(&SyslogSpec{Address: pc.LocalAddr().String(), AppName: "gw"}, "local0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	err = s.Write(&Record{
+		Time:     time.Date(2022, 10, 1, 8, 0, 0, 0, time.UTC),
+		Severity: SeverityWarning,
+		MsgID:    "access",
+		Message:  "GET /a 200",
+		Fields:   map[string]string{"path": `/a"]`, "status": "200"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostname, _ := os.Hostname()
+	want := fmt.Sprintf(`<132>1 2022-10-01T08:00:00Z %s gw %d access [access@32473 path="/a\"\]" status="200"] GET /a 200`,
+		hostname, os.Getpid())
+	if got := string(buf[:n]); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
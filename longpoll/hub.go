@@ -0,0 +1,128 @@
+package longpoll
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/FucAttaCk/gateway/eventbus"
+)
+
+// Topic is the event bus topic waking parked requests. The Key of a
+// message is the LongPoll filter, as "<pipeline>/<filter>", and the key
+// of the waiters separated by a colon, e.g. "api/poll:/updates/app",
+// its Data and ContentType are the response. Publishing on it is the
+// same as calling Fire but doesn't require importing this package.
+const Topic = "longpoll.wakeup"
+
+type (
+	// event is delivered to the requests parked on its key.
+	event struct {
+		data        []byte
+		contentType string
+	}
+
+	// waitKey is a key of the waiters of a filter, filters don't
+	// share keys.
+	waitKey struct {
+		filter string
+		key    string
+	}
+
+	// hub parks waiters by key until an event for the key is fired.
+	hub struct {
+		mutex   sync.Mutex
+		waiters map[waitKey]map[chan *event]struct{}
+		counts  map[string]int
+	}
+)
+
+// defaultHub is shared by all LongPoll filters, so waiters stay parked
+// across generations of a filter and any other filter can call Fire.
+var defaultHub = newHub()
+
+func init() {
+	eventbus.Get[*eventbus.Message](Topic).Subscribe(0, func(m *eventbus.Message) {
+		// object names can't contain a colon, keys can
+		filter, key, ok := strings.Cut(m.Key, ":")
+		if !ok {
+			return
+		}
+		defaultHub.fire(filter, key, &event{data: m.Data, contentType: m.ContentType})
+	})
+}
+
+func newHub() *hub {
+	return &hub{waiters: map[waitKey]map[chan *event]struct{}{}, counts: map[string]int{}}
+}
+
+// Fire wakes all requests parked on key by the LongPoll filter, named
+// "<pipeline>/<filter>", with data as the response body and returns how
+// many were woken.
+func Fire(filter, key string, data []byte, contentType string) int {
+	return defaultHub.fire(filter, key, &event{data: data, contentType: contentType})
+}
+
+// wait parks a waiter of filter on key, it returns false if max waiters
+// of the filter are parked already. cancel must be called once the
+// waiter is done.
+func (h *hub) wait(filter, key string, max int) (ch chan *event, cancel func(), ok bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if max > 0 && h.counts[filter] >= max {
+		return nil, nil, false
+	}
+
+	wk := waitKey{filter: filter, key: key}
+	ch = make(chan *event, 1)
+	set := h.waiters[wk]
+	if set == nil {
+		set = map[chan *event]struct{}{}
+		h.waiters[wk] = set
+	}
+	set[ch] = struct{}{}
+	h.counts[filter]++
+
+	cancel = func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		set := h.waiters[wk]
+		if _, ok := set[ch]; !ok {
+			// fired already
+			return
+		}
+		delete(set, ch)
+		if len(set) == 0 {
+			delete(h.waiters, wk)
+		}
+		h.release(filter, 1)
+	}
+	return ch, cancel, true
+}
+
+func (h *hub) fire(filter, key string, ev *event) int {
+	wk := waitKey{filter: filter, key: key}
+	h.mutex.Lock()
+	set := h.waiters[wk]
+	delete(h.waiters, wk)
+	h.release(filter, len(set))
+	h.mutex.Unlock()
+
+	for ch := range set {
+		ch <- ev
+	}
+	return len(set)
+}
+
+// release drops n waiters from the count of filter, h.mutex is held.
+func (h *hub) release(filter string, n int) {
+	if h.counts[filter] -= n; h.counts[filter] <= 0 {
+		delete(h.counts, filter)
+	}
+}
+
+// parked returns the number of waiters parked by filter.
+func (h *hub) parked(filter string) int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.counts[filter]
+}
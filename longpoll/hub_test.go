@@ -0,0 +1,47 @@
+package longpoll
+
+import "testing"
+
+func TestHub(t *testing.T) {
+	h := newHub()
+
+	ch1, cancel1, _ := h.wait("api/poll", "config", 0)
+	ch2, cancel2, _ := h.wait("api/poll", "config", 0)
+	_, cancel3, _ := h.wait("api/poll", "other", 0)
+	ch4, cancel4, _ := h.wait("web/poll", "config", 0)
+	defer cancel1()
+	defer cancel2()
+	defer cancel4()
+
+	if _, _, ok := h.wait("api/poll", "config", 3); ok {
+		t.Errorf("waiter parked beyond the limit")
+	}
+	// the limit is per filter
+	if _, cancel, ok := h.wait("web/poll", "config", 3); !ok {
+		t.Errorf("waiter of another filter not parked")
+	} else {
+		cancel()
+	}
+
+	if n := h.fire("api/poll", "config", &event{data: []byte("v2")}); n != 2 {
+		t.Errorf("fired %d waiters, want 2", n)
+	}
+	for _, ch := range []chan *event{ch1, ch2} {
+		if ev := <-ch; string(ev.data) != "v2" {
+			t.Errorf("unexpected event %q", ev.data)
+		}
+	}
+	select {
+	case <-ch4:
+		t.Error("waiter of another filter woken")
+	default:
+	}
+
+	cancel3()
+	if n := h.parked("api/poll"); n != 0 {
+		t.Errorf("%d waiters still parked", n)
+	}
+	if n := h.parked("web/poll"); n != 1 {
+		t.Errorf("%d waiters of the other filter parked, want 1", n)
+	}
+}
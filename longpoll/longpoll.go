@@ -0,0 +1,242 @@
+package longpoll
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of LongPoll.
+	Kind = "LongPoll"
+
+	defaultTimeout    = 30 * time.Second
+	maxTimeout        = 5 * time.Minute
+	defaultMaxWaiters = 10000
+	maxEventSize      = 64 << 10
+
+	resultTimeout            = "timeout"
+	resultTooManyWaiters     = "tooManyWaiters"
+	resultUnauthorized       = "unauthorized"
+	resultMethodNotAllowed   = "methodNotAllowed"
+	resultInvalidRequest     = "invalidRequest"
+	resultClientDisconnected = "clientDisconnected"
+)
+
+var results = []string{resultTimeout, resultTooManyWaiters, resultUnauthorized,
+	resultMethodNotAllowed, resultInvalidRequest, resultClientDisconnected}
+
+func init() {
+	httppipeline.Register(&LongPoll{})
+}
+
+type (
+	// Spec is the spec of LongPoll.
+	Spec struct {
+		// Take the key from this query parameter instead of the
+		// request path.
+		KeyQuery string
+		// How long a request is parked at most, e.g. 60s. Default:
+		// 30s, at most 5m.
+		Timeout string
+		// The maximum number of requests parked by this filter.
+		// Default: 10000.
+		MaxWaiters int
+		// A POST to this path with a key query parameter fires the
+		// key, its body becomes the response of the requests parked
+		// by this filter.
+		TriggerPath string
+		// The bearer token required by TriggerPath.
+		TriggerToken string
+	}
+
+	// LongPoll parks requests until an event for their key is fired or
+	// they time out, for cheap near-real-time update endpoints.
+	LongPoll struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+		name       string
+		timeout    time.Duration
+		maxWaiters int
+
+		clientDisconnects uint64
+	}
+
+	// Status is the status of LongPoll.
+	Status struct {
+		Parked            int    `yaml:"parked"`
+		ClientDisconnects uint64 `yaml:"clientDisconnects"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.Timeout != "" {
+		d, err := time.ParseDuration(spec.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout: %v", err)
+		}
+		if d <= 0 || d > maxTimeout {
+			return fmt.Errorf("timeout must be positive and at most %s", maxTimeout)
+		}
+	}
+	if spec.TriggerPath != "" && spec.TriggerToken == "" {
+		return fmt.Errorf("trigger token is required with a trigger path")
+	}
+	return nil
+}
+
+// Kind returns the kind of LongPoll.
+func (lp *LongPoll) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of LongPoll.
+func (lp *LongPoll) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of LongPoll.
+func (lp *LongPoll) Description() string {
+	return "LongPoll parks requests until an event for their key is fired."
+}
+
+// Results returns the results of LongPoll.
+func (lp *LongPoll) Results() []string {
+	return results
+}
+
+// Init initializes LongPoll.
+func (lp *LongPoll) Init(filterSpec *httppipeline.FilterSpec) {
+	lp.filterSpec = filterSpec
+	lp.spec = filterSpec.FilterSpec().(*Spec)
+	lp.name = filterSpec.Pipeline() + "/" + filterSpec.Name()
+	lp.timeout = defaultTimeout
+	if lp.spec.Timeout != "" {
+		lp.timeout, _ = time.ParseDuration(lp.spec.Timeout)
+	}
+	lp.maxWaiters = lp.spec.MaxWaiters
+	if lp.maxWaiters <= 0 {
+		lp.maxWaiters = defaultMaxWaiters
+	}
+}
+
+// Inherit inherits previous generation of LongPoll, parked requests
+// stay parked since the hub is shared and keyed by the filter name.
+func (lp *LongPoll) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	lp.Init(filterSpec)
+}
+
+// Handle handles HTTP request
+func (lp *LongPoll) Handle(ctx context.HTTPContext) string {
+	res := lp.handle(ctx)
+	return ctx.CallNextHandler(res)
+}
+
+func (lp *LongPoll) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	if lp.spec.TriggerPath != "" && r.Path() == lp.spec.TriggerPath {
+		return lp.trigger(ctx)
+	}
+
+	w := ctx.Response()
+	if r.Method() != http.MethodGet {
+		w.Header().Add("Allow", "GET")
+		w.SetStatusCode(http.StatusMethodNotAllowed)
+		return resultMethodNotAllowed
+	}
+
+	key := lp.key(r)
+	ch, cancel, ok := defaultHub.wait(lp.name, key, lp.maxWaiters)
+	if !ok {
+		ctx.AddTag("long poll: too many waiters")
+		w.Header().Set("Retry-After", "1")
+		w.SetStatusCode(http.StatusServiceUnavailable)
+		return resultTooManyWaiters
+	}
+	defer cancel()
+
+	timer := time.NewTimer(lp.timeout)
+	defer timer.Stop()
+	select {
+	case ev := <-ch:
+		if ev.contentType != "" {
+			w.Header().Set("Content-Type", ev.contentType)
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.SetStatusCode(http.StatusOK)
+		w.SetBody(bytes.NewReader(ev.data))
+		return ""
+	case <-timer.C:
+		w.Header().Set("Cache-Control", "no-store")
+		w.SetStatusCode(http.StatusNoContent)
+		return resultTimeout
+	case <-ctx.Done():
+		atomic.AddUint64(&lp.clientDisconnects, 1)
+		ctx.AddTag("long poll: client gone")
+		w.SetStatusCode(context.EGStatusClientClosedRequest)
+		return resultClientDisconnected
+	}
+}
+
+func (lp *LongPoll) key(r context.HTTPRequest) string {
+	if lp.spec.KeyQuery != "" {
+		q, _ := url.ParseQuery(r.Query())
+		return q.Get(lp.spec.KeyQuery)
+	}
+	return r.Path()
+}
+
+func (lp *LongPoll) trigger(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	w := ctx.Response()
+	if r.Method() != http.MethodPost {
+		w.Header().Add("Allow", "POST")
+		w.SetStatusCode(http.StatusMethodNotAllowed)
+		return resultMethodNotAllowed
+	}
+
+	token := strings.TrimPrefix(r.Header().Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(lp.spec.TriggerToken)) != 1 {
+		w.SetStatusCode(http.StatusUnauthorized)
+		return resultUnauthorized
+	}
+
+	q, _ := url.ParseQuery(r.Query())
+	key := q.Get("key")
+	if key == "" {
+		w.SetStatusCode(http.StatusBadRequest)
+		return resultInvalidRequest
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body(), maxEventSize+1))
+	if err != nil || len(data) > maxEventSize {
+		w.SetStatusCode(http.StatusRequestEntityTooLarge)
+		return resultInvalidRequest
+	}
+
+	n := Fire(lp.name, key, data, r.Header().Get("Content-Type"))
+	ctx.AddTag(fmt.Sprintf("long poll: fired %s to %d waiters", key, n))
+	w.SetStatusCode(http.StatusNoContent)
+	return ""
+}
+
+// Status returns Status generated by Runtime.
+func (lp *LongPoll) Status() interface{} {
+	return &Status{
+		Parked:            defaultHub.parked(lp.name),
+		ClientDisconnects: atomic.LoadUint64(&lp.clientDisconnects),
+	}
+}
+
+// Close closes LongPoll.
+func (lp *LongPoll) Close() {}
@@ -0,0 +1,32 @@
+package longpoll
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestClientGone(t *testing.T) {
+	lp := &LongPoll{spec: &Spec{}, timeout: time.Minute, maxWaiters: defaultMaxWaiters}
+	gone := make(chan struct{})
+	close(gone)
+
+	status := 0
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedDone = func() <-chan struct{} { return gone }
+	ctx.MockedRequest.MockedMethod = func() string { return http.MethodGet }
+	ctx.MockedRequest.MockedPath = func() string { return "/updates/gone" }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return httpheader.New(http.Header{}) }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { status = code }
+
+	if res := lp.handle(ctx); res != resultClientDisconnected || status != context.EGStatusClientClosedRequest {
+		t.Errorf("handle() = %s, %d", res, status)
+	}
+	if s := lp.Status().(*Status); s.ClientDisconnects != 1 || s.Parked != 0 {
+		t.Errorf("status %+v", s)
+	}
+}
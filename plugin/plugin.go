@@ -0,0 +1,71 @@
+// Package plugin is the stable interface for filters maintained out of
+// this repository. A filter package calls Register from its init
+// function and is compiled into the server by listing its import path
+// in cmd/server/plugins.txt and running go generate ./cmd/server, so
+// adding a filter Kind doesn't require forking the repository.
+//
+// Only the names declared here are covered by compatibility promises,
+// filters should not depend on other packages of this repository.
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+type (
+	// Filter is the interface a filter Kind implements.
+	Filter = httppipeline.Filter
+	// FilterSpec is the spec a filter gets in Init and Inherit.
+	FilterSpec = httppipeline.FilterSpec
+	// HTTPContext is the request a filter handles.
+	HTTPContext = context.HTTPContext
+
+	// Info describes a filter Kind registered through this package.
+	Info struct {
+		Kind        string `yaml:"kind"`
+		Description string `yaml:"description"`
+		Package     string `yaml:"package"`
+	}
+)
+
+var (
+	mutex   sync.Mutex
+	plugins = map[string]*Info{}
+)
+
+// Register registers filter f, it panics if the Kind of f is empty or
+// already registered, like all registration errors they are caught by
+// the first test or start of the server.
+func Register(f Filter) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := plugins[f.Kind()]; ok {
+		panic(fmt.Errorf("plugin %s registered twice", f.Kind()))
+	}
+	httppipeline.Register(f)
+	plugins[f.Kind()] = &Info{
+		Kind:        f.Kind(),
+		Description: f.Description(),
+		Package:     fmt.Sprintf("%T", f),
+	}
+}
+
+// List returns the filters registered through this package sorted by
+// Kind.
+func List() []*Info {
+	mutex.Lock()
+	defer mutex.Unlock()
+	result := make([]*Info, 0, len(plugins))
+	for _, info := range plugins {
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Kind < result[j].Kind
+	})
+	return result
+}
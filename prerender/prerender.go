@@ -0,0 +1,315 @@
+package prerender
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// Kind is the kind of Prerender.
+	Kind = "Prerender"
+
+	defaultTTL             = 24 * time.Hour
+	defaultTimeout         = 30 * time.Second
+	defaultRefreshInterval = 6 * time.Hour
+	defaultMaxPages        = 1000
+	defaultMaxRenders      = 4
+
+	resultPrerendered = "prerendered"
+)
+
+// defaultBots matches the user agents of the common search engine and
+// link preview crawlers.
+const defaultBots = `(?i)googlebot|bingbot|yandex|baiduspider|duckduckbot|slurp|` +
+	`facebookexternalhit|twitterbot|linkedinbot|slackbot|discordbot|telegrambot|whatsapp|applebot`
+
+var results = []string{resultPrerendered}
+
+func init() {
+	httppipeline.Register(&Prerender{})
+}
+
+type (
+	// Spec is the spec of Prerender.
+	Spec struct {
+		// The headless renderer service, {url} is replaced by the
+		// escaped page URL, e.g. http://renderer:3000/render?url={url}.
+		// Without {url}, a url query parameter is appended.
+		RendererURL string
+		// The public origin of the site, e.g. https://www.example.com,
+		// the page URLs given to the renderer start with it.
+		BaseURL string
+		// Globs of the routes served prerendered to bots, like the
+		// path.Match patterns, e.g. /products/*. Default: all routes
+		// without a file extension.
+		Routes []string
+		// A sitemap URL or file whose routes are rendered ahead of
+		// time and refreshed periodically.
+		Sitemap string
+		// How often the sitemap is rendered again, e.g. 1h. Default: 6h.
+		RefreshInterval string
+		// A regular expression matching the user agents of bots.
+		// Default: the major search engines and link previewers.
+		BotUserAgents string
+		// How long a rendered page is served, e.g. 1h. Default: 24h.
+		TTL string
+		// The timeout of the renderer, e.g. 10s. Default: 30s.
+		Timeout string
+		// The maximum number of cached pages. Default: 1000.
+		MaxPages int
+		// The maximum number of renders in flight, bots requesting
+		// uncached routes beyond it get the application. Default: 4.
+		MaxRenders int
+		// The query parameters that select different pages, e.g.
+		// page, the others are dropped from the rendered routes.
+		// Default: none, the query is dropped.
+		QueryParams []string
+	}
+
+	// Prerender serves the pages of a single page application rendered
+	// by a headless browser to bots, so they get indexable HTML, while
+	// humans get the normal application from the next filter.
+	Prerender struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+		bots       *regexp.Regexp
+		renderer   *renderer
+
+		hits       uint64
+		misses     uint64
+		failures   uint64
+		overloaded uint64
+
+		done chan struct{}
+		wg   sync.WaitGroup
+	}
+
+	// Status is the status of Prerender.
+	Status struct {
+		Pages    int    `yaml:"pages"`
+		Hits     uint64 `yaml:"hits"`
+		Misses   uint64 `yaml:"misses"`
+		Failures uint64 `yaml:"failures"`
+		// Requests that got the application because MaxRenders
+		// renders were in flight.
+		Overloaded uint64 `yaml:"overloaded"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	for _, u := range []struct{ name, value string }{
+		{"rendererURL", spec.RendererURL},
+		{"baseURL", spec.BaseURL},
+	} {
+		parsed, err := url.Parse(u.value)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid %s %q", u.name, u.value)
+		}
+	}
+	for _, r := range spec.Routes {
+		if _, err := path.Match(r, ""); err != nil {
+			return fmt.Errorf("invalid route %q: %v", r, err)
+		}
+	}
+	if spec.BotUserAgents != "" {
+		if _, err := regexp.Compile(spec.BotUserAgents); err != nil {
+			return fmt.Errorf("invalid bot user agents: %v", err)
+		}
+	}
+	for _, d := range []struct{ name, value string }{
+		{"refreshInterval", spec.RefreshInterval},
+		{"ttl", spec.TTL},
+		{"timeout", spec.Timeout},
+	} {
+		if d.value == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d.value); err != nil || v <= 0 {
+			return fmt.Errorf("invalid %s %q", d.name, d.value)
+		}
+	}
+	return nil
+}
+
+func parseDuration(s string, defaultValue time.Duration) time.Duration {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return defaultValue
+	}
+	return d
+}
+
+// Kind returns the kind of Prerender.
+func (pr *Prerender) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of Prerender.
+func (pr *Prerender) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of Prerender.
+func (pr *Prerender) Description() string {
+	return "Prerender serves single page application routes rendered by a headless browser to bots."
+}
+
+// Results returns the results of Prerender.
+func (pr *Prerender) Results() []string {
+	return results
+}
+
+// Init initializes Prerender.
+func (pr *Prerender) Init(filterSpec *httppipeline.FilterSpec) {
+	pr.filterSpec = filterSpec
+	pr.spec = filterSpec.FilterSpec().(*Spec)
+	bots := pr.spec.BotUserAgents
+	if bots == "" {
+		bots = defaultBots
+	}
+	pr.bots = regexp.MustCompile(bots)
+	pr.renderer = newRenderer(pr.spec)
+	pr.done = make(chan struct{})
+	if pr.spec.Sitemap != "" {
+		pr.wg.Add(1)
+		go pr.run()
+	}
+}
+
+// Inherit inherits previous generation of Prerender.
+func (pr *Prerender) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	pr.Init(filterSpec)
+}
+
+// Handle handles HTTP request
+func (pr *Prerender) Handle(ctx context.HTTPContext) string {
+	res := pr.handle(ctx)
+	return ctx.CallNextHandler(res)
+}
+
+func (pr *Prerender) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	if r.Method() != http.MethodGet && r.Method() != http.MethodHead {
+		return ""
+	}
+	if !pr.bots.MatchString(r.Header().Get("User-Agent")) || !pr.matchRoute(r.Path()) {
+		return ""
+	}
+
+	route := pr.renderer.route(r.Std().URL.EscapedPath(), r.Query())
+	p, ok := pr.renderer.get(route)
+	if ok {
+		atomic.AddUint64(&pr.hits, 1)
+	} else {
+		atomic.AddUint64(&pr.misses, 1)
+		var err error
+		p, err = pr.renderer.render(route, false)
+		if err == errOverloaded {
+			atomic.AddUint64(&pr.overloaded, 1)
+			ctx.AddTag("prerender: too many renders in flight")
+			return ""
+		}
+		if err != nil {
+			// the bot gets the application, like a human
+			atomic.AddUint64(&pr.failures, 1)
+			ctx.AddTag(fmt.Sprintf("prerender failed: %v", err))
+			return ""
+		}
+	}
+
+	w := ctx.Response()
+	w.Header().Set("Content-Type", p.contentType)
+	w.Header().Add("Vary", "User-Agent")
+	w.SetStatusCode(p.status)
+	if r.Method() == http.MethodGet {
+		w.SetBody(bytes.NewReader(p.body))
+	}
+	ctx.AddTag("prerendered")
+	return resultPrerendered
+}
+
+// matchRoute reports whether p is a route to prerender, static assets
+// are never prerendered by default.
+func (pr *Prerender) matchRoute(p string) bool {
+	if len(pr.spec.Routes) == 0 {
+		return path.Ext(p) == "" || strings.HasSuffix(p, ".html")
+	}
+	for _, r := range pr.spec.Routes {
+		if matched, _ := path.Match(r, p); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// run renders the routes of the sitemap ahead of time.
+func (pr *Prerender) run() {
+	defer pr.wg.Done()
+
+	ticker := time.NewTicker(parseDuration(pr.spec.RefreshInterval, defaultRefreshInterval))
+	defer ticker.Stop()
+	for {
+		pr.refresh()
+		select {
+		case <-ticker.C:
+		case <-pr.done:
+			return
+		}
+	}
+}
+
+func (pr *Prerender) refresh() {
+	routes, err := pr.renderer.sitemapRoutes()
+	if err != nil {
+		logger.Warn("load sitemap failed", zap.String("sitemap", pr.spec.Sitemap), zap.Error(err))
+		return
+	}
+	var rendered, failed int
+	for _, route := range routes {
+		select {
+		case <-pr.done:
+			return
+		default:
+		}
+		if _, err := pr.renderer.render(route, true); err != nil {
+			failed++
+			logger.Debug("prerender route failed", zap.String("route", route), zap.Error(err))
+			continue
+		}
+		rendered++
+	}
+	logger.Info("prerendered sitemap",
+		zap.String("sitemap", pr.spec.Sitemap), zap.Int("rendered", rendered), zap.Int("failed", failed))
+}
+
+// Status returns Status generated by Runtime.
+func (pr *Prerender) Status() interface{} {
+	return &Status{
+		Pages:      pr.renderer.pages.Len(),
+		Hits:       atomic.LoadUint64(&pr.hits),
+		Misses:     atomic.LoadUint64(&pr.misses),
+		Failures:   atomic.LoadUint64(&pr.failures),
+		Overloaded: atomic.LoadUint64(&pr.overloaded),
+	}
+}
+
+// Close closes Prerender.
+func (pr *Prerender) Close() {
+	close(pr.done)
+	pr.wg.Wait()
+}
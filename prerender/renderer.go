@@ -0,0 +1,291 @@
+package prerender
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FucAttaCk/gateway/cryptopolicy"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	maxPageSize    = 8 << 20
+	maxSitemapSize = 50 << 20
+)
+
+// errOverloaded is returned when the renders in flight reach
+// MaxRenders, the request gets the application instead.
+var errOverloaded = errors.New("too many renders in flight")
+
+type (
+	// page is a rendered route.
+	page struct {
+		status      int
+		contentType string
+		body        []byte
+		expires     time.Time
+	}
+
+	// renderer fetches routes through the external renderer and
+	// caches the pages.
+	renderer struct {
+		spec     *Spec
+		client   *http.Client
+		pages    *lru.Cache
+		ttl      time.Duration
+		baseURL  *url.URL
+		template string
+		// the query parameters kept in the routes
+		queryParams map[string]bool
+
+		// bounds the renders in flight
+		slots    chan struct{}
+		mu       sync.Mutex
+		inflight map[string]*rendering
+	}
+
+	// rendering is a render in progress, requests for the same route
+	// wait for it instead of rendering again.
+	rendering struct {
+		done chan struct{}
+		page *page
+		err  error
+	}
+
+	urlSet struct {
+		URLs     []location `xml:"url"`
+		Sitemaps []location `xml:"sitemap"`
+	}
+
+	location struct {
+		Loc string `xml:"loc"`
+	}
+)
+
+func newRenderer(spec *Spec) *renderer {
+	r := &renderer{
+		spec:     spec,
+		client:   cryptopolicy.NewHTTPClient(parseDuration(spec.Timeout, defaultTimeout)),
+		ttl:      parseDuration(spec.TTL, defaultTTL),
+		template: spec.RendererURL,
+		inflight: make(map[string]*rendering),
+	}
+	r.baseURL, _ = url.Parse(spec.BaseURL)
+	size := spec.MaxPages
+	if size <= 0 {
+		size = defaultMaxPages
+	}
+	r.pages, _ = lru.New(size)
+	maxRenders := spec.MaxRenders
+	if maxRenders <= 0 {
+		maxRenders = defaultMaxRenders
+	}
+	r.slots = make(chan struct{}, maxRenders)
+	r.queryParams = make(map[string]bool, len(spec.QueryParams))
+	for _, name := range spec.QueryParams {
+		r.queryParams[name] = true
+	}
+	return r
+}
+
+// route returns the route of the escaped path p and the raw query q,
+// which keeps the QueryParams only, sorted, so random parameters don't
+// make new pages.
+func (r *renderer) route(p, q string) string {
+	if p == "" {
+		p = "/"
+	}
+	if q == "" || len(r.queryParams) == 0 {
+		return p
+	}
+	values, err := url.ParseQuery(q)
+	if err != nil {
+		return p
+	}
+	for name := range values {
+		if !r.queryParams[name] {
+			delete(values, name)
+		}
+	}
+	if len(values) == 0 {
+		return p
+	}
+	return p + "?" + values.Encode()
+}
+
+// rendererURL returns the URL asking the renderer for route, route is
+// a path with an optional query.
+func (r *renderer) rendererURL(route string) string {
+	target := strings.TrimRight(r.baseURL.String(), "/") + route
+	if strings.Contains(r.template, "{url}") {
+		return strings.ReplaceAll(r.template, "{url}", url.QueryEscape(target))
+	}
+	sep := "?"
+	if strings.Contains(r.template, "?") {
+		sep = "&"
+	}
+	return r.template + sep + "url=" + url.QueryEscape(target)
+}
+
+// get returns the cached page of route if it didn't expire yet.
+func (r *renderer) get(route string) (*page, bool) {
+	v, ok := r.pages.Get(route)
+	if !ok {
+		return nil, false
+	}
+	p := v.(*page)
+	if time.Now().After(p.expires) {
+		r.pages.Remove(route)
+		return nil, false
+	}
+	return p, true
+}
+
+// render renders route, concurrent renders of the same route share
+// one request to the renderer. When wait is false, it fails with
+// errOverloaded instead of waiting for one of the MaxRenders slots.
+func (r *renderer) render(route string, wait bool) (*page, error) {
+	r.mu.Lock()
+	rd, ok := r.inflight[route]
+	if !ok {
+		if wait {
+			r.mu.Unlock()
+			r.slots <- struct{}{}
+			r.mu.Lock()
+			rd, ok = r.inflight[route]
+			if ok {
+				<-r.slots
+			}
+		} else {
+			select {
+			case r.slots <- struct{}{}:
+			default:
+				r.mu.Unlock()
+				return nil, errOverloaded
+			}
+		}
+	}
+	if !ok {
+		rd = &rendering{done: make(chan struct{})}
+		r.inflight[route] = rd
+		r.mu.Unlock()
+
+		rd.page, rd.err = r.fetch(route)
+		r.mu.Lock()
+		delete(r.inflight, route)
+		r.mu.Unlock()
+		<-r.slots
+		close(rd.done)
+		return rd.page, rd.err
+	}
+	r.mu.Unlock()
+	<-rd.done
+	return rd.page, rd.err
+}
+
+// fetch fetches route from the renderer and caches the page.
+func (r *renderer) fetch(route string) (*page, error) {
+	resp, err := r.client.Get(r.rendererURL(route))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// only successes and not-founds are worth serving to crawlers
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return nil, fmt.Errorf("renderer answered %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxPageSize {
+		return nil, fmt.Errorf("rendered page exceeds %d bytes", maxPageSize)
+	}
+	p := &page{
+		status:      resp.StatusCode,
+		contentType: resp.Header.Get("Content-Type"),
+		body:        body,
+		expires:     time.Now().Add(r.ttl),
+	}
+	if p.contentType == "" {
+		p.contentType = "text/html; charset=utf-8"
+	}
+	r.pages.Add(route, p)
+	return p, nil
+}
+
+// sitemapRoutes returns the routes listed by the sitemap, which is a
+// URL or a local file. Sitemap indexes are followed one level deep.
+func (r *renderer) sitemapRoutes() ([]string, error) {
+	set, err := r.loadSitemap(r.spec.Sitemap)
+	if err != nil {
+		return nil, err
+	}
+	locs := set.URLs
+	for _, s := range set.Sitemaps {
+		loc := strings.TrimSpace(s.Loc)
+		if !isHTTP(loc) {
+			// a remote index must not make us read local files
+			logger.Warn("skip sitemap that is no http url", zap.String("sitemap", r.spec.Sitemap), zap.String("loc", loc))
+			continue
+		}
+		child, err := r.loadSitemap(loc)
+		if err != nil {
+			return nil, err
+		}
+		locs = append(locs, child.URLs...)
+	}
+
+	routes := make([]string, 0, len(locs))
+	for _, l := range locs {
+		u, err := url.Parse(strings.TrimSpace(l.Loc))
+		if err != nil {
+			continue
+		}
+		routes = append(routes, r.route(u.EscapedPath(), u.RawQuery))
+	}
+	return routes, nil
+}
+
+func (r *renderer) loadSitemap(location string) (*urlSet, error) {
+	var body io.Reader
+	if isHTTP(location) {
+		resp, err := r.client.Get(location)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetch sitemap %s: %s", location, resp.Status)
+		}
+		body = resp.Body
+	} else {
+		f, err := os.Open(location)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		body = f
+	}
+
+	set := &urlSet{}
+	if err := xml.NewDecoder(io.LimitReader(body, maxSitemapSize)).Decode(set); err != nil {
+		return nil, fmt.Errorf("decode sitemap %s: %v", location, err)
+	}
+	return set, nil
+}
+
+func isHTTP(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
@@ -0,0 +1,130 @@
+package prerender
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRenderer(t *testing.T) {
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Query().Get("url"))
+		w.Write([]byte("<html>rendered</html>"))
+	}))
+	defer srv.Close()
+
+	sitemap := filepath.Join(t.TempDir(), "sitemap.xml")
+	os.WriteFile(sitemap, []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://www.example.com/</loc></url>
+  <url><loc>https://www.example.com/products/42?utm_source=x&amp;color=red</loc></url>
+</urlset>`), 0o644)
+
+	r := newRenderer(&Spec{
+		RendererURL: srv.URL + "/render?url={url}",
+		BaseURL:     "https://www.example.com",
+		Sitemap:     sitemap,
+		QueryParams: []string{"color"},
+	})
+	routes, err := r.sitemapRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 || routes[0] != "/" || routes[1] != "/products/42?color=red" {
+		t.Fatalf("unexpected routes %q", routes)
+	}
+
+	if _, ok := r.get(routes[1]); ok {
+		t.Fatal("unexpected cached page")
+	}
+	if _, err := r.render(routes[1], false); err != nil {
+		t.Fatal(err)
+	}
+	p, ok := r.get(routes[1])
+	if !ok || string(p.body) != "<html>rendered</html>" || p.contentType == "" {
+		t.Fatalf("unexpected page %+v", p)
+	}
+	if requested[0] != "https://www.example.com/products/42?color=red" {
+		t.Errorf("renderer asked for %q", requested[0])
+	}
+}
+
+func TestRenderLimits(t *testing.T) {
+	var renders int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&renders, 1)
+		<-release
+		w.Write([]byte("<html>rendered</html>"))
+	}))
+	defer srv.Close()
+
+	r := newRenderer(&Spec{
+		RendererURL: srv.URL + "/render",
+		BaseURL:     "https://www.example.com",
+		MaxRenders:  1,
+	})
+	if route := r.route("/products", "x=1"); route != "/products" {
+		t.Errorf("random query kept in route %q", route)
+	}
+
+	var wg sync.WaitGroup
+	render := func() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.render("/a", false); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	render()
+	for atomic.LoadInt32(&renders) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// requests for the same route wait for the render in flight
+	render()
+	render()
+	if _, err := r.render("/b", false); err != errOverloaded {
+		t.Errorf("render beyond the limit: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&renders); n != 1 {
+		t.Errorf("%d renders of one route, want 1", n)
+	}
+	if _, err := r.render("/b", false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSitemapIndex(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "secret.xml")
+	os.WriteFile(local, []byte(`<urlset><url><loc>https://www.example.com/secret</loc></url></urlset>`), 0o644)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/child.xml" {
+			w.Write([]byte(`<urlset><url><loc>https://www.example.com/public</loc></url></urlset>`))
+			return
+		}
+		w.Write([]byte(`<sitemapindex><sitemap><loc>` + local + `</loc></sitemap>` +
+			`<sitemap><loc>file://` + local + `</loc></sitemap>` +
+			`<sitemap><loc>http://` + r.Host + `/child.xml</loc></sitemap></sitemapindex>`))
+	}))
+	defer srv.Close()
+
+	r := newRenderer(&Spec{RendererURL: srv.URL, BaseURL: "https://www.example.com", Sitemap: srv.URL + "/sitemap.xml"})
+	routes, err := r.sitemapRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 1 || routes[0] != "/public" {
+		t.Errorf("routes %q, want /public only", routes)
+	}
+}
@@ -0,0 +1,209 @@
+package presign
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of PresignRedirect.
+	Kind = "PresignRedirect"
+
+	defaultExpires = 5 * time.Minute
+	maxExpires     = 7 * 24 * time.Hour
+
+	resultMethodNotAllowed = "methodNotAllowed"
+	resultNotFound         = "notFound"
+)
+
+var results = []string{resultMethodNotAllowed, resultNotFound}
+
+func init() {
+	httppipeline.Register(&PresignRedirect{})
+}
+
+type (
+	// Spec is the spec of PresignRedirect.
+	Spec struct {
+		// The storage endpoint, e.g. https://s3.eu-west-1.amazonaws.com
+		// or https://storage.googleapis.com.
+		Endpoint string
+		// Default: us-east-1, use auto for GCS.
+		Region string
+		Bucket string
+		// Address the bucket as a subdomain of the endpoint instead
+		// of the first path segment.
+		VirtualHosted bool
+		AccessKeyID   string
+		// The secret key, or the name of the environment variable
+		// holding it when it starts with $.
+		SecretAccessKey string
+		// How long the URL is valid, e.g. 1m. Default: 5m, at most 7 days.
+		Expires string
+		// Removed from the request path before it becomes the key.
+		StripPathPrefix string
+		// Prepended to the key, e.g. downloads/.
+		KeyPrefix string
+		// 302 or 307. Default: 302.
+		StatusCode int
+	}
+
+	// PresignRedirect redirects clients to a short-lived presigned URL
+	// of the requested object, so large objects are downloaded from the
+	// storage directly while authentication stays at the gateway.
+	PresignRedirect struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+		signer     *signer
+		expires    time.Duration
+		statusCode int
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	u, err := url.Parse(spec.Endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid endpoint %q", spec.Endpoint)
+	}
+	if spec.Bucket == "" {
+		return fmt.Errorf("bucket is required")
+	}
+	if spec.AccessKeyID == "" || spec.SecretAccessKey == "" {
+		return fmt.Errorf("access key id and secret access key are required")
+	}
+	if spec.Expires != "" {
+		d, err := time.ParseDuration(spec.Expires)
+		if err != nil {
+			return fmt.Errorf("invalid expires: %v", err)
+		}
+		if d < time.Second || d > maxExpires {
+			return fmt.Errorf("expires must be between 1s and %s", maxExpires)
+		}
+	}
+	switch spec.StatusCode {
+	case 0, http.StatusFound, http.StatusTemporaryRedirect:
+	default:
+		return fmt.Errorf("invalid status code %d", spec.StatusCode)
+	}
+	return nil
+}
+
+// Kind returns the kind of PresignRedirect.
+func (pr *PresignRedirect) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of PresignRedirect.
+func (pr *PresignRedirect) DefaultSpec() interface{} {
+	return &Spec{Region: "us-east-1"}
+}
+
+// Description returns the description of PresignRedirect.
+func (pr *PresignRedirect) Description() string {
+	return "PresignRedirect redirects clients to presigned URLs of S3 compatible object storage."
+}
+
+// Results returns the results of PresignRedirect.
+func (pr *PresignRedirect) Results() []string {
+	return results
+}
+
+// Init initializes PresignRedirect.
+func (pr *PresignRedirect) Init(filterSpec *httppipeline.FilterSpec) {
+	pr.filterSpec = filterSpec
+	pr.spec = filterSpec.FilterSpec().(*Spec)
+
+	u, _ := url.Parse(pr.spec.Endpoint)
+	secret := pr.spec.SecretAccessKey
+	if strings.HasPrefix(secret, "$") {
+		secret = os.Getenv(secret[1:])
+	}
+	pr.signer = &signer{
+		scheme:        u.Scheme,
+		host:          u.Host,
+		region:        pr.spec.Region,
+		bucket:        pr.spec.Bucket,
+		virtualHosted: pr.spec.VirtualHosted,
+		accessKeyID:   pr.spec.AccessKeyID,
+		secretKey:     secret,
+	}
+	pr.expires = defaultExpires
+	if pr.spec.Expires != "" {
+		pr.expires, _ = time.ParseDuration(pr.spec.Expires)
+	}
+	pr.statusCode = pr.spec.StatusCode
+	if pr.statusCode == 0 {
+		pr.statusCode = http.StatusFound
+	}
+}
+
+// Inherit inherits previous generation of PresignRedirect.
+func (pr *PresignRedirect) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	pr.Init(filterSpec)
+}
+
+// Handle handles HTTP request
+func (pr *PresignRedirect) Handle(ctx context.HTTPContext) string {
+	res := pr.handle(ctx)
+	return ctx.CallNextHandler(res)
+}
+
+func (pr *PresignRedirect) handle(ctx context.HTTPContext) string {
+	w := ctx.Response()
+	method := ctx.Request().Method()
+	if method != http.MethodGet && method != http.MethodHead {
+		w.Header().Add("Allow", "GET, HEAD")
+		w.SetStatusCode(http.StatusMethodNotAllowed)
+		return resultMethodNotAllowed
+	}
+
+	name := strings.TrimPrefix(ctx.Request().Path(), pr.spec.StripPathPrefix)
+	name = strings.TrimPrefix(name, "/")
+	if !validName(name) {
+		// never presign the bucket listing, a "directory" or a key
+		// the storage may resolve outside of KeyPrefix
+		w.SetStatusCode(http.StatusNotFound)
+		return resultNotFound
+	}
+	key := pr.spec.KeyPrefix + name
+	location := pr.signer.presign(method, key, time.Now(), pr.expires)
+
+	w.Header().Set("Location", location)
+	// the URL expires, it must not outlive it in caches
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.SetStatusCode(pr.statusCode)
+	ctx.AddTag("presigned redirect to " + pr.spec.Bucket + "/" + key)
+	return ""
+}
+
+// validName reports whether name, the requested part of a key, names
+// an object: it is not empty, doesn't end with a slash and has no ..
+// segments.
+func validName(name string) bool {
+	if name == "" || strings.HasSuffix(name, "/") {
+		return false
+	}
+	for _, seg := range strings.Split(name, "/") {
+		if seg == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// Status returns Status generated by Runtime.
+func (pr *PresignRedirect) Status() interface{} {
+	return nil
+}
+
+// Close closes PresignRedirect.
+func (pr *PresignRedirect) Close() {}
@@ -0,0 +1,21 @@
+package presign
+
+import "testing"
+
+func TestValidName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"test.txt":        true,
+		"a/b/c.bin":       true,
+		"a..b":            true,
+		"":                false,
+		"dir/":            false,
+		"../secret":       false,
+		"a/../../b":       false,
+		"a/..":            false,
+		"downloads//x.gz": true,
+	} {
+		if got := validName(name); got != want {
+			t.Errorf("validName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
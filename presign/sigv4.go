@@ -0,0 +1,110 @@
+package presign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat  = "20060102T150405Z"
+)
+
+// signer builds presigned GET URLs with AWS Signature Version 4, which
+// S3 and the S3 compatible XML API of GCS accept.
+type signer struct {
+	scheme        string
+	host          string
+	region        string
+	bucket        string
+	virtualHosted bool
+	accessKeyID   string
+	secretKey     string
+}
+
+// presign returns the URL of key valid for expires from now.
+func (s *signer) presign(method, key string, now time.Time, expires time.Duration) string {
+	host, uri := s.host, "/"+s.bucket+"/"+key
+	if s.virtualHosted {
+		host, uri = s.bucket+"."+s.host, "/"+key
+	}
+	uri = encodePath(uri)
+
+	now = now.UTC()
+	amzDate := now.Format(amzDateFormat)
+	scope := amzDate[:8] + "/" + s.region + "/s3/aws4_request"
+
+	query := map[string]string{
+		"X-Amz-Algorithm":     sigV4Algorithm,
+		"X-Amz-Credential":    s.accessKeyID + "/" + scope,
+		"X-Amz-Date":          amzDate,
+		"X-Amz-Expires":       strconv.FormatInt(int64(expires/time.Second), 10),
+		"X-Amz-SignedHeaders": "host",
+	}
+	canonicalQuery := canonicalQueryString(query)
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		uri,
+		canonicalQuery,
+		"host:" + host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key4 := hmacSHA256([]byte("AWS4"+s.secretKey), amzDate[:8])
+	key4 = hmacSHA256(key4, s.region)
+	key4 = hmacSHA256(key4, "s3")
+	key4 = hmacSHA256(key4, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key4, stringToSign))
+
+	return s.scheme + "://" + host + uri + "?" + canonicalQuery + "&X-Amz-Signature=" + signature
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalQueryString(query map[string]string) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = uriEncode(k) + "=" + uriEncode(query[k])
+	}
+	return strings.Join(parts, "&")
+}
+
+// encodePath encodes every segment of p as SigV4 requires, slashes
+// are kept.
+func encodePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i := range segments {
+		segments[i] = uriEncode(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
+
+// uriEncode escapes everything but the unreserved characters of
+// RFC 3986, unlike url.QueryEscape which turns spaces into '+'.
+func uriEncode(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
@@ -0,0 +1,236 @@
+package querynorm
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of QueryNormalizer.
+	Kind = "QueryNormalizer"
+
+	// How repeated parameters are handled.
+	duplicatesKeep  = "keep"
+	duplicatesFirst = "first"
+	duplicatesLast  = "last"
+
+	defaultOriginalHeader = "X-Original-Query"
+)
+
+var results []string
+
+func init() {
+	httppipeline.Register(&QueryNormalizer{})
+}
+
+type (
+	// Spec is the spec of QueryNormalizer.
+	Spec struct {
+		// Order the parameters by name, repeated parameters keep
+		// their order.
+		Sort bool
+		// keep, first or last: what is kept of a repeated parameter.
+		// Default: keep.
+		Duplicates string
+		// Globs of the parameter names removed, like the path.Match
+		// patterns, e.g. utm_* or fbclid.
+		Strip []string
+		// Remove the parameters without a value, e.g. a in ?a=&b=1.
+		DropEmpty bool
+		// The request header the original query is preserved in, so
+		// later filters and the access log can still use it, e.g. as
+		// {http.request.header.x-original-query}. The header is
+		// always overwritten, or removed without a query, so clients
+		// can't forge it. Set to - to not preserve the query, the
+		// default header is removed then. Default: X-Original-Query.
+		OriginalHeader string
+	}
+
+	// QueryNormalizer rewrites the query of requests to a canonical
+	// form before they are matched or cached, so equivalent URLs
+	// don't fragment caches.
+	QueryNormalizer struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		normalized uint64
+	}
+
+	// Status is the status of QueryNormalizer.
+	Status struct {
+		Normalized uint64 `yaml:"normalized"`
+	}
+
+	// param is one raw name=value pair of a query.
+	param struct {
+		name string
+		raw  string
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	switch spec.Duplicates {
+	case "", duplicatesKeep, duplicatesFirst, duplicatesLast:
+	default:
+		return fmt.Errorf("invalid duplicates %q", spec.Duplicates)
+	}
+	for _, g := range spec.Strip {
+		if _, err := path.Match(g, ""); err != nil {
+			return fmt.Errorf("invalid strip glob %q: %v", g, err)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of QueryNormalizer.
+func (qn *QueryNormalizer) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of QueryNormalizer.
+func (qn *QueryNormalizer) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of QueryNormalizer.
+func (qn *QueryNormalizer) Description() string {
+	return "QueryNormalizer sorts, deduplicates and strips query parameters before matching and caching."
+}
+
+// Results returns the results of QueryNormalizer.
+func (qn *QueryNormalizer) Results() []string {
+	return results
+}
+
+// Init initializes QueryNormalizer.
+func (qn *QueryNormalizer) Init(filterSpec *httppipeline.FilterSpec) {
+	qn.filterSpec = filterSpec
+	qn.spec = filterSpec.FilterSpec().(*Spec)
+}
+
+// Inherit inherits previous generation of QueryNormalizer.
+func (qn *QueryNormalizer) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	qn.Init(filterSpec)
+}
+
+// Handle handles HTTP request
+func (qn *QueryNormalizer) Handle(ctx context.HTTPContext) string {
+	qn.handle(ctx)
+	return ctx.CallNextHandler("")
+}
+
+func (qn *QueryNormalizer) handle(ctx context.HTTPContext) {
+	r := ctx.Request()
+	original := r.Query()
+
+	// whatever the client sent in the header is replaced
+	header := qn.spec.OriginalHeader
+	if header == "" {
+		header = defaultOriginalHeader
+	}
+	switch {
+	case header == "-":
+		r.Header().Del(defaultOriginalHeader)
+	case original == "":
+		r.Header().Del(header)
+	default:
+		r.Header().Set(header, original)
+	}
+
+	if original == "" {
+		return
+	}
+	normalized := qn.normalize(original)
+	if normalized == original {
+		return
+	}
+	r.SetQuery(normalized)
+	atomic.AddUint64(&qn.normalized, 1)
+}
+
+// normalize returns the canonical form of rawQuery. Names and values
+// keep their original escaping, only whole parameters are moved or
+// removed.
+func (qn *QueryNormalizer) normalize(rawQuery string) string {
+	var params []param
+	for _, raw := range strings.Split(rawQuery, "&") {
+		if raw == "" {
+			continue
+		}
+		rawName, rawValue := raw, ""
+		if i := strings.IndexByte(raw, '='); i >= 0 {
+			rawName, rawValue = raw[:i], raw[i+1:]
+		}
+		if qn.spec.DropEmpty && rawValue == "" {
+			continue
+		}
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			name = rawName
+		}
+		if qn.stripped(name) {
+			continue
+		}
+		params = append(params, param{name: name, raw: raw})
+	}
+
+	switch qn.spec.Duplicates {
+	case duplicatesFirst:
+		params = dedup(params, false)
+	case duplicatesLast:
+		params = dedup(params, true)
+	}
+	if qn.spec.Sort {
+		sort.SliceStable(params, func(i, j int) bool { return params[i].name < params[j].name })
+	}
+
+	raws := make([]string, len(params))
+	for i, p := range params {
+		raws[i] = p.raw
+	}
+	return strings.Join(raws, "&")
+}
+
+func (qn *QueryNormalizer) stripped(name string) bool {
+	for _, g := range qn.spec.Strip {
+		if matched, _ := path.Match(g, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// dedup keeps one parameter of every name, the first or the last one,
+// at the position of the first one.
+func dedup(params []param, last bool) []param {
+	index := make(map[string]int, len(params))
+	result := params[:0]
+	for _, p := range params {
+		if i, ok := index[p.name]; ok {
+			if last {
+				result[i] = p
+			}
+			continue
+		}
+		index[p.name] = len(result)
+		result = append(result, p)
+	}
+	return result
+}
+
+// Status returns Status generated by Runtime.
+func (qn *QueryNormalizer) Status() interface{} {
+	return &Status{Normalized: atomic.LoadUint64(&qn.normalized)}
+}
+
+// Close closes QueryNormalizer.
+func (qn *QueryNormalizer) Close() {}
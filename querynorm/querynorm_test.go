@@ -0,0 +1,56 @@
+package querynorm
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestNormalize(t *testing.T) {
+	for _, c := range []struct {
+		spec        Spec
+		query, want string
+	}{
+		{Spec{Sort: true}, "b=2&a=1&b=1", "a=1&b=2&b=1"},
+		{Spec{Duplicates: duplicatesFirst}, "b=2&a=1&b=1", "b=2&a=1"},
+		{Spec{Duplicates: duplicatesLast, Sort: true}, "b=2&a=1&b=1", "a=1&b=1"},
+		{Spec{Strip: []string{"utm_*", "fbclid"}}, "id=7&utm_source=x&utm%5Fmedium=y&fbclid=z", "id=7"},
+		{Spec{DropEmpty: true}, "a=&b=1&c&&d=%20", "b=1&d=%20"},
+		{Spec{Sort: true}, "q=a%26b&p=x+y", "p=x+y&q=a%26b"},
+	} {
+		qn := &QueryNormalizer{spec: &c.spec}
+		if got := qn.normalize(c.query); got != c.want {
+			t.Errorf("%+v: normalize(%q) = %q, want %q", c.spec, c.query, got, c.want)
+		}
+	}
+}
+
+func TestOriginalHeader(t *testing.T) {
+	for _, c := range []struct {
+		originalHeader string
+		query          string
+		header, want   string
+	}{
+		{"", "a=1&b=2", "X-Original-Query", "a=1&b=2"},
+		{"", "b=2&a=1", "X-Original-Query", "b=2&a=1"},
+		{"", "", "X-Original-Query", ""},
+		{"X-Query", "a=1", "X-Query", "a=1"},
+		{"-", "b=2&a=1", "X-Original-Query", ""},
+	} {
+		query := c.query
+		h := http.Header{}
+		h.Set(c.header, "forged")
+		ctx := &contexttest.MockedHTTPContext{}
+		ctx.MockedRequest.MockedQuery = func() string { return query }
+		ctx.MockedRequest.MockedSetQuery = func(q string) { query = q }
+		ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return httpheader.New(h) }
+
+		qn := &QueryNormalizer{spec: &Spec{Sort: true, OriginalHeader: c.originalHeader}}
+		qn.handle(ctx)
+		if got := h.Get(c.header); got != c.want {
+			t.Errorf("%q with header %q: %s = %q, want %q", c.query, c.originalHeader, c.header, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package responsediff
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxBodyChanges bounds the body changes recorded for one request, a
+// completely different document would flood the output otherwise.
+const maxBodyChanges = 100
+
+type (
+	// response is a response of an upstream, the body is cut at the
+	// maximum body size.
+	response struct {
+		status    int
+		header    http.Header
+		body      []byte
+		truncated bool
+	}
+
+	// Diff is one recorded difference between the responses of the
+	// old and the new upstream, it is written as a JSON line.
+	Diff struct {
+		Time      time.Time `json:"time"`
+		Method    string    `json:"method"`
+		Path      string    `json:"path"`
+		Query     string    `json:"query,omitempty"`
+		OldStatus int       `json:"oldStatus"`
+		NewStatus int       `json:"newStatus,omitempty"`
+		// The new upstream failed to answer.
+		Error   string    `json:"error,omitempty"`
+		Headers []*Change `json:"headers,omitempty"`
+		Body    []*Change `json:"body,omitempty"`
+	}
+
+	// Change is a header or body value that differs, Path is the
+	// header name or the JSON path, e.g. $.items[2].price.
+	Change struct {
+		Path string      `json:"path"`
+		Old  interface{} `json:"old"`
+		New  interface{} `json:"new"`
+	}
+)
+
+// differ compares responses ignoring the configured headers and JSON
+// fields.
+type differ struct {
+	ignoreHeaders map[string]bool
+	// paths like items[0].id, and keys ignored at any depth
+	ignorePaths map[string]bool
+	ignoreKeys  map[string]bool
+}
+
+func newDiffer(spec *Spec) *differ {
+	d := &differ{
+		ignoreHeaders: make(map[string]bool),
+		ignorePaths:   make(map[string]bool),
+		ignoreKeys:    make(map[string]bool),
+	}
+	ignoreHeaders := spec.IgnoreHeaders
+	if ignoreHeaders == nil {
+		ignoreHeaders = defaultIgnoreHeaders
+	}
+	for _, h := range ignoreHeaders {
+		d.ignoreHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+	if !spec.CompareSensitiveHeaders {
+		for _, h := range sensitiveHeaders {
+			d.ignoreHeaders[http.CanonicalHeaderKey(h)] = true
+		}
+	}
+	for _, f := range spec.IgnoreFields {
+		f = strings.TrimPrefix(f, "$.")
+		if strings.ContainsAny(f, ".[") {
+			d.ignorePaths[f] = true
+		} else {
+			d.ignoreKeys[f] = true
+		}
+	}
+	return d
+}
+
+// diff fills the header and body changes of d, it reports whether the
+// responses differ at all.
+func (df *differ) diff(d *Diff, oldResp, newResp *response) bool {
+	d.OldStatus, d.NewStatus = oldResp.status, newResp.status
+	d.Headers = df.diffHeaders(oldResp.header, newResp.header)
+	d.Body = df.diffBodies(oldResp, newResp)
+	return d.OldStatus != d.NewStatus || len(d.Headers) > 0 || len(d.Body) > 0
+}
+
+func (df *differ) diffHeaders(oldHeader, newHeader http.Header) []*Change {
+	names := make(map[string]bool)
+	for name := range oldHeader {
+		names[name] = true
+	}
+	for name := range newHeader {
+		names[name] = true
+	}
+
+	var changes []*Change
+	for name := range names {
+		if df.ignoreHeaders[name] {
+			continue
+		}
+		o, n := strings.Join(oldHeader.Values(name), ", "), strings.Join(newHeader.Values(name), ", ")
+		if o != n {
+			changes = append(changes, &Change{Path: name, Old: o, New: n})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func (df *differ) diffBodies(oldResp, newResp *response) []*Change {
+	if oldResp.truncated || newResp.truncated {
+		// only the prefixes are known, comparing them proves nothing
+		return nil
+	}
+	if isJSON(oldResp.header) && isJSON(newResp.header) {
+		var o, n interface{}
+		if json.Unmarshal(oldResp.body, &o) == nil && json.Unmarshal(newResp.body, &n) == nil {
+			var changes []*Change
+			df.diffJSON(&changes, "$", o, n)
+			return changes
+		}
+	}
+	if bytes.Equal(oldResp.body, newResp.body) {
+		return nil
+	}
+	return []*Change{{Path: "$", Old: summary(oldResp.body), New: summary(newResp.body)}}
+}
+
+// diffJSON appends the changes between o and n at path p, object keys
+// are compared regardless of their order.
+func (df *differ) diffJSON(changes *[]*Change, p string, o, n interface{}) {
+	if len(*changes) >= maxBodyChanges {
+		return
+	}
+	if df.ignorePaths[strings.TrimPrefix(p, "$.")] {
+		return
+	}
+	switch ov := o.(type) {
+	case map[string]interface{}:
+		nv, ok := n.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(ov)+len(nv))
+		for k := range ov {
+			keys = append(keys, k)
+		}
+		for k := range nv {
+			if _, ok := ov[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if df.ignoreKeys[k] {
+				continue
+			}
+			df.diffJSON(changes, p+"."+k, ov[k], nv[k])
+		}
+		return
+	case []interface{}:
+		nv, ok := n.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(ov) || i < len(nv); i++ {
+			var oe, ne interface{}
+			if i < len(ov) {
+				oe = ov[i]
+			}
+			if i < len(nv) {
+				ne = nv[i]
+			}
+			df.diffJSON(changes, fmt.Sprintf("%s[%d]", p, i), oe, ne)
+		}
+		return
+	}
+	if !reflect.DeepEqual(o, n) {
+		*changes = append(*changes, &Change{Path: p, Old: o, New: n})
+	}
+}
+
+func isJSON(h http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// summary stands for a body that isn't JSON.
+func summary(body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf("%d bytes, sha256 %s", len(body), hex.EncodeToString(sum[:8]))
+}
@@ -0,0 +1,94 @@
+package responsediff
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/FucAttaCk/gateway/keyring"
+)
+
+func TestDiff(t *testing.T) {
+	df := newDiffer(&Spec{IgnoreFields: []string{"requestId", "meta.generated"}})
+	jsonHeader := func(extra ...string) http.Header {
+		h := http.Header{"Content-Type": {"application/json"}, "Date": {extra[0]}}
+		for i := 1; i+1 < len(extra); i += 2 {
+			h.Set(extra[i], extra[i+1])
+		}
+		return h
+	}
+
+	oldResp := &response{
+		status: 200,
+		header: jsonHeader("Mon", "Cache-Control", "max-age=60", "Set-Cookie", "session=old"),
+		body:   []byte(`{"requestId":"a","items":[{"id":1,"price":10}],"meta":{"generated":1,"page":1}}`),
+	}
+	newResp := &response{
+		status: 200,
+		header: jsonHeader("Tue", "Cache-Control", "no-cache", "Set-Cookie", "session=new"),
+		body:   []byte(`{"meta":{"page":1,"generated":2},"items":[{"price":12,"id":1},{"id":2}],"requestId":"b"}`),
+	}
+
+	d := &Diff{}
+	if !df.diff(d, oldResp, newResp) {
+		t.Fatal("no difference found")
+	}
+	if len(d.Headers) != 1 || d.Headers[0].Path != "Cache-Control" {
+		t.Errorf("header changes = %+v, want Cache-Control only", d.Headers)
+	}
+	sensitive := newDiffer(&Spec{CompareSensitiveHeaders: true})
+	if d := (&Diff{}); !sensitive.diff(d, oldResp, newResp) || len(d.Headers) != 2 || d.Headers[1].Path != "Set-Cookie" {
+		t.Errorf("header changes with sensitive headers = %+v", d.Headers)
+	}
+	var paths []string
+	for _, c := range d.Body {
+		paths = append(paths, c.Path)
+	}
+	if len(paths) != 2 || paths[0] != "$.items[0].price" || paths[1] != "$.items[1]" {
+		t.Errorf("body changes = %v, want $.items[0].price and $.items[1]", paths)
+	}
+
+	// key order and whitespace don't matter
+	newResp.header, newResp.body = oldResp.header, []byte(`{"meta": {"page": 1, "generated": 1}, "items": [{"price": 10, "id": 1}], "requestId": "a"}`)
+	if d := (&Diff{}); df.diff(d, oldResp, newResp) {
+		t.Errorf("equal documents differ: %+v", d.Body)
+	}
+
+	text := http.Header{"Content-Type": {"text/plain"}}
+	if d := (&Diff{}); !df.diff(d, &response{status: 200, header: text, body: []byte("a")},
+		&response{status: 200, header: text, body: []byte("b")}) || len(d.Body) != 1 || d.Body[0].Path != "$" {
+		t.Errorf("text bodies: changes = %+v", d.Body)
+	}
+}
+
+func TestMarshalEncryption(t *testing.T) {
+	kr, err := keyring.Open(&keyring.Spec{File: filepath.Join(t.TempDir(), "keys.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kr.Close()
+	rd := &ResponseDiff{keyring: kr}
+
+	line, err := rd.marshal(&Diff{Path: "/secret", Body: []*Change{{Path: "$.token", Old: "a", New: "b"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(line, []byte("token")) || bytes.ContainsAny(line, "\n") {
+		t.Fatalf("line %q is not sealed", line)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := kr.Unseal(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Diff{}
+	if err := json.Unmarshal(data, d); err != nil || d.Path != "/secret" || d.Body[0].Path != "$.token" {
+		t.Errorf("unsealed %s, %v", data, err)
+	}
+}
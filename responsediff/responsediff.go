@@ -0,0 +1,493 @@
+package responsediff
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/FucAttaCk/gateway/cryptopolicy"
+	"github.com/FucAttaCk/gateway/keyring"
+	"github.com/FucAttaCk/gateway/util"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// Kind is the kind of ResponseDiff.
+	Kind = "ResponseDiff"
+
+	defaultTimeout     = 10 * time.Second
+	defaultMaxBodySize = 1 << 20
+	defaultMaxPending  = 100
+
+	resultServed = "served"
+	resultFailed = "failed"
+	// the client went away, it is no failure of the upstreams
+	resultClientDisconnected = "clientDisconnected"
+)
+
+var (
+	results = []string{resultServed, resultFailed, resultClientDisconnected}
+
+	defaultMethods       = []string{http.MethodGet, http.MethodHead}
+	defaultIgnoreHeaders = []string{"Date", "Age", "Content-Length"}
+	// sensitiveHeaders carry credentials and sessions, they are left
+	// out of the differences unless CompareSensitiveHeaders is set.
+	sensitiveHeaders = []string{
+		"Set-Cookie", "Cookie", "Authorization", "Proxy-Authorization",
+		"WWW-Authenticate", "Proxy-Authenticate", "X-Api-Key", "X-Auth-Token",
+	}
+)
+
+// hopHeaders are not forwarded to the upstreams.
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+func init() {
+	httppipeline.Register(&ResponseDiff{})
+}
+
+type (
+	// Spec is the spec of ResponseDiff.
+	Spec struct {
+		// The upstream being replaced, its responses are served, e.g.
+		// http://10.0.0.1:8080. The request path is appended to it.
+		OldUpstream string
+		// The upstream being validated, its responses are only
+		// compared.
+		NewUpstream string
+		// Methods sent to both upstreams, the others go to the old one
+		// only. Only add methods whose requests can safely be sent
+		// twice. Default: GET and HEAD.
+		Methods []string
+		// The percentage of requests sent to the new upstream too,
+		// e.g. 10. Default: 100.
+		Percentage float64
+		// The timeout of each upstream, e.g. 5s. Default: 10s.
+		Timeout string
+		// Requests and responses larger than this aren't compared.
+		// Default: 1MiB.
+		MaxBodySize int64
+		// Response headers not compared. Default: Date, Age and
+		// Content-Length.
+		IgnoreHeaders []string
+		// Compare the headers carrying credentials and sessions too,
+		// like Set-Cookie and Authorization, their values end up in
+		// the output.
+		CompareSensitiveHeaders bool
+		// JSON fields not compared, either paths like meta.requestId
+		// or items[0].id, or keys ignored at any depth like timestamp.
+		IgnoreFields []string
+		// The file the differences are appended to, one JSON object
+		// per line.
+		Output string
+		// Encrypt the differences with keys of this keyring, they
+		// hold response headers and bodies. Each line is then the
+		// base64 of a sealed JSON object.
+		Encryption *keyring.Spec
+		// New upstream requests in flight beyond this are skipped.
+		// Default: 100.
+		MaxPending int
+	}
+
+	// ResponseDiff serves the responses of an old upstream while it
+	// sends the same requests to a new one, and records how the
+	// responses differ, to validate a migration on real traffic.
+	ResponseDiff struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+		oldURL     *url.URL
+		newURL     *url.URL
+		methods    map[string]bool
+		client     *http.Client
+		differ     *differ
+		pending    chan struct{}
+		wg         sync.WaitGroup
+
+		mu      sync.Mutex
+		file    *os.File
+		keyring *keyring.Keyring
+
+		compared  uint64
+		differed  uint64
+		newFailed uint64
+		skipped   uint64
+		// requests whose client went away before the old upstream
+		// answered
+		clientDisconnects uint64
+	}
+
+	// Status is the status of ResponseDiff.
+	Status struct {
+		Compared          uint64 `yaml:"compared"`
+		Differed          uint64 `yaml:"differed"`
+		NewFailed         uint64 `yaml:"newFailed"`
+		Skipped           uint64 `yaml:"skipped"`
+		ClientDisconnects uint64 `yaml:"clientDisconnects"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	for _, u := range []struct{ name, value string }{
+		{"oldUpstream", spec.OldUpstream},
+		{"newUpstream", spec.NewUpstream},
+	} {
+		parsed, err := url.Parse(u.value)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid %s %q", u.name, u.value)
+		}
+	}
+	if spec.Output == "" {
+		return fmt.Errorf("output is required")
+	}
+	if spec.Percentage < 0 || spec.Percentage > 100 {
+		return fmt.Errorf("invalid percentage %v", spec.Percentage)
+	}
+	if spec.Timeout != "" {
+		if d, err := time.ParseDuration(spec.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q", spec.Timeout)
+		}
+	}
+	if spec.Encryption != nil {
+		return spec.Encryption.Validate()
+	}
+	return nil
+}
+
+func parseDuration(s string, defaultValue time.Duration) time.Duration {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return defaultValue
+	}
+	return d
+}
+
+// Kind returns the kind of ResponseDiff.
+func (rd *ResponseDiff) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of ResponseDiff.
+func (rd *ResponseDiff) DefaultSpec() interface{} {
+	return &Spec{Percentage: 100}
+}
+
+// Description returns the description of ResponseDiff.
+func (rd *ResponseDiff) Description() string {
+	return "ResponseDiff serves an old upstream and records how the responses of a new upstream differ."
+}
+
+// Results returns the results of ResponseDiff.
+func (rd *ResponseDiff) Results() []string {
+	return results
+}
+
+// Init initializes ResponseDiff.
+func (rd *ResponseDiff) Init(filterSpec *httppipeline.FilterSpec) {
+	rd.filterSpec = filterSpec
+	rd.spec = filterSpec.FilterSpec().(*Spec)
+	rd.oldURL, _ = url.Parse(rd.spec.OldUpstream)
+	rd.newURL, _ = url.Parse(rd.spec.NewUpstream)
+
+	methods := rd.spec.Methods
+	if len(methods) == 0 {
+		methods = defaultMethods
+	}
+	rd.methods = make(map[string]bool, len(methods))
+	for _, m := range methods {
+		rd.methods[strings.ToUpper(m)] = true
+	}
+
+	rd.client = cryptopolicy.NewHTTPClient(parseDuration(rd.spec.Timeout, defaultTimeout))
+	// the redirects are part of the responses being compared
+	rd.client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	rd.differ = newDiffer(rd.spec)
+	maxPending := rd.spec.MaxPending
+	if maxPending <= 0 {
+		maxPending = defaultMaxPending
+	}
+	rd.pending = make(chan struct{}, maxPending)
+
+	if rd.spec.Encryption != nil {
+		kr, err := keyring.Open(rd.spec.Encryption)
+		if err != nil {
+			// nothing is recorded rather than recorded in clear
+			logger.Error("open response diff keyring failed", zap.String("output", rd.spec.Output), zap.Error(err))
+			return
+		}
+		rd.keyring = kr
+	}
+	f, err := os.OpenFile(rd.spec.Output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err == nil {
+		// the file may have been created by an older version
+		err = f.Chmod(0o600)
+	}
+	if err != nil {
+		logger.Error("open response diff output failed", zap.String("output", rd.spec.Output), zap.Error(err))
+		if f != nil {
+			f.Close()
+		}
+		return
+	}
+	rd.file = f
+}
+
+// Inherit inherits previous generation of ResponseDiff.
+func (rd *ResponseDiff) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	rd.Init(filterSpec)
+}
+
+// Handle handles HTTP request
+func (rd *ResponseDiff) Handle(ctx context.HTTPContext) string {
+	res := rd.handle(ctx)
+	return ctx.CallNextHandler(res)
+}
+
+func (rd *ResponseDiff) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	w := ctx.Response()
+	maxBodySize := rd.maxBodySize()
+
+	body, err := io.ReadAll(io.LimitReader(r.Body(), maxBodySize+1))
+	if err != nil && util.ClientGone(r.Std().Context(), err) {
+		return rd.clientDisconnected(ctx)
+	}
+	if err != nil {
+		ctx.AddTag(fmt.Sprintf("response diff: read body failed: %v", err))
+		w.SetStatusCode(http.StatusBadRequest)
+		return resultFailed
+	}
+	oldBody := io.Reader(bytes.NewReader(body))
+	shadow := rd.methods[r.Method()] && rd.sampled()
+	if int64(len(body)) > maxBodySize {
+		oldBody = io.MultiReader(oldBody, r.Body())
+		shadow = false
+	}
+
+	oldReq, err := rd.newRequest(rd.oldURL, r.Std(), oldBody)
+	if err != nil {
+		ctx.AddTag(fmt.Sprintf("response diff: %v", err))
+		w.SetStatusCode(http.StatusBadGateway)
+		return resultFailed
+	}
+	oldReq = oldReq.WithContext(r.Std().Context())
+
+	var newResp chan *response
+	var newErr error
+	if shadow {
+		select {
+		case rd.pending <- struct{}{}:
+			newReq, err := rd.newRequest(rd.newURL, r.Std(), bytes.NewReader(body))
+			if err != nil {
+				<-rd.pending
+				break
+			}
+			newResp = make(chan *response, 1)
+			rd.wg.Add(1)
+			go func() {
+				defer rd.wg.Done()
+				defer func() { <-rd.pending }()
+				var resp *response
+				resp, newErr = rd.do(newReq)
+				newResp <- resp
+			}()
+		default:
+			atomic.AddUint64(&rd.skipped, 1)
+		}
+	}
+
+	resp, err := rd.client.Do(oldReq)
+	// the old request is canceled with the client, the shadow one
+	// goes on, but nothing waits for it
+	if err != nil && util.ClientGone(r.Std().Context(), nil) {
+		return rd.clientDisconnected(ctx)
+	}
+	if err != nil {
+		ctx.AddTag(fmt.Sprintf("response diff: old upstream failed: %v", err))
+		w.SetStatusCode(http.StatusBadGateway)
+		return resultFailed
+	}
+	prefix, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		resp.Body.Close()
+		if util.ClientGone(r.Std().Context(), nil) {
+			return rd.clientDisconnected(ctx)
+		}
+		ctx.AddTag(fmt.Sprintf("response diff: read old upstream failed: %v", err))
+		w.SetStatusCode(http.StatusBadGateway)
+		return resultFailed
+	}
+
+	old := &response{status: resp.StatusCode, header: resp.Header, body: prefix}
+	for k, v := range resp.Header {
+		w.Header().Std()[k] = v
+	}
+	for _, h := range hopHeaders {
+		w.Header().Del(h)
+	}
+	w.SetStatusCode(resp.StatusCode)
+	if int64(len(prefix)) > maxBodySize {
+		old.truncated = true
+		w.SetBody(io.MultiReader(bytes.NewReader(prefix), resp.Body))
+		ctx.OnFinish(func() { resp.Body.Close() })
+	} else {
+		resp.Body.Close()
+		w.SetBody(bytes.NewReader(prefix))
+	}
+
+	if newResp != nil {
+		d := &Diff{
+			Time:   time.Now(),
+			Method: r.Method(),
+			Path:   r.Path(),
+			Query:  r.Query(),
+		}
+		rd.wg.Add(1)
+		go func() {
+			defer rd.wg.Done()
+			rd.compare(d, old, <-newResp, newErr)
+		}()
+	}
+	return resultServed
+}
+
+// clientDisconnected answers a request whose client went away.
+func (rd *ResponseDiff) clientDisconnected(ctx context.HTTPContext) string {
+	atomic.AddUint64(&rd.clientDisconnects, 1)
+	ctx.AddTag("response diff: client disconnected")
+	ctx.Response().SetStatusCode(context.EGStatusClientClosedRequest)
+	return resultClientDisconnected
+}
+
+func (rd *ResponseDiff) maxBodySize() int64 {
+	if rd.spec.MaxBodySize > 0 {
+		return rd.spec.MaxBodySize
+	}
+	return defaultMaxBodySize
+}
+
+func (rd *ResponseDiff) sampled() bool {
+	p := rd.spec.Percentage
+	return p >= 100 || rand.Float64()*100 < p
+}
+
+// newRequest builds the request to upstream from the request of the
+// client.
+func (rd *ResponseDiff) newRequest(upstream *url.URL, stdr *http.Request, body io.Reader) (*http.Request, error) {
+	u := *upstream
+	u.Path = strings.TrimRight(u.Path, "/") + stdr.URL.Path
+	u.RawQuery = stdr.URL.RawQuery
+	req, err := http.NewRequest(stdr.Method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = stdr.Header.Clone()
+	for _, h := range hopHeaders {
+		req.Header.Del(h)
+	}
+	req.Host = stdr.Host
+	return req, nil
+}
+
+// do sends req and reads the response.
+func (rd *ResponseDiff) do(req *http.Request) (*response, error) {
+	resp, err := rd.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	maxBodySize := rd.maxBodySize()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	result := &response{status: resp.StatusCode, header: resp.Header, body: body}
+	if int64(len(body)) > maxBodySize {
+		result.body, result.truncated = body[:maxBodySize], true
+	}
+	return result, nil
+}
+
+// compare records the difference of the responses, if any.
+func (rd *ResponseDiff) compare(d *Diff, oldResp, newResp *response, newErr error) {
+	atomic.AddUint64(&rd.compared, 1)
+	if newErr != nil {
+		atomic.AddUint64(&rd.newFailed, 1)
+		d.OldStatus = oldResp.status
+		d.Error = newErr.Error()
+	} else if !rd.differ.diff(d, oldResp, newResp) {
+		return
+	}
+	atomic.AddUint64(&rd.differed, 1)
+
+	if rd.file == nil {
+		return
+	}
+	data, err := rd.marshal(d)
+	if err != nil {
+		logger.Warn("marshal response diff failed", zap.Error(err))
+		return
+	}
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if _, err := rd.file.Write(append(data, '\n')); err != nil {
+		logger.Warn("write response diff failed", zap.String("output", rd.spec.Output), zap.Error(err))
+	}
+}
+
+// marshal encodes d as a line of the output, sealed if encryption is
+// configured.
+func (rd *ResponseDiff) marshal(d *Diff) ([]byte, error) {
+	data, err := json.Marshal(d)
+	if err != nil || rd.keyring == nil {
+		return data, err
+	}
+	sealed, err := rd.keyring.Seal(data)
+	if err != nil {
+		return nil, err
+	}
+	line := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(line, sealed)
+	return line, nil
+}
+
+// Status returns Status generated by Runtime.
+func (rd *ResponseDiff) Status() interface{} {
+	return &Status{
+		Compared:          atomic.LoadUint64(&rd.compared),
+		Differed:          atomic.LoadUint64(&rd.differed),
+		NewFailed:         atomic.LoadUint64(&rd.newFailed),
+		Skipped:           atomic.LoadUint64(&rd.skipped),
+		ClientDisconnects: atomic.LoadUint64(&rd.clientDisconnects),
+	}
+}
+
+// Close closes ResponseDiff, it waits for the pending comparisons.
+func (rd *ResponseDiff) Close() {
+	rd.wg.Wait()
+	if rd.file != nil {
+		rd.file.Close()
+	}
+	if rd.keyring != nil {
+		rd.keyring.Close()
+	}
+}
@@ -0,0 +1,285 @@
+// Package secevent unifies the security relevant decisions of the
+// filters (block lists, anomaly blocks, honeypots, auth failures) into
+// one structured event stream that can be shipped to a SIEM.
+package secevent
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/FucAttaCk/gateway/logsink"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// FormatJSON encodes every event as one JSON object.
+	FormatJSON = "json"
+	// FormatCEF encodes every event in ArcSight Common Event Format.
+	FormatCEF = "cef"
+
+	// Event types emitted by the filters of this repository.
+	TypeBlocklist   = "blocklist"
+	TypeAnomaly     = "anomaly"
+	TypeHoneypot    = "honeypot"
+	TypeAuthFailure = "authFailure"
+	TypeHotlink     = "hotlink"
+
+	queueSize = 1024
+
+	cefVendor  = "FucAttaCk"
+	cefProduct = "GateWay"
+	cefVersion = "1.0"
+)
+
+type (
+	// Event is one security event.
+	Event struct {
+		Time     time.Time         `json:"time"`
+		Type     string            `json:"type"`
+		Severity int               `json:"severity"`
+		Source   string            `json:"source"`
+		Action   string            `json:"action"`
+		ClientIP string            `json:"clientIP,omitempty"`
+		Method   string            `json:"method,omitempty"`
+		Path     string            `json:"path,omitempty"`
+		Reason   string            `json:"reason,omitempty"`
+		Fields   map[string]string `json:"fields,omitempty"`
+	}
+
+	// Spec describes where the security events of a filter go. Events
+	// are always logged at debug level, the sinks are optional.
+	Spec struct {
+		// json or cef. Default: json.
+		Format   string
+		Syslog   *logsink.SyslogSpec
+		Journald *logsink.JournaldSpec
+		Webhook  *WebhookSpec
+		Kafka    *KafkaSpec
+	}
+
+	// Stream formats events and delivers them to the sinks in the
+	// background. Events are dropped when the queue is full, so a slow
+	// SIEM never slows down request handling. Events emitted after
+	// Close are dropped, requests of a previous generation of a filter
+	// may still emit them.
+	Stream struct {
+		source  string
+		format  string
+		sinks   []sink
+		queue   chan *Event
+		dropped uint64
+		wg      sync.WaitGroup
+
+		mu     sync.RWMutex
+		closed bool
+	}
+
+	sink interface {
+		send(ev *Event, data []byte) error
+		close()
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	switch spec.Format {
+	case "", FormatJSON, FormatCEF:
+	default:
+		return fmt.Errorf("invalid security event format %s", spec.Format)
+	}
+	if spec.Syslog != nil {
+		if err := spec.Syslog.Validate(); err != nil {
+			return err
+		}
+	}
+	if spec.Webhook != nil && spec.Webhook.URL == "" {
+		return fmt.Errorf("security event webhook url is required")
+	}
+	if spec.Kafka != nil && (len(spec.Kafka.Brokers) == 0 || spec.Kafka.Topic == "") {
+		return fmt.Errorf("security event kafka brokers and topic are required")
+	}
+	return nil
+}
+
+// New creates a Stream for the filter named source, spec may be nil.
+func New(source string, spec *Spec) *Stream {
+	s := &Stream{
+		source: source,
+		format: FormatJSON,
+		queue:  make(chan *Event, queueSize),
+	}
+	if spec != nil {
+		if spec.Format != "" {
+			s.format = spec.Format
+		}
+		if spec.Syslog != nil {
+			if sl, err := logsink.NewSyslog(spec.Syslog, "auth"); err != nil {
+				logger.Error("create security event syslog sink failed", zap.Error(err))
+			} else {
+				s.sinks = append(s.sinks, &logSink{sink: sl})
+			}
+		}
+		if spec.Journald != nil {
+			if j, err := logsink.NewJournald(spec.Journald); err != nil {
+				logger.Error("create security event journald sink failed", zap.Error(err))
+			} else {
+				s.sinks = append(s.sinks, &logSink{sink: j})
+			}
+		}
+		if spec.Webhook != nil {
+			s.sinks = append(s.sinks, newWebhookSink(spec.Webhook))
+		}
+		if spec.Kafka != nil {
+			if k, err := newKafkaSink(spec.Kafka); err != nil {
+				logger.Error("create security event kafka sink failed", zap.Error(err))
+			} else {
+				s.sinks = append(s.sinks, k)
+			}
+		}
+	}
+
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// Emit queues ev for delivery, Time and Source are filled in if empty.
+func (s *Stream) Emit(ev *Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if ev.Source == "" {
+		ev.Source = s.source
+	}
+
+	// scans emit an event per request, so the sinks are where
+	// events are looked at
+	logger.Debug("security event",
+		zap.String("type", ev.Type),
+		zap.String("source", ev.Source),
+		zap.String("action", ev.Action),
+		zap.String("clientIP", ev.ClientIP),
+		zap.String("path", ev.Path),
+		zap.String("reason", ev.Reason))
+
+	if len(s.sinks) == 0 {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	}
+	select {
+	case s.queue <- ev:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Dropped returns the number of events dropped because the queue
+// was full or the stream was closed.
+func (s *Stream) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *Stream) run() {
+	defer s.wg.Done()
+	for ev := range s.queue {
+		data := s.encode(ev)
+		for _, sk := range s.sinks {
+			if err := sk.send(ev, data); err != nil {
+				logger.Debug("send security event failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (s *Stream) encode(ev *Event) []byte {
+	if s.format == FormatCEF {
+		return []byte(ev.CEF())
+	}
+	data, _ := json.Marshal(ev)
+	return data
+}
+
+// Close delivers the queued events and closes the sinks.
+func (s *Stream) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	for _, sk := range s.sinks {
+		sk.close()
+	}
+}
+
+// CEF returns the event in Common Event Format.
+func (ev *Event) CEF() string {
+	var sb strings.Builder
+	sb.WriteString("CEF:0|")
+	sb.WriteString(cefHeaderEscaper.Replace(cefVendor))
+	sb.WriteByte('|')
+	sb.WriteString(cefHeaderEscaper.Replace(cefProduct))
+	sb.WriteByte('|')
+	sb.WriteString(cefVersion)
+	sb.WriteByte('|')
+	sb.WriteString(cefHeaderEscaper.Replace(ev.Type))
+	sb.WriteByte('|')
+	sb.WriteString(cefHeaderEscaper.Replace(ev.Type + " " + ev.Action))
+	sb.WriteByte('|')
+	sb.WriteString(strconv.Itoa(ev.Severity))
+	sb.WriteByte('|')
+
+	ext := []string{
+		"rt", strconv.FormatInt(ev.Time.UnixMilli(), 10),
+		"act", ev.Action,
+		"src", ev.ClientIP,
+		"requestMethod", ev.Method,
+		"request", ev.Path,
+		"msg", ev.Reason,
+		"cs1Label", "source",
+		"cs1", ev.Source,
+	}
+	keys := make([]string, 0, len(ev.Fields))
+	for k := range ev.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		ext = append(ext, k, ev.Fields[k])
+	}
+	first := true
+	for i := 0; i < len(ext); i += 2 {
+		if ext[i+1] == "" {
+			continue
+		}
+		if !first {
+			sb.WriteByte(' ')
+		}
+		first = false
+		sb.WriteString(ext[i])
+		sb.WriteByte('=')
+		sb.WriteString(cefExtensionEscaper.Replace(ext[i+1]))
+	}
+	return sb.String()
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
@@ -0,0 +1,45 @@
+package secevent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventCEF(t *testing.T) {
+	ev := &Event{
+		Time:     time.UnixMilli(1700000000000),
+		Type:     TypeHoneypot,
+		Severity: 8,
+		Source:   "blocker|1",
+		Action:   "block",
+		ClientIP: "10.0.0.1",
+		Method:   "GET",
+		Path:     "/.env?a=b",
+		Reason:   "honeypot /.env",
+		Fields:   map[string]string{"userAgent": `curl\8`},
+	}
+	want := `CEF:0|FucAttaCk|GateWay|1.0|honeypot|honeypot block|8|` +
+		`rt=1700000000000 act=block src=10.0.0.1 requestMethod=GET request=/.env?a\=b ` +
+		`msg=honeypot /.env cs1Label=source cs1=blocker|1 userAgent=curl\\8`
+	if got := ev.CEF(); got != want {
+		t.Errorf("CEF() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEmitAfterClose(t *testing.T) {
+	s := New("blocker", nil)
+	// a stream with a sink queues events
+	s.sinks = []sink{nopSink{}}
+	s.Emit(&Event{Type: TypeHoneypot})
+	s.Close()
+	s.Close()
+	s.Emit(&Event{Type: TypeHoneypot})
+	if n := s.Dropped(); n != 1 {
+		t.Errorf("dropped %d events, want 1", n)
+	}
+}
+
+type nopSink struct{}
+
+func (nopSink) send(*Event, []byte) error { return nil }
+func (nopSink) close()                    {}
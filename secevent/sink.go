@@ -0,0 +1,144 @@
+package secevent
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/FucAttaCk/gateway/cryptopolicy"
+	"github.com/FucAttaCk/gateway/logsink"
+	"github.com/Shopify/sarama"
+)
+
+const webhookTimeout = 10 * time.Second
+
+type (
+	// WebhookSpec describes an HTTP endpoint events are POSTed to.
+	WebhookSpec struct {
+		URL     string
+		Headers map[string]string
+	}
+
+	// KafkaSpec describes a Kafka topic events are produced to.
+	KafkaSpec struct {
+		Brokers []string
+		Topic   string
+	}
+
+	// logSink sends events to a logsink.Sink, the formatted event is
+	// the message and its fields are attached as structured data.
+	logSink struct {
+		sink logsink.Sink
+	}
+
+	webhookSink struct {
+		spec   *WebhookSpec
+		client *http.Client
+	}
+
+	kafkaSink struct {
+		topic    string
+		producer sarama.AsyncProducer
+	}
+)
+
+// logSeverity maps the 0-10 event severity to a syslog severity.
+func logSeverity(severity int) int {
+	switch {
+	case severity >= 9:
+		return logsink.SeverityCritical
+	case severity >= 7:
+		return logsink.SeverityError
+	case severity >= 4:
+		return logsink.SeverityWarning
+	default:
+		return logsink.SeverityNotice
+	}
+}
+
+func (s *logSink) send(ev *Event, data []byte) error {
+	fields := map[string]string{
+		"source": ev.Source,
+		"action": ev.Action,
+	}
+	for k, v := range map[string]string{
+		"clientIP": ev.ClientIP,
+		"method":   ev.Method,
+		"path":     ev.Path,
+		"reason":   ev.Reason,
+	} {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+	for k, v := range ev.Fields {
+		fields[k] = v
+	}
+
+	return s.sink.Write(&logsink.Record{
+		Time:     ev.Time,
+		Severity: logSeverity(ev.Severity),
+		MsgID:    ev.Type,
+		Message:  string(data),
+		Fields:   fields,
+	})
+}
+
+func (s *logSink) close() {
+	s.sink.Close()
+}
+func newWebhookSink(spec *WebhookSpec) *webhookSink {
+	return &webhookSink{spec: spec, client: cryptopolicy.NewHTTPClient(webhookTimeout)}
+}
+
+func (s *webhookSink) send(ev *Event, data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.spec.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if data[0] == '{' {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Set("Content-Type", "text/plain")
+	}
+	for k, v := range s.spec.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *webhookSink) close() {}
+
+func newKafkaSink(spec *KafkaSpec) (*kafkaSink, error) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Errors = false
+	config.Producer.Return.Successes = false
+	producer, err := sarama.NewAsyncProducer(spec.Brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaSink{topic: spec.Topic, producer: producer}, nil
+}
+
+func (s *kafkaSink) send(ev *Event, data []byte) error {
+	s.producer.Input() <- &sarama.ProducerMessage{
+		Topic: s.topic,
+		Key:   sarama.StringEncoder(ev.ClientIP),
+		Value: sarama.ByteEncoder(data),
+	}
+	return nil
+}
+
+func (s *kafkaSink) close() {
+	s.producer.Close()
+}
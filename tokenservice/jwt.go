@@ -0,0 +1,146 @@
+package tokenservice
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/FucAttaCk/gateway/cryptopolicy"
+)
+
+const (
+	algHS256 = "HS256"
+	algES256 = "ES256"
+)
+
+type (
+	// signingKey signs tokens with one key of the spec.
+	signingKey struct {
+		id        string
+		algorithm string
+		secret    []byte
+		private   *ecdsa.PrivateKey
+	}
+
+	jwk struct {
+		Kty string `json:"kty"`
+		Crv string `json:"crv"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+)
+
+var b64 = base64.RawURLEncoding
+
+func loadKey(spec *KeySpec) (*signingKey, error) {
+	k := &signingKey{id: spec.ID, algorithm: spec.Algorithm}
+	if k.algorithm == "" {
+		k.algorithm = algHS256
+	}
+	if err := cryptopolicy.CheckSignatureAlgorithm(k.algorithm); err != nil {
+		return nil, fmt.Errorf("key %s: %v", spec.ID, err)
+	}
+
+	switch k.algorithm {
+	case algHS256:
+		secret := spec.Secret
+		if strings.HasPrefix(secret, "$") {
+			secret = os.Getenv(secret[1:])
+		}
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("key %s: HS256 secret must be at least 32 bytes", spec.ID)
+		}
+		k.secret = []byte(secret)
+	case algES256:
+		data, err := os.ReadFile(spec.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", spec.ID, err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("key %s: no PEM data in %s", spec.ID, spec.PrivateKeyFile)
+		}
+		var key interface{}
+		if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", spec.ID, err)
+		}
+		private, ok := key.(*ecdsa.PrivateKey)
+		if !ok || private.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("key %s: ES256 requires a P-256 private key", spec.ID)
+		}
+		k.private = private
+	default:
+		return nil, fmt.Errorf("key %s: unsupported algorithm %s", spec.ID, k.algorithm)
+	}
+	return k, nil
+}
+
+// sign returns the compact serialization of a JWT carrying claims.
+func (k *signingKey) sign(claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": k.algorithm, "typ": "JWT", "kid": k.id})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+
+	var signature []byte
+	switch k.algorithm {
+	case algHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case algES256:
+		digest := sha256.Sum256([]byte(signingInput))
+		r, s, err := ecdsa.Sign(rand.Reader, k.private, digest[:])
+		if err != nil {
+			return "", err
+		}
+		// JWS wants the fixed size concatenation of r and s
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signingInput + "." + b64.EncodeToString(signature), nil
+}
+
+// jwk returns the public key for the JWKS document, HMAC keys are
+// secret and have none.
+func (k *signingKey) jwk() *jwk {
+	if k.private == nil {
+		return nil
+	}
+	public := k.private.Public().(*ecdsa.PublicKey)
+	return &jwk{
+		Kty: "EC",
+		Crv: "P-256",
+		Kid: k.id,
+		Use: "sig",
+		Alg: algES256,
+		X:   b64.EncodeToString(fixedBytes(public.X)),
+		Y:   b64.EncodeToString(fixedBytes(public.Y)),
+	}
+}
+
+func fixedBytes(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, 32))
+}
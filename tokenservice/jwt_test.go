@@ -0,0 +1,75 @@
+package tokenservice
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSignHS256(t *testing.T) {
+	secret := strings.Repeat("k", 32)
+	key, err := loadKey(&KeySpec{ID: "2024-01", Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := key.sign(map[string]interface{}{"sub": "ci"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token has %d parts", len(parts))
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if b64.EncodeToString(mac.Sum(nil)) != parts[2] {
+		t.Errorf("invalid signature")
+	}
+	var header map[string]string
+	data, _ := b64.DecodeString(parts[0])
+	json.Unmarshal(data, &header)
+	if header["kid"] != "2024-01" || header["alg"] != algHS256 {
+		t.Errorf("unexpected header %v", header)
+	}
+}
+
+func TestSignES256(t *testing.T) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(private)
+	file := filepath.Join(t.TempDir(), "key.pem")
+	os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+
+	key, err := loadKey(&KeySpec{ID: "ec", Algorithm: algES256, PrivateKeyFile: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := key.sign(map[string]interface{}{"sub": "ci"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(token, ".")
+	sig, _ := b64.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(&private.PublicKey, digest[:], r, s) {
+		t.Errorf("invalid signature")
+	}
+	if j := key.jwk(); j == nil || j.Kid != "ec" {
+		t.Errorf("unexpected jwk %v", j)
+	}
+}
@@ -0,0 +1,87 @@
+package tokenservice
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+type (
+	// refreshStore keeps the refresh tokens in memory. Tokens are
+	// single use, redeeming one issues a new one.
+	refreshStore struct {
+		mutex  sync.Mutex
+		tokens map[string]*refreshToken
+		done   chan struct{}
+	}
+
+	refreshToken struct {
+		clientID string
+		expiry   time.Time
+	}
+)
+
+func newRefreshStore() *refreshStore {
+	rs := &refreshStore{
+		tokens: map[string]*refreshToken{},
+		done:   make(chan struct{}),
+	}
+	go rs.run()
+	return rs
+}
+
+func (rs *refreshStore) run() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rs.cleanup(time.Now())
+		case <-rs.done:
+			return
+		}
+	}
+}
+
+func (rs *refreshStore) cleanup(now time.Time) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	for token, rt := range rs.tokens {
+		if now.After(rt.expiry) {
+			delete(rs.tokens, token)
+		}
+	}
+}
+
+// issue returns a new refresh token of the client.
+func (rs *refreshStore) issue(clientID string, ttl time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := b64.EncodeToString(buf)
+
+	rs.mutex.Lock()
+	rs.tokens[token] = &refreshToken{clientID: clientID, expiry: time.Now().Add(ttl)}
+	rs.mutex.Unlock()
+	return token, nil
+}
+
+// redeem consumes token and returns the client it was issued to.
+func (rs *refreshStore) redeem(token string) (string, bool) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	rt, ok := rs.tokens[token]
+	if !ok {
+		return "", false
+	}
+	delete(rs.tokens, token)
+	if time.Now().After(rt.expiry) {
+		return "", false
+	}
+	return rt.clientID, true
+}
+
+func (rs *refreshStore) close() {
+	close(rs.done)
+}
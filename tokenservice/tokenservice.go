@@ -0,0 +1,450 @@
+package tokenservice
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/FucAttaCk/gateway/keyring"
+	"github.com/FucAttaCk/gateway/secevent"
+	"github.com/FucAttaCk/gateway/util"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// Kind is the kind of TokenService.
+	Kind = "TokenService"
+
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 24 * time.Hour
+	maxRequestSize         = 64 << 10
+
+	resultMethodNotAllowed = "methodNotAllowed"
+	resultInvalidRequest   = "invalidRequest"
+	resultUnauthorized     = "unauthorized"
+)
+
+var results = []string{resultMethodNotAllowed, resultInvalidRequest, resultUnauthorized}
+
+func init() {
+	httppipeline.Register(&TokenService{})
+}
+
+type (
+	// Spec is the spec of TokenService.
+	Spec struct {
+		// The iss claim of issued tokens.
+		Issuer string
+		// The aud claim of issued tokens.
+		Audience string
+		// Default: 15m.
+		AccessTokenTTL string
+		// Default: 24h, 0s disables refresh tokens.
+		RefreshTokenTTL string
+		// Signing keys, the first one signs new tokens. Keep the
+		// previous key listed while its tokens are still valid
+		// when rotating.
+		Keys []*KeySpec
+		// Sign with HS256 and the current key of a shared keyring
+		// instead of Keys, the keyring takes care of rotation.
+		Keyring *keyring.Spec
+		// The request path serving the public keys as a JWKS
+		// document, e.g. /.well-known/jwks.json.
+		JWKSPath string
+		Clients  []*ClientSpec
+		// Where failed client authentications are reported.
+		SecurityEvents *secevent.Spec
+	}
+
+	// KeySpec is a signing key.
+	KeySpec struct {
+		// The kid header of tokens signed by the key.
+		ID string
+		// HS256 or ES256. Default: HS256.
+		Algorithm string
+		// The HS256 secret, or the name of the environment variable
+		// holding it when it starts with $.
+		Secret string
+		// The PEM encoded P-256 private key of ES256.
+		PrivateKeyFile string
+	}
+
+	// ClientSpec is a client allowed to obtain tokens.
+	ClientSpec struct {
+		ID string
+		// The client secret, or the name of the environment variable
+		// holding it when it starts with $.
+		Secret string
+		// Extra claims, values may use placeholders such as
+		// {client_id} and {env.NAME}.
+		Claims map[string]string
+		// The scope claim of the client's tokens.
+		Scopes []string
+	}
+
+	// TokenService issues JWTs to first-party clients with the OAuth 2
+	// client credentials and refresh token grants.
+	TokenService struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+		keys       []*signingKey
+		keyring    *keyring.Keyring
+		clients    map[string]*ClientSpec
+		secrets    map[string]string
+		refresh    *refreshStore
+		events     *secevent.Stream
+
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+	}
+
+	tokenResponse struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int64  `json:"expires_in"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+		Scope        string `json:"scope,omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if (len(spec.Keys) == 0) == (spec.Keyring == nil) {
+		return fmt.Errorf("exactly one of keys and keyring is required")
+	}
+	if spec.Keyring != nil {
+		if err := spec.Keyring.Validate(); err != nil {
+			return err
+		}
+	}
+	ids := map[string]bool{}
+	for _, k := range spec.Keys {
+		if k.ID == "" {
+			return fmt.Errorf("key id is required")
+		}
+		if ids[k.ID] {
+			return fmt.Errorf("duplicated key id %s", k.ID)
+		}
+		ids[k.ID] = true
+		if _, err := loadKey(k); err != nil {
+			return err
+		}
+	}
+	for _, d := range []string{spec.AccessTokenTTL, spec.RefreshTokenTTL} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d); err != nil {
+			return fmt.Errorf("invalid ttl: %v", err)
+		}
+	}
+	clients := map[string]bool{}
+	for _, c := range spec.Clients {
+		if c.ID == "" || c.Secret == "" {
+			return fmt.Errorf("client id and secret are required")
+		}
+		if clients[c.ID] {
+			return fmt.Errorf("duplicated client id %s", c.ID)
+		}
+		clients[c.ID] = true
+	}
+	if spec.SecurityEvents != nil {
+		return spec.SecurityEvents.Validate()
+	}
+	return nil
+}
+
+// Kind returns the kind of TokenService.
+func (ts *TokenService) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of TokenService.
+func (ts *TokenService) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of TokenService.
+func (ts *TokenService) Description() string {
+	return "TokenService issues JWTs to first-party clients."
+}
+
+// Results returns the results of TokenService.
+func (ts *TokenService) Results() []string {
+	return results
+}
+
+// Init initializes TokenService.
+func (ts *TokenService) Init(filterSpec *httppipeline.FilterSpec) {
+	ts.reload(filterSpec)
+	ts.refresh = newRefreshStore()
+}
+
+// Inherit inherits previous generation of TokenService, refresh tokens
+// issued by it stay valid.
+func (ts *TokenService) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	// release the keyring before opening it again, its settings may
+	// have changed; requests of the previous generation can still
+	// sign with the released keyring
+	prev := previousGeneration.(*TokenService)
+	if prev.keyring != nil {
+		prev.keyring.Close()
+	}
+	ts.reload(filterSpec)
+	prev.events.Close()
+	ts.refresh = prev.refresh
+}
+
+func (ts *TokenService) reload(filterSpec *httppipeline.FilterSpec) {
+	ts.filterSpec = filterSpec
+	ts.spec = filterSpec.FilterSpec().(*Spec)
+
+	ts.keys = ts.keys[:0]
+	for _, k := range ts.spec.Keys {
+		// validated already
+		key, _ := loadKey(k)
+		ts.keys = append(ts.keys, key)
+	}
+	if ts.spec.Keyring != nil {
+		kr, err := keyring.Open(ts.spec.Keyring)
+		if err != nil {
+			logger.Error("open keyring failed", zap.String("filter", filterSpec.Name()), zap.Error(err))
+		} else {
+			ts.keyring = kr
+		}
+	}
+	ts.clients = map[string]*ClientSpec{}
+	ts.secrets = map[string]string{}
+	for _, c := range ts.spec.Clients {
+		ts.clients[c.ID] = c
+		secret := c.Secret
+		if strings.HasPrefix(secret, "$") {
+			secret = os.Getenv(secret[1:])
+		}
+		ts.secrets[c.ID] = secret
+	}
+
+	ts.accessTTL = defaultAccessTokenTTL
+	if ts.spec.AccessTokenTTL != "" {
+		ts.accessTTL, _ = time.ParseDuration(ts.spec.AccessTokenTTL)
+	}
+	ts.refreshTTL = defaultRefreshTokenTTL
+	if ts.spec.RefreshTokenTTL != "" {
+		ts.refreshTTL, _ = time.ParseDuration(ts.spec.RefreshTokenTTL)
+	}
+	ts.events = secevent.New(filterSpec.Name(), ts.spec.SecurityEvents)
+}
+
+// Handle handles HTTP request
+func (ts *TokenService) Handle(ctx context.HTTPContext) string {
+	res := ts.handle(ctx)
+	return ctx.CallNextHandler(res)
+}
+
+func (ts *TokenService) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	w := ctx.Response()
+
+	if ts.spec.JWKSPath != "" && r.Path() == ts.spec.JWKSPath {
+		if r.Method() != http.MethodGet && r.Method() != http.MethodHead {
+			w.Header().Add("Allow", "GET, HEAD")
+			w.SetStatusCode(http.StatusMethodNotAllowed)
+			return resultMethodNotAllowed
+		}
+		return ts.serveJWKS(ctx)
+	}
+
+	if r.Method() != http.MethodPost {
+		w.Header().Add("Allow", "POST")
+		w.SetStatusCode(http.StatusMethodNotAllowed)
+		return resultMethodNotAllowed
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body(), maxRequestSize))
+	if err != nil {
+		return ts.oauthError(ctx, http.StatusBadRequest, "invalid_request", resultInvalidRequest)
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return ts.oauthError(ctx, http.StatusBadRequest, "invalid_request", resultInvalidRequest)
+	}
+
+	var clientID string
+	switch form.Get("grant_type") {
+	case "client_credentials":
+		id, secret, ok := r.Std().BasicAuth()
+		if !ok {
+			id, secret = form.Get("client_id"), form.Get("client_secret")
+		}
+		if !ts.authenticate(id, secret) {
+			ts.authFailure(ctx, "invalid client credentials", id)
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+			return ts.oauthError(ctx, http.StatusUnauthorized, "invalid_client", resultUnauthorized)
+		}
+		clientID = id
+	case "refresh_token":
+		if ts.refreshTTL <= 0 {
+			return ts.oauthError(ctx, http.StatusBadRequest, "unsupported_grant_type", resultInvalidRequest)
+		}
+		id, ok := ts.refresh.redeem(form.Get("refresh_token"))
+		if !ok || ts.clients[id] == nil {
+			ts.authFailure(ctx, "invalid refresh token", id)
+			return ts.oauthError(ctx, http.StatusBadRequest, "invalid_grant", resultUnauthorized)
+		}
+		clientID = id
+	default:
+		return ts.oauthError(ctx, http.StatusBadRequest, "unsupported_grant_type", resultInvalidRequest)
+	}
+
+	resp, err := ts.issue(ts.clients[clientID])
+	if err != nil {
+		ctx.AddTag("issue token failed: " + err.Error())
+		return ts.oauthError(ctx, http.StatusInternalServerError, "server_error", resultInvalidRequest)
+	}
+	ctx.AddTag("token issued to " + clientID)
+	w.Header().Set("Cache-Control", "no-store")
+	return ts.writeJSON(ctx, http.StatusOK, resp)
+}
+
+func (ts *TokenService) authenticate(id, secret string) bool {
+	expected, ok := ts.secrets[id]
+	if !ok || expected == "" {
+		// compare anyway, so unknown clients take as long as
+		// known ones
+		expected = "\x00"
+	}
+	match := subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) == 1
+	return ok && match
+}
+
+func (ts *TokenService) issue(client *ClientSpec) (*tokenResponse, error) {
+	now := time.Now()
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return nil, err
+	}
+
+	repl := util.NewReplacer()
+	repl.Set("client_id", client.ID)
+	claims := map[string]interface{}{}
+	for k, v := range client.Claims {
+		claims[k] = repl.ReplaceAll(v, "")
+	}
+	claims["sub"] = client.ID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(ts.accessTTL).Unix()
+	claims["jti"] = b64.EncodeToString(jti)
+	if ts.spec.Issuer != "" {
+		claims["iss"] = ts.spec.Issuer
+	}
+	if ts.spec.Audience != "" {
+		claims["aud"] = ts.spec.Audience
+	}
+	scope := strings.Join(client.Scopes, " ")
+	if scope != "" {
+		claims["scope"] = scope
+	}
+
+	key, err := ts.signingKey()
+	if err != nil {
+		return nil, err
+	}
+	token, err := key.sign(claims)
+	if err != nil {
+		return nil, err
+	}
+	resp := &tokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(ts.accessTTL / time.Second),
+		Scope:       scope,
+	}
+	if ts.refreshTTL > 0 {
+		if resp.RefreshToken, err = ts.refresh.issue(client.ID, ts.refreshTTL); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (ts *TokenService) signingKey() (*signingKey, error) {
+	if ts.spec.Keyring == nil {
+		return ts.keys[0], nil
+	}
+	if ts.keyring == nil {
+		return nil, fmt.Errorf("keyring unavailable")
+	}
+	current := ts.keyring.Current()
+	return &signingKey{id: current.ID, algorithm: algHS256, secret: current.Secret}, nil
+}
+
+func (ts *TokenService) serveJWKS(ctx context.HTTPContext) string {
+	keys := []*jwk{}
+	for _, k := range ts.keys {
+		if j := k.jwk(); j != nil {
+			keys = append(keys, j)
+		}
+	}
+	ctx.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return ts.writeJSON(ctx, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+func (ts *TokenService) oauthError(ctx context.HTTPContext, code int, oauthErr, result string) string {
+	ctx.Response().Header().Set("Cache-Control", "no-store")
+	ts.writeJSON(ctx, code, map[string]string{"error": oauthErr})
+	ctx.AddTag("token request failed: " + oauthErr)
+	return result
+}
+
+func (ts *TokenService) writeJSON(ctx context.HTTPContext, code int, v interface{}) string {
+	w := ctx.Response()
+	data, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json")
+	w.SetStatusCode(code)
+	w.SetBody(bytes.NewReader(data))
+	return ""
+}
+
+// authFailure reports a token request denied for invalid credentials
+// of the client clientID, which may be empty.
+func (ts *TokenService) authFailure(ctx context.HTTPContext, reason, clientID string) {
+	r := ctx.Request()
+	ev := &secevent.Event{
+		Type:     secevent.TypeAuthFailure,
+		Severity: 5,
+		Action:   "deny",
+		ClientIP: r.RealIP(),
+		Method:   r.Method(),
+		Path:     r.Path(),
+		Reason:   reason,
+	}
+	if clientID != "" {
+		ev.Fields = map[string]string{"clientID": clientID}
+	}
+	ts.events.Emit(ev)
+}
+
+// Status returns Status generated by Runtime.
+func (ts *TokenService) Status() interface{} {
+	return nil
+}
+
+// Close closes TokenService.
+func (ts *TokenService) Close() {
+	ts.refresh.close()
+	ts.events.Close()
+	if ts.keyring != nil {
+		ts.keyring.Close()
+	}
+}
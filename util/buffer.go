@@ -0,0 +1,51 @@
+package util
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+const (
+	copyBufferSize = 32 << 10
+
+	// buffers grown beyond this size are dropped instead of pooled,
+	// so one huge response doesn't pin memory forever
+	maxPooledBufferSize = 1 << 20
+)
+
+var (
+	bufferPool = sync.Pool{
+		New: func() any { return new(bytes.Buffer) },
+	}
+	copyBufferPool = sync.Pool{
+		New: func() any {
+			b := make([]byte, copyBufferSize)
+			return &b
+		},
+	}
+)
+
+// GetBuffer returns an empty buffer from the shared pool.
+// Call PutBuffer when done with it.
+func GetBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// PutBuffer resets buf and returns it to the shared pool.
+// buf must not be used after calling PutBuffer.
+func PutBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// Copy is like io.Copy, but uses a pooled buffer instead of
+// allocating one for every call.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
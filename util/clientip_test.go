@@ -0,0 +1,43 @@
+package util
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("invalid prefix accepted")
+	}
+
+	for _, c := range []struct {
+		peer, xff, realIP, want string
+	}{
+		// forwarded headers of untrusted peers are ignored
+		{"203.0.113.7:1234", "198.51.100.1", "198.51.100.2", "203.0.113.7"},
+		{"10.1.2.3:80", "198.51.100.1", "", "198.51.100.1"},
+		// a client can't prepend a victim to the chain
+		{"10.1.2.3:80", "198.51.100.9, 203.0.113.7, 192.168.1.1", "", "203.0.113.7"},
+		{"10.1.2.3:80", "198.51.100.9, 203.0.113.7", "", "203.0.113.7"},
+		{"10.1.2.3:80", "garbage, 10.2.0.1", "", "10.2.0.1"},
+		{"10.1.2.3:80", "", "198.51.100.2", "198.51.100.2"},
+		{"10.1.2.3:80", "", "", "10.1.2.3"},
+		{"[::ffff:10.1.2.3]:80", "198.51.100.1", "", "198.51.100.1"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.peer
+		if c.xff != "" {
+			r.Header.Set("X-Forwarded-For", c.xff)
+		}
+		if c.realIP != "" {
+			r.Header.Set("X-Real-Ip", c.realIP)
+		}
+		if got := ClientIP(r, trusted); got != c.want {
+			t.Errorf("ClientIP(%s, %q, %q) = %s, want %s", c.peer, c.xff, c.realIP, got, c.want)
+		}
+	}
+}
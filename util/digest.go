@@ -0,0 +1,123 @@
+package util
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrDigestMismatch is returned by a DigestReader at the end of a body
+// that doesn't match its declared digest.
+var ErrDigestMismatch = errors.New("body digest mismatch")
+
+type expectedDigest struct {
+	name string
+	hash hash.Hash
+	sum  []byte
+}
+
+// DigestReader verifies the Content-MD5 and Content-Digest (RFC 9530)
+// headers of a body while it is read. Instead of io.EOF, it returns
+// ErrDigestMismatch if the body doesn't match. Digests in algorithms
+// it doesn't know are ignored. It also computes the SHA-256 digest of
+// the body, to be forwarded upstream.
+type DigestReader struct {
+	r        io.Reader
+	expected []*expectedDigest
+	sha256   hash.Hash
+	w        io.Writer
+	err      error
+}
+
+// NewDigestReader returns a DigestReader reading r, which is the body
+// of a request or response with header. It fails if the digest headers
+// are malformed.
+func NewDigestReader(r io.Reader, header http.Header) (*DigestReader, error) {
+	dr := &DigestReader{r: r, sha256: sha256.New()}
+	writers := []io.Writer{dr.sha256}
+
+	if v := header.Get("Content-MD5"); v != "" {
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+		if err != nil || len(sum) != md5.Size {
+			return nil, fmt.Errorf("invalid Content-MD5 %q", v)
+		}
+		dr.expected = append(dr.expected, &expectedDigest{name: "md5", hash: md5.New(), sum: sum})
+	}
+	for _, v := range header.Values("Content-Digest") {
+		for _, item := range strings.Split(v, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			i := strings.IndexByte(item, '=')
+			if i < 0 {
+				return nil, fmt.Errorf("invalid Content-Digest %q", item)
+			}
+			alg, value := strings.ToLower(item[:i]), item[i+1:]
+			var h hash.Hash
+			switch alg {
+			case "sha-256":
+				h = sha256.New()
+			case "sha-512":
+				h = sha512.New()
+			default:
+				continue
+			}
+			// byte sequences are enclosed in colons
+			if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+				return nil, fmt.Errorf("invalid Content-Digest %q", item)
+			}
+			sum, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+			if err != nil || len(sum) != h.Size() {
+				return nil, fmt.Errorf("invalid Content-Digest %q", item)
+			}
+			dr.expected = append(dr.expected, &expectedDigest{name: alg, hash: h, sum: sum})
+		}
+	}
+
+	for _, e := range dr.expected {
+		writers = append(writers, e.hash)
+	}
+	dr.w = io.MultiWriter(writers...)
+	return dr, nil
+}
+
+// Read implements io.Reader.
+func (dr *DigestReader) Read(p []byte) (int, error) {
+	if dr.err != nil {
+		return 0, dr.err
+	}
+	n, err := dr.r.Read(p)
+	dr.w.Write(p[:n])
+	if err == io.EOF {
+		for _, e := range dr.expected {
+			if !bytes.Equal(e.hash.Sum(nil), e.sum) {
+				err = fmt.Errorf("%w: %s", ErrDigestMismatch, e.name)
+				break
+			}
+		}
+	}
+	if err != nil {
+		dr.err = err
+	}
+	return n, err
+}
+
+// Verifies reports whether the body has a digest header to verify.
+func (dr *DigestReader) Verifies() bool {
+	return len(dr.expected) > 0
+}
+
+// ContentDigest returns the Content-Digest header value of the body
+// read so far, it is complete once Read returned io.EOF.
+func (dr *DigestReader) ContentDigest() string {
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(dr.sha256.Sum(nil)) + ":"
+}
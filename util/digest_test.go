@@ -0,0 +1,46 @@
+package util
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDigestReader(t *testing.T) {
+	const body = "hello world"
+	// digests of body
+	const (
+		md5Sum    = "XrY7u+Ae7tCTyyK7j1rNww=="
+		sha256Sum = "sha-256=:uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=:"
+	)
+
+	for _, c := range []struct {
+		name   string
+		header http.Header
+		err    error
+	}{
+		{"none", http.Header{}, nil},
+		{"md5", http.Header{"Content-Md5": {md5Sum}}, nil},
+		{"sha-256", http.Header{"Content-Digest": {sha256Sum}}, nil},
+		{"unknown", http.Header{"Content-Digest": {"crc32=:AAAAAA==:"}}, nil},
+		{"mismatch", http.Header{"Content-Digest": {"sha-256=:" + strings.Repeat("A", 43) + "=:"}}, ErrDigestMismatch},
+	} {
+		dr, err := NewDigestReader(strings.NewReader(body), c.header)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		_, err = io.ReadAll(dr)
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.err)
+		}
+		if c.err == nil && dr.ContentDigest() != sha256Sum {
+			t.Errorf("%s: unexpected digest %s", c.name, dr.ContentDigest())
+		}
+	}
+
+	if _, err := NewDigestReader(nil, http.Header{"Content-Md5": {"nope"}}); err == nil {
+		t.Error("malformed Content-MD5 accepted")
+	}
+}
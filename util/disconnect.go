@@ -0,0 +1,20 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"syscall"
+)
+
+// ClientGone reports whether the client of a request went away, as
+// told by the canceled request context ctx or err, the error of
+// reading the request or writing the response. Such requests are no
+// failures of the gateway or its upstreams.
+func ClientGone(ctx context.Context, err error) bool {
+	if errors.Is(ctx.Err(), context.Canceled) || errors.Is(err, context.Canceled) {
+		return true
+	}
+	// a body cut short, or a connection closed under a write
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
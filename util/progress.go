@@ -0,0 +1,115 @@
+package util
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStalled is returned by a ProgressReader aborted by WatchStall.
+var ErrStalled = errors.New("copy stalled")
+
+// ProgressReader wraps a reader to count the bytes read through
+// it and to remember when the last read happened. When it wraps an
+// io.Seeker, seeking is passed through.
+type ProgressReader struct {
+	r     io.Reader
+	start time.Time
+	n     int64
+	last  int64
+
+	abortOnce sync.Once
+	aborted   atomic.Value
+}
+
+// NewProgressReader returns a new ProgressReader reading from r.
+func NewProgressReader(r io.Reader) *ProgressReader {
+	now := time.Now()
+	return &ProgressReader{r: r, start: now, last: now.UnixNano()}
+}
+
+// Read implements io.Reader.
+func (pr *ProgressReader) Read(p []byte) (int, error) {
+	if err := pr.Err(); err != nil {
+		return 0, err
+	}
+	n, err := pr.r.Read(p)
+	atomic.AddInt64(&pr.n, int64(n))
+	atomic.StoreInt64(&pr.last, time.Now().UnixNano())
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (pr *ProgressReader) Seek(offset int64, whence int) (int64, error) {
+	s, ok := pr.r.(io.Seeker)
+	if !ok {
+		return 0, errors.New("seek on a non-seekable reader")
+	}
+	atomic.StoreInt64(&pr.last, time.Now().UnixNano())
+	return s.Seek(offset, whence)
+}
+
+// BytesRead returns the number of bytes read so far.
+func (pr *ProgressReader) BytesRead() int64 {
+	return atomic.LoadInt64(&pr.n)
+}
+
+// Throughput returns the average number of bytes read per second.
+func (pr *ProgressReader) Throughput() float64 {
+	elapsed := time.Since(pr.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(pr.BytesRead()) / elapsed
+}
+
+// Idle returns how long ago the last read or seek happened.
+func (pr *ProgressReader) Idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&pr.last)))
+}
+
+// Abort makes all subsequent reads fail with err.
+func (pr *ProgressReader) Abort(err error) {
+	pr.abortOnce.Do(func() { pr.aborted.Store(err) })
+}
+
+// Err returns the error the reader was aborted with, if any.
+func (pr *ProgressReader) Err() error {
+	if err, ok := pr.aborted.Load().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// WatchStall aborts pr with ErrStalled once it has made no progress
+// for timeout. Note that a copy blocked in writing is only aborted on
+// its next read, the writer's own deadlines still apply. Call the
+// returned function to stop watching.
+func WatchStall(pr *ProgressReader, timeout time.Duration) (stop func()) {
+	done := make(chan struct{})
+	interval := timeout / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if pr.Idle() >= timeout {
+					pr.Abort(ErrStalled)
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
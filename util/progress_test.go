@@ -0,0 +1,46 @@
+package util
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchStall(t *testing.T) {
+	pr := NewProgressReader(strings.NewReader(strings.Repeat("x", 100)))
+	stop := WatchStall(pr, 200*time.Millisecond)
+	p := make([]byte, 1)
+	// reading more often than the timeout keeps the copy alive
+	for i := 0; i < 20; i++ {
+		if _, err := pr.Read(p); err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// nothing is aborted once the watch is stopped
+	stop()
+	time.Sleep(300 * time.Millisecond)
+	if _, err := pr.Read(p); err != nil {
+		t.Fatalf("read after stop: %v", err)
+	}
+	if n := pr.BytesRead(); n != 21 {
+		t.Errorf("%d bytes read, want 21", n)
+	}
+
+	pr = NewProgressReader(strings.NewReader(strings.Repeat("x", 100)))
+	stop = WatchStall(pr, 50*time.Millisecond)
+	defer stop()
+	if _, err := pr.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for pr.Err() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("stall not detected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := pr.Read(p); err != ErrStalled {
+		t.Errorf("read of a stalled copy: %v", err)
+	}
+}
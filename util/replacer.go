@@ -135,7 +135,8 @@ func (r *Replacer) replace(input, empty string,
 		return input, nil
 	}
 
-	var sb strings.Builder
+	sb := GetBuffer()
+	defer PutBuffer(sb)
 
 	// it is reasonable to assume that the output
 	// will be approximately as long as the input
@@ -0,0 +1,122 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// RequestHasher hashes the selected attributes of requests, so cache
+// keys, consistent hashing, experiment buckets and idempotency keys
+// agree on what makes two requests the same. The hashes are stable
+// across processes and releases: attributes are written in a fixed
+// order, length-prefixed, with query parameters and headers sorted.
+type RequestHasher struct {
+	// Include the request method.
+	Method bool
+	// Include the request path.
+	Path bool
+	// Include the whole query, its parameters sorted. QueryParams
+	// selects some parameters only.
+	Query       bool
+	QueryParams []string
+	// Include these headers, their names are case insensitive.
+	Headers []string
+	// Include the body digest passed to the hash functions, e.g. the
+	// SHA-256 sum computed by a DigestReader.
+	Body bool
+}
+
+// Sum64 returns the 64 bit hash of r, for hash rings and buckets.
+func (rh *RequestHasher) Sum64(r *http.Request, bodyDigest []byte) uint64 {
+	d := xxhash.New()
+	rh.write(d, r, bodyDigest)
+	return d.Sum64()
+}
+
+// Key returns the hex encoded SHA-256 hash of r, for keys that must
+// not collide, like cache and idempotency keys.
+func (rh *RequestHasher) Key(r *http.Request, bodyDigest []byte) string {
+	h := sha256.New()
+	rh.write(h, r, bodyDigest)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Bucket returns the bucket of r among n buckets, e.g. to assign
+// experiment groups.
+func (rh *RequestHasher) Bucket(r *http.Request, bodyDigest []byte, n int) int {
+	if n <= 1 {
+		return 0
+	}
+	return int(rh.Sum64(r, bodyDigest) % uint64(n))
+}
+
+func (rh *RequestHasher) write(h hash.Hash, r *http.Request, bodyDigest []byte) {
+	if rh.Method {
+		writeField(h, "m", r.Method)
+	}
+	if rh.Path {
+		writeField(h, "p", r.URL.Path)
+	}
+	if rh.Query || len(rh.QueryParams) > 0 {
+		query := r.URL.Query()
+		names := rh.QueryParams
+		if len(names) == 0 {
+			names = make([]string, 0, len(query))
+			for name := range query {
+				names = append(names, name)
+			}
+		} else {
+			names = append([]string(nil), names...)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			writeValues(h, "q", name, sortedCopy(query[name]))
+		}
+	}
+	if len(rh.Headers) > 0 {
+		names := make([]string, len(rh.Headers))
+		for i, name := range rh.Headers {
+			names[i] = http.CanonicalHeaderKey(name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			writeValues(h, "h", strings.ToLower(name), r.Header.Values(name))
+		}
+	}
+	if rh.Body {
+		writeField(h, "b", string(bodyDigest))
+	}
+}
+
+// writeField writes a tagged, length-prefixed value, so that different
+// splits of the same bytes never hash the same.
+func writeField(h hash.Hash, tag, value string) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(value)))
+	h.Write([]byte(tag))
+	h.Write(n[:])
+	h.Write([]byte(value))
+}
+
+func writeValues(h hash.Hash, tag, name string, values []string) {
+	writeField(h, tag, name)
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(values)))
+	h.Write(n[:])
+	for _, v := range values {
+		writeField(h, "v", v)
+	}
+}
+
+func sortedCopy(values []string) []string {
+	values = append([]string(nil), values...)
+	sort.Strings(values)
+	return values
+}
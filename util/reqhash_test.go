@@ -0,0 +1,46 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestHasher(t *testing.T) {
+	rh := &RequestHasher{Method: true, Path: true, Query: true, Headers: []string{"x-tenant"}}
+	req := func(target, tenant string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if tenant != "" {
+			r.Header.Set("X-Tenant", tenant)
+		}
+		return r
+	}
+
+	a := rh.Key(req("/items?b=2&a=1", "t1"), nil)
+	if b := rh.Key(req("/items?a=1&b=2", "t1"), nil); a != b {
+		t.Error("query order changed the key")
+	}
+	if b := rh.Key(req("/items?a=1&b=2", "t2"), nil); a == b {
+		t.Error("header ignored")
+	}
+	if b := rh.Key(req("/items?a=12", "t1"), nil); a == b {
+		t.Error("query ignored")
+	}
+
+	// pinned, so a change of the encoding doesn't go unnoticed
+	if got := (&RequestHasher{Path: true}).Sum64(req("/", ""), nil); got != 0xbf426d84ee7e7cb6 {
+		t.Errorf("Sum64 = %#x, the encoding changed", got)
+	}
+
+	counts := make([]int, 4)
+	for i := 0; i < 400; i++ {
+		r := req("/", "")
+		r.Header.Set("X-User", string(rune('a'+i%26))+string(rune('a'+i/26)))
+		counts[(&RequestHasher{Headers: []string{"X-User"}}).Bucket(r, nil, 4)]++
+	}
+	for i, n := range counts {
+		if n < 50 {
+			t.Errorf("bucket %d got %d of 400 requests", i, n)
+		}
+	}
+}
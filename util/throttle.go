@@ -0,0 +1,111 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// TokenBucket limits a rate of bytes, it is goroutine-safe so a bucket
+// can be shared by many readers to limit their sum.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a full bucket refilled with rate tokens per
+// second, holding at most burst tokens.
+func NewTokenBucket(rate, burst int64) *TokenBucket {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &TokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Burst returns the maximum number of tokens of the bucket.
+func (tb *TokenBucket) Burst() int64 {
+	return int64(tb.burst)
+}
+
+// reserve takes n tokens, possibly going into debt, and returns how
+// long the caller has to wait for the debt to be paid back.
+func (tb *TokenBucket) reserve(n int) time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+	tb.tokens -= float64(n)
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// ThrottledReader limits the rate at which a reader is read through
+// token buckets. When it wraps an io.Seeker, seeking is passed through.
+type ThrottledReader struct {
+	r       io.Reader
+	ctx     context.Context
+	buckets []*TokenBucket
+	chunk   int
+}
+
+// NewThrottledReader returns a reader reading from r no faster than
+// any of the buckets allows, waits end early with the error of ctx.
+func NewThrottledReader(ctx context.Context, r io.Reader, buckets ...*TokenBucket) *ThrottledReader {
+	chunk := 32 * 1024
+	for _, b := range buckets {
+		if burst := int(b.Burst()); burst > 0 && burst < chunk {
+			chunk = burst
+		}
+	}
+	return &ThrottledReader{r: r, ctx: ctx, buckets: buckets, chunk: chunk}
+}
+
+// Read implements io.Reader.
+func (tr *ThrottledReader) Read(p []byte) (int, error) {
+	if len(p) > tr.chunk {
+		p = p[:tr.chunk]
+	}
+	n, err := tr.r.Read(p)
+	var wait time.Duration
+	for _, b := range tr.buckets {
+		if d := b.reserve(n); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-tr.ctx.Done():
+			return n, tr.ctx.Err()
+		}
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (tr *ThrottledReader) Seek(offset int64, whence int) (int64, error) {
+	s, ok := tr.r.(io.Seeker)
+	if !ok {
+		return 0, errors.New("seek on a non-seekable reader")
+	}
+	return s.Seek(offset, whence)
+}
@@ -0,0 +1,32 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestThrottledReader(t *testing.T) {
+	// the bucket starts full, so the first 10KiB are free
+	shared := NewTokenBucket(100*1024, 10*1024)
+	perRequest := NewTokenBucket(200*1024, 0)
+	r := NewThrottledReader(context.Background(), bytes.NewReader(make([]byte, 30*1024)), shared, perRequest)
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, r)
+	if err != nil || n != 30*1024 {
+		t.Fatalf("copied %d bytes: %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Errorf("copy took %v, want about 200ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r = NewThrottledReader(ctx, bytes.NewReader(make([]byte, 30*1024)), NewTokenBucket(1024, 0))
+	if _, err := io.Copy(io.Discard, r); err != context.Canceled {
+		t.Errorf("copy with a canceled context: %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package webdav
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/nacos-group/nacos-sdk-go/common/logger"
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	// Kind is the kind of WebDAVServer.
+	Kind = "WebDAVServer"
+
+	resultReadOnly = "readOnly"
+	resultFailed   = "failed"
+)
+
+var results = []string{resultReadOnly, resultFailed}
+
+// readMethods don't change the share.
+var readMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	"PROPFIND":         true,
+}
+
+func init() {
+	httppipeline.Register(&WebDAVServer{})
+}
+
+type (
+	// Spec is the spec of WebDAVServer.
+	Spec struct {
+		// The directory shared.
+		Root string
+		// The request path prefix of the share, e.g. /dav. It is
+		// stripped before the path is mapped to a file.
+		Prefix string
+		// Only allow GET, HEAD, OPTIONS and PROPFIND. Default: true.
+		ReadOnly bool
+	}
+
+	// WebDAVServer exposes a directory through WebDAV, so a file share
+	// can be reached through the same pipeline as everything else.
+	WebDAVServer struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+		handler    *webdav.Handler
+
+		mu      sync.Mutex
+		methods map[string]uint64
+	}
+
+	// Status is the status of WebDAVServer.
+	Status struct {
+		// Requests by method.
+		Methods map[string]uint64 `yaml:"methods"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	info, err := os.Stat(spec.Root)
+	if err != nil {
+		return fmt.Errorf("invalid root: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("root %s is not a directory", spec.Root)
+	}
+	if spec.Prefix != "" && !strings.HasPrefix(spec.Prefix, "/") {
+		return fmt.Errorf("prefix %q must start with /", spec.Prefix)
+	}
+	return nil
+}
+
+// Kind returns the kind of WebDAVServer.
+func (ws *WebDAVServer) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of WebDAVServer.
+func (ws *WebDAVServer) DefaultSpec() interface{} {
+	return &Spec{ReadOnly: true}
+}
+
+// Description returns the description of WebDAVServer.
+func (ws *WebDAVServer) Description() string {
+	return "WebDAVServer serves a directory through WebDAV."
+}
+
+// Results returns the results of WebDAVServer.
+func (ws *WebDAVServer) Results() []string {
+	return results
+}
+
+// Init initializes WebDAVServer.
+func (ws *WebDAVServer) Init(filterSpec *httppipeline.FilterSpec) {
+	ws.init(filterSpec, webdav.NewMemLS())
+}
+
+// Inherit inherits previous generation of WebDAVServer, the locks
+// held by clients are kept.
+func (ws *WebDAVServer) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	prev := previousGeneration.(*WebDAVServer)
+	ws.init(filterSpec, prev.handler.LockSystem)
+	prev.Close()
+}
+
+func (ws *WebDAVServer) init(filterSpec *httppipeline.FilterSpec, ls webdav.LockSystem) {
+	ws.filterSpec = filterSpec
+	ws.spec = filterSpec.FilterSpec().(*Spec)
+	ws.methods = make(map[string]uint64)
+	ws.handler = &webdav.Handler{
+		Prefix:     strings.TrimRight(ws.spec.Prefix, "/"),
+		FileSystem: webdav.Dir(ws.spec.Root),
+		LockSystem: ls,
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				logger.Debug("webdav request failed",
+					zap.String("method", r.Method),
+					zap.String("path", r.URL.Path),
+					zap.Error(err))
+			}
+		},
+	}
+}
+
+// Handle handles HTTP request
+func (ws *WebDAVServer) Handle(ctx context.HTTPContext) string {
+	res := ws.handle(ctx)
+	return ctx.CallNextHandler(res)
+}
+
+func (ws *WebDAVServer) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	w := ctx.Response()
+	method := r.Method()
+	ws.count(method)
+
+	if ws.spec.ReadOnly && !readMethods[method] {
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS, PROPFIND")
+		w.SetStatusCode(http.StatusMethodNotAllowed)
+		return resultReadOnly
+	}
+
+	stdReq := r.Std().Clone(r.Std().Context())
+	stdReq.Body = io.NopCloser(r.Body())
+	rw := &statusWriter{ResponseWriter: w.Std()}
+	ws.handler.ServeHTTP(rw, stdReq)
+	if rw.status >= http.StatusInternalServerError {
+		ctx.AddTag(fmt.Sprintf("webdav %s failed with %d", method, rw.status))
+		return resultFailed
+	}
+	return ""
+}
+
+func (ws *WebDAVServer) count(method string) {
+	ws.mu.Lock()
+	ws.methods[method]++
+	ws.mu.Unlock()
+}
+
+// Status returns Status generated by Runtime.
+func (ws *WebDAVServer) Status() interface{} {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	methods := make(map[string]uint64, len(ws.methods))
+	for m, n := range ws.methods {
+		methods[m] = n
+	}
+	return &Status{Methods: methods}
+}
+
+// Close closes WebDAVServer.
+func (ws *WebDAVServer) Close() {}
+
+// statusWriter records the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.status == 0 {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(p)
+}
@@ -0,0 +1,62 @@
+package webdav
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAVServer(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(ws *WebDAVServer, method, target string, body string) (string, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		status := 0
+		ctx := &contexttest.MockedHTTPContext{}
+		ctx.MockedRequest.MockedMethod = func() string { return method }
+		ctx.MockedRequest.MockedStd = func() *http.Request { return req }
+		ctx.MockedRequest.MockedBody = func() io.Reader { return req.Body }
+		ctx.MockedResponse.MockedStd = func() http.ResponseWriter { return rec }
+		ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return httpheader.New(rec.Header()) }
+		ctx.MockedResponse.MockedSetStatusCode = func(code int) { status = code }
+		res := ws.handle(ctx)
+		if status != 0 {
+			rec.Code = status
+		}
+		return res, rec
+	}
+
+	ws := &WebDAVServer{methods: map[string]uint64{}}
+	ws.spec = &Spec{Root: root, Prefix: "/dav", ReadOnly: true}
+	ws.handler = &webdav.Handler{Prefix: "/dav", FileSystem: webdav.Dir(root), LockSystem: webdav.NewMemLS()}
+
+	if _, rec := serve(ws, "PROPFIND", "/dav/", ""); rec.Code != http.StatusMultiStatus || !strings.Contains(rec.Body.String(), "a.txt") {
+		t.Errorf("PROPFIND: %d %s", rec.Code, rec.Body)
+	}
+	if res, rec := serve(ws, http.MethodPut, "/dav/b.txt", "b"); res != resultReadOnly || rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT on a read-only share: %s %d", res, rec.Code)
+	}
+
+	ws.spec.ReadOnly = false
+	if _, rec := serve(ws, http.MethodPut, "/dav/b.txt", "b"); rec.Code != http.StatusCreated {
+		t.Errorf("PUT: %d", rec.Code)
+	}
+	if _, rec := serve(ws, "MKCOL", "/dav/dir", ""); rec.Code != http.StatusCreated {
+		t.Errorf("MKCOL: %d", rec.Code)
+	}
+	if ws.Status().(*Status).Methods["PUT"] != 2 {
+		t.Errorf("status = %+v", ws.Status())
+	}
+}